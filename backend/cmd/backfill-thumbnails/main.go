@@ -0,0 +1,172 @@
+// Command backfill-thumbnails generates the configured THUMBNAIL_SIZES for
+// scans that were uploaded before thumbnails existed. With local storage,
+// scans from before image_url was recorded are matched to their file in
+// UPLOAD_DIR, and the path is saved on the scan and its first page.
+//
+// Usage:
+//
+//	go run ./cmd/backfill-thumbnails [-batch-size 100] [-force]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	_ "github.com/lib/pq"
+
+	"github.com/gemini-hackathon/app/internal/config"
+	"github.com/gemini-hackathon/app/internal/imageproc"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+	"github.com/gemini-hackathon/app/internal/thumbnail"
+)
+
+func main() {
+	batchSize := flag.Int("batch-size", 100, "number of scans loaded per query")
+	force := flag.Bool("force", false, "regenerate thumbnails that already exist")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	db, err := sql.Open("postgres", cfg.DBConnectionString)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatalf("Failed to ping database: %v", err)
+	}
+
+	storageDB := storage.NewPostgresDB(db)
+
+	fileStorage, err := storage.NewFileStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create file storage: %v", err)
+	}
+
+	generator := thumbnail.NewGenerator(storageDB, fileStorage, imageproc.NewProcessor(imageproc.Options{}), cfg.ThumbnailSizes)
+
+	ctx := context.Background()
+	var generated, skipped, failed int
+	var afterID int64
+
+	for {
+		scans, err := storageDB.GetScansAfterID(ctx, afterID, *batchSize)
+		if err != nil {
+			log.Fatalf("Failed to list scans after %d: %v", afterID, err)
+		}
+		if len(scans) == 0 {
+			break
+		}
+		afterID = scans[len(scans)-1].ID
+
+		scanIDs := make([]int64, len(scans))
+		for i, scan := range scans {
+			scanIDs[i] = scan.ID
+		}
+
+		existing, err := storageDB.GetScanThumbnails(ctx, scanIDs)
+		if err != nil {
+			log.Fatalf("Failed to load thumbnails: %v", err)
+		}
+
+		for _, scan := range scans {
+			if !*force && hasAllSizes(existing[scan.ID], cfg.ThumbnailSizes) {
+				skipped++
+				continue
+			}
+
+			imagePath := scan.ImageURL
+			if imagePath == "" && cfg.StorageBackend != "s3" {
+				imagePath, err = resolveLegacyImage(ctx, storageDB, cfg.UploadDir, scan.ID)
+				if err != nil {
+					log.Printf("Failed to resolve image for scan %d: %v", scan.ID, err)
+					failed++
+					continue
+				}
+			}
+			if imagePath == "" {
+				skipped++
+				continue
+			}
+
+			imageData, err := fileStorage.OpenImage(imagePath)
+			if err != nil {
+				log.Printf("Failed to read image for scan %d: %v", scan.ID, err)
+				failed++
+				continue
+			}
+
+			if err := generator.Generate(ctx, scan.ID, imageData); err != nil {
+				log.Printf("Failed to generate thumbnails for scan %d: %v", scan.ID, err)
+				failed++
+				continue
+			}
+
+			generated++
+		}
+	}
+
+	log.Printf("Done: generated=%d skipped=%d failed=%d", generated, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// legacyImageTypes are the formats the original upload handler saved as
+// <scanID><ext> in UPLOAD_DIR.
+var legacyImageTypes = []string{"image/jpeg", "image/png", "image/webp"}
+
+// resolveLegacyImage finds the file of a scan whose image_url was never
+// recorded and saves its path and hash on the scan and its first page. It
+// returns an empty path when there is no such file.
+func resolveLegacyImage(ctx context.Context, db storage.DB, uploadDir string, scanID int64) (string, error) {
+	for _, mimeType := range legacyImageTypes {
+		path := filepath.Join(uploadDir, storage.ImageFilename(strconv.FormatInt(scanID, 10), mimeType))
+		file, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		hash, err := storage.CalculateSHA256(file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+
+		if err := db.UpdateScanImage(ctx, scanID, path, &hash); err != nil {
+			return "", err
+		}
+		if err := db.UpdateScanPageImage(ctx, scanID, 1, path, &hash); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	return "", nil
+}
+
+func hasAllSizes(thumbnails []*models.ScanThumbnail, sizes []int) bool {
+	have := make(map[int]bool, len(thumbnails))
+	for _, thumb := range thumbnails {
+		have[thumb.Size] = true
+	}
+	for _, size := range sizes {
+		if !have[size] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/imageproc"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+	"github.com/gemini-hackathon/app/internal/testutil"
+	"github.com/gemini-hackathon/app/internal/thumbnail"
+)
+
+func TestResolveLegacyImage(t *testing.T) {
+	ctx := context.Background()
+	uploadDir := t.TempDir()
+	mockDB := testutil.NewMockDB()
+
+	// A scan as the original upload handler left it: the file is named
+	// after the scan, but neither the scan nor its page records it.
+	scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, CreatedAt: time.Now()})
+	mockDB.CreateScanPage(ctx, &models.ScanPage{ScanID: scanID, PageNumber: 1, CreatedAt: time.Now()})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 48))); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	legacyPath := filepath.Join(uploadDir, strconv.FormatInt(scanID, 10)+".png")
+	if err := os.WriteFile(legacyPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write legacy image: %v", err)
+	}
+
+	path, err := resolveLegacyImage(ctx, mockDB, uploadDir, scanID)
+	if err != nil {
+		t.Fatalf("resolveLegacyImage() error = %v", err)
+	}
+	if path != legacyPath {
+		t.Errorf("resolveLegacyImage() = %q; want %q", path, legacyPath)
+	}
+
+	scan, _ := mockDB.GetScanByID(ctx, scanID)
+	if scan.ImageURL != legacyPath || scan.ImageHash == nil {
+		t.Errorf("scan image = %q (hash %v); want %q with a hash", scan.ImageURL, scan.ImageHash, legacyPath)
+	}
+	pages, _ := mockDB.GetScanPages(ctx, scanID)
+	if len(pages) != 1 || pages[0].ImageURL != legacyPath {
+		t.Errorf("page image not updated: %+v", pages)
+	}
+
+	fileStorage, err := storage.NewLocalFileStorage(uploadDir)
+	if err != nil {
+		t.Fatalf("Failed to create file storage: %v", err)
+	}
+	imageData, err := fileStorage.OpenImage(path)
+	if err != nil {
+		t.Fatalf("OpenImage() error = %v", err)
+	}
+	generator := thumbnail.NewGenerator(mockDB, fileStorage, imageproc.NewProcessor(imageproc.Options{}), []int{32})
+	if err := generator.Generate(ctx, scanID, imageData); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	thumbnails, _ := mockDB.GetScanThumbnails(ctx, []int64{scanID})
+	if !hasAllSizes(thumbnails[scanID], []int{32}) {
+		t.Errorf("thumbnails = %+v; want size 32", thumbnails[scanID])
+	}
+
+	// A scan without a file is left alone.
+	otherID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, CreatedAt: time.Now()})
+	if path, err := resolveLegacyImage(ctx, mockDB, uploadDir, otherID); err != nil || path != "" {
+		t.Errorf("resolveLegacyImage() = %q, %v; want no image", path, err)
+	}
+}
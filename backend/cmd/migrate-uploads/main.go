@@ -0,0 +1,214 @@
+// Command migrate-uploads copies scan images from the local UPLOAD_DIR into
+// the configured S3 bucket and points the scan, page, thumbnail and document
+// records at the new object keys.
+//
+// Usage:
+//
+//	go run ./cmd/migrate-uploads [-dry-run] [-delete-local]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/gemini-hackathon/app/internal/config"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "list the files that would be migrated without uploading")
+	deleteLocal := flag.Bool("delete-local", false, "remove local files after a successful upload")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	db, err := sql.Open("postgres", cfg.DBConnectionString)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatalf("Failed to ping database: %v", err)
+	}
+
+	storageDB := storage.NewPostgresDB(db)
+
+	s3Storage, err := storage.NewS3FileStorage(storage.S3OptionsFromConfig(cfg))
+	if err != nil {
+		log.Fatalf("Failed to create S3 storage: %v", err)
+	}
+
+	entries, err := os.ReadDir(cfg.UploadDir)
+	if err != nil {
+		log.Fatalf("Failed to read upload directory %s: %v", cfg.UploadDir, err)
+	}
+
+	ctx := context.Background()
+	var migrated, skipped, failed int
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		scanID, variant, ok := parseUploadName(strings.TrimSuffix(name, ext))
+		if !ok {
+			log.Printf("Skipping %s: file name is not a scan image", name)
+			skipped++
+			continue
+		}
+
+		if _, err := storageDB.GetScanByID(ctx, scanID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Skipping %s: scan %d does not exist", name, scanID)
+				skipped++
+				continue
+			}
+			log.Printf("Failed to look up scan %d: %v", scanID, err)
+			failed++
+			continue
+		}
+
+		localPath := filepath.Join(cfg.UploadDir, name)
+		if *dryRun {
+			log.Printf("Would migrate %s -> scan %d", localPath, scanID)
+			continue
+		}
+
+		key, hash, err := migrateFile(s3Storage, localPath, scanID, variant, storage.MimeTypeFromExtension(ext))
+		if err != nil {
+			log.Printf("Failed to upload %s: %v", localPath, err)
+			failed++
+			continue
+		}
+
+		pageNumber, pageVariant := pageOfVariant(variant)
+		switch {
+		case ext == ".pdf":
+			err = storageDB.UpdateScanDocument(ctx, scanID, key)
+		case pageVariant == "original":
+			if pageNumber == 1 {
+				err = storageDB.UpdateScanOriginalImage(ctx, scanID, key)
+			}
+			if err == nil {
+				err = storageDB.UpdateScanPageOriginalImage(ctx, scanID, pageNumber, key)
+			}
+		case strings.HasPrefix(variant, "thumb-"):
+			size, _ := strconv.Atoi(strings.TrimPrefix(variant, "thumb-"))
+			err = storageDB.UpsertScanThumbnail(ctx, &models.ScanThumbnail{
+				ScanID:    scanID,
+				Size:      size,
+				ImageURL:  key,
+				ImageHash: hash,
+				CreatedAt: time.Now(),
+			})
+		default:
+			if pageNumber == 1 {
+				err = storageDB.UpdateScanImage(ctx, scanID, key, hash)
+			}
+			if err == nil {
+				err = storageDB.UpdateScanPageImage(ctx, scanID, pageNumber, key, hash)
+			}
+		}
+		if err != nil {
+			log.Printf("Uploaded %s but failed to update scan %d: %v", localPath, scanID, err)
+			failed++
+			continue
+		}
+
+		if *deleteLocal {
+			if err := os.Remove(localPath); err != nil {
+				log.Printf("Warning: failed to remove %s: %v", localPath, err)
+			}
+		}
+
+		log.Printf("Migrated %s -> %s", localPath, key)
+		migrated++
+	}
+
+	log.Printf("Done: migrated=%d skipped=%d failed=%d", migrated, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseUploadName splits a stored file name (without extension) such as
+// "42", "42-original", "42-p2", "42-p2-original" or "42-thumb-320" into the
+// scan ID and image variant.
+func parseUploadName(base string) (int64, string, bool) {
+	idPart, variant, _ := strings.Cut(base, "-")
+	scanID, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+
+	switch {
+	case variant == "", variant == "original":
+		return scanID, variant, true
+	case strings.HasPrefix(variant, "p"):
+		if pageNumber, _ := pageOfVariant(variant); pageNumber < 2 {
+			return 0, "", false
+		}
+		return scanID, variant, true
+	case strings.HasPrefix(variant, "thumb-"):
+		if _, err := strconv.Atoi(strings.TrimPrefix(variant, "thumb-")); err != nil {
+			return 0, "", false
+		}
+		return scanID, variant, true
+	default:
+		return 0, "", false
+	}
+}
+
+// pageOfVariant returns the page a variant belongs to and the variant within
+// that page, e.g. "p2-original" -> (2, "original"). Variants without a page
+// prefix belong to page 1; a malformed prefix yields page 0.
+func pageOfVariant(variant string) (int, string) {
+	if !strings.HasPrefix(variant, "p") {
+		return 1, variant
+	}
+
+	pagePart, rest, _ := strings.Cut(strings.TrimPrefix(variant, "p"), "-")
+	pageNumber, err := strconv.Atoi(pagePart)
+	if err != nil || (rest != "" && rest != "original") {
+		return 0, ""
+	}
+	return pageNumber, rest
+}
+
+func migrateFile(fileStorage storage.FileStorage, localPath string, scanID int64, variant, mimeType string) (string, *string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := strconv.FormatInt(scanID, 10)
+	if variant != "" {
+		name += "-" + variant
+	}
+
+	return fileStorage.SaveImageStream(name, file, info.Size(), mimeType)
+}
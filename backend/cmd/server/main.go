@@ -0,0 +1,193 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/gemini-hackathon/app/internal/auth"
+	"github.com/gemini-hackathon/app/internal/config"
+	"github.com/gemini-hackathon/app/internal/gemini"
+	"github.com/gemini-hackathon/app/internal/handlers"
+	"github.com/gemini-hackathon/app/internal/imageproc"
+	"github.com/gemini-hackathon/app/internal/knowledge"
+	"github.com/gemini-hackathon/app/internal/mail"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+	"github.com/gemini-hackathon/app/internal/thumbnail"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	db, err := sql.Open("postgres", cfg.DBConnectionString)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatalf("Failed to ping database: %v", err)
+	}
+
+	if err := storage.RunMigrations(db, "migrations"); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	storageDB := storage.NewPostgresDB(db)
+
+	fileStorage, err := storage.NewFileStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create file storage: %v", err)
+	}
+
+	// Redis holds OAuth states, magic links and the token denylist. While it
+	// is down they go to the fallback store instead.
+	var redisFallback storage.RedisClient
+	switch cfg.RedisFallback {
+	case "memory":
+		redisFallback = storage.NewMemoryClient()
+	case "postgres":
+		redisFallback = storage.NewPostgresKVClient(db)
+	}
+	redisClient := storage.NewFailoverClient(storage.OpenRedisClient(cfg.RedisAddr), redisFallback, cfg.RedisFallback,
+		time.Duration(cfg.RedisCheckInterval)*time.Second)
+	defer redisClient.Close()
+
+	geminiClient := gemini.NewClient(cfg.GeminiAPIKey)
+
+	imageProcessor := imageproc.NewProcessor(imageproc.Options{
+		MaxDimension:    cfg.ImageMaxDimension,
+		OutputFormat:    cfg.ImageOutputFormat,
+		JPEGQuality:     cfg.ImageJPEGQuality,
+		Deskew:          cfg.ImageDeskew,
+		EnhanceContrast: cfg.ImageEnhance,
+	})
+
+	// Load knowledge service for vocabulary lookup
+	var knowledgeSvc knowledge.Service
+	if cfg.KnowledgeCSVPath != "" {
+		var err error
+		knowledgeSvc, err = knowledge.NewService(cfg.KnowledgeCSVPath)
+		if err != nil {
+			log.Printf("Warning: Failed to load knowledge CSV from %s: %v. Continuing without knowledge context.", cfg.KnowledgeCSVPath, err)
+			knowledgeSvc = knowledge.NewEmptyService()
+		} else {
+			log.Printf("Loaded knowledge CSV from %s", cfg.KnowledgeCSVPath)
+		}
+	} else {
+		knowledgeSvc = knowledge.NewEmptyService()
+	}
+
+	thumbnailGenerator := thumbnail.NewGenerator(storageDB, fileStorage, imageProcessor, cfg.ThumbnailSizes)
+
+	jwtKeys, err := auth.LoadKeySet(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	tokenService := auth.NewTokenServiceWithKeys(jwtKeys, cfg.TokenExpiryMinutes).WithDenylist(redisClient)
+	urlSigner := auth.NewURLSigner(cfg.SignedURLSecret, cfg.SignedURLExpiryMinutes)
+
+	providers := []auth.Provider{auth.NewGoogleOAuthService(cfg)}
+	if cfg.GitHubOAuthClientID != "" {
+		providers = append(providers, auth.NewGitHubProvider(cfg))
+	}
+	if cfg.AppleClientID != "" {
+		appleProvider, err := auth.NewAppleProvider(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up Sign in with Apple: %v", err)
+		}
+		providers = append(providers, appleProvider)
+	}
+
+	sessionService := auth.NewSessionService(storageDB, tokenService, cfg.RefreshTokenExpiryDays)
+
+	magicLinks := auth.NewMagicLinkService(redisClient, mail.NewSender(cfg), cfg.AppBaseURL, cfg.MagicLinkExpiryMinutes)
+
+	authHandlers := handlers.NewAuthHandlers(providers, redisClient, tokenService, sessionService, storageDB, cfg).WithMagicLinks(magicLinks)
+	userHandlers := handlers.NewUserHandlers(storageDB)
+	scanHandlers := handlers.NewScanHandlers(storageDB, fileStorage, geminiClient, knowledgeSvc, imageProcessor, thumbnailGenerator, urlSigner, cfg)
+	aiHandlers := handlers.NewAIHandlers(storageDB, geminiClient, knowledgeSvc)
+	annotationHandlers := handlers.NewAnnotationHandlers(storageDB, geminiClient, knowledgeSvc, cfg)
+	healthHandlers := handlers.NewHealthHandlers(storageDB, redisClient)
+	adminHandlers := handlers.NewAdminHandlers(storageDB, tokenService, cfg)
+	orgHandlers := handlers.NewOrgHandlers(storageDB)
+
+	authMiddleware := middleware.NewAuthMiddleware(tokenService).WithURLSigner(urlSigner).WithUsers(storageDB)
+	adminOnly := middleware.RequireRole(models.RoleAdmin)
+	reviewers := middleware.RequireRole(models.RoleAdmin, models.RoleContentEditor)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", healthHandlers.ReadyzAPI)
+
+	mux.HandleFunc("/.well-known/jwks.json", authHandlers.JWKSAPI)
+	mux.HandleFunc("/v1/auth/", authHandlers.OAuthAPI)
+	mux.HandleFunc("/v1/auth/email/start", authHandlers.MagicLinkStartAPI)
+	mux.HandleFunc("/v1/auth/email/verify", authHandlers.MagicLinkVerifyAPI)
+	mux.HandleFunc("/v1/auth/refresh", authHandlers.RefreshAPI)
+	mux.HandleFunc("/v1/auth/logout", authHandlers.LogoutAPI)
+
+	authMux := http.NewServeMux()
+	authMux.HandleFunc("/v1/users/me/languages", userHandlers.GetLanguagesAPI)
+	authMux.HandleFunc("/v1/users/me", userHandlers.UsersMeAPI)
+	authMux.HandleFunc("/v1/users/me/sessions", authHandlers.SessionsAPI)
+	authMux.HandleFunc("/v1/users/me/sessions/", authHandlers.SessionAPI)
+	authMux.HandleFunc("/v1/users/me/identities", authHandlers.IdentitiesAPI)
+	authMux.HandleFunc("/v1/users/me/identities/", authHandlers.IdentityAPI)
+	authMux.HandleFunc("/v1/scans", scanHandlers.ScansAPI)
+	authMux.HandleFunc("/v1/scans/batch", scanHandlers.CreateScanBatchAPI)
+	authMux.HandleFunc("/v1/scans/", scanHandlers.ScanAPI)
+	authMux.HandleFunc("/v1/ai/analyze", aiHandlers.AnalyzeAPI)
+	authMux.HandleFunc("/v1/ai/analyze/stream", aiHandlers.AnalyzeStreamAPI)
+	authMux.HandleFunc("/v1/annotations", annotationHandlers.AnnotationsAPI)
+	authMux.HandleFunc("/v1/annotations/", annotationHandlers.AnnotationAPI)
+	authMux.HandleFunc("/v1/orgs", orgHandlers.OrgsAPI)
+	authMux.HandleFunc("/v1/orgs/", orgHandlers.OrgAPI)
+	authMux.HandleFunc("/v1/invitations", orgHandlers.InvitationsAPI)
+	authMux.HandleFunc("/v1/invitations/", orgHandlers.InvitationAPI)
+	authMux.Handle("/v1/admin/users", adminOnly(http.HandlerFunc(adminHandlers.UsersAPI)))
+	authMux.Handle("/v1/admin/users/", adminOnly(http.HandlerFunc(adminHandlers.UserAPI)))
+	authMux.Handle("/v1/admin/stats", adminOnly(http.HandlerFunc(adminHandlers.StatsAPI)))
+	authMux.Handle("/v1/admin/audit", adminOnly(http.HandlerFunc(adminHandlers.AuditAPI)))
+	authMux.Handle("/v1/admin/scans/failed", reviewers(http.HandlerFunc(adminHandlers.FailedScansAPI)))
+
+	mux.Handle("/v1/", authMiddleware.Handle(authMux))
+
+	reactFS := http.FileServer(http.Dir("web/dist"))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/") || strings.HasPrefix(r.URL.Path, "/healthz") {
+			http.NotFound(w, r)
+			return
+		}
+
+		if _, err := os.Stat("web/dist/index.html"); err == nil {
+			if r.URL.Path != "/" && !strings.HasPrefix(r.URL.Path, "/v1/") && r.URL.Path != "/healthz" {
+				r.URL.Path = "/"
+			}
+			reactFS.ServeHTTP(w, r)
+		} else {
+			http.Error(w, "Frontend not built. Run: cd web && bun run build", http.StatusServiceUnavailable)
+		}
+	})
+
+	handler := middleware.LoggingMiddleware(mux)
+
+	log.Printf("Server starting on :%s", cfg.Port)
+	if err := http.ListenAndServe(":"+cfg.Port, handler); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+
+	"github.com/gemini-hackathon/app/internal/config"
+)
+
+// AppleProvider signs users in with Sign in with Apple. The client secret is
+// a short-lived JWT signed with the team's private key, and the account comes
+// from the ID token, since Apple has no user info endpoint.
+type AppleProvider struct {
+	config     *oauth2.Config
+	issuer     string
+	teamID     string
+	keyID      string
+	privateKey *ecdsa.PrivateKey
+	verifier   *oidcVerifier
+}
+
+func NewAppleProvider(cfg *config.Config) (*AppleProvider, error) {
+	block, err := readPEM(cfg.ApplePrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Apple private key: %w", err)
+	}
+	privateKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apple private key must be an EC key")
+	}
+
+	issuer := strings.TrimSuffix(cfg.AppleIssuer, "/")
+	return &AppleProvider{
+		config: &oauth2.Config{
+			ClientID:    cfg.AppleClientID,
+			RedirectURL: callbackURL(cfg.AppBaseURL, "apple"),
+			Scopes:      []string{"name", "email"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   issuer + "/auth/authorize",
+				TokenURL:  issuer + "/auth/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		issuer:     issuer,
+		teamID:     cfg.AppleTeamID,
+		keyID:      cfg.AppleKeyID,
+		privateKey: privateKey,
+		verifier:   newOIDCVerifier(cfg.AppleClientID, issuer+"/auth/keys", issuer),
+	}, nil
+}
+
+func (p *AppleProvider) Name() string {
+	return "apple"
+}
+
+// GetAuthURL asks Apple to post the code back to the callback, which it
+// requires when the name or email scope is requested. Apple does not support
+// PKCE; the nonce binds the ID token to the sign-in instead.
+func (p *AppleProvider) GetAuthURL(state string, req *AuthRequest) string {
+	return p.config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("response_mode", "form_post"),
+		oauth2.SetAuthURLParam("nonce", req.Nonce),
+	)
+}
+
+func (p *AppleProvider) ExchangeCode(ctx context.Context, code string, req *AuthRequest) (*oauth2.Token, error) {
+	secret, err := p.clientSecret()
+	if err != nil {
+		return nil, err
+	}
+	config := *p.config
+	config.ClientSecret = secret
+	return config.Exchange(ctx, code)
+}
+
+func (p *AppleProvider) GetUserInfo(ctx context.Context, token *oauth2.Token, req *AuthRequest) (*UserInfo, error) {
+	claims, err := p.verifier.VerifyToken(ctx, token, req.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Email == "" {
+		return nil, errors.New("apple ID token has no email")
+	}
+	return &UserInfo{
+		ID:            claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+	}, nil
+}
+
+// clientSecret returns the JWT Apple accepts as client secret.
+func (p *AppleProvider) clientSecret() (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Issuer:    p.teamID,
+		Subject:   p.config.ClientID,
+		Audience:  jwt.ClaimStrings{p.issuer},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+	})
+	token.Header["kid"] = p.keyID
+
+	secret, err := token.SignedString(p.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign Apple client secret: %w", err)
+	}
+	return secret, nil
+}
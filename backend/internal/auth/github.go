@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/gemini-hackathon/app/internal/config"
+)
+
+// GitHubProvider signs users in with GitHub OAuth apps. GitHub is not an
+// OpenID provider, so the account comes from its REST API.
+type GitHubProvider struct {
+	config *oauth2.Config
+	apiURL string
+}
+
+func NewGitHubProvider(cfg *config.Config) *GitHubProvider {
+	baseURL := strings.TrimSuffix(cfg.GitHubBaseURL, "/")
+	return &GitHubProvider{
+		config: &oauth2.Config{
+			ClientID:     cfg.GitHubOAuthClientID,
+			ClientSecret: cfg.GitHubOAuthClientSecret,
+			RedirectURL:  callbackURL(cfg.AppBaseURL, "github"),
+			Scopes:       []string{"read:user", "user:email"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  baseURL + "/login/oauth/authorize",
+				TokenURL: baseURL + "/login/oauth/access_token",
+			},
+		},
+		apiURL: strings.TrimSuffix(cfg.GitHubAPIURL, "/"),
+	}
+}
+
+func (p *GitHubProvider) Name() string {
+	return "github"
+}
+
+func (p *GitHubProvider) GetAuthURL(state string, req *AuthRequest) string {
+	return p.config.AuthCodeURL(state, oauth2.S256ChallengeOption(req.CodeVerifier))
+}
+
+func (p *GitHubProvider) ExchangeCode(ctx context.Context, code string, req *AuthRequest) (*oauth2.Token, error) {
+	return p.config.Exchange(ctx, code, oauth2.VerifierOption(req.CodeVerifier))
+}
+
+// GetUserInfo returns the GitHub account with its primary email, falling
+// back to any verified email. Accounts without one are refused, since every
+// user needs an email.
+func (p *GitHubProvider) GetUserInfo(ctx context.Context, token *oauth2.Token, req *AuthRequest) (*UserInfo, error) {
+	client := p.config.Client(ctx, token)
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		Email     string `json:"email"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := p.get(ctx, client, "/user", &user); err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(ctx, client, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	info := &UserInfo{
+		ID:         strconv.FormatInt(user.ID, 10),
+		Name:       user.Name,
+		PictureURL: user.AvatarURL,
+		Email:      user.Email,
+	}
+	if info.Name == "" {
+		info.Name = user.Login
+	}
+	for _, email := range emails {
+		if email.Verified && (email.Primary || !info.EmailVerified) {
+			info.Email = email.Email
+			info.EmailVerified = true
+		}
+	}
+	if info.Email == "" {
+		return nil, errors.New("github account has no email address")
+	}
+	return info, nil
+}
+
+func (p *GitHubProvider) get(ctx context.Context, client *http.Client, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("github API %s returned status %d", path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return nil
+}
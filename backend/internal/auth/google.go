@@ -2,94 +2,75 @@ package auth
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"net/http"
+	"errors"
 	"strings"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 
 	"github.com/gemini-hackathon/app/internal/config"
-	"github.com/gemini-hackathon/app/internal/storage"
 )
 
-type GoogleOAuthService struct {
-	config      *oauth2.Config
-	redisClient storage.RedisClient
-	appBaseURL  string
-}
+// Google signs ID tokens with the keys published here, under either
+// spelling of its issuer.
+const googleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
 
-type UserInfo struct {
-	Email      string
-	ID         string
-	Name       string
-	PictureURL string
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// GoogleOAuthService signs users in with Google's OpenID Connect flow. The
+// account comes from the verified ID token.
+type GoogleOAuthService struct {
+	config     *oauth2.Config
+	appBaseURL string
+	verifier   *oidcVerifier
 }
 
-func NewGoogleOAuthService(cfg *config.Config, redis storage.RedisClient) *GoogleOAuthService {
+func NewGoogleOAuthService(cfg *config.Config) *GoogleOAuthService {
 	oauthConfig := &oauth2.Config{
 		ClientID:     cfg.GoogleOAuthClientID,
 		ClientSecret: cfg.GoogleOAuthClientSecret,
-		RedirectURL:  fmt.Sprintf("%s/v1/auth/google/callback", cfg.AppBaseURL),
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-		},
-		Endpoint: google.Endpoint,
+		RedirectURL:  callbackURL(cfg.AppBaseURL, "google"),
+		Scopes:       []string{"openid", "email", "profile"},
+		Endpoint:     google.Endpoint,
 	}
 
 	return &GoogleOAuthService{
-		config:      oauthConfig,
-		redisClient: redis,
-		appBaseURL:  cfg.AppBaseURL,
+		config:     oauthConfig,
+		appBaseURL: cfg.AppBaseURL,
+		verifier:   newOIDCVerifier(cfg.GoogleOAuthClientID, googleJWKSURL, googleIssuers...),
 	}
 }
 
-func (s *GoogleOAuthService) GetAuthURL(state string) string {
-	return s.config.AuthCodeURL(state, oauth2.SetAuthURLParam("prompt", "consent"))
+func (s *GoogleOAuthService) Name() string {
+	return "google"
 }
 
-func (s *GoogleOAuthService) ExchangeCode(ctx context.Context, code string) (*oauth2.Token, error) {
-	return s.config.Exchange(ctx, code)
+func (s *GoogleOAuthService) GetAuthURL(state string, req *AuthRequest) string {
+	return s.config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("prompt", "consent"),
+		oauth2.SetAuthURLParam("nonce", req.Nonce),
+		oauth2.S256ChallengeOption(req.CodeVerifier),
+	)
 }
 
-func (s *GoogleOAuthService) GetUserInfo(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
-	client := s.config.Client(ctx, token)
-	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user info: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("google user info API returned status %d", resp.StatusCode)
-	}
+func (s *GoogleOAuthService) ExchangeCode(ctx context.Context, code string, req *AuthRequest) (*oauth2.Token, error) {
+	return s.config.Exchange(ctx, code, oauth2.VerifierOption(req.CodeVerifier))
+}
 
-	var data struct {
-		Email     string `json:"email"`
-		ID        string `json:"id"`
-		Name      string `json:"name"`
-		Picture   string `json:"picture"`
-		LocalPart string `json:"local_part"`
+func (s *GoogleOAuthService) GetUserInfo(ctx context.Context, token *oauth2.Token, req *AuthRequest) (*UserInfo, error) {
+	claims, err := s.verifier.VerifyToken(ctx, token, req.Nonce)
+	if err != nil {
+		return nil, err
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, fmt.Errorf("failed to decode user info: %w", err)
-	}
-
-	if data.Email == "" && data.LocalPart != "" {
-		data.Email = data.LocalPart + "@gmail.com"
+	if claims.Email == "" {
+		return nil, errors.New("google ID token has no email")
 	}
 
 	return &UserInfo{
-		Email:      data.Email,
-		ID:         data.ID,
-		Name:       data.Name,
-		PictureURL: strings.ReplaceAll(data.Picture, "=s96-c", "=s200-c"),
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		ID:            claims.Subject,
+		Name:          claims.Name,
+		PictureURL:    strings.ReplaceAll(claims.Picture, "=s96-c", "=s200-c"),
 	}, nil
 }
-
-func (s *GoogleOAuthService) RedisClient() storage.RedisClient {
-	return s.redisClient
-}
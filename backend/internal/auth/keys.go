@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/gemini-hackathon/app/internal/config"
+)
+
+// Supported JWT signing algorithms.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is a key tokens are signed or verified with. Keys loaded from a
+// public key can only verify.
+type SigningKey struct {
+	ID        string
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+}
+
+// NewHMACKey returns an HS256 key. The same secret signs and verifies.
+func NewHMACKey(id, secret string) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+}
+
+// LoadPrivateKey reads a PEM private key (PKCS#8, or PKCS#1 for RSA) for
+// alg, which is RS256 or EdDSA.
+func LoadPrivateKey(id, alg, path string) (*SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key in %s", path)
+	}
+	signingKey, err := newAsymmetricKey(id, alg, signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	signingKey.signKey = key
+	return signingKey, nil
+}
+
+// LoadPublicKey reads a PEM public key (PKIX) for alg, which is RS256 or
+// EdDSA. The key can only verify tokens.
+func LoadPublicKey(id, alg, path string) (*SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	signingKey, err := newAsymmetricKey(id, alg, key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return signingKey, nil
+}
+
+func newAsymmetricKey(id, alg string, public crypto.PublicKey) (*SigningKey, error) {
+	switch alg {
+	case AlgRS256:
+		if _, ok := public.(*rsa.PublicKey); !ok {
+			return nil, errors.New("RS256 needs an RSA key")
+		}
+		return &SigningKey{ID: id, method: jwt.SigningMethodRS256, verifyKey: public}, nil
+	case AlgEdDSA:
+		if _, ok := public.(ed25519.PublicKey); !ok {
+			return nil, errors.New("EdDSA needs an Ed25519 key")
+		}
+		return &SigningKey{ID: id, method: jwt.SigningMethodEdDSA, verifyKey: public}, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", alg)
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens are
+// still accepted from. Rotating keys means making a new key current while
+// the old one stays in the set until its tokens have expired.
+type KeySet struct {
+	current *SigningKey
+	keys    map[string]*SigningKey
+}
+
+// NewKeySet returns a set signing with current and also verifying with
+// others. Key IDs must be unique.
+func NewKeySet(current *SigningKey, others ...*SigningKey) (*KeySet, error) {
+	if current.signKey == nil {
+		return nil, fmt.Errorf("key %q cannot sign", current.ID)
+	}
+
+	set := &KeySet{current: current, keys: make(map[string]*SigningKey)}
+	for _, key := range append([]*SigningKey{current}, others...) {
+		if _, ok := set.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		set.keys[key.ID] = key
+	}
+	return set, nil
+}
+
+// LoadKeySet builds the key set from JWT_SIGNING_ALG, JWT_KEY_ID, JWT_SECRET
+// or JWT_PRIVATE_KEY_FILE, and JWT_VERIFY_KEYS.
+func LoadKeySet(cfg *config.Config) (*KeySet, error) {
+	var current *SigningKey
+	if cfg.JWTSigningAlg == AlgHS256 {
+		current = NewHMACKey(cfg.JWTKeyID, cfg.JWTSecret)
+	} else {
+		var err error
+		current, err = LoadPrivateKey(cfg.JWTKeyID, cfg.JWTSigningAlg, cfg.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var others []*SigningKey
+	for _, entry := range cfg.JWTVerifyKeys {
+		key, err := parseVerifyKey(entry)
+		if err != nil {
+			return nil, err
+		}
+		others = append(others, key)
+	}
+	return NewKeySet(current, others...)
+}
+
+// parseVerifyKey parses a JWT_VERIFY_KEYS entry: "kid:HS256:secret" or
+// "kid:RS256:/path/to/public.pem" (likewise EdDSA).
+func parseVerifyKey(entry string) (*SigningKey, error) {
+	parts := strings.SplitN(entry, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("JWT_VERIFY_KEYS entry %q must be kid:alg:secret-or-path", parts[0])
+	}
+	if parts[1] == AlgHS256 {
+		return NewHMACKey(parts[0], parts[2]), nil
+	}
+	return LoadPublicKey(parts[0], parts[1], parts[2])
+}
+
+// key returns the key for a token's kid header. Tokens issued before key IDs
+// were introduced have none and are checked against the current key.
+func (s *KeySet) key(token *jwt.Token) (*SigningKey, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := s.current
+	if kid != "" {
+		key = s.keys[kid]
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set, current key first. HMAC keys are
+// secret and never listed.
+func (s *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	if jwk, ok := toJWK(s.current); ok {
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	var ids []string
+	for id := range s.keys {
+		if id != s.current.ID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if jwk, ok := toJWK(s.keys[id]); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+func toJWK(key *SigningKey) (JWK, bool) {
+	jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.method.Alg()}
+	switch public := key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// jwksRefreshInterval limits how often an unknown kid makes the verifier
+// download the provider's keys again.
+const jwksRefreshInterval = time.Minute
+
+// IDTokenClaims are the OpenID Connect ID token claims the app uses.
+type IDTokenClaims struct {
+	Email         string   `json:"email"`
+	EmailVerified jsonBool `json:"email_verified"`
+	Nonce         string   `json:"nonce"`
+	Name          string   `json:"name"`
+	Picture       string   `json:"picture"`
+	jwt.RegisteredClaims
+}
+
+// jsonBool accepts both true and "true"; Apple sends booleans as strings.
+type jsonBool bool
+
+func (b *jsonBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`, "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+// oidcVerifier checks ID tokens issued by one provider to one client,
+// fetching the provider's signing keys from its JWKS URL as needed. Some
+// providers use more than one spelling of their issuer.
+type oidcVerifier struct {
+	issuers  []string
+	clientID string
+	jwksURL  string
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]any
+	fetchedAt time.Time
+}
+
+func newOIDCVerifier(clientID, jwksURL string, issuers ...string) *oidcVerifier {
+	return &oidcVerifier{
+		issuers:  issuers,
+		clientID: clientID,
+		jwksURL:  jwksURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// VerifyToken verifies the ID token of a token response.
+func (v *oidcVerifier) VerifyToken(ctx context.Context, token *oauth2.Token, nonce string) (*IDTokenClaims, error) {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	return v.Verify(ctx, rawIDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience and expiry of rawIDToken,
+// and that it carries nonce, the one sent in the auth request.
+func (v *oidcVerifier) Verify(ctx context.Context, rawIDToken, nonce string) (*IDTokenClaims, error) {
+	var claims IDTokenClaims
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithAudience(v.clientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if !slices.Contains(v.issuers, claims.Issuer) {
+		return nil, fmt.Errorf("invalid ID token: unexpected issuer %q", claims.Issuer)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: missing subject")
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	return &claims, nil
+}
+
+func (v *oidcVerifier) key(ctx context.Context, kid string) (any, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+
+	keys, err := fetchJWKS(ctx, v.client, v.jwksURL)
+	v.fetchedAt = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// fetchJWKS downloads a JSON Web Key Set and returns its RSA and P-256 keys
+// by key ID. Keys of other types are skipped.
+func fetchJWKS(ctx context.Context, client *http.Client, jwksURL string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			Curve   string `json:"crv"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]any)
+	for _, jwk := range jwks.Keys {
+		switch {
+		case jwk.KeyType == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[jwk.KeyID] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case jwk.KeyType == "EC" && jwk.Curve == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[jwk.KeyID] = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+	return keys, nil
+}
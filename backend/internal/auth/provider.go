@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+// Provider is an OAuth identity provider users can sign in with. Sign-in
+// goes GetAuthURL, then ExchangeCode with the code from the callback, then
+// GetUserInfo, all with the same AuthRequest.
+type Provider interface {
+	// Name identifies the provider in routes and in users.provider.
+	Name() string
+	GetAuthURL(state string, req *AuthRequest) string
+	ExchangeCode(ctx context.Context, code string, req *AuthRequest) (*oauth2.Token, error)
+	GetUserInfo(ctx context.Context, token *oauth2.Token, req *AuthRequest) (*UserInfo, error)
+}
+
+// AuthRequest holds the secrets of one sign-in attempt, kept on the server
+// with its state until the callback. CodeVerifier is the PKCE verifier whose
+// challenge goes in the auth URL; Nonce must come back in the ID token of
+// OpenID providers.
+type AuthRequest struct {
+	CodeVerifier string `json:"codeVerifier"`
+	Nonce        string `json:"nonce"`
+}
+
+func NewAuthRequest() (*AuthRequest, error) {
+	nonce, err := randomToken(24)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthRequest{
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Nonce:        nonce,
+	}, nil
+}
+
+// UserInfo is the account a provider vouches for. ID is stable per provider;
+// EmailVerified says whether the provider confirmed the user owns Email.
+type UserInfo struct {
+	Email         string
+	EmailVerified bool
+	ID            string
+	Name          string
+	PictureURL    string
+}
+
+func callbackURL(appBaseURL, provider string) string {
+	return fmt.Sprintf("%s/v1/auth/%s/callback", appBaseURL, provider)
+}
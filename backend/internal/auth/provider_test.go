@@ -0,0 +1,250 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/gemini-hackathon/app/internal/auth"
+	"github.com/gemini-hackathon/app/internal/config"
+)
+
+// oidcStandIn is a local OpenID provider shaped like Sign in with Apple: it
+// publishes a JWKS and answers the token endpoint with an ID token.
+type oidcStandIn struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	form   chan map[string]string
+}
+
+func newOIDCStandIn(t *testing.T) *oidcStandIn {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	s := &oidcStandIn{key: key, form: make(chan map[string]string, 1)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test-key",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/auth/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.form <- map[string]string{
+			"code":          r.PostForm.Get("code"),
+			"client_id":     r.PostForm.Get("client_id"),
+			"client_secret": r.PostForm.Get("client_secret"),
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, s.claims)
+		token.Header["kid"] = "test-key"
+		idToken, _ := token.SignedString(key)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestAppleProvider(t *testing.T) {
+	server := newOIDCStandIn(t)
+
+	teamKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(teamKey)
+	keyPath := filepath.Join(t.TempDir(), "AuthKey.p8")
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	provider, err := auth.NewAppleProvider(&config.Config{
+		AppBaseURL:          "http://app.test",
+		AppleClientID:       "com.example.app",
+		AppleTeamID:         "TEAM123",
+		AppleKeyID:          "KEY123",
+		ApplePrivateKeyFile: keyPath,
+		AppleIssuer:         server.URL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	authReq, err := auth.NewAuthRequest()
+	if err != nil {
+		t.Fatalf("Failed to create auth request: %v", err)
+	}
+	signIn := func(claims jwt.MapClaims) (*auth.UserInfo, error) {
+		server.claims = claims
+		token, err := provider.ExchangeCode(context.Background(), "the-code", authReq)
+		if err != nil {
+			t.Fatalf("Failed to exchange code: %v", err)
+		}
+		<-server.form
+		return provider.GetUserInfo(context.Background(), token, authReq)
+	}
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            server.URL,
+			"aud":            "com.example.app",
+			"sub":            "001234.abcd",
+			"email":          "user@privaterelay.appleid.com",
+			"email_verified": "true",
+			"nonce":          authReq.Nonce,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+		}
+	}
+
+	t.Run("AuthURL", func(t *testing.T) {
+		u := provider.GetAuthURL("xyz", authReq)
+		for _, want := range []string{server.URL + "/auth/authorize?", "response_mode=form_post", "state=xyz", "client_id=com.example.app", "nonce=" + authReq.Nonce} {
+			if !strings.Contains(u, want) {
+				t.Errorf("Expected auth URL to contain %q, got %s", want, u)
+			}
+		}
+	})
+
+	t.Run("SignsClientSecret", func(t *testing.T) {
+		server.claims = validClaims()
+		if _, err := provider.ExchangeCode(context.Background(), "the-code", authReq); err != nil {
+			t.Fatalf("Failed to exchange code: %v", err)
+		}
+		form := <-server.form
+
+		var claims jwt.RegisteredClaims
+		_, err := jwt.ParseWithClaims(form["client_secret"], &claims, func(token *jwt.Token) (interface{}, error) {
+			if token.Header["kid"] != "KEY123" {
+				t.Errorf("Expected kid KEY123, got %v", token.Header["kid"])
+			}
+			return &teamKey.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"}))
+		if err != nil {
+			t.Fatalf("Client secret does not verify: %v", err)
+		}
+		if claims.Issuer != "TEAM123" || claims.Subject != "com.example.app" {
+			t.Errorf("Unexpected client secret claims: %+v", claims)
+		}
+		if form["code"] != "the-code" || form["client_id"] != "com.example.app" {
+			t.Errorf("Unexpected token request: %v", form)
+		}
+	})
+
+	t.Run("UserInfoFromIDToken", func(t *testing.T) {
+		info, err := signIn(validClaims())
+		if err != nil {
+			t.Fatalf("Failed to get user info: %v", err)
+		}
+		if info.ID != "001234.abcd" || info.Email != "user@privaterelay.appleid.com" || !info.EmailVerified {
+			t.Errorf("Unexpected user info: %+v", info)
+		}
+	})
+
+	t.Run("RejectsWrongAudience", func(t *testing.T) {
+		claims := validClaims()
+		claims["aud"] = "com.example.other"
+		if _, err := signIn(claims); err == nil {
+			t.Error("Expected an ID token for another client to be rejected")
+		}
+	})
+
+	t.Run("RejectsWrongNonce", func(t *testing.T) {
+		claims := validClaims()
+		claims["nonce"] = "replayed"
+		if _, err := signIn(claims); err == nil {
+			t.Error("Expected an ID token from another sign-in to be rejected")
+		}
+		delete(claims, "nonce")
+		if _, err := signIn(claims); err == nil {
+			t.Error("Expected an ID token without nonce to be rejected")
+		}
+	})
+
+	t.Run("RejectsExpiredToken", func(t *testing.T) {
+		claims := validClaims()
+		claims["exp"] = time.Now().Add(-time.Minute).Unix()
+		if _, err := signIn(claims); err == nil {
+			t.Error("Expected an expired ID token to be rejected")
+		}
+	})
+}
+
+func TestGitHubProvider(t *testing.T) {
+	authReq, _ := auth.NewAuthRequest()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code_verifier") != authReq.CodeVerifier {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "gh-token", "token_type": "bearer"}`))
+	})
+	mux.HandleFunc("/api/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id": 583231, "login": "octocat", "name": "", "email": null, "avatar_url": "https://avatars.example/u/583231"}`))
+	})
+	mux.HandleFunc("/api/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "octocat@example.com", "primary": true, "verified": true},
+			{"email": "new@example.com", "primary": false, "verified": false}
+		]`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider := auth.NewGitHubProvider(&config.Config{
+		AppBaseURL:              "http://app.test",
+		GitHubOAuthClientID:     "client",
+		GitHubOAuthClientSecret: "secret",
+		GitHubBaseURL:           server.URL,
+		GitHubAPIURL:            server.URL + "/api",
+	})
+
+	if u := provider.GetAuthURL("xyz", authReq); !strings.Contains(u, "code_challenge_method=S256") {
+		t.Errorf("Expected a PKCE challenge in the auth URL, got %s", u)
+	}
+	token, err := provider.ExchangeCode(context.Background(), "code", authReq)
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+	info, err := provider.GetUserInfo(context.Background(), token, authReq)
+	if err != nil {
+		t.Fatalf("Failed to get user info: %v", err)
+	}
+	if info.ID != "583231" || info.Name != "octocat" || info.PictureURL == "" {
+		t.Errorf("Unexpected user info: %+v", info)
+	}
+	if info.Email != "octocat@example.com" || !info.EmailVerified {
+		t.Errorf("Expected the primary verified email, got %q (verified %v)", info.Email, info.EmailVerified)
+	}
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+	// ErrRefreshTokenReused means a token that was already exchanged was
+	// presented again. The token may have been stolen, so its whole
+	// session has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// SessionService issues access tokens together with rotating refresh tokens.
+// Every refresh token is single-use: exchanging it revokes it and returns a
+// new one of the same family, so a family identifies one signed-in device.
+type SessionService struct {
+	db     storage.DB
+	tokens *TokenService
+	expiry time.Duration
+}
+
+func NewSessionService(db storage.DB, tokens *TokenService, refreshExpiryDays int) *SessionService {
+	return &SessionService{
+		db:     db,
+		tokens: tokens,
+		expiry: time.Duration(refreshExpiryDays) * 24 * time.Hour,
+	}
+}
+
+// Client describes the device a session was started from.
+type Client struct {
+	UserAgent string
+	IPAddress string
+}
+
+// TokenPair is the result of signing in or refreshing.
+type TokenPair struct {
+	SessionID        string
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// Start begins a new session for userID.
+func (s *SessionService) Start(ctx context.Context, userID int64, client Client) (*TokenPair, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, record, err := s.newRefreshToken(userID, familyID, time.Now(), client)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.CreateRefreshToken(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return s.tokenPair(record, refreshToken)
+}
+
+// Refresh exchanges refreshToken for a new token pair of the same session.
+// It returns ErrRefreshTokenInvalid for unknown, expired or revoked tokens
+// and ErrRefreshTokenReused, after revoking the session, for tokens that
+// were already exchanged.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string, client Client) (*TokenPair, error) {
+	current, err := s.db.GetRefreshTokenByHash(ctx, HashToken(refreshToken))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if current == nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if current.ReplacedBy != nil {
+		return nil, s.revokeReused(ctx, current)
+	}
+	if current.RevokedAt != nil || !time.Now().Before(current.ExpiresAt) {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	nextToken, next, err := s.newRefreshToken(current.UserID, current.FamilyID, current.SessionStartedAt, client)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.RotateRefreshToken(ctx, current.ID, next); err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenRevoked) {
+			// Another request exchanged the token first.
+			return nil, s.revokeReused(ctx, current)
+		}
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	return s.tokenPair(next, nextToken)
+}
+
+// Revoke ends the session refreshToken belongs to. Unknown tokens are
+// ignored, so signing out twice is not an error.
+func (s *SessionService) Revoke(ctx context.Context, refreshToken string) error {
+	current, err := s.db.GetRefreshTokenByHash(ctx, HashToken(refreshToken))
+	if err != nil {
+		return fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if current == nil {
+		return nil
+	}
+	if _, err := s.db.RevokeRefreshTokenFamily(ctx, current.UserID, current.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+func (s *SessionService) revokeReused(ctx context.Context, token *models.RefreshToken) error {
+	if _, err := s.db.RevokeRefreshTokenFamily(ctx, token.UserID, token.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return ErrRefreshTokenReused
+}
+
+func (s *SessionService) newRefreshToken(userID int64, familyID string, startedAt time.Time, client Client) (string, *models.RefreshToken, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	record := &models.RefreshToken{
+		UserID:           userID,
+		FamilyID:         familyID,
+		TokenHash:        HashToken(token),
+		SessionStartedAt: startedAt,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(s.expiry),
+	}
+	if client.UserAgent != "" {
+		record.UserAgent = &client.UserAgent
+	}
+	if client.IPAddress != "" {
+		record.IPAddress = &client.IPAddress
+	}
+	return token, record, nil
+}
+
+func (s *SessionService) tokenPair(record *models.RefreshToken, refreshToken string) (*TokenPair, error) {
+	accessToken, accessExpiresAt, err := s.tokens.GenerateSessionToken(record.UserID, record.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		SessionID:        record.FamilyID,
+		AccessToken:      accessToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: record.ExpiresAt,
+	}, nil
+}
+
+// HashToken returns the form a refresh token is stored and looked up
+// in.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
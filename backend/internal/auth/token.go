@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
@@ -8,39 +9,100 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	// ErrTokenRevoked is returned by Authenticate for tokens on the
+	// denylist.
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrDenylistUnavailable is returned by Authenticate when the denylist
+	// could not be checked.
+	ErrDenylistUnavailable = errors.New("token denylist unavailable")
+)
+
+// Denylist records revoked access tokens by their jti until they would have
+// expired anyway.
+type Denylist interface {
+	DenyToken(ctx context.Context, jti string, ttl time.Duration) error
+	IsTokenDenied(ctx context.Context, jti string) (bool, error)
+}
+
 type TokenService struct {
-	secret []byte
-	expiry time.Duration
+	keys     *KeySet
+	expiry   time.Duration
+	denylist Denylist
 }
 
+// NewTokenService signs with a single HS256 secret.
 func NewTokenService(secret string, expiryMinutes int) *TokenService {
+	keys, _ := NewKeySet(NewHMACKey("", secret))
+	return NewTokenServiceWithKeys(keys, expiryMinutes)
+}
+
+// NewTokenServiceWithKeys signs with the current key of keys and accepts
+// tokens from any key in it.
+func NewTokenServiceWithKeys(keys *KeySet, expiryMinutes int) *TokenService {
 	return &TokenService{
-		secret: []byte(secret),
+		keys:   keys,
 		expiry: time.Duration(expiryMinutes) * time.Minute,
 	}
 }
 
+// WithDenylist makes Authenticate reject tokens revoked with RevokeToken or
+// RevokeSession.
+func (s *TokenService) WithDenylist(denylist Denylist) *TokenService {
+	s.denylist = denylist
+	return s
+}
+
+// JWTClaims are the claims of an access token. SessionID is the refresh
+// token family the token was issued for, empty for tokens issued without one.
+// ImpersonatorID is the admin acting as the user, for impersonation tokens.
 type JWTClaims struct {
-	UserID int64 `json:"user_id"`
+	UserID         int64  `json:"user_id"`
+	SessionID      string `json:"sid,omitempty"`
+	ImpersonatorID int64  `json:"imp,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func (s *TokenService) GenerateToken(userID int64) (string, time.Time, error) {
+	return s.GenerateSessionToken(userID, "")
+}
+
+// GenerateSessionToken is GenerateToken for an access token that belongs to
+// the session sessionID.
+func (s *TokenService) GenerateSessionToken(userID int64, sessionID string) (string, time.Time, error) {
+	return s.generate(JWTClaims{UserID: userID, SessionID: sessionID}, s.expiry)
+}
+
+// GenerateImpersonationToken issues an access token for userID carrying
+// impersonatorID, valid for expiry. It has no session, so it cannot be
+// refreshed.
+func (s *TokenService) GenerateImpersonationToken(userID, impersonatorID int64, expiry time.Duration) (string, time.Time, error) {
+	return s.generate(JWTClaims{UserID: userID, ImpersonatorID: impersonatorID}, expiry)
+}
+
+func (s *TokenService) generate(claims JWTClaims, expiry time.Duration) (string, time.Time, error) {
 	now := time.Now()
-	expiresAt := now.Add(s.expiry)
+	expiresAt := now.Add(expiry)
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
 
-	claims := JWTClaims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiresAt),
-			IssuedAt:  jwt.NewNumericDate(now),
-			NotBefore: jwt.NewNumericDate(now),
-			Issuer:    "gemini-ocr-app",
-		},
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Issuer:    "gemini-ocr-app",
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(s.secret)
+	key := s.keys.current
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	tokenString, err := token.SignedString(key.signKey)
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -49,21 +111,100 @@ func (s *TokenService) GenerateToken(userID int64) (string, time.Time, error) {
 }
 
 func (s *TokenService) ValidateToken(tokenString string) (int64, error) {
+	claims, err := s.ParseToken(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
+
+// ParseToken validates tokenString and returns all of its claims. It does
+// not consult the denylist; see Authenticate.
+func (s *TokenService) ParseToken(tokenString string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		key, err := s.keys.key(token)
+		if err != nil {
+			return nil, err
 		}
-		return s.secret, nil
+		return key.verifyKey, nil
 	})
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	claims, ok := token.Claims.(*JWTClaims)
 	if !ok || !token.Valid {
-		return 0, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	return claims.UserID, nil
+	return claims, nil
+}
+
+// Authenticate is ParseToken that also rejects revoked tokens, and tokens of
+// revoked sessions, with ErrTokenRevoked, failing with ErrDenylistUnavailable
+// when it cannot tell.
+func (s *TokenService) Authenticate(ctx context.Context, tokenString string) (*JWTClaims, error) {
+	claims, err := s.ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if s.denylist == nil {
+		return claims, nil
+	}
+
+	for _, id := range []string{claims.ID, sessionDenylistID(claims.SessionID)} {
+		if id == "" {
+			continue
+		}
+		denied, err := s.denylist.IsTokenDenied(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDenylistUnavailable, err)
+		}
+		if denied {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// RevokeSession puts every access token issued for sessionID on the
+// denylist, for as long as the newest of them can still be valid.
+func (s *TokenService) RevokeSession(ctx context.Context, sessionID string) error {
+	if s.denylist == nil || sessionID == "" {
+		return nil
+	}
+	return s.denylist.DenyToken(ctx, sessionDenylistID(sessionID), s.expiry)
+}
+
+// sessionDenylistID is the denylist entry of a session, or "" for none. The
+// prefix keeps it apart from the jtis of single tokens.
+func sessionDenylistID(sessionID string) string {
+	if sessionID == "" {
+		return ""
+	}
+	return "sid:" + sessionID
+}
+
+// RevokeToken puts a valid token on the denylist for the rest of its
+// lifetime. Invalid and expired tokens need no revoking and are ignored.
+func (s *TokenService) RevokeToken(ctx context.Context, tokenString string) error {
+	if s.denylist == nil {
+		return nil
+	}
+	claims, err := s.ParseToken(tokenString)
+	if err != nil || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.denylist.DenyToken(ctx, claims.ID, ttl)
+}
+
+// JWKS returns the public keys tokens may be verified with.
+func (s *TokenService) JWKS() JWKS {
+	return s.keys.JWKS()
 }
@@ -0,0 +1,66 @@
+// Package authz checks that a stored resource belongs to the user asking
+// for it.
+package authz
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+)
+
+// ErrNotFound is returned for a resource that does not exist or belongs to
+// another user. Handlers answer 404 in both cases, so other users' IDs
+// cannot be probed.
+var ErrNotFound = errors.New("resource not found")
+
+// ErrNotOwner is returned for another user's resource. It matches
+// ErrNotFound and exists only so the attempt can be logged.
+var ErrNotOwner = fmt.Errorf("%w: belongs to another user", ErrNotFound)
+
+// Owned loads a resource with load and returns it if owner reports userID.
+// Other errors from load are returned unchanged.
+func Owned[T any](ctx context.Context, userID, id int64, load func(context.Context, int64) (*T, error), owner func(*T) int64) (*T, error) {
+	resource, err := load(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && resource == nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if owner(resource) != userID {
+		return nil, ErrNotOwner
+	}
+	return resource, nil
+}
+
+// Scan returns the user's scan with scanID.
+func Scan(ctx context.Context, db storage.DB, userID, scanID int64) (*models.Scan, error) {
+	return Owned(ctx, userID, scanID, db.GetScanByID, func(scan *models.Scan) int64 { return scan.UserID })
+}
+
+// Annotation returns the user's annotation with annotationID.
+func Annotation(ctx context.Context, db storage.DB, userID, annotationID int64) (*models.Annotation, error) {
+	return Owned(ctx, userID, annotationID, db.GetAnnotationByID, func(annotation *models.Annotation) int64 { return annotation.UserID })
+}
+
+// Analysis returns the user's analysis with analysisID.
+func Analysis(ctx context.Context, db storage.DB, userID, analysisID int64) (*models.Analysis, error) {
+	return Owned(ctx, userID, analysisID, db.GetAnalysisByID, func(analysis *models.Analysis) int64 { return analysis.UserID })
+}
+
+// OrgMember returns the user's membership of orgID, or ErrNotFound if the
+// user is not a member.
+func OrgMember(ctx context.Context, db storage.DB, userID, orgID int64) (*models.OrgMember, error) {
+	member, err := db.GetOrgMember(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, ErrNotFound
+	}
+	return member, nil
+}
@@ -0,0 +1,73 @@
+package authz_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/gemini-hackathon/app/internal/authz"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/testutil"
+)
+
+func TestOwned(t *testing.T) {
+	ctx := context.Background()
+	mockDB := testutil.NewMockDB()
+	scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1})
+
+	t.Run("Owner", func(t *testing.T) {
+		scan, err := authz.Scan(ctx, mockDB, 1, scanID)
+		if err != nil || scan == nil || scan.ID != scanID {
+			t.Errorf("Scan() = %v, %v; want the scan", scan, err)
+		}
+	})
+
+	t.Run("OtherUser", func(t *testing.T) {
+		_, err := authz.Scan(ctx, mockDB, 2, scanID)
+		if !errors.Is(err, authz.ErrNotFound) || !errors.Is(err, authz.ErrNotOwner) {
+			t.Errorf("Scan() error = %v; want ErrNotOwner matching ErrNotFound", err)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := authz.Annotation(ctx, mockDB, 1, 99)
+		if !errors.Is(err, authz.ErrNotFound) || errors.Is(err, authz.ErrNotOwner) {
+			t.Errorf("Annotation() error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("NoRows", func(t *testing.T) {
+		load := func(context.Context, int64) (*models.Scan, error) { return nil, sql.ErrNoRows }
+		_, err := authz.Owned(ctx, 1, 1, load, func(scan *models.Scan) int64 { return scan.UserID })
+		if !errors.Is(err, authz.ErrNotFound) {
+			t.Errorf("Owned() error = %v; want ErrNotFound", err)
+		}
+	})
+
+	t.Run("DatabaseError", func(t *testing.T) {
+		dbErr := errors.New("connection refused")
+		load := func(context.Context, int64) (*models.Scan, error) { return nil, dbErr }
+		_, err := authz.Owned(ctx, 1, 1, load, func(scan *models.Scan) int64 { return scan.UserID })
+		if !errors.Is(err, dbErr) || errors.Is(err, authz.ErrNotFound) {
+			t.Errorf("Owned() error = %v; want the database error", err)
+		}
+	})
+}
+
+func TestOrgMember(t *testing.T) {
+	ctx := context.Background()
+	mockDB := testutil.NewMockDB()
+	orgID, _ := mockDB.CreateOrganization(ctx, &models.Organization{Name: "Cohort"}, 1)
+	mockDB.AddOrgMember(ctx, &models.OrgMember{OrgID: orgID, UserID: 2, Role: models.OrgRoleMember})
+
+	if member, err := authz.OrgMember(ctx, mockDB, 2, orgID); err != nil || member.Role != models.OrgRoleMember {
+		t.Errorf("OrgMember() = %v, %v; want the membership", member, err)
+	}
+	if member, err := authz.OrgMember(ctx, mockDB, 1, orgID); err != nil || member.Role != models.OrgRoleOwner {
+		t.Errorf("OrgMember() = %v, %v; want the owner's membership", member, err)
+	}
+	if _, err := authz.OrgMember(ctx, mockDB, 3, orgID); !errors.Is(err, authz.ErrNotFound) {
+		t.Errorf("OrgMember() non-member error = %v; want ErrNotFound", err)
+	}
+}
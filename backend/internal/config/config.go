@@ -3,28 +3,91 @@ package config
 import (
 	"bufio"
 	"fmt"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 )
 
 type Config struct {
 	GeminiAPIKey       string
+	OCRModels          []string
 	AppBaseURL         string
 	Port               string
 	DBConnectionString string
+	StorageBackend     string
 	UploadDir          string
 	MaxUploadSize      int64
+	MaxRequestSize     int64
+	MaxPagesPerScan    int
+	MaxBatchFiles      int
+	OCRConcurrency     int
+	ReprocessScanLimit int
+	ReprocessUserLimit int
+	MaxTextLength      int
+	URLFetchTimeout    int
+	ImageMaxDimension  int
+	ImageMaxSide       int
+	ImageMaxPixels     int
+	ImageOutputFormat  string
+	ImageJPEGQuality   int
+	ImageDeskew        bool
+	ImageEnhance       bool
+	ThumbnailSizes     []int
+	ThumbnailSize      int
 	SessionCookieName  string
 	SessionSecure      bool
 
 	GoogleOAuthClientID     string
 	GoogleOAuthClientSecret string
+	GitHubOAuthClientID     string
+	GitHubOAuthClientSecret string
+	GitHubBaseURL           string
+	GitHubAPIURL            string
+	AppleClientID           string
+	AppleTeamID             string
+	AppleKeyID              string
+	ApplePrivateKeyFile     string
+	AppleIssuer             string
+	MagicLinkExpiryMinutes  int
+	MagicLinkCooldown       int
+	MagicLinkIPLimit        int
+	MailSender              string
+	MailFrom                string
+	SMTPAddr                string
+	SMTPUsername            string
+	SMTPPassword            string
 	RedisAddr               string
+	RedisFallback           string
+	RedisCheckInterval      int
 	JWTSecret               string
+	JWTSigningAlg           string
+	JWTKeyID                string
+	JWTPrivateKeyFile       string
+	JWTVerifyKeys           []string
+	OAuthRedirectAllowlist  []string
 	TokenExpiryMinutes      int
+	RefreshTokenExpiryDays  int
+	ImpersonationMinutes    int
+	SignedURLSecret         string
+	SignedURLExpiryMinutes  int
 	DefaultPageSize         int
 	KnowledgeCSVPath        string
+	RequireAnalysisID       bool
+
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3Prefix          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3UseSSL          bool
+	S3ForcePathStyle  bool
+	S3SSE             string
+	S3SSEKMSKeyID     string
+	S3SSECustomerKey  string
+	S3TimeoutSeconds  int
 }
 
 func Load() (*Config, error) {
@@ -58,20 +121,80 @@ func Load() (*Config, error) {
 
 	cfg := &Config{
 		GeminiAPIKey:            geminiAPIKey,
+		OCRModels:               getEnvAsSliceOrDefault("OCR_MODELS", []string{"gemini-2.5-flash", "gemini-2.5-pro"}),
 		AppBaseURL:              getEnvOrDefault("APP_BASE_URL", "http://localhost:8080"),
 		Port:                    getEnvOrDefault("PORT", "8080"),
 		DBConnectionString:      dbConnStr,
+		StorageBackend:          getEnvOrDefault("STORAGE_BACKEND", "local"),
 		UploadDir:               getEnvOrDefault("UPLOAD_DIR", "data/uploads"),
 		MaxUploadSize:           getEnvAsInt64OrDefault("MAX_UPLOAD_SIZE", 10*1024*1024),
+		MaxRequestSize:          getEnvAsInt64OrDefault("MAX_REQUEST_SIZE", 50*1024*1024),
+		MaxPagesPerScan:         getEnvAsIntOrDefault("MAX_PAGES_PER_SCAN", 20),
+		MaxBatchFiles:           getEnvAsIntOrDefault("MAX_BATCH_FILES", 50),
+		OCRConcurrency:          getEnvAsIntOrDefault("OCR_CONCURRENCY", 4),
+		ReprocessScanLimit:      getEnvAsIntOrDefault("REPROCESS_LIMIT_PER_SCAN", 5),
+		ReprocessUserLimit:      getEnvAsIntOrDefault("REPROCESS_LIMIT_PER_USER", 30),
+		MaxTextLength:           getEnvAsIntOrDefault("MAX_TEXT_LENGTH", 20000),
+		URLFetchTimeout:         getEnvAsIntOrDefault("URL_FETCH_TIMEOUT_SECONDS", 10),
+		ImageMaxDimension:       getEnvAsIntOrDefault("IMAGE_MAX_DIMENSION", 2048),
+		ImageMaxSide:            getEnvAsIntOrDefault("IMAGE_MAX_SIDE", 12000),
+		ImageMaxPixels:          getEnvAsIntOrDefault("IMAGE_MAX_PIXELS", 50_000_000),
+		ImageOutputFormat:       getEnvOrDefault("IMAGE_OUTPUT_FORMAT", "jpeg"),
+		ImageJPEGQuality:        getEnvAsIntOrDefault("IMAGE_JPEG_QUALITY", 85),
+		ImageDeskew:             getEnvAsBoolOrDefault("IMAGE_DESKEW", false),
+		ImageEnhance:            getEnvAsBoolOrDefault("IMAGE_ENHANCE_CONTRAST", false),
+		ThumbnailSizes:          getEnvAsIntSliceOrDefault("THUMBNAIL_SIZES", []int{160, 320, 640}),
+		ThumbnailSize:           getEnvAsIntOrDefault("THUMBNAIL_DEFAULT_SIZE", 320),
 		SessionCookieName:       getEnvOrDefault("SESSION_COOKIE_NAME", "sid"),
 		SessionSecure:           getEnvAsBoolOrDefault("SESSION_SECURE", false),
 		GoogleOAuthClientID:     os.Getenv("GOOGLE_OAUTH_CLIENT_ID"),
 		GoogleOAuthClientSecret: os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"),
+		GitHubOAuthClientID:     os.Getenv("GITHUB_OAUTH_CLIENT_ID"),
+		GitHubOAuthClientSecret: os.Getenv("GITHUB_OAUTH_CLIENT_SECRET"),
+		GitHubBaseURL:           getEnvOrDefault("GITHUB_BASE_URL", "https://github.com"),
+		GitHubAPIURL:            getEnvOrDefault("GITHUB_API_URL", "https://api.github.com"),
+		AppleClientID:           os.Getenv("APPLE_CLIENT_ID"),
+		AppleTeamID:             os.Getenv("APPLE_TEAM_ID"),
+		AppleKeyID:              os.Getenv("APPLE_KEY_ID"),
+		ApplePrivateKeyFile:     os.Getenv("APPLE_PRIVATE_KEY_FILE"),
+		AppleIssuer:             getEnvOrDefault("APPLE_ISSUER", "https://appleid.apple.com"),
+		MagicLinkExpiryMinutes:  getEnvAsIntOrDefault("MAGIC_LINK_EXPIRY_MINUTES", 15),
+		MagicLinkCooldown:       getEnvAsIntOrDefault("MAGIC_LINK_COOLDOWN_SECONDS", 60),
+		MagicLinkIPLimit:        getEnvAsIntOrDefault("MAGIC_LINK_LIMIT_PER_IP", 20),
+		MailSender:              getEnvOrDefault("MAIL_SENDER", "log"),
+		MailFrom:                getEnvOrDefault("MAIL_FROM", "no-reply@localhost"),
+		SMTPAddr:                os.Getenv("SMTP_ADDR"),
+		SMTPUsername:            os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:            os.Getenv("SMTP_PASSWORD"),
 		RedisAddr:               getEnvOrDefault("REDIS_ADDR", "localhost:6379"),
+		RedisFallback:           getEnvOrDefault("REDIS_FALLBACK", "postgres"),
+		RedisCheckInterval:      getEnvAsIntOrDefault("REDIS_CHECK_INTERVAL_SECONDS", 5),
 		JWTSecret:               os.Getenv("JWT_SECRET"),
+		JWTSigningAlg:           getEnvOrDefault("JWT_SIGNING_ALG", "HS256"),
+		JWTKeyID:                getEnvOrDefault("JWT_KEY_ID", "default"),
+		JWTPrivateKeyFile:       os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		JWTVerifyKeys:           getEnvAsSliceOrDefault("JWT_VERIFY_KEYS", nil),
+		OAuthRedirectAllowlist:  getEnvAsSliceOrDefault("OAUTH_REDIRECT_ALLOWLIST", nil),
 		TokenExpiryMinutes:      getEnvAsIntOrDefault("TOKEN_EXPIRY_MINUTES", 30),
+		RefreshTokenExpiryDays:  getEnvAsIntOrDefault("REFRESH_TOKEN_EXPIRY_DAYS", 30),
+		ImpersonationMinutes:    getEnvAsIntOrDefault("IMPERSONATION_EXPIRY_MINUTES", 15),
+		SignedURLSecret:         os.Getenv("SIGNED_URL_SECRET"),
+		SignedURLExpiryMinutes:  getEnvAsIntOrDefault("SIGNED_URL_EXPIRY_MINUTES", 15),
 		DefaultPageSize:         getEnvAsIntOrDefault("DEFAULT_PAGE_SIZE", 20),
 		KnowledgeCSVPath:        getEnvOrDefault("KNOWLEDGE_CSV_PATH", "data/knowledge.csv"),
+		RequireAnalysisID:       getEnvAsBoolOrDefault("REQUIRE_ANALYSIS_ID", true),
+		S3Endpoint:              getEnvOrDefault("S3_ENDPOINT", "s3.amazonaws.com"),
+		S3Region:                os.Getenv("S3_REGION"),
+		S3Bucket:                os.Getenv("S3_BUCKET"),
+		S3Prefix:                getEnvOrDefault("S3_PREFIX", "scans"),
+		S3AccessKeyID:           os.Getenv("S3_ACCESS_KEY_ID"),
+		S3SecretAccessKey:       os.Getenv("S3_SECRET_ACCESS_KEY"),
+		S3UseSSL:                getEnvAsBoolOrDefault("S3_USE_SSL", true),
+		S3ForcePathStyle:        getEnvAsBoolOrDefault("S3_FORCE_PATH_STYLE", false),
+		S3SSE:                   os.Getenv("S3_SSE"),
+		S3SSEKMSKeyID:           os.Getenv("S3_SSE_KMS_KEY_ID"),
+		S3SSECustomerKey:        os.Getenv("S3_SSE_CUSTOMER_KEY"),
+		S3TimeoutSeconds:        getEnvAsIntOrDefault("S3_TIMEOUT_SECONDS", 60),
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -88,15 +211,118 @@ func (c *Config) Validate() error {
 	if c.DBConnectionString == "" {
 		return fmt.Errorf("DB_CONNECTION_STRING or PostgreSQL connection details are required")
 	}
-	if c.UploadDir == "" {
-		return fmt.Errorf("UPLOAD_DIR cannot be empty")
+	switch c.StorageBackend {
+	case "local":
+		if c.UploadDir == "" {
+			return fmt.Errorf("UPLOAD_DIR cannot be empty")
+		}
+	case "s3":
+		if c.S3Bucket == "" {
+			return fmt.Errorf("S3_BUCKET is required when STORAGE_BACKEND=s3")
+		}
+		if c.S3Endpoint == "" {
+			return fmt.Errorf("S3_ENDPOINT is required when STORAGE_BACKEND=s3")
+		}
+		if c.S3TimeoutSeconds <= 0 {
+			return fmt.Errorf("S3_TIMEOUT_SECONDS must be positive")
+		}
+	default:
+		return fmt.Errorf("STORAGE_BACKEND must be 'local' or 's3'")
 	}
 	if c.MaxUploadSize <= 0 {
 		return fmt.Errorf("MAX_UPLOAD_SIZE must be positive")
 	}
+	if c.MaxRequestSize < c.MaxUploadSize {
+		return fmt.Errorf("MAX_REQUEST_SIZE must be at least MAX_UPLOAD_SIZE")
+	}
+	if c.MaxPagesPerScan <= 0 {
+		return fmt.Errorf("MAX_PAGES_PER_SCAN must be positive")
+	}
+	if c.ImageMaxDimension < 0 {
+		return fmt.Errorf("IMAGE_MAX_DIMENSION cannot be negative")
+	}
+	if c.MaxBatchFiles <= 0 {
+		return fmt.Errorf("MAX_BATCH_FILES must be positive")
+	}
+	if c.OCRConcurrency <= 0 {
+		return fmt.Errorf("OCR_CONCURRENCY must be positive")
+	}
+	if c.ReprocessScanLimit <= 0 || c.ReprocessUserLimit <= 0 {
+		return fmt.Errorf("REPROCESS_LIMIT_PER_SCAN and REPROCESS_LIMIT_PER_USER must be positive")
+	}
+	if c.MaxTextLength <= 0 {
+		return fmt.Errorf("MAX_TEXT_LENGTH must be positive")
+	}
+	if c.URLFetchTimeout <= 0 {
+		return fmt.Errorf("URL_FETCH_TIMEOUT_SECONDS must be positive")
+	}
+	if c.ImageMaxSide <= 0 || c.ImageMaxPixels <= 0 {
+		return fmt.Errorf("IMAGE_MAX_SIDE and IMAGE_MAX_PIXELS must be positive")
+	}
+	if c.ImageOutputFormat != "jpeg" && c.ImageOutputFormat != "png" {
+		return fmt.Errorf("IMAGE_OUTPUT_FORMAT must be 'jpeg' or 'png'")
+	}
+	if !slices.Contains(c.ThumbnailSizes, c.ThumbnailSize) {
+		return fmt.Errorf("THUMBNAIL_DEFAULT_SIZE must be one of THUMBNAIL_SIZES")
+	}
+	switch c.RedisFallback {
+	case "memory", "postgres", "none":
+	default:
+		return fmt.Errorf("REDIS_FALLBACK must be 'memory', 'postgres' or 'none'")
+	}
+	if c.RedisCheckInterval <= 0 {
+		return fmt.Errorf("REDIS_CHECK_INTERVAL_SECONDS must be positive")
+	}
+	switch c.JWTSigningAlg {
+	case "HS256":
+	case "RS256", "EdDSA":
+		if c.JWTPrivateKeyFile == "" {
+			return fmt.Errorf("JWT_PRIVATE_KEY_FILE is required when JWT_SIGNING_ALG is %s", c.JWTSigningAlg)
+		}
+	default:
+		return fmt.Errorf("JWT_SIGNING_ALG must be 'HS256', 'RS256' or 'EdDSA'")
+	}
+	if c.AppleClientID != "" && (c.AppleTeamID == "" || c.AppleKeyID == "" || c.ApplePrivateKeyFile == "") {
+		return fmt.Errorf("APPLE_TEAM_ID, APPLE_KEY_ID and APPLE_PRIVATE_KEY_FILE are required with APPLE_CLIENT_ID")
+	}
+	for _, entry := range c.OAuthRedirectAllowlist {
+		if u, err := url.Parse(entry); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("OAUTH_REDIRECT_ALLOWLIST entry %q must be an absolute http(s) URL", entry)
+		}
+	}
+	if c.MagicLinkExpiryMinutes <= 0 {
+		return fmt.Errorf("MAGIC_LINK_EXPIRY_MINUTES must be positive")
+	}
+	if c.MagicLinkCooldown <= 0 || c.MagicLinkIPLimit <= 0 {
+		return fmt.Errorf("MAGIC_LINK_COOLDOWN_SECONDS and MAGIC_LINK_LIMIT_PER_IP must be positive")
+	}
+	switch c.MailSender {
+	case "log":
+	case "smtp":
+		if c.SMTPAddr == "" {
+			return fmt.Errorf("SMTP_ADDR is required when MAIL_SENDER is smtp")
+		}
+	default:
+		return fmt.Errorf("MAIL_SENDER must be 'log' or 'smtp'")
+	}
 	if c.TokenExpiryMinutes <= 0 {
 		return fmt.Errorf("TOKEN_EXPIRY_MINUTES must be positive")
 	}
+	if c.RefreshTokenExpiryDays <= 0 {
+		return fmt.Errorf("REFRESH_TOKEN_EXPIRY_DAYS must be positive")
+	}
+	if c.ImpersonationMinutes <= 0 {
+		return fmt.Errorf("IMPERSONATION_EXPIRY_MINUTES must be positive")
+	}
+	if c.SignedURLSecret == "" {
+		return fmt.Errorf("SIGNED_URL_SECRET is required")
+	}
+	if c.SignedURLSecret == c.JWTSecret {
+		return fmt.Errorf("SIGNED_URL_SECRET must differ from JWT_SECRET")
+	}
+	if c.SignedURLExpiryMinutes <= 0 {
+		return fmt.Errorf("SIGNED_URL_EXPIRY_MINUTES must be positive")
+	}
 	if c.DefaultPageSize <= 0 {
 		return fmt.Errorf("DEFAULT_PAGE_SIZE must be positive")
 	}
@@ -128,6 +354,46 @@ func getEnvAsIntOrDefault(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsSliceOrDefault parses a comma-separated list, dropping empty
+// entries, and returns defaultValue when no entries are left.
+func getEnvAsSliceOrDefault(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var result []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	if len(result) == 0 {
+		return defaultValue
+	}
+	return result
+}
+
+// getEnvAsIntSliceOrDefault parses a comma-separated list of positive
+// integers and returns it sorted ascending.
+func getEnvAsIntSliceOrDefault(key string, defaultValue []int) []int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var result []int
+	for _, part := range strings.Split(value, ",") {
+		intValue, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || intValue <= 0 {
+			return defaultValue
+		}
+		result = append(result, intValue)
+	}
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
 func getEnvAsBoolOrDefault(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
@@ -0,0 +1,186 @@
+// Package fetcher downloads user-supplied URLs on the server without letting
+// them reach internal services (SSRF). Every connection, including those made
+// while following redirects, is checked against the address it actually
+// dials, so DNS rebinding cannot slip a private address past the check.
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrInvalidURL       = errors.New("invalid URL")
+	ErrForbiddenAddress = errors.New("URL resolves to a forbidden address")
+	ErrTooLarge         = errors.New("response body exceeds the size limit")
+	ErrBadStatus        = errors.New("unexpected response status")
+)
+
+const maxRedirects = 3
+
+// Options configures a Fetcher. AllowPrivateNetworks disables the address
+// check and exists for tests against local servers only.
+type Options struct {
+	Timeout              time.Duration
+	MaxBytes             int64
+	AllowPrivateNetworks bool
+}
+
+type Result struct {
+	Data        []byte
+	ContentType string
+	FinalURL    string
+}
+
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+// blockedPrefixes are ranges that are not publicly routable but are not
+// covered by the netip.Addr helpers used in isPublicAddr.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+func New(opts Options) *Fetcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+			}
+			return nil
+		}
+	}
+
+	transport := &http.Transport{
+		// Never route through an environment proxy: the check must see the
+		// real destination.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("%w: too many redirects", ErrInvalidURL)
+			}
+			return validateURL(req.URL)
+		},
+	}
+
+	return &Fetcher{client: client, maxBytes: opts.MaxBytes}
+}
+
+// Fetch GETs rawURL and returns the body, refusing non-HTTP(S) URLs,
+// credentials in the URL, private destinations and bodies over MaxBytes.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Result, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if err := validateURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	req.Header.Set("Accept", "image/*, application/pdf")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrForbiddenAddress) || errors.Is(err, ErrInvalidURL) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch URL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d", ErrBadStatus, resp.StatusCode)
+	}
+	if f.maxBytes > 0 && resp.ContentLength > f.maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	body := io.Reader(resp.Body)
+	if f.maxBytes > 0 {
+		body = io.LimitReader(resp.Body, f.maxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if f.maxBytes > 0 && int64(len(data)) > f.maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	return &Result{
+		Data:        data,
+		ContentType: resp.Header.Get("Content-Type"),
+		FinalURL:    resp.Request.URL.String(),
+	}, nil
+}
+
+func validateURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: only http and https URLs are allowed", ErrInvalidURL)
+	}
+	if u.Host == "" || u.Hostname() == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidURL)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials are not allowed", ErrInvalidURL)
+	}
+	return nil
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
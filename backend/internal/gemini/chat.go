@@ -0,0 +1,113 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gemini-hackathon/app/internal/knowledge"
+	"google.golang.org/genai"
+)
+
+// Roles of the turns of a chat, as the API names them.
+const (
+	ChatRoleUser  = genai.RoleUser
+	ChatRoleModel = genai.RoleModel
+)
+
+// ChatTurn is one earlier message of a conversation.
+type ChatTurn struct {
+	Role string
+	Text string
+}
+
+// AnnotationChatRequest is a follow-up question about an annotation. The
+// OCR text, selected text, explanation and knowledge entries are the
+// context the original annotation was made with.
+type AnnotationChatRequest struct {
+	OCRText        string
+	SelectedText   string
+	Explanation    string
+	Entries        []knowledge.Entry
+	TargetLanguage string
+	History        []ChatTurn
+	Question       string
+}
+
+type ChatResponse struct {
+	Text  string
+	Model string
+}
+
+// ChatAboutAnnotation answers a follow-up question about an annotation,
+// passing the reply text to onText as it streams in.
+func (c *client) ChatAboutAnnotation(ctx context.Context, req AnnotationChatRequest, onText func(text string)) (*ChatResponse, error) {
+	if err := c.ready(); err != nil {
+		return nil, err
+	}
+
+	contents := make([]*genai.Content, 0, len(req.History)+1)
+	for _, turn := range req.History {
+		role := genai.Role(ChatRoleUser)
+		if turn.Role == ChatRoleModel {
+			role = ChatRoleModel
+		}
+		contents = append(contents, genai.NewContentFromText(turn.Text, role))
+	}
+	contents = append(contents, genai.NewContentFromText(req.Question, genai.RoleUser))
+
+	cfg := &genai.GenerateContentConfig{
+		SystemInstruction: genai.NewContentFromText(buildChatInstruction(req), genai.RoleUser),
+	}
+
+	var reply strings.Builder
+	err := c.generateStreamWithRetry(ctx, c.modelName, contents, cfg, func(text string) {
+		reply.WriteString(text)
+		if onText != nil {
+			onText(text)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate chat reply: %w", err)
+	}
+	if reply.Len() == 0 {
+		return nil, fmt.Errorf("empty response from API")
+	}
+
+	return &ChatResponse{Text: reply.String(), Model: c.modelName}, nil
+}
+
+func buildChatInstruction(req AnnotationChatRequest) string {
+	language := languageNames[req.TargetLanguage]
+	if language == "" {
+		language = languageNames["ID"]
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("You are helping a Japanese language learner understand text in a professional/work context. ")
+	sb.WriteString("The learner already received an explanation of the selected text and is asking follow-up questions about it, ")
+	sb.WriteString("such as whether it is appropriate to say to a manager or a customer.\n\n")
+
+	writeKnowledgeEntries(&sb, req.Entries)
+
+	sb.WriteString("## Full OCR text:\n")
+	sb.WriteString(req.OCRText)
+	sb.WriteString("\n\n")
+
+	sb.WriteString("## Selected text:\n")
+	sb.WriteString(req.SelectedText)
+	sb.WriteString("\n\n")
+
+	if req.Explanation != "" {
+		sb.WriteString("## Explanation already given:\n")
+		sb.WriteString(req.Explanation)
+		sb.WriteString("\n\n")
+	}
+
+	sb.WriteString(fmt.Sprintf("Answer in %s, in a few short paragraphs of plain text without markdown. ", language))
+	sb.WriteString("Quote Japanese examples in Japanese with their reading. ")
+	sb.WriteString("If a question is unrelated to the text or to Japanese, say so briefly.")
+
+	return sb.String()
+}
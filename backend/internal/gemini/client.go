@@ -13,9 +13,14 @@ import (
 )
 
 type Client interface {
-	OCR(ctx context.Context, imageData []byte, mimeType string) (*OCRResponse, error)
+	OCR(ctx context.Context, imageData []byte, mimeType string, opts OCROptions) (*OCRResponse, error)
+	OCRDocument(ctx context.Context, documentData []byte, mimeType string, opts OCROptions) (*DocumentOCRResponse, error)
 	Annotate(ctx context.Context, ocrText string, selectedText string) (*AnnotationResponse, error)
 	AnnotateWithKnowledge(ctx context.Context, ocrText string, selectedText string, entries []knowledge.Entry) (*AnnotationResponse, error)
+	AnnotateWithKnowledgeStream(ctx context.Context, ocrText string, selectedText string, entries []knowledge.Entry, onField FieldFunc) (*AnnotationResponse, error)
+	ChatAboutAnnotation(ctx context.Context, req AnnotationChatRequest, onText func(text string)) (*ChatResponse, error)
+	TranslateDocument(ctx context.Context, paragraphs []string, targetLanguage string) (*TranslationResponse, error)
+	ExtractVocabulary(ctx context.Context, text string, targetLanguage string) (*VocabularyResponse, error)
 }
 
 type client struct {
@@ -45,10 +50,30 @@ func NewClient(apiKey string) Client {
 	}
 }
 
+// OCROptions overrides the defaults for a single OCR call. Zero values keep
+// the client's model and let the model detect the language.
+type OCROptions struct {
+	Model        string
+	LanguageHint string
+}
+
 type OCRResponse struct {
 	RawText        string
 	StructuredJSON string
 	Language       string
+	Model          string
+}
+
+// DocumentOCRResponse holds the text of each page of a multi-page document.
+type DocumentOCRResponse struct {
+	Pages    []PageText `json:"pages"`
+	Language string     `json:"language"`
+	Model    string     `json:"-"`
+}
+
+type PageText struct {
+	PageNumber int    `json:"page_number"`
+	RawText    string `json:"raw_text"`
 }
 
 type AnnotationResponse struct {
@@ -57,9 +82,62 @@ type AnnotationResponse struct {
 	WhenToUse           string `json:"when_to_use"`
 	WordBreakdown       string `json:"word_breakdown"`
 	AlternativeMeanings string `json:"alternative_meanings"`
+	JLPTLevel           string `json:"jlpt_level"`
+	PolitenessRegister  string `json:"politeness_register"`
+	PartOfSpeech        string `json:"part_of_speech"`
+	Reading             string `json:"reading"`
+}
+
+// TranslationResponse is a whole-document translation. Paragraphs holds one
+// entry per input paragraph, identified by its zero-based index.
+type TranslationResponse struct {
+	Paragraphs  []ParagraphTranslation `json:"paragraphs"`
+	Summary     string                 `json:"summary"`
+	ActionItems []ActionItem           `json:"action_items"`
+	Model       string                 `json:"-"`
+}
+
+type ParagraphTranslation struct {
+	Index       int    `json:"index"`
+	Translation string `json:"translation"`
+}
+
+type ActionItem struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	DueDate     string `json:"due_date"`
+}
+
+// VocabularyResponse lists the words and set phrases in a text that a
+// learner is likely not to know.
+type VocabularyResponse struct {
+	Items []VocabularyItem `json:"items"`
+	Model string           `json:"-"`
 }
 
-func (c *client) OCR(ctx context.Context, imageData []byte, mimeType string) (*OCRResponse, error) {
+type VocabularyItem struct {
+	Term      string `json:"term"`
+	Reading   string `json:"reading"`
+	Meaning   string `json:"meaning"`
+	Kind      string `json:"kind"`
+	JLPTLevel string `json:"jlpt_level"`
+	Sentence  string `json:"sentence"`
+}
+
+// maxExtractedVocabulary is how many terms the model is asked for, so long
+// documents still get a list a learner can work through. The handlers store
+// up to a larger number, adding terms found in the knowledge base.
+const maxExtractedVocabulary = 30
+
+// languageNames maps the app's language codes to names the model
+// understands in a prompt.
+var languageNames = map[string]string{
+	"ID": "Indonesian",
+	"JP": "Japanese",
+	"EN": "English",
+}
+
+func (c *client) OCR(ctx context.Context, imageData []byte, mimeType string, opts OCROptions) (*OCRResponse, error) {
 	if c.genaiClient == nil {
 		if c.initErr != nil {
 			return nil, fmt.Errorf("gemini client not initialized: %w", c.initErr)
@@ -67,7 +145,8 @@ func (c *client) OCR(ctx context.Context, imageData []byte, mimeType string) (*O
 		return nil, fmt.Errorf("gemini client not initialized: check API key")
 	}
 
-	prompt := "Extract all Japanese text from this image. Return ONLY a JSON object with keys 'raw_text' (the extracted text) and 'language' (detected language code 'JP' for japan). Preserve line breaks and formatting. Do not include markdown, code fences, or any extra text."
+	prompt := "Extract all Japanese text from this image. Return ONLY a JSON object with keys 'raw_text' (the extracted text) and 'language' (detected language code 'JP' for japan). Preserve line breaks and formatting. Do not include markdown, code fences, or any extra text." + languageHintPrompt(opts.LanguageHint)
+	model := c.ocrModel(opts)
 
 	parts := []*genai.Part{
 		{Text: prompt},
@@ -97,7 +176,7 @@ func (c *client) OCR(ctx context.Context, imageData []byte, mimeType string) (*O
 	for attempt := 0; attempt < 3; attempt++ {
 		result, err = c.genaiClient.Models.GenerateContent(
 			ctx,
-			c.modelName,
+			model,
 			[]*genai.Content{{Parts: parts}},
 			cfg,
 		)
@@ -138,6 +217,7 @@ func (c *client) OCR(ctx context.Context, imageData []byte, mimeType string) (*O
 					RawText:        structured.RawText,
 					StructuredJSON: string(structuredJSON),
 					Language:       structured.Language,
+					Model:          model,
 				}, nil
 			}
 		}
@@ -145,6 +225,7 @@ func (c *client) OCR(ctx context.Context, imageData []byte, mimeType string) (*O
 			RawText:        text,
 			StructuredJSON: "",
 			Language:       "ja",
+			Model:          model,
 		}, nil
 	}
 
@@ -154,9 +235,75 @@ func (c *client) OCR(ctx context.Context, imageData []byte, mimeType string) (*O
 		RawText:        structured.RawText,
 		StructuredJSON: string(structuredJSON),
 		Language:       structured.Language,
+		Model:          model,
 	}, nil
 }
 
+// OCRDocument sends a whole document (e.g. a PDF) to the model natively and
+// returns the extracted text page by page.
+func (c *client) OCRDocument(ctx context.Context, documentData []byte, mimeType string, opts OCROptions) (*DocumentOCRResponse, error) {
+	if err := c.ready(); err != nil {
+		return nil, err
+	}
+
+	prompt := "Extract all Japanese text from every page of this document. Return ONLY a JSON object with keys 'pages' (an array with one entry per page, in order, each with 'page_number' starting at 1 and 'raw_text' holding that page's text) and 'language' (detected language code 'JP' for japan). Preserve line breaks and formatting. Do not include markdown, code fences, or any extra text." + languageHintPrompt(opts.LanguageHint)
+	model := c.ocrModel(opts)
+
+	parts := []*genai.Part{
+		{Text: prompt},
+		{
+			InlineData: &genai.Blob{
+				Data:     documentData,
+				MIMEType: mimeType,
+			},
+		},
+	}
+
+	cfg := &genai.GenerateContentConfig{
+		ResponseMIMEType: "application/json",
+		ResponseSchema: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"pages": {
+					Type: genai.TypeArray,
+					Items: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"page_number": {Type: genai.TypeInteger},
+							"raw_text":    {Type: genai.TypeString},
+						},
+						Required:         []string{"page_number", "raw_text"},
+						PropertyOrdering: []string{"page_number", "raw_text"},
+					},
+				},
+				"language": {Type: genai.TypeString},
+			},
+			Required:         []string{"pages", "language"},
+			PropertyOrdering: []string{"pages", "language"},
+		},
+	}
+
+	result, err := c.generateWithRetry(ctx, model, []*genai.Content{{Parts: parts}}, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate document OCR content: %w", err)
+	}
+
+	text := result.Text()
+	if text == "" {
+		return nil, fmt.Errorf("empty response from API")
+	}
+
+	var doc DocumentOCRResponse
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		if err2 := json.Unmarshal([]byte(normalizeJSONCandidate(text)), &doc); err2 != nil {
+			return nil, fmt.Errorf("failed to parse document OCR JSON: %w", err)
+		}
+	}
+	doc.Model = model
+
+	return &doc, nil
+}
+
 func (c *client) Annotate(ctx context.Context, ocrText string, selectedText string) (*AnnotationResponse, error) {
 	if c.genaiClient == nil {
 		if c.initErr != nil {
@@ -179,36 +326,10 @@ Provide a detailed annotation in JSON format with these exact fields:
 - when_to_use: When and in what situation this phrase is used
 - word_breakdown: Explanation of each word/component in the selected text
 - alternative_meanings: Alternative meanings in different fields or contexts
-
+`+annotationFieldsPrompt+`
 Return only valid JSON, no markdown formatting.`, ocrText, selectedText)
 
-	cfg := &genai.GenerateContentConfig{
-		ResponseMIMEType: "application/json",
-		ResponseSchema: &genai.Schema{
-			Type: genai.TypeObject,
-			Properties: map[string]*genai.Schema{
-				"meaning":              {Type: genai.TypeString},
-				"usage_example":        {Type: genai.TypeString},
-				"when_to_use":          {Type: genai.TypeString},
-				"word_breakdown":       {Type: genai.TypeString},
-				"alternative_meanings": {Type: genai.TypeString},
-			},
-			Required: []string{
-				"meaning",
-				"usage_example",
-				"when_to_use",
-				"word_breakdown",
-				"alternative_meanings",
-			},
-			PropertyOrdering: []string{
-				"meaning",
-				"usage_example",
-				"when_to_use",
-				"word_breakdown",
-				"alternative_meanings",
-			},
-		},
-	}
+	cfg := annotationConfig()
 
 	var result *genai.GenerateContentResponse
 	var err error
@@ -265,33 +386,7 @@ func (c *client) AnnotateWithKnowledge(ctx context.Context, ocrText string, sele
 
 	prompt := buildEnhancedPrompt(ocrText, selectedText, entries)
 
-	cfg := &genai.GenerateContentConfig{
-		ResponseMIMEType: "application/json",
-		ResponseSchema: &genai.Schema{
-			Type: genai.TypeObject,
-			Properties: map[string]*genai.Schema{
-				"meaning":              {Type: genai.TypeString},
-				"usage_example":        {Type: genai.TypeString},
-				"when_to_use":          {Type: genai.TypeString},
-				"word_breakdown":       {Type: genai.TypeString},
-				"alternative_meanings": {Type: genai.TypeString},
-			},
-			Required: []string{
-				"meaning",
-				"usage_example",
-				"when_to_use",
-				"word_breakdown",
-				"alternative_meanings",
-			},
-			PropertyOrdering: []string{
-				"meaning",
-				"usage_example",
-				"when_to_use",
-				"word_breakdown",
-				"alternative_meanings",
-			},
-		},
-	}
+	cfg := annotationConfig()
 
 	var result *genai.GenerateContentResponse
 	var err error
@@ -324,18 +419,214 @@ func (c *client) AnnotateWithKnowledge(ctx context.Context, ocrText string, sele
 		return nil, fmt.Errorf("empty response from API")
 	}
 
-	var annotation AnnotationResponse
-	if err := json.Unmarshal([]byte(text), &annotation); err != nil {
-		normalized := normalizeJSONCandidate(text)
-		if normalized != text {
-			if err2 := json.Unmarshal([]byte(normalized), &annotation); err2 == nil {
-				return &annotation, nil
-			}
+	return parseAnnotation(text)
+}
+
+// TranslateDocument translates paragraphs into targetLanguage one by one, so
+// the result can be shown side by side with the original, and extracts a
+// short summary and the action items (deadlines, documents to bring, tasks).
+func (c *client) TranslateDocument(ctx context.Context, paragraphs []string, targetLanguage string) (*TranslationResponse, error) {
+	if err := c.ready(); err != nil {
+		return nil, err
+	}
+
+	languageName, ok := languageNames[targetLanguage]
+	if !ok {
+		languageName = targetLanguage
+	}
+
+	var sb strings.Builder
+	sb.WriteString("You are helping a foreign worker in Japan understand a document they received at work.\n\n")
+	sb.WriteString("## Document paragraphs (JSON array, in order):\n")
+	paragraphsJSON, err := json.Marshal(paragraphs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode paragraphs: %w", err)
+	}
+	sb.Write(paragraphsJSON)
+	sb.WriteString("\n\n")
+	sb.WriteString(fmt.Sprintf("Translate the document into %s. Return a JSON object with these exact fields:\n", languageName))
+	sb.WriteString("- paragraphs: one entry per input paragraph, with 'index' (its zero-based position in the array) and 'translation'. Do not merge, split or skip paragraphs.\n")
+	sb.WriteString(fmt.Sprintf("- summary: two or three sentences in %s on what the document is about\n", languageName))
+	sb.WriteString(fmt.Sprintf("- action_items: what the reader must do, each with 'type' (one of 'deadline', 'document', 'task'), 'description' in %s and 'due_date' (YYYY-MM-DD if the document states a date, otherwise empty). Use an empty array if there is nothing to do.\n\n", languageName))
+	sb.WriteString("Return only valid JSON, no markdown formatting.")
+
+	cfg := &genai.GenerateContentConfig{
+		ResponseMIMEType: "application/json",
+		ResponseSchema: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"paragraphs": {
+					Type: genai.TypeArray,
+					Items: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"index":       {Type: genai.TypeInteger},
+							"translation": {Type: genai.TypeString},
+						},
+						Required:         []string{"index", "translation"},
+						PropertyOrdering: []string{"index", "translation"},
+					},
+				},
+				"summary": {Type: genai.TypeString},
+				"action_items": {
+					Type: genai.TypeArray,
+					Items: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"type":        {Type: genai.TypeString, Enum: []string{"deadline", "document", "task"}},
+							"description": {Type: genai.TypeString},
+							"due_date":    {Type: genai.TypeString},
+						},
+						Required:         []string{"type", "description"},
+						PropertyOrdering: []string{"type", "description", "due_date"},
+					},
+				},
+			},
+			Required:         []string{"paragraphs", "summary", "action_items"},
+			PropertyOrdering: []string{"paragraphs", "summary", "action_items"},
+		},
+	}
+
+	result, err := c.generateWithRetry(ctx, c.modelName, genai.Text(sb.String()), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate translation: %w", err)
+	}
+
+	text := result.Text()
+	if text == "" {
+		return nil, fmt.Errorf("empty response from API")
+	}
+
+	var translation TranslationResponse
+	if err := json.Unmarshal([]byte(text), &translation); err != nil {
+		if err2 := json.Unmarshal([]byte(normalizeJSONCandidate(text)), &translation); err2 != nil {
+			return nil, fmt.Errorf("failed to parse translation JSON: %w", err)
 		}
-		return nil, fmt.Errorf("failed to parse annotation JSON: %w", err)
 	}
+	translation.Model = c.modelName
 
-	return &annotation, nil
+	return &translation, nil
+}
+
+// ExtractVocabulary picks the words and set phrases in text that are worth
+// learning for an intermediate learner working in Japan, with their reading,
+// a meaning in targetLanguage and an estimated JLPT level.
+func (c *client) ExtractVocabulary(ctx context.Context, text string, targetLanguage string) (*VocabularyResponse, error) {
+	if err := c.ready(); err != nil {
+		return nil, err
+	}
+
+	languageName, ok := languageNames[targetLanguage]
+	if !ok {
+		languageName = targetLanguage
+	}
+
+	prompt := fmt.Sprintf(`You are helping a Japanese language learner who works in Japan decide which words to study.
+
+Text:
+%s
+
+List at most %d words and set phrases from the text that an intermediate learner is unlikely to know, most useful first. Skip particles, basic words (JLPT N5) and proper names. Return a JSON object with an 'items' array; each item has these exact fields:
+- term: the word or phrase exactly as it appears in the text
+- reading: its reading in hiragana
+- meaning: a short meaning in %s
+- kind: 'word' or 'phrase'
+- jlpt_level: estimated JLPT level, one of 'N1', 'N2', 'N3', 'N4', 'N5'
+- sentence: the sentence of the text it appears in
+
+Return only valid JSON, no markdown formatting.`, text, maxExtractedVocabulary, languageName)
+
+	cfg := &genai.GenerateContentConfig{
+		ResponseMIMEType: "application/json",
+		ResponseSchema: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"items": {
+					Type: genai.TypeArray,
+					Items: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"term":       {Type: genai.TypeString},
+							"reading":    {Type: genai.TypeString},
+							"meaning":    {Type: genai.TypeString},
+							"kind":       {Type: genai.TypeString, Enum: []string{"word", "phrase"}},
+							"jlpt_level": {Type: genai.TypeString, Enum: []string{"N1", "N2", "N3", "N4", "N5"}},
+							"sentence":   {Type: genai.TypeString},
+						},
+						Required:         []string{"term", "reading", "meaning", "kind", "jlpt_level"},
+						PropertyOrdering: []string{"term", "reading", "meaning", "kind", "jlpt_level", "sentence"},
+					},
+				},
+			},
+			Required: []string{"items"},
+		},
+	}
+
+	result, err := c.generateWithRetry(ctx, c.modelName, genai.Text(prompt), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract vocabulary: %w", err)
+	}
+
+	responseText := result.Text()
+	if responseText == "" {
+		return nil, fmt.Errorf("empty response from API")
+	}
+
+	var vocabulary VocabularyResponse
+	if err := json.Unmarshal([]byte(responseText), &vocabulary); err != nil {
+		if err2 := json.Unmarshal([]byte(normalizeJSONCandidate(responseText)), &vocabulary); err2 != nil {
+			return nil, fmt.Errorf("failed to parse vocabulary JSON: %w", err)
+		}
+	}
+	vocabulary.Model = c.modelName
+
+	return &vocabulary, nil
+}
+
+// annotationFieldsPrompt describes the structured annotation fields. Their
+// allowed values are enforced by the schema in annotationConfig.
+const annotationFieldsPrompt = `- jlpt_level: Estimated JLPT level of the selected text (N1 hardest to N5 easiest)
+- politeness_register: sonkeigo (respectful), kenjougo (humble), teineigo (polite) or casual
+- part_of_speech: Grammatical category of the selected text; use 'expression' for set phrases
+- reading: Reading of the selected text in hiragana
+`
+
+// annotationConfig is the response schema shared by Annotate,
+// AnnotateWithKnowledge and AnnotateWithKnowledgeStream.
+func annotationConfig() *genai.GenerateContentConfig {
+	fields := []string{
+		"meaning",
+		"usage_example",
+		"when_to_use",
+		"word_breakdown",
+		"alternative_meanings",
+		"jlpt_level",
+		"politeness_register",
+		"part_of_speech",
+		"reading",
+	}
+
+	return &genai.GenerateContentConfig{
+		ResponseMIMEType: "application/json",
+		ResponseSchema: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"meaning":              {Type: genai.TypeString},
+				"usage_example":        {Type: genai.TypeString},
+				"when_to_use":          {Type: genai.TypeString},
+				"word_breakdown":       {Type: genai.TypeString},
+				"alternative_meanings": {Type: genai.TypeString},
+				"jlpt_level":           {Type: genai.TypeString, Enum: []string{"N1", "N2", "N3", "N4", "N5"}},
+				"politeness_register":  {Type: genai.TypeString, Enum: []string{"sonkeigo", "kenjougo", "teineigo", "casual"}},
+				"part_of_speech": {Type: genai.TypeString, Enum: []string{
+					"noun", "verb", "i-adjective", "na-adjective", "adverb", "particle", "conjunction", "counter", "expression", "other",
+				}},
+				"reading": {Type: genai.TypeString},
+			},
+			Required:         fields,
+			PropertyOrdering: fields,
+		},
+	}
 }
 
 // buildEnhancedPrompt creates a prompt that includes reference knowledge from CSV.
@@ -345,27 +636,7 @@ func buildEnhancedPrompt(ocrText string, selectedText string, entries []knowledg
 	sb.WriteString("You are helping a Japanese language learner understand text in a professional/work context.\n\n")
 
 	// Add reference knowledge if available
-	if len(entries) > 0 {
-		sb.WriteString("## Reference Knowledge:\n")
-		for _, entry := range entries {
-			sb.WriteString(fmt.Sprintf("Term: %s (%s)\n", entry.Kosakata, entry.Kana))
-			sb.WriteString(fmt.Sprintf("Reading: %s\n", entry.CaraBaca))
-			sb.WriteString(fmt.Sprintf("Meaning: %s\n", entry.Arti))
-			if entry.Deskripsi != "" {
-				sb.WriteString(fmt.Sprintf("Description: %s\n", entry.Deskripsi))
-			}
-			if len(entry.BidangPekerjaan) > 0 {
-				sb.WriteString(fmt.Sprintf("Fields: %s\n", strings.Join(entry.BidangPekerjaan, ", ")))
-			}
-			if len(entry.Industri) > 0 {
-				sb.WriteString(fmt.Sprintf("Industry: %s\n", strings.Join(entry.Industri, ", ")))
-			}
-			if entry.Konteks != "" {
-				sb.WriteString(fmt.Sprintf("Context: %s\n", entry.Konteks))
-			}
-			sb.WriteString("\n")
-		}
-	}
+	writeKnowledgeEntries(&sb, entries)
 
 	sb.WriteString("## Full OCR text:\n")
 	sb.WriteString(ocrText)
@@ -384,12 +655,90 @@ func buildEnhancedPrompt(ocrText string, selectedText string, entries []knowledg
 	sb.WriteString("- usage_example: Example sentence showing how to use this in a professional/work context\n")
 	sb.WriteString("- when_to_use: When and in what situation this phrase is used\n")
 	sb.WriteString("- word_breakdown: Explanation of each word/component in the selected text\n")
-	sb.WriteString("- alternative_meanings: Alternative meanings in different fields or contexts\n\n")
+	sb.WriteString("- alternative_meanings: Alternative meanings in different fields or contexts\n")
+	sb.WriteString(annotationFieldsPrompt)
+	sb.WriteString("\n")
 	sb.WriteString("Return only valid JSON, no markdown formatting.")
 
 	return sb.String()
 }
 
+// writeKnowledgeEntries writes the "Reference Knowledge" prompt section. It
+// writes nothing without entries.
+func writeKnowledgeEntries(sb *strings.Builder, entries []knowledge.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	sb.WriteString("## Reference Knowledge:\n")
+	for _, entry := range entries {
+		sb.WriteString(fmt.Sprintf("Term: %s (%s)\n", entry.Kosakata, entry.Kana))
+		sb.WriteString(fmt.Sprintf("Reading: %s\n", entry.CaraBaca))
+		sb.WriteString(fmt.Sprintf("Meaning: %s\n", entry.Arti))
+		if entry.Deskripsi != "" {
+			sb.WriteString(fmt.Sprintf("Description: %s\n", entry.Deskripsi))
+		}
+		if len(entry.BidangPekerjaan) > 0 {
+			sb.WriteString(fmt.Sprintf("Fields: %s\n", strings.Join(entry.BidangPekerjaan, ", ")))
+		}
+		if len(entry.Industri) > 0 {
+			sb.WriteString(fmt.Sprintf("Industry: %s\n", strings.Join(entry.Industri, ", ")))
+		}
+		if entry.Konteks != "" {
+			sb.WriteString(fmt.Sprintf("Context: %s\n", entry.Konteks))
+		}
+		sb.WriteString("\n")
+	}
+}
+
+func (c *client) ocrModel(opts OCROptions) string {
+	if opts.Model != "" {
+		return opts.Model
+	}
+	return c.modelName
+}
+
+// languageHintPrompt tells the model which language to expect, which helps
+// with handwriting and mixed-script notices. It is empty without a hint.
+func languageHintPrompt(hint string) string {
+	if hint == "" {
+		return ""
+	}
+	return fmt.Sprintf(" The text is expected to be mainly in the language with code '%s'.", hint)
+}
+
+func (c *client) ready() error {
+	if c.genaiClient == nil {
+		if c.initErr != nil {
+			return fmt.Errorf("gemini client not initialized: %w", c.initErr)
+		}
+		return fmt.Errorf("gemini client not initialized: check API key")
+	}
+	return nil
+}
+
+// generateWithRetry calls GenerateContent, retrying overloaded/UNAVAILABLE
+// errors up to three times with jittered exponential backoff.
+func (c *client) generateWithRetry(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	var result *genai.GenerateContentResponse
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		result, err = c.genaiClient.Models.GenerateContent(ctx, model, contents, cfg)
+		if err == nil {
+			return result, nil
+		}
+		if !isOverloadedError(err) || attempt == 2 {
+			return nil, err
+		}
+
+		backoff := time.Duration(500*(1<<attempt)) * time.Millisecond
+		jitter := time.Duration(rand.Intn(250)) * time.Millisecond
+		if !sleepWithContext(ctx, backoff+jitter) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
 func isOverloadedError(err error) bool {
 	msg := strings.ToLower(err.Error())
 	return strings.Contains(msg, "503") || strings.Contains(msg, "unavailable") || strings.Contains(msg, "overloaded")
@@ -53,7 +53,7 @@ func TestOCR_Integration(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	result, err := client.OCR(ctx, imageData, "image/jpeg")
+	result, err := client.OCR(ctx, imageData, "image/jpeg", OCROptions{})
 	if err != nil {
 		if strings.Contains(err.Error(), "overloaded") ||
 			strings.Contains(err.Error(), "503") ||
@@ -128,7 +128,7 @@ func TestOCR_InvalidImage(t *testing.T) {
 	invalidImageData := []byte("not a valid image")
 	ctx := context.Background()
 
-	_, err := client.OCR(ctx, invalidImageData, "image/jpeg")
+	_, err := client.OCR(ctx, invalidImageData, "image/jpeg", OCROptions{})
 	if err == nil {
 		t.Error("Expected error for invalid image data, got nil")
 	}
@@ -156,7 +156,7 @@ func TestOCR_NoAPIKey(t *testing.T) {
 	client := NewClient("")
 	ctx := context.Background()
 
-	_, err := client.OCR(ctx, []byte("test"), "image/jpeg")
+	_, err := client.OCR(ctx, []byte("test"), "image/jpeg", OCROptions{})
 	if err == nil {
 		t.Error("Expected error when API key is empty, got nil")
 	}
@@ -231,3 +231,103 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+func TestOCRDocument_NoAPIKey(t *testing.T) {
+	client := NewClient("")
+	ctx := context.Background()
+
+	_, err := client.OCRDocument(ctx, []byte("%PDF-1.4"), "application/pdf", OCROptions{})
+	if err == nil {
+		t.Error("Expected error when API key is empty, got nil")
+	}
+	if err != nil && !strings.Contains(err.Error(), "not initialized") {
+		t.Errorf("Expected error about client not initialized, got: %v", err)
+	}
+}
+
+func TestTranslateDocument_NoAPIKey(t *testing.T) {
+	client := NewClient("")
+	ctx := context.Background()
+
+	_, err := client.TranslateDocument(ctx, []string{"お疲れ様です"}, "EN")
+	if err == nil {
+		t.Error("Expected error when API key is empty, got nil")
+	}
+	if err != nil && !strings.Contains(err.Error(), "not initialized") {
+		t.Errorf("Expected error about client not initialized, got: %v", err)
+	}
+}
+
+func TestExtractVocabulary_NoAPIKey(t *testing.T) {
+	client := NewClient("")
+	ctx := context.Background()
+
+	_, err := client.ExtractVocabulary(ctx, "書類を提出してください。", "EN")
+	if err == nil {
+		t.Error("Expected error when API key is empty, got nil")
+	}
+	if err != nil && !strings.Contains(err.Error(), "not initialized") {
+		t.Errorf("Expected error about client not initialized, got: %v", err)
+	}
+}
+
+func TestAnnotateWithKnowledgeStream_NoAPIKey(t *testing.T) {
+	client := NewClient("")
+	ctx := context.Background()
+
+	_, err := client.AnnotateWithKnowledgeStream(ctx, "test ocr text", "test selected", nil, nil)
+	if err == nil {
+		t.Error("Expected error when API key is empty, got nil")
+	}
+	if err != nil && !strings.Contains(err.Error(), "not initialized") {
+		t.Errorf("Expected error about client not initialized, got: %v", err)
+	}
+}
+
+func TestFieldStream(t *testing.T) {
+	var fields []string
+	s := newFieldStream(func(field, value string) {
+		fields = append(fields, field+"="+value)
+	})
+
+	chunks := []string{
+		`{"meaning": "to work`,
+		` hard", "usage_`,
+		`example": "お疲れ様です\"。", "jlpt_level"`,
+		`: "N4"}`,
+	}
+	want := [][]string{
+		nil,
+		{"meaning=to work hard"},
+		{"meaning=to work hard", `usage_example=お疲れ様です"。`},
+		{"meaning=to work hard", `usage_example=お疲れ様です"。`, "jlpt_level=N4"},
+	}
+
+	for i, chunk := range chunks {
+		s.write(chunk)
+		if strings.Join(fields, "|") != strings.Join(want[i], "|") {
+			t.Errorf("after chunk %d: fields = %q; want %q", i, fields, want[i])
+		}
+	}
+
+	annotation, err := parseAnnotation(s.buf.String())
+	if err != nil {
+		t.Fatalf("parseAnnotation() error = %v", err)
+	}
+	if annotation.Meaning != "to work hard" || annotation.JLPTLevel != "N4" {
+		t.Errorf("parseAnnotation() = %+v", annotation)
+	}
+}
+
+func TestChatAboutAnnotation_NoAPIKey(t *testing.T) {
+	client := NewClient("")
+	ctx := context.Background()
+
+	_, err := client.ChatAboutAnnotation(ctx, AnnotationChatRequest{SelectedText: "お疲れ様です", Question: "Can I say this to my boss?"}, nil)
+	if err == nil {
+		t.Error("Expected error when API key is empty, got nil")
+	}
+	if err != nil && !strings.Contains(err.Error(), "not initialized") {
+		t.Errorf("Expected error about client not initialized, got: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/knowledge"
+	"google.golang.org/genai"
+)
+
+// FieldFunc receives a top-level field of a streamed JSON response, by its
+// JSON name, as soon as the field's value is complete.
+type FieldFunc func(field, value string)
+
+// AnnotateWithKnowledgeStream is AnnotateWithKnowledge with streaming
+// generation. onField is called once per annotation field while the model is
+// still writing the rest; the parsed annotation is returned at the end.
+func (c *client) AnnotateWithKnowledgeStream(ctx context.Context, ocrText string, selectedText string, entries []knowledge.Entry, onField FieldFunc) (*AnnotationResponse, error) {
+	if err := c.ready(); err != nil {
+		return nil, err
+	}
+
+	prompt := buildEnhancedPrompt(ocrText, selectedText, entries)
+	cfg := annotationConfig()
+
+	fields := newFieldStream(onField)
+	if err := c.generateStreamWithRetry(ctx, c.modelName, genai.Text(prompt), cfg, fields.write); err != nil {
+		return nil, fmt.Errorf("failed to generate annotation: %w", err)
+	}
+
+	text := fields.buf.String()
+	if text == "" {
+		return nil, fmt.Errorf("empty response from API")
+	}
+	return parseAnnotation(text)
+}
+
+// generateStreamWithRetry calls GenerateContentStream and passes the text of
+// each chunk to onChunk. Text already passed on cannot be taken back, so only
+// a stream that fails before its first chunk is retried, like
+// generateWithRetry.
+func (c *client) generateStreamWithRetry(ctx context.Context, model string, contents []*genai.Content, cfg *genai.GenerateContentConfig, onChunk func(text string)) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		started := false
+		err = nil
+		for chunk, chunkErr := range c.genaiClient.Models.GenerateContentStream(ctx, model, contents, cfg) {
+			if chunkErr != nil {
+				err = chunkErr
+				break
+			}
+			if text := chunk.Text(); text != "" {
+				started = true
+				onChunk(text)
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		if started || !isOverloadedError(err) || attempt == 2 {
+			return err
+		}
+
+		backoff := time.Duration(500*(1<<attempt)) * time.Millisecond
+		jitter := time.Duration(rand.Intn(250)) * time.Millisecond
+		if !sleepWithContext(ctx, backoff+jitter) {
+			return err
+		}
+	}
+	return err
+}
+
+// fieldStream collects streamed JSON text and reports each top-level string
+// field once its closing quote has arrived.
+type fieldStream struct {
+	buf     strings.Builder
+	emitted map[string]bool
+	onField FieldFunc
+}
+
+func newFieldStream(onField FieldFunc) *fieldStream {
+	return &fieldStream{emitted: make(map[string]bool), onField: onField}
+}
+
+func (s *fieldStream) write(text string) {
+	s.buf.WriteString(text)
+
+	// The buffer is re-read from the start on every chunk. Annotations are
+	// a few KB, so this is cheaper than keeping decoder state across chunks.
+	dec := json.NewDecoder(strings.NewReader(s.buf.String()))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return
+	}
+	for {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		key, ok := keyTok.(string)
+		if !ok {
+			return
+		}
+		valueTok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		value, ok := valueTok.(string)
+		if !ok {
+			return
+		}
+		if !s.emitted[key] {
+			s.emitted[key] = true
+			if s.onField != nil {
+				s.onField(key, value)
+			}
+		}
+	}
+}
+
+func parseAnnotation(text string) (*AnnotationResponse, error) {
+	var annotation AnnotationResponse
+	if err := json.Unmarshal([]byte(text), &annotation); err != nil {
+		normalized := normalizeJSONCandidate(text)
+		if normalized != text {
+			if err2 := json.Unmarshal([]byte(normalized), &annotation); err2 == nil {
+				return &annotation, nil
+			}
+		}
+		return nil, fmt.Errorf("failed to parse annotation JSON: %w", err)
+	}
+	return &annotation, nil
+}
@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/auth"
+	"github.com/gemini-hackathon/app/internal/config"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+)
+
+// AdminHandlers serve the admin dashboard. Routes are expected behind
+// middleware.RequireRole; every change they make is added to the audit log.
+type AdminHandlers struct {
+	db           storage.DB
+	tokenService *auth.TokenService
+	config       *config.Config
+}
+
+func NewAdminHandlers(db storage.DB, tokenService *auth.TokenService, cfg *config.Config) *AdminHandlers {
+	return &AdminHandlers{
+		db:           db,
+		tokenService: tokenService,
+		config:       cfg,
+	}
+}
+
+type AdminUserResponse struct {
+	ID                int64   `json:"id"`
+	Email             string  `json:"email"`
+	Provider          string  `json:"provider"`
+	Role              string  `json:"role"`
+	PreferredLanguage string  `json:"preferredLanguage"`
+	Disabled          bool    `json:"disabled"`
+	DisabledAt        *string `json:"disabledAt,omitempty"`
+	CreatedAt         string  `json:"createdAt"`
+}
+
+type AdminUsersResponse struct {
+	Data []AdminUserResponse `json:"data"`
+	Meta PaginationMeta      `json:"meta"`
+}
+
+// UpdateAdminUserRequest changes the fields that are set.
+type UpdateAdminUserRequest struct {
+	Role     *string `json:"role"`
+	Disabled *bool   `json:"disabled"`
+}
+
+type ImpersonateRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ImpersonateResponse carries an access token acting as User. It cannot be
+// refreshed; the admin signs in as themselves again once it expires.
+type ImpersonateResponse struct {
+	Token         string            `json:"token"`
+	ExpirySeconds int               `json:"expirySeconds"`
+	ExpiresAt     string            `json:"expiresAt"`
+	User          AdminUserResponse `json:"user"`
+}
+
+type UserStats struct {
+	Total    int            `json:"total"`
+	ByRole   map[string]int `json:"byRole"`
+	Disabled int            `json:"disabled"`
+	New      int            `json:"new"`
+	Active   int            `json:"active"`
+}
+
+type ScanStats struct {
+	Total  int `json:"total"`
+	New    int `json:"new"`
+	Failed int `json:"failed"`
+}
+
+type AnnotationStats struct {
+	Total int `json:"total"`
+	New   int `json:"new"`
+}
+
+// UsageStatsResponse counts everything, and what is new or active in the
+// last Days days.
+type UsageStatsResponse struct {
+	Days        int             `json:"days"`
+	Since       string          `json:"since"`
+	Users       UserStats       `json:"users"`
+	Scans       ScanStats       `json:"scans"`
+	Annotations AnnotationStats `json:"annotations"`
+}
+
+type ScanFailureResponse struct {
+	ID         int64  `json:"id"`
+	ScanID     int64  `json:"scanId"`
+	UserID     int64  `json:"userId"`
+	PageNumber *int   `json:"pageNumber,omitempty"`
+	Source     string `json:"source"`
+	Error      string `json:"error"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+type ScanFailuresResponse struct {
+	Data []ScanFailureResponse `json:"data"`
+	Meta PaginationMeta        `json:"meta"`
+}
+
+type AuditEntryResponse struct {
+	ID           int64          `json:"id"`
+	ActorUserID  int64          `json:"actorUserId"`
+	Action       string         `json:"action"`
+	TargetUserID *int64         `json:"targetUserId,omitempty"`
+	Details      map[string]any `json:"details"`
+	CreatedAt    string         `json:"createdAt"`
+}
+
+type AuditEntriesResponse struct {
+	Data []AuditEntryResponse `json:"data"`
+	Meta PaginationMeta       `json:"meta"`
+}
+
+// UsersAPI lists users, optionally those whose email contains q.
+func (h *AdminHandlers) UsersAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page, size := h.pageParams(r)
+	users, err := h.db.ListUsers(r.Context(), strings.TrimSpace(r.URL.Query().Get("q")), page, size)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to list users")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]AdminUserResponse, len(users))
+	for i, user := range users {
+		data[i] = toAdminUserResponse(user)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AdminUsersResponse{Data: data, Meta: paginationMeta(page, size, len(users))})
+}
+
+// UserAPI routes /v1/admin/users/{id}, which shows (GET) or changes (PATCH)
+// a user, and /v1/admin/users/{id}/impersonate.
+func (h *AdminHandlers) UserAPI(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/admin/users/"), "/")
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "impersonate") {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 2 {
+		h.impersonate(w, r, user)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toAdminUserResponse(user))
+	case http.MethodPatch:
+		h.updateUser(w, r, user)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// updateUser changes a user's role or disables or re-enables the account.
+// Disabling also signs the user out everywhere. Admins cannot change their
+// own role or disable themselves, so there is always an admin left.
+func (h *AdminHandlers) updateUser(w http.ResponseWriter, r *http.Request, user *models.User) {
+	ctx := r.Context()
+	actorID := middleware.GetUserID(ctx)
+	log := h.logger(r)
+
+	var req UpdateAdminUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role != nil && !models.IsValidRole(*req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	if user.ID == actorID && (req.Role != nil || req.Disabled != nil) {
+		http.Error(w, "Cannot change your own account", http.StatusBadRequest)
+		return
+	}
+
+	if req.Role != nil && *req.Role != user.Role {
+		audit := h.auditEntry(r, models.AuditActionSetRole, user.ID, map[string]any{"from": user.Role, "to": *req.Role})
+		if err := h.db.UpdateUserRole(ctx, user.ID, *req.Role, audit); err != nil {
+			log.ErrorWithErr(err, "Failed to update user role")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Disabled != nil && *req.Disabled != (user.DisabledAt != nil) {
+		action, update := models.AuditActionEnableUser, h.db.EnableUser
+		if *req.Disabled {
+			action, update = models.AuditActionDisableUser, h.db.DisableUser
+		}
+		if err := update(ctx, user.ID, h.auditEntry(r, action, user.ID, nil)); err != nil {
+			log.ErrorWithErr(err, "Failed to update user status")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	updated, err := h.db.GetUserByID(ctx, user.ID)
+	if err != nil || updated == nil {
+		log.ErrorWithErr(err, "Failed to reload user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toAdminUserResponse(updated))
+}
+
+// impersonate issues a short-lived token acting as user, for support. The
+// reason is required and recorded in the audit log, along with every request
+// made with the token that can change data. Admins cannot be impersonated.
+func (h *AdminHandlers) impersonate(w http.ResponseWriter, r *http.Request, user *models.User) {
+	ctx := r.Context()
+	actorID := middleware.GetUserID(ctx)
+	log := h.logger(r)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+	if user.ID == actorID || user.Role == models.RoleAdmin {
+		http.Error(w, "Cannot impersonate an admin", http.StatusForbidden)
+		return
+	}
+	if user.DisabledAt != nil {
+		http.Error(w, "Account is disabled", http.StatusConflict)
+		return
+	}
+
+	// The audit entry is committed before the token exists, so no token is
+	// ever issued without one.
+	expiry := time.Duration(h.config.ImpersonationMinutes) * time.Minute
+	details := map[string]any{"reason": req.Reason, "expiresAt": time.Now().Add(expiry).Format(time.RFC3339)}
+	err := h.db.RecordImpersonation(ctx, user.ID, h.auditEntry(r, models.AuditActionImpersonate, user.ID, details))
+	if errors.Is(err, storage.ErrNotImpersonable) {
+		http.Error(w, "User can no longer be impersonated", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to write audit entry")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	token, expiresAt, err := h.tokenService.GenerateImpersonationToken(user.ID, actorID, expiry)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to generate impersonation token")
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	log.Warnf("Impersonating user %d: %s", user.ID, req.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ImpersonateResponse{
+		Token:         token,
+		ExpirySeconds: int(expiry / time.Second),
+		ExpiresAt:     expiresAt.Format(time.RFC3339),
+		User:          toAdminUserResponse(user),
+	})
+}
+
+// StatsAPI reports usage statistics over the last days days, 30 by default.
+func (h *AdminHandlers) StatsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 365 {
+			http.Error(w, "days must be between 1 and 365", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	stats, err := h.db.GetUsageStats(r.Context(), since)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get usage stats")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UsageStatsResponse{
+		Days:  days,
+		Since: since.Format(time.RFC3339),
+		Users: UserStats{
+			Total:    stats.Users,
+			ByRole:   stats.UsersByRole,
+			Disabled: stats.DisabledUsers,
+			New:      stats.NewUsers,
+			Active:   stats.ActiveUsers,
+		},
+		Scans: ScanStats{
+			Total:  stats.Scans,
+			New:    stats.NewScans,
+			Failed: stats.FailedScans,
+		},
+		Annotations: AnnotationStats{
+			Total: stats.Annotations,
+			New:   stats.NewAnnotations,
+		},
+	})
+}
+
+// FailedScansAPI lists failed OCR attempts of all users, newest first.
+func (h *AdminHandlers) FailedScansAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page, size := h.pageParams(r)
+	failures, err := h.db.GetScanFailures(r.Context(), page, size)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get scan failures")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]ScanFailureResponse, len(failures))
+	for i, failure := range failures {
+		data[i] = ScanFailureResponse{
+			ID:         failure.ID,
+			ScanID:     failure.ScanID,
+			UserID:     failure.UserID,
+			PageNumber: failure.PageNumber,
+			Source:     failure.Source,
+			Error:      failure.Error,
+			CreatedAt:  failure.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ScanFailuresResponse{Data: data, Meta: paginationMeta(page, size, len(failures))})
+}
+
+// AuditAPI lists the audit log, newest first, optionally only the entries
+// affecting userId.
+func (h *AdminHandlers) AuditAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var targetUserID int64
+	if value := r.URL.Query().Get("userId"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 1 {
+			http.Error(w, "Invalid userId", http.StatusBadRequest)
+			return
+		}
+		targetUserID = id
+	}
+
+	page, size := h.pageParams(r)
+	entries, err := h.db.GetAuditEntries(r.Context(), targetUserID, page, size)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get audit entries")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]AuditEntryResponse, len(entries))
+	for i, entry := range entries {
+		details := entry.Details
+		if details == nil {
+			details = map[string]any{}
+		}
+		data[i] = AuditEntryResponse{
+			ID:           entry.ID,
+			ActorUserID:  entry.ActorUserID,
+			Action:       entry.Action,
+			TargetUserID: entry.TargetUserID,
+			Details:      details,
+			CreatedAt:    entry.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditEntriesResponse{Data: data, Meta: paginationMeta(page, size, len(entries))})
+}
+
+// auditEntry returns an audit entry for an action the signed-in admin takes
+// on targetUserID, to be written along with the change itself.
+func (h *AdminHandlers) auditEntry(r *http.Request, action string, targetUserID int64, details map[string]any) *models.AuditEntry {
+	return &models.AuditEntry{
+		ActorUserID:  middleware.GetUserID(r.Context()),
+		Action:       action,
+		TargetUserID: &targetUserID,
+		Details:      details,
+		CreatedAt:    time.Now(),
+	}
+}
+
+func (h *AdminHandlers) logger(r *http.Request) *logger.Logger {
+	return logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(middleware.GetUserID(r.Context()))
+}
+
+// pageParams reads the page and size query parameters like the other list
+// endpoints.
+func (h *AdminHandlers) pageParams(r *http.Request) (int, int) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+	if size < 1 {
+		size = h.config.DefaultPageSize
+	}
+	if size > 100 {
+		size = 100
+	}
+	return page, size
+}
+
+func paginationMeta(page, size, count int) PaginationMeta {
+	meta := PaginationMeta{CurrentPage: page, PageSize: size}
+	if count == size {
+		next := page + 1
+		meta.NextPage = &next
+	}
+	if page > 1 {
+		prev := page - 1
+		meta.PreviousPage = &prev
+	}
+	return meta
+}
+
+func toAdminUserResponse(user *models.User) AdminUserResponse {
+	response := AdminUserResponse{
+		ID:                user.ID,
+		Email:             user.Email,
+		Provider:          user.Provider,
+		Role:              user.Role,
+		PreferredLanguage: user.PreferredLanguage,
+		Disabled:          user.DisabledAt != nil,
+		CreatedAt:         user.CreatedAt.Format(time.RFC3339),
+	}
+	if user.DisabledAt != nil {
+		disabledAt := user.DisabledAt.Format(time.RFC3339)
+		response.DisabledAt = &disabledAt
+	}
+	return response
+}
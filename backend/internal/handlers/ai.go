@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/gemini-hackathon/app/internal/authz"
 	"github.com/gemini-hackathon/app/internal/gemini"
 	"github.com/gemini-hackathon/app/internal/knowledge"
 	"github.com/gemini-hackathon/app/internal/middleware"
@@ -30,14 +36,22 @@ func NewAIHandlers(db storage.DB, geminiClient gemini.Client, knowledgeSvc knowl
 type AnalyzeRequest struct {
 	TextToAnalyze string `json:"textToAnalyze"`
 	Context       string `json:"context"`
+	ScanID        int64  `json:"scanId,omitempty"`
 }
 
+// AnalyzeResponse is the explanation of the analyzed text. AnalysisID names
+// the stored result, which POST /v1/annotations saves by reference.
 type AnalyzeResponse struct {
+	AnalysisID         int64  `json:"analysisId,omitempty"`
 	Meaning            string `json:"meaning"`
 	UsageExample       string `json:"usageExample"`
 	UsageTiming        string `json:"usageTiming"`
 	WordBreakdown      string `json:"wordBreakdown"`
 	AlternativeMeaning string `json:"alternativeMeaning"`
+	JLPTLevel          string `json:"jlptLevel,omitempty"`
+	PolitenessRegister string `json:"politenessRegister,omitempty"`
+	PartOfSpeech       string `json:"partOfSpeech,omitempty"`
+	Reading            string `json:"reading,omitempty"`
 }
 
 type NuanceSummary struct {
@@ -45,47 +59,13 @@ type NuanceSummary struct {
 }
 
 func (h *AIHandlers) AnalyzeAPI(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	userID := middleware.GetUserID(r.Context())
-	if userID == 0 {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	var req AnalyzeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if req.TextToAnalyze == "" {
-		http.Error(w, "textToAnalyze is required", http.StatusBadRequest)
-		return
-	}
-
-	user, err := h.db.GetUserByID(r.Context(), userID)
-	if err != nil {
-		log.Printf("Failed to get user: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	req, userID, ok := h.decodeAnalyzeRequest(w, r)
+	if !ok {
 		return
 	}
 
-	if user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
-	targetLanguage := user.PreferredLanguage
-	if targetLanguage == "" {
-		targetLanguage = "ID"
-	}
-
 	// Lookup knowledge context for the selected text
-	entries := h.knowledge.Lookup(req.TextToAnalyze)
+	entries := userKnowledge(r.Context(), h.db, h.knowledge, userID).Lookup(req.TextToAnalyze)
 
 	// Call Gemini with knowledge context
 	resp, err := h.geminiClient.AnnotateWithKnowledge(r.Context(), req.Context, req.TextToAnalyze, entries)
@@ -95,61 +75,95 @@ func (h *AIHandlers) AnalyzeAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response := AnalyzeResponse{
-		Meaning:            resp.Meaning,
-		UsageExample:       resp.UsageExample,
-		UsageTiming:        resp.WhenToUse,
-		WordBreakdown:      resp.WordBreakdown,
-		AlternativeMeaning: resp.AlternativeMeanings,
+	response := toAnalyzeResponse(resp)
+	response.AnalysisID, err = h.saveAnalysis(r.Context(), userID, req, resp)
+	if err != nil {
+		log.Printf("Failed to save analysis: %v", err)
+		http.Error(w, "Failed to analyze text", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-func (h *AIHandlers) AnalyzeWithLanguageAPI(w http.ResponseWriter, r *http.Request) {
+// decodeAnalyzeRequest checks the method, user and body shared by AnalyzeAPI
+// and AnalyzeStreamAPI. It writes the error response and returns false on
+// failure.
+func (h *AIHandlers) decodeAnalyzeRequest(w http.ResponseWriter, r *http.Request) (*AnalyzeRequest, int64, bool) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+		return nil, 0, false
 	}
 
 	userID := middleware.GetUserID(r.Context())
 	if userID == 0 {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+		return nil, 0, false
 	}
 
 	var req AnalyzeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+		return nil, 0, false
 	}
 
 	if req.TextToAnalyze == "" {
 		http.Error(w, "textToAnalyze is required", http.StatusBadRequest)
-		return
+		return nil, 0, false
 	}
 
-	// Lookup knowledge context for the selected text
-	entries := h.knowledge.Lookup(req.TextToAnalyze)
-
-	resp, err := h.geminiClient.AnnotateWithKnowledge(r.Context(), req.Context, req.TextToAnalyze, entries)
+	user, err := h.db.GetUserByID(r.Context(), userID)
 	if err != nil {
-		log.Printf("Failed to generate annotation with language: %v", err)
-		http.Error(w, "Failed to analyze text", http.StatusInternalServerError)
-		return
+		log.Printf("Failed to get user: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, 0, false
 	}
 
-	response := AnalyzeResponse{
-		Meaning:            resp.Meaning,
-		UsageExample:       resp.UsageExample,
-		UsageTiming:        resp.WhenToUse,
-		WordBreakdown:      resp.WordBreakdown,
-		AlternativeMeaning: resp.AlternativeMeanings,
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, 0, false
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	if req.ScanID > 0 {
+		_, err := authz.Scan(r.Context(), h.db, userID, req.ScanID)
+		if errors.Is(err, authz.ErrNotFound) {
+			http.Error(w, "Scan not found", http.StatusNotFound)
+			return nil, 0, false
+		}
+		if err != nil {
+			log.Printf("Failed to get scan: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return nil, 0, false
+		}
+	}
+
+	return &req, userID, true
+}
+
+// saveAnalysis stores the model's result so an annotation can be created
+// from it, and returns its ID.
+func (h *AIHandlers) saveAnalysis(ctx context.Context, userID int64, req *AnalyzeRequest, resp *gemini.AnnotationResponse) (int64, error) {
+	analysis := &models.Analysis{
+		UserID:     userID,
+		Text:       req.TextToAnalyze,
+		NuanceData: toNuanceData(resp),
+		CreatedAt:  time.Now(),
+	}
+	if req.ScanID > 0 {
+		analysis.ScanID = &req.ScanID
+	}
+	if req.Context != "" {
+		analysis.ContextText = &req.Context
+	}
+	return h.db.CreateAnalysis(ctx, analysis)
+}
+
+// AnalyzeWithLanguageAPI is AnalyzeAPI under its older name. It stores the
+// analysis the same way and returns its analysisId, so annotations can be
+// saved from it.
+func (h *AIHandlers) AnalyzeWithLanguageAPI(w http.ResponseWriter, r *http.Request) {
+	h.AnalyzeAPI(w, r)
 }
 
 type AnnotationAnnotation struct {
@@ -161,13 +175,72 @@ type AnnotationAnnotation struct {
 }
 
 func toNuanceData(resp *gemini.AnnotationResponse) models.NuanceData {
-	return models.NuanceData{
+	return sanitizeNuance(models.NuanceData{
 		Meaning:            resp.Meaning,
 		UsageExample:       resp.UsageExample,
 		UsageTiming:        resp.WhenToUse,
 		WordBreakdown:      resp.WordBreakdown,
 		AlternativeMeaning: resp.AlternativeMeanings,
+		JLPTLevel:          resp.JLPTLevel,
+		PolitenessRegister: resp.PolitenessRegister,
+		PartOfSpeech:       resp.PartOfSpeech,
+		Reading:            resp.Reading,
+	})
+}
+
+func toAnalyzeResponse(resp *gemini.AnnotationResponse) AnalyzeResponse {
+	nuance := toNuanceData(resp)
+	return AnalyzeResponse{
+		Meaning:            nuance.Meaning,
+		UsageExample:       nuance.UsageExample,
+		UsageTiming:        nuance.UsageTiming,
+		WordBreakdown:      nuance.WordBreakdown,
+		AlternativeMeaning: nuance.AlternativeMeaning,
+		JLPTLevel:          nuance.JLPTLevel,
+		PolitenessRegister: nuance.PolitenessRegister,
+		PartOfSpeech:       nuance.PartOfSpeech,
+		Reading:            nuance.Reading,
+	}
+}
+
+// normalizeNuance puts the structured fields in their canonical case.
+func normalizeNuance(nuance models.NuanceData) models.NuanceData {
+	nuance.JLPTLevel = strings.ToUpper(strings.TrimSpace(nuance.JLPTLevel))
+	nuance.PolitenessRegister = strings.ToLower(strings.TrimSpace(nuance.PolitenessRegister))
+	nuance.PartOfSpeech = strings.ToLower(strings.TrimSpace(nuance.PartOfSpeech))
+	nuance.Reading = strings.TrimSpace(nuance.Reading)
+	return nuance
+}
+
+// sanitizeNuance normalizes model output and clears structured fields whose
+// value is not one the app knows, so they never break filtering.
+func sanitizeNuance(nuance models.NuanceData) models.NuanceData {
+	nuance = normalizeNuance(nuance)
+	if !slices.Contains(models.JLPTLevels, nuance.JLPTLevel) {
+		nuance.JLPTLevel = ""
+	}
+	if !slices.Contains(models.PolitenessRegisters, nuance.PolitenessRegister) {
+		nuance.PolitenessRegister = ""
+	}
+	if !slices.Contains(models.PartsOfSpeech, nuance.PartOfSpeech) {
+		nuance.PartOfSpeech = ""
+	}
+	return nuance
+}
+
+// validateNuanceFields reports the first structured field with a value the
+// app does not know. Empty fields are allowed.
+func validateNuanceFields(nuance models.NuanceData) error {
+	if nuance.JLPTLevel != "" && !slices.Contains(models.JLPTLevels, nuance.JLPTLevel) {
+		return fmt.Errorf("jlptLevel must be one of %s", strings.Join(models.JLPTLevels, ", "))
+	}
+	if nuance.PolitenessRegister != "" && !slices.Contains(models.PolitenessRegisters, nuance.PolitenessRegister) {
+		return fmt.Errorf("politenessRegister must be one of %s", strings.Join(models.PolitenessRegisters, ", "))
+	}
+	if nuance.PartOfSpeech != "" && !slices.Contains(models.PartsOfSpeech, nuance.PartOfSpeech) {
+		return fmt.Errorf("partOfSpeech must be one of %s", strings.Join(models.PartsOfSpeech, ", "))
 	}
+	return nil
 }
 
 func summarizeNuance(nuance models.NuanceData) string {
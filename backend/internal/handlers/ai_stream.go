@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gemini-hackathon/app/internal/models"
+)
+
+// Event types sent by AnalyzeStreamAPI. A stream is any number of field
+// events followed by exactly one done or error event.
+const (
+	AnalyzeEventField = "field"
+	AnalyzeEventDone  = "done"
+	AnalyzeEventError = "error"
+)
+
+// AnalyzeStreamEvent is one message of a streamed analysis. Field uses the
+// AnalyzeResponse JSON names; Result is the same body AnalyzeAPI returns.
+type AnalyzeStreamEvent struct {
+	Type   string           `json:"type"`
+	Field  string           `json:"field,omitempty"`
+	Value  string           `json:"value,omitempty"`
+	Result *AnalyzeResponse `json:"result,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// analyzeStreamFields maps the model's field names to AnalyzeResponse names.
+var analyzeStreamFields = map[string]string{
+	"meaning":              "meaning",
+	"usage_example":        "usageExample",
+	"when_to_use":          "usageTiming",
+	"word_breakdown":       "wordBreakdown",
+	"alternative_meanings": "alternativeMeaning",
+	"jlpt_level":           "jlptLevel",
+	"politeness_register":  "politenessRegister",
+	"part_of_speech":       "partOfSpeech",
+	"reading":              "reading",
+}
+
+// AnalyzeStreamAPI is AnalyzeAPI with the annotation streamed field by field
+// while the model writes it. The response is Server-Sent Events, or
+// newline-delimited JSON when the client accepts application/x-ndjson.
+func (h *AIHandlers) AnalyzeStreamAPI(w http.ResponseWriter, r *http.Request) {
+	req, userID, ok := h.decodeAnalyzeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Lookup knowledge context for the selected text
+	entries := userKnowledge(r.Context(), h.db, h.knowledge, userID).Lookup(req.TextToAnalyze)
+
+	stream := startEventStream(w, r)
+	send := func(event AnalyzeStreamEvent) {
+		stream.send(event.Type, event)
+	}
+
+	resp, err := h.geminiClient.AnnotateWithKnowledgeStream(r.Context(), req.Context, req.TextToAnalyze, entries, func(field, value string) {
+		if name, value, ok := streamField(field, value); ok {
+			send(AnalyzeStreamEvent{Type: AnalyzeEventField, Field: name, Value: value})
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to stream annotation: %v", err)
+		send(AnalyzeStreamEvent{Type: AnalyzeEventError, Error: "Failed to analyze text"})
+		return
+	}
+
+	response := toAnalyzeResponse(resp)
+	response.AnalysisID, err = h.saveAnalysis(r.Context(), userID, req, resp)
+	if err != nil {
+		log.Printf("Failed to save analysis: %v", err)
+		send(AnalyzeStreamEvent{Type: AnalyzeEventError, Error: "Failed to analyze text"})
+		return
+	}
+	send(AnalyzeStreamEvent{Type: AnalyzeEventDone, Result: &response})
+}
+
+// streamField converts a field reported by the model to its AnalyzeResponse
+// name and value. Structured fields are sanitized like the final response,
+// and fields that end up empty are not sent.
+func streamField(field, value string) (string, string, bool) {
+	name, ok := analyzeStreamFields[field]
+	if !ok {
+		return "", "", false
+	}
+
+	var nuance models.NuanceData
+	switch field {
+	case "jlpt_level":
+		nuance.JLPTLevel = value
+		value = sanitizeNuance(nuance).JLPTLevel
+	case "politeness_register":
+		nuance.PolitenessRegister = value
+		value = sanitizeNuance(nuance).PolitenessRegister
+	case "part_of_speech":
+		nuance.PartOfSpeech = value
+		value = sanitizeNuance(nuance).PartOfSpeech
+	case "reading":
+		nuance.Reading = value
+		value = sanitizeNuance(nuance).Reading
+	}
+	return name, value, value != ""
+}
@@ -2,34 +2,57 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gemini-hackathon/app/internal/authz"
 	"github.com/gemini-hackathon/app/internal/config"
+	"github.com/gemini-hackathon/app/internal/gemini"
+	"github.com/gemini-hackathon/app/internal/knowledge"
 	"github.com/gemini-hackathon/app/internal/middleware"
 	"github.com/gemini-hackathon/app/internal/models"
 	"github.com/gemini-hackathon/app/internal/storage"
 )
 
 type AnnotationHandlers struct {
-	db     storage.DB
-	config *config.Config
+	db           storage.DB
+	geminiClient gemini.Client
+	knowledge    knowledge.Service
+	config       *config.Config
 }
 
-func NewAnnotationHandlers(db storage.DB, cfg *config.Config) *AnnotationHandlers {
+func NewAnnotationHandlers(db storage.DB, geminiClient gemini.Client, knowledgeSvc knowledge.Service, cfg *config.Config) *AnnotationHandlers {
 	return &AnnotationHandlers{
-		db:     db,
-		config: cfg,
+		db:           db,
+		geminiClient: geminiClient,
+		knowledge:    knowledgeSvc,
+		config:       cfg,
 	}
 }
 
+// CreateAnnotationRequest saves an annotation. With AnalysisID the nuance
+// data, and by default the highlighted and context text, come from the
+// stored analysis; NuanceData is only used without it.
 type CreateAnnotationRequest struct {
+	AnalysisID      int64             `json:"analysisId,omitempty"`
 	ScanID          int64             `json:"scanId"`
+	PageNumber      *int              `json:"pageNumber,omitempty"`
 	HighlightedText string            `json:"highlightedText"`
 	ContextText     string            `json:"contextText"`
 	NuanceData      models.NuanceData `json:"nuanceData"`
+
+	// StartOffset and EndOffset locate highlightedText in the scan's full
+	// text; see models.Annotation. OCRBlock and BoundingBox optionally point
+	// at the highlighted area of the page image.
+	StartOffset *int                `json:"startOffset,omitempty"`
+	EndOffset   *int                `json:"endOffset,omitempty"`
+	OCRBlock    *string             `json:"ocrBlock,omitempty"`
+	BoundingBox *models.BoundingBox `json:"boundingBox,omitempty"`
 }
 
 type CreateAnnotationResponse struct {
@@ -38,18 +61,30 @@ type CreateAnnotationResponse struct {
 }
 
 type AnnotationListItem struct {
-	ID              int64  `json:"id"`
-	HighlightedText string `json:"highlightedText"`
-	NuanceSummary   string `json:"nuanceSummary"`
-	CreatedAt       string `json:"createdAt"`
+	ID                 int64  `json:"id"`
+	ScanID             *int64 `json:"scanId,omitempty"`
+	PageNumber         *int   `json:"pageNumber,omitempty"`
+	HighlightedText    string `json:"highlightedText"`
+	NuanceSummary      string `json:"nuanceSummary"`
+	JLPTLevel          string `json:"jlptLevel,omitempty"`
+	PolitenessRegister string `json:"politenessRegister,omitempty"`
+	PartOfSpeech       string `json:"partOfSpeech,omitempty"`
+	Reading            string `json:"reading,omitempty"`
+	CreatedAt          string `json:"createdAt"`
 }
 
 type GetAnnotationResponse struct {
-	ID              int64             `json:"id"`
-	HighlightedText string            `json:"highlightedText"`
-	ContextText     string            `json:"contextText,omitempty"`
-	NuanceData      models.NuanceData `json:"nuanceData"`
-	CreatedAt       string            `json:"createdAt"`
+	ID              int64               `json:"id"`
+	ScanID          *int64              `json:"scanId,omitempty"`
+	PageNumber      *int                `json:"pageNumber,omitempty"`
+	HighlightedText string              `json:"highlightedText"`
+	ContextText     string              `json:"contextText,omitempty"`
+	NuanceData      models.NuanceData   `json:"nuanceData"`
+	StartOffset     *int                `json:"startOffset,omitempty"`
+	EndOffset       *int                `json:"endOffset,omitempty"`
+	OCRBlock        *string             `json:"ocrBlock,omitempty"`
+	BoundingBox     *models.BoundingBox `json:"boundingBox,omitempty"`
+	CreatedAt       string              `json:"createdAt"`
 }
 
 type GetAnnotationsResponse struct {
@@ -75,27 +110,87 @@ func (h *AnnotationHandlers) CreateAnnotationAPI(w http.ResponseWriter, r *http.
 		return
 	}
 
+	var analysis *models.Analysis
+	if req.AnalysisID > 0 {
+		var ok bool
+		analysis, ok = h.applyAnalysis(w, r, userID, &req)
+		if !ok {
+			return
+		}
+	} else if h.config.RequireAnalysisID {
+		h.writeJSONError(w, http.StatusBadRequest, "analysisId is required")
+		return
+	}
+
 	if req.HighlightedText == "" {
 		h.writeJSONError(w, http.StatusBadRequest, "highlightedText is required")
 		return
 	}
 
+	req.NuanceData = normalizeNuance(req.NuanceData)
+	if err := validateNuanceFields(req.NuanceData); err != nil {
+		h.writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	var scanID *int64
 	if req.ScanID > 0 {
 		scanID = &req.ScanID
 	}
 
+	locatesHighlight := req.StartOffset != nil || req.EndOffset != nil || req.OCRBlock != nil || req.BoundingBox != nil
+	if scanID == nil && (req.PageNumber != nil || locatesHighlight) {
+		h.writeJSONError(w, http.StatusBadRequest, "pageNumber, offsets, ocrBlock and boundingBox require scanId")
+		return
+	}
+
+	if scanID != nil {
+		scan, err := authz.Scan(r.Context(), h.db, userID, req.ScanID)
+		if errors.Is(err, authz.ErrNotFound) {
+			h.writeJSONError(w, http.StatusNotFound, "Scan not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get scan: %v", err)
+			h.writeJSONError(w, http.StatusInternalServerError, "Failed to create annotation")
+			return
+		}
+		if req.PageNumber != nil && (*req.PageNumber < 1 || *req.PageNumber > scan.PageCount) {
+			h.writeJSONError(w, http.StatusBadRequest, "pageNumber is out of range for this scan")
+			return
+		}
+		if err := validateHighlight(scan, &req); err != nil {
+			h.writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	annotation := &models.Annotation{
 		UserID:          userID,
 		ScanID:          scanID,
+		PageNumber:      req.PageNumber,
 		HighlightedText: req.HighlightedText,
 		ContextText:     &req.ContextText,
 		NuanceData:      req.NuanceData,
 		IsBookmarked:    true,
+		StartOffset:     req.StartOffset,
+		EndOffset:       req.EndOffset,
+		OCRBlock:        req.OCRBlock,
+		BoundingBox:     req.BoundingBox,
 		CreatedAt:       time.Now(),
 	}
 
-	annotationID, err := h.db.CreateAnnotation(r.Context(), annotation)
+	var annotationID int64
+	var err error
+	if analysis != nil {
+		annotationID, err = h.db.CreateAnnotationFromAnalysis(r.Context(), annotation, analysis.ID)
+	} else {
+		annotationID, err = h.db.CreateAnnotation(r.Context(), annotation)
+	}
+	if errors.Is(err, storage.ErrAnalysisAlreadySaved) {
+		h.writeJSONError(w, http.StatusConflict, "Analysis has already been saved")
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to create annotation: %v", err)
 		h.writeJSONError(w, http.StatusInternalServerError, "Failed to create annotation")
@@ -112,37 +207,54 @@ func (h *AnnotationHandlers) CreateAnnotationAPI(w http.ResponseWriter, r *http.
 	json.NewEncoder(w).Encode(response)
 }
 
-func (h *AnnotationHandlers) GetAnnotationAPI(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
-		return
+// applyAnalysis fills req from the stored analysis it references, after
+// checking that the analysis is the user's and matches the request's scan
+// and text. It writes the error response and returns false otherwise.
+func (h *AnnotationHandlers) applyAnalysis(w http.ResponseWriter, r *http.Request, userID int64, req *CreateAnnotationRequest) (*models.Analysis, bool) {
+	analysis, err := authz.Analysis(r.Context(), h.db, userID, req.AnalysisID)
+	if errors.Is(err, authz.ErrNotFound) {
+		h.writeJSONError(w, http.StatusNotFound, "Analysis not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get analysis: %v", err)
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to create annotation")
+		return nil, false
+	}
+	if analysis.AnnotationID != nil {
+		h.writeJSONError(w, http.StatusConflict, "Analysis has already been saved")
+		return nil, false
 	}
 
-	userID := middleware.GetUserID(r.Context())
-	if userID == 0 {
-		h.writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
-		return
+	switch {
+	case req.ScanID == 0 && analysis.ScanID != nil:
+		req.ScanID = *analysis.ScanID
+	case req.ScanID > 0 && (analysis.ScanID == nil || *analysis.ScanID != req.ScanID):
+		h.writeJSONError(w, http.StatusBadRequest, "analysisId does not belong to this scan")
+		return nil, false
 	}
 
-	// Extract annotation ID from path: /v1/annotations/{id}
-	path := r.URL.Path
-	idStr := path[len("/v1/annotations/"):]
-	annotationID, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		h.writeJSONError(w, http.StatusBadRequest, "Invalid annotation ID")
-		return
+	if req.HighlightedText != "" && req.HighlightedText != analysis.Text {
+		h.writeJSONError(w, http.StatusBadRequest, "highlightedText does not match the analyzed text")
+		return nil, false
+	}
+	req.HighlightedText = analysis.Text
+	if req.ContextText == "" && analysis.ContextText != nil {
+		req.ContextText = *analysis.ContextText
 	}
+	req.NuanceData = analysis.NuanceData
 
-	annotation, err := h.db.GetAnnotationByID(r.Context(), annotationID)
-	if err != nil {
-		log.Printf("Failed to get annotation: %v", err)
-		h.writeJSONError(w, http.StatusNotFound, "Annotation not found")
+	return analysis, true
+}
+
+func (h *AnnotationHandlers) GetAnnotationAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Verify the annotation belongs to the user
-	if annotation.UserID != userID {
-		h.writeJSONError(w, http.StatusForbidden, "Access denied")
+	annotation, ok := h.ownedAnnotation(w, r)
+	if !ok {
 		return
 	}
 
@@ -153,9 +265,15 @@ func (h *AnnotationHandlers) GetAnnotationAPI(w http.ResponseWriter, r *http.Req
 
 	response := GetAnnotationResponse{
 		ID:              annotation.ID,
+		ScanID:          annotation.ScanID,
+		PageNumber:      annotation.PageNumber,
 		HighlightedText: annotation.HighlightedText,
 		ContextText:     contextText,
 		NuanceData:      annotation.NuanceData,
+		StartOffset:     annotation.StartOffset,
+		EndOffset:       annotation.EndOffset,
+		OCRBlock:        annotation.OCRBlock,
+		BoundingBox:     annotation.BoundingBox,
 		CreatedAt:       annotation.CreatedAt.Format(time.RFC3339),
 	}
 
@@ -188,7 +306,30 @@ func (h *AnnotationHandlers) GetAnnotationsAPI(w http.ResponseWriter, r *http.Re
 		size = 100
 	}
 
-	annotations, err := h.db.GetAnnotationsByUserID(r.Context(), userID, page, size)
+	query := r.URL.Query()
+	filter := models.AnnotationFilter{
+		JLPTLevel:          strings.ToUpper(query.Get("jlptLevel")),
+		PolitenessRegister: strings.ToLower(query.Get("politenessRegister")),
+		PartOfSpeech:       strings.ToLower(query.Get("partOfSpeech")),
+		Reading:            query.Get("reading"),
+		Sort:               query.Get("sort"),
+	}
+	if err := validateNuanceFields(models.NuanceData{
+		JLPTLevel:          filter.JLPTLevel,
+		PolitenessRegister: filter.PolitenessRegister,
+		PartOfSpeech:       filter.PartOfSpeech,
+	}); err != nil {
+		h.writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch filter.Sort {
+	case "", models.AnnotationSortNewest, models.AnnotationSortJLPT:
+	default:
+		h.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("sort must be %s or %s", models.AnnotationSortNewest, models.AnnotationSortJLPT))
+		return
+	}
+
+	annotations, err := h.db.GetAnnotationsByUserID(r.Context(), userID, filter, page, size)
 	if err != nil {
 		log.Printf("Failed to get annotations: %v", err)
 		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get annotations")
@@ -199,10 +340,16 @@ func (h *AnnotationHandlers) GetAnnotationsAPI(w http.ResponseWriter, r *http.Re
 	for i, ann := range annotations {
 		summary := summarizeNuance(ann.NuanceData)
 		data[i] = AnnotationListItem{
-			ID:              ann.ID,
-			HighlightedText: ann.HighlightedText,
-			NuanceSummary:   summary,
-			CreatedAt:       ann.CreatedAt.Format(time.RFC3339),
+			ID:                 ann.ID,
+			ScanID:             ann.ScanID,
+			PageNumber:         ann.PageNumber,
+			HighlightedText:    ann.HighlightedText,
+			NuanceSummary:      summary,
+			JLPTLevel:          ann.NuanceData.JLPTLevel,
+			PolitenessRegister: ann.NuanceData.PolitenessRegister,
+			PartOfSpeech:       ann.NuanceData.PartOfSpeech,
+			Reading:            ann.NuanceData.Reading,
+			CreatedAt:          ann.CreatedAt.Format(time.RFC3339),
 		}
 	}
 
@@ -239,6 +386,45 @@ func (h *AnnotationHandlers) writeJSONError(w http.ResponseWriter, statusCode in
 	})
 }
 
+// ownedAnnotation loads the annotation named in the request path and checks
+// that it belongs to the current user; other users' annotations are reported
+// as not found. It writes the error response and returns false otherwise.
+func (h *AnnotationHandlers) ownedAnnotation(w http.ResponseWriter, r *http.Request) (*models.Annotation, bool) {
+	userID := middleware.GetUserID(r.Context())
+	if userID == 0 {
+		h.writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+
+	idStr, _ := splitAnnotationPath(r.URL.Path)
+	annotationID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid annotation ID")
+		return nil, false
+	}
+
+	annotation, err := authz.Annotation(r.Context(), h.db, userID, annotationID)
+	if errors.Is(err, authz.ErrNotFound) {
+		h.writeJSONError(w, http.StatusNotFound, "Annotation not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get annotation: %v", err)
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get annotation")
+		return nil, false
+	}
+
+	return annotation, true
+}
+
+// splitAnnotationPath splits /v1/annotations/{id}/{action} into the ID and
+// the action, which is empty for the annotation itself.
+func splitAnnotationPath(urlPath string) (string, string) {
+	rest := strings.Trim(strings.TrimPrefix(urlPath, "/v1/annotations/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	return id, action
+}
+
 func (h *AnnotationHandlers) AnnotationsAPI(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
@@ -249,3 +435,16 @@ func (h *AnnotationHandlers) AnnotationsAPI(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// AnnotationAPI routes /v1/annotations/{id} and its sub-resources.
+func (h *AnnotationHandlers) AnnotationAPI(w http.ResponseWriter, r *http.Request) {
+	_, action := splitAnnotationPath(r.URL.Path)
+	switch action {
+	case "":
+		h.GetAnnotationAPI(w, r)
+	case "messages":
+		h.AnnotationMessagesAPI(w, r)
+	default:
+		h.writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
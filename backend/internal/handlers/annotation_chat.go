@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gemini-hackathon/app/internal/authz"
+	"github.com/gemini-hackathon/app/internal/gemini"
+	"github.com/gemini-hackathon/app/internal/knowledge"
+	"github.com/gemini-hackathon/app/internal/models"
+)
+
+const (
+	// maxChatMessageLength bounds a single question, in characters.
+	maxChatMessageLength = 2000
+
+	// maxChatHistory is the number of earlier messages sent to the model
+	// with a new question. Older ones stay stored but are left out.
+	maxChatHistory = 20
+)
+
+// Event types sent by SendAnnotationMessageAPI. A stream is any number of
+// delta events followed by exactly one done or error event.
+const (
+	ChatEventDelta = "delta"
+	ChatEventDone  = "done"
+	ChatEventError = "error"
+)
+
+type SendAnnotationMessageRequest struct {
+	Content string `json:"content"`
+}
+
+type AnnotationMessageResponse struct {
+	ID        int64  `json:"id"`
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"createdAt"`
+}
+
+type AnnotationMessagesResponse struct {
+	Data []AnnotationMessageResponse `json:"data"`
+}
+
+// AnnotationChatEvent is one message of a streamed chat reply. Text is the
+// next part of the reply; done carries both stored messages.
+type AnnotationChatEvent struct {
+	Type     string                     `json:"type"`
+	Text     string                     `json:"text,omitempty"`
+	Question *AnnotationMessageResponse `json:"question,omitempty"`
+	Reply    *AnnotationMessageResponse `json:"reply,omitempty"`
+	Error    string                     `json:"error,omitempty"`
+}
+
+func (h *AnnotationHandlers) AnnotationMessagesAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetAnnotationMessagesAPI(w, r)
+	case http.MethodPost:
+		h.SendAnnotationMessageAPI(w, r)
+	default:
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GetAnnotationMessagesAPI returns the chat about an annotation, oldest
+// message first.
+func (h *AnnotationHandlers) GetAnnotationMessagesAPI(w http.ResponseWriter, r *http.Request) {
+	annotation, ok := h.ownedAnnotation(w, r)
+	if !ok {
+		return
+	}
+
+	messages, err := h.db.GetAnnotationMessages(r.Context(), annotation.ID)
+	if err != nil {
+		log.Printf("Failed to get annotation messages: %v", err)
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get messages")
+		return
+	}
+
+	data := make([]AnnotationMessageResponse, len(messages))
+	for i, message := range messages {
+		data[i] = toAnnotationMessageResponse(message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AnnotationMessagesResponse{Data: data})
+}
+
+// SendAnnotationMessageAPI asks the model a follow-up question about an
+// annotation and streams the reply like AnalyzeStreamAPI. The model sees the
+// earlier messages, the scan text and the knowledge entries for the
+// highlighted text. The question and reply are stored once the reply is
+// complete, so a failed reply leaves the history unchanged.
+func (h *AnnotationHandlers) SendAnnotationMessageAPI(w http.ResponseWriter, r *http.Request) {
+	annotation, ok := h.ownedAnnotation(w, r)
+	if !ok {
+		return
+	}
+
+	var req SendAnnotationMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	question := strings.TrimSpace(req.Content)
+	if question == "" {
+		h.writeJSONError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+	if utf8.RuneCountInString(question) > maxChatMessageLength {
+		h.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("content must be at most %d characters", maxChatMessageLength))
+		return
+	}
+
+	chatReq, err := h.annotationChatRequest(r, annotation, question)
+	if err != nil {
+		log.Printf("Failed to load annotation chat context: %v", err)
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to send message")
+		return
+	}
+
+	stream := startEventStream(w, r)
+	send := func(event AnnotationChatEvent) {
+		stream.send(event.Type, event)
+	}
+
+	resp, err := h.geminiClient.ChatAboutAnnotation(r.Context(), *chatReq, func(text string) {
+		send(AnnotationChatEvent{Type: ChatEventDelta, Text: text})
+	})
+	if err != nil {
+		log.Printf("Failed to generate chat reply: %v", err)
+		send(AnnotationChatEvent{Type: ChatEventError, Error: "Failed to generate reply"})
+		return
+	}
+
+	questionMessage := &models.AnnotationMessage{
+		AnnotationID: annotation.ID,
+		Role:         models.MessageRoleUser,
+		Content:      question,
+		CreatedAt:    time.Now(),
+	}
+	replyMessage := &models.AnnotationMessage{
+		AnnotationID: annotation.ID,
+		Role:         models.MessageRoleModel,
+		Content:      resp.Text,
+		CreatedAt:    time.Now(),
+	}
+	if resp.Model != "" {
+		replyMessage.Model = &resp.Model
+	}
+	if err := h.db.CreateAnnotationMessages(r.Context(), questionMessage, replyMessage); err != nil {
+		log.Printf("Failed to save annotation messages: %v", err)
+		send(AnnotationChatEvent{Type: ChatEventError, Error: "Failed to save reply"})
+		return
+	}
+
+	questionResponse := toAnnotationMessageResponse(questionMessage)
+	replyResponse := toAnnotationMessageResponse(replyMessage)
+	send(AnnotationChatEvent{Type: ChatEventDone, Question: &questionResponse, Reply: &replyResponse})
+}
+
+// annotationChatRequest gathers what the model needs to answer a question:
+// the annotation's original context, its explanation, the user's language
+// and the latest messages of the chat.
+func (h *AnnotationHandlers) annotationChatRequest(r *http.Request, annotation *models.Annotation, question string) (*gemini.AnnotationChatRequest, error) {
+	ctx := r.Context()
+
+	ocrText := ""
+	if annotation.ContextText != nil {
+		ocrText = *annotation.ContextText
+	}
+	if annotation.ScanID != nil {
+		scan, err := authz.Scan(ctx, h.db, annotation.UserID, *annotation.ScanID)
+		if err != nil && !errors.Is(err, authz.ErrNotFound) {
+			return nil, fmt.Errorf("failed to get scan: %w", err)
+		}
+		if scan != nil && scan.FullOCRText != nil && *scan.FullOCRText != "" {
+			ocrText = *scan.FullOCRText
+		}
+	}
+
+	user, err := h.db.GetUserByID(ctx, annotation.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	targetLanguage := "ID"
+	if user != nil && user.PreferredLanguage != "" {
+		targetLanguage = user.PreferredLanguage
+	}
+
+	messages, err := h.db.GetAnnotationMessages(ctx, annotation.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	if len(messages) > maxChatHistory {
+		messages = messages[len(messages)-maxChatHistory:]
+	}
+	history := make([]gemini.ChatTurn, len(messages))
+	for i, message := range messages {
+		role := gemini.ChatRoleUser
+		if message.Role == models.MessageRoleModel {
+			role = gemini.ChatRoleModel
+		}
+		history[i] = gemini.ChatTurn{Role: role, Text: message.Content}
+	}
+
+	var entries []knowledge.Entry
+	if knowledgeSvc := userKnowledge(ctx, h.db, h.knowledge, annotation.UserID); knowledgeSvc != nil {
+		entries = knowledgeSvc.Lookup(annotation.HighlightedText)
+	}
+
+	return &gemini.AnnotationChatRequest{
+		OCRText:        ocrText,
+		SelectedText:   annotation.HighlightedText,
+		Explanation:    explainNuance(annotation.NuanceData),
+		Entries:        entries,
+		TargetLanguage: targetLanguage,
+		History:        history,
+		Question:       question,
+	}, nil
+}
+
+// explainNuance renders the stored explanation of an annotation for a
+// prompt, skipping empty fields.
+func explainNuance(nuance models.NuanceData) string {
+	fields := []struct{ label, value string }{
+		{"Meaning", nuance.Meaning},
+		{"Usage example", nuance.UsageExample},
+		{"When to use", nuance.UsageTiming},
+		{"Word breakdown", nuance.WordBreakdown},
+		{"Alternative meanings", nuance.AlternativeMeaning},
+		{"Politeness register", nuance.PolitenessRegister},
+		{"JLPT level", nuance.JLPTLevel},
+	}
+
+	var sb strings.Builder
+	for _, field := range fields {
+		if field.value != "" {
+			fmt.Fprintf(&sb, "%s: %s\n", field.label, field.value)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func toAnnotationMessageResponse(message *models.AnnotationMessage) AnnotationMessageResponse {
+	return AnnotationMessageResponse{
+		ID:        message.ID,
+		Role:      message.Role,
+		Content:   message.Content,
+		CreatedAt: message.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gemini-hackathon/app/internal/auth"
@@ -18,37 +20,86 @@ import (
 )
 
 type AuthHandlers struct {
-	googleOAuth  *auth.GoogleOAuthService
+	providers    map[string]auth.Provider
+	redis        storage.RedisClient
 	tokenService *auth.TokenService
+	sessions     *auth.SessionService
+	magicLinks   *auth.MagicLinkService
 	db           storage.DB
 	config       *config.Config
 }
 
-func NewAuthHandlers(googleOAuth *auth.GoogleOAuthService, tokenService *auth.TokenService, db storage.DB, cfg *config.Config) *AuthHandlers {
+func NewAuthHandlers(providers []auth.Provider, redis storage.RedisClient, tokenService *auth.TokenService, sessions *auth.SessionService, db storage.DB, cfg *config.Config) *AuthHandlers {
+	byName := make(map[string]auth.Provider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
 	return &AuthHandlers{
-		googleOAuth:  googleOAuth,
+		providers:    byName,
+		redis:        redis,
 		tokenService: tokenService,
+		sessions:     sessions,
 		db:           db,
 		config:       cfg,
 	}
 }
 
-type GoogleStateResponse struct {
+// WithMagicLinks enables signing in by email through links sent by magicLinks.
+func (h *AuthHandlers) WithMagicLinks(magicLinks *auth.MagicLinkService) *AuthHandlers {
+	h.magicLinks = magicLinks
+	return h
+}
+
+type OAuthStateResponse struct {
 	SSORedirection string `json:"ssoRedirection"`
 }
 
-type GoogleCallbackRequest struct {
+type OAuthCallbackRequest struct {
 	Code  string `json:"code"`
 	State string `json:"state"`
 }
 
-type GoogleCallbackResponse struct {
-	Token         string `json:"token"`
-	ExpirySeconds int    `json:"expirySeconds"`
-	ExpiresAt     string `json:"expiresAt"`
+// TokenResponse is returned when signing in and refreshing. Token is the
+// access token; RefreshToken is single-use and replaced on every refresh.
+// RedirectTo is the checked post-login target the sign-in was started with.
+type TokenResponse struct {
+	Token            string `json:"token"`
+	ExpirySeconds    int    `json:"expirySeconds"`
+	ExpiresAt        string `json:"expiresAt"`
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresAt string `json:"refreshExpiresAt"`
+	SessionID        string `json:"sessionId"`
+	RedirectTo       string `json:"redirectTo,omitempty"`
+}
+
+// OAuthAPI routes /v1/auth/{provider}/state and /v1/auth/{provider}/callback
+// to the provider's sign-in handlers.
+func (h *AuthHandlers) OAuthAPI(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/auth/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	provider, ok := h.providers[parts[0]]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch parts[1] {
+	case "state":
+		h.OAuthStateAPI(w, r, provider)
+	case "callback":
+		h.OAuthCallback(w, r, provider)
+	default:
+		http.NotFound(w, r)
+	}
 }
 
-func (h *AuthHandlers) GoogleStateAPI(w http.ResponseWriter, r *http.Request) {
+// OAuthStateAPI starts signing in with provider. The optional redirect query
+// parameter is where the frontend should send the user afterwards; it is
+// checked against OAUTH_REDIRECT_ALLOWLIST and returned with the tokens.
+func (h *AuthHandlers) OAuthStateAPI(w http.ResponseWriter, r *http.Request, provider auth.Provider) {
 	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
 
 	if r.Method != http.MethodGet {
@@ -57,34 +108,48 @@ func (h *AuthHandlers) GoogleStateAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	state := generateState(32)
-	redirectURL := h.googleOAuth.GetAuthURL(state)
+	redirectTo := r.URL.Query().Get("redirect")
+	if err := h.checkRedirect(redirectTo); err != nil {
+		log.Warnf("Rejected post-login redirect: %s", redirectTo)
+		http.Error(w, "Redirect not allowed", http.StatusBadRequest)
+		return
+	}
 
-	if err := h.googleOAuth.RedisClient().SetState(r.Context(), state, "", 10*time.Minute); err != nil {
+	redirectURL, err := h.startOAuth(w, r, provider, 0, redirectTo)
+	if err != nil {
 		log.ErrorWithErr(err, "Failed to store state in Redis")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	log.Infof("Generated OAuth state for Google SSO")
+	log.Infof("Generated OAuth state for %s SSO", provider.Name())
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(GoogleStateResponse{
+	json.NewEncoder(w).Encode(OAuthStateResponse{
 		SSORedirection: redirectURL,
 	})
 }
 
-func (h *AuthHandlers) GoogleCallbackRedirect(w http.ResponseWriter, r *http.Request) {
-	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
-
-	if r.Method != http.MethodGet {
-		log.Warnf("Method not allowed: %s", r.Method)
+// OAuthCallback handles the provider redirecting the browser back (GET, or
+// a form POST for Apple) and the frontend posting the code as JSON.
+func (h *AuthHandlers) OAuthCallback(w http.ResponseWriter, r *http.Request, provider auth.Provider) {
+	switch {
+	case r.Method == http.MethodGet:
+		h.OAuthCallbackRedirect(w, r, provider)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded"):
+		h.OAuthCallbackRedirect(w, r, provider)
+	case r.Method == http.MethodPost:
+		h.OAuthCallbackAPI(w, r, provider)
+	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
 	}
+}
 
-	state := r.URL.Query().Get("state")
-	code := r.URL.Query().Get("code")
+func (h *AuthHandlers) OAuthCallbackRedirect(w http.ResponseWriter, r *http.Request, provider auth.Provider) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+
+	state := r.FormValue("state")
+	code := r.FormValue("code")
 
 	if state == "" || code == "" {
 		log.Warn("Missing state or code in OAuth callback")
@@ -96,22 +161,16 @@ func (h *AuthHandlers) GoogleCallbackRedirect(w http.ResponseWriter, r *http.Req
 	if len(state) > 8 {
 		statePreview = state[:8]
 	}
-	log.Infof("Processing OAuth callback with state: %s...", statePreview)
+	log.Infof("Processing %s OAuth callback with state: %s...", provider.Name(), statePreview)
 
-	redirectURL := fmt.Sprintf("%s/auth/callback?state=%s&code=%s", h.config.AppBaseURL, state, code)
+	redirectURL := buildCallbackURL(h.config.AppBaseURL, state, code, provider.Name())
 	http.Redirect(w, r, redirectURL, http.StatusFound)
 }
 
-func (h *AuthHandlers) GoogleCallbackAPI(w http.ResponseWriter, r *http.Request) {
+func (h *AuthHandlers) OAuthCallbackAPI(w http.ResponseWriter, r *http.Request, provider auth.Provider) {
 	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
 
-	if r.Method != http.MethodPost {
-		log.Warnf("Method not allowed: %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req GoogleCallbackRequest
+	var req OAuthCallbackRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Warnf("Failed to decode request body: %v", err)
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -132,108 +191,161 @@ func (h *AuthHandlers) GoogleCallbackAPI(w http.ResponseWriter, r *http.Request)
 
 	log.Infof("Attempting to exchange authorization code")
 
-	_, err := h.googleOAuth.RedisClient().GetState(r.Context(), req.State)
+	state, err := h.consumeOAuthState(r, req.State, provider, 0)
+	if errors.Is(err, errInvalidState) {
+		log.Warnf("Invalid, reused or foreign state for %s", provider.Name())
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.ErrorWithErr(err, "State validation failed")
-		http.Error(w, "Invalid state", http.StatusBadRequest)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	oauthToken, err := h.googleOAuth.ExchangeCode(r.Context(), req.Code)
+	oauthToken, err := provider.ExchangeCode(r.Context(), req.Code, &state.AuthRequest)
 	if err != nil {
-		log.ErrorWithErr(err, "Failed to exchange code with Google")
+		log.ErrorWithErr(err, "Failed to exchange code with provider")
 		http.Error(w, "Failed to exchange code", http.StatusInternalServerError)
 		return
 	}
 
-	userInfo, err := h.googleOAuth.GetUserInfo(r.Context(), oauthToken)
+	userInfo, err := provider.GetUserInfo(r.Context(), oauthToken, &state.AuthRequest)
 	if err != nil {
-		log.ErrorWithErr(err, "Failed to get user info from Google")
+		log.ErrorWithErr(err, "Failed to get user info from provider")
 		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
 		return
 	}
 
-	log.Infof("Retrieved user info from Google: %s", userInfo.Email)
+	log.Infof("Retrieved user info from %s: %s", provider.Name(), userInfo.Email)
+
+	h.signIn(w, r, provider.Name(), userInfo, state.RedirectTo)
+}
 
-	user, err := h.db.GetUserByProvider(r.Context(), "google", userInfo.ID)
+// signIn finds or creates the user for a provider account and responds with
+// the tokens of a new session, along with redirectTo. Disabled accounts are
+// refused. An account new to the app joins the user who
+// already has a verified identity with the same email, if the provider
+// verified it too; unverified emails never link accounts.
+func (h *AuthHandlers) signIn(w http.ResponseWriter, r *http.Request, provider string, userInfo *auth.UserInfo, redirectTo string) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+
+	user, isNewUser, err := h.findOrCreateUser(r, provider, userInfo)
 	if err != nil {
-		log.ErrorWithErr(err, "Failed to get user by provider from database")
+		log.ErrorWithErr(err, "Failed to find or create user")
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-
-	isNewUser := false
-	if user == nil {
-		user = &models.User{
-			Email:             userInfo.Email,
-			Provider:          "google",
-			ProviderID:        userInfo.ID,
-			PreferredLanguage: "ID",
-			CreatedAt:         time.Now(),
-			UpdatedAt:         time.Now(),
-		}
-		if userInfo.PictureURL != "" {
-			user.AvatarURL = &userInfo.PictureURL
-		}
-
-		if err := h.db.CreateUser(r.Context(), user); err != nil {
-			log.ErrorWithErr(err, "Failed to create new user in database")
-			http.Error(w, "Failed to create user", http.StatusInternalServerError)
-			return
-		}
-		isNewUser = true
+	if user.DisabledAt != nil {
+		log.Warnf("Sign-in refused for disabled user %d", user.ID)
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+	if isNewUser {
 		log.Infof("Created new user: %s (ID: %d)", user.Email, user.ID)
 	} else {
 		log.Infof("Existing user authenticated: %s (ID: %d)", user.Email, user.ID)
 	}
 
-	token, expiresAt, err := h.tokenService.GenerateToken(user.ID)
+	tokens, err := h.sessions.Start(r.Context(), user.ID, requestClient(r))
 	if err != nil {
-		log.ErrorWithErr(err, "Failed to generate JWT token")
+		log.ErrorWithErr(err, "Failed to start session")
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
 	log.WithFields(map[string]any{
 		"user_id":          user.ID,
+		"provider":         provider,
 		"is_new_user":      isNewUser,
 		"token_expiry_sec": h.config.TokenExpiryMinutes * 60,
 	}).Infof("Successfully generated JWT token for user")
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(GoogleCallbackResponse{
-		Token:         token,
-		ExpirySeconds: int(h.config.TokenExpiryMinutes * 60),
-		ExpiresAt:     expiresAt.Format(time.RFC3339),
-	})
+	h.writeTokens(w, tokens, redirectTo)
 }
 
-func (h *AuthHandlers) GoogleCallback(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		h.GoogleCallbackRedirect(w, r)
-	case http.MethodPost:
-		h.GoogleCallbackAPI(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func (h *AuthHandlers) findOrCreateUser(r *http.Request, provider string, userInfo *auth.UserInfo) (*models.User, bool, error) {
+	ctx := r.Context()
+
+	identity, err := h.db.GetUserIdentity(ctx, provider, userInfo.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	if identity != nil {
+		user, err := h.db.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, false, err
+		}
+		if user == nil {
+			return nil, false, fmt.Errorf("identity %d has no user", identity.ID)
+		}
+		return user, false, nil
 	}
+
+	identity = &models.UserIdentity{
+		Provider:      provider,
+		ProviderID:    userInfo.ID,
+		Email:         userInfo.Email,
+		EmailVerified: userInfo.EmailVerified,
+		CreatedAt:     time.Now(),
+	}
+
+	if userInfo.EmailVerified {
+		user, err := h.db.GetUserByVerifiedEmail(ctx, userInfo.Email)
+		if err != nil {
+			return nil, false, err
+		}
+		if user != nil {
+			identity.UserID = user.ID
+			if _, err := h.db.CreateUserIdentity(ctx, identity); err != nil {
+				return nil, false, err
+			}
+			logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(ctx)).WithUserID(user.ID).Infof("Linked %s identity by verified email", provider)
+			return user, false, nil
+		}
+	}
+
+	user := &models.User{
+		Email:             userInfo.Email,
+		Provider:          provider,
+		ProviderID:        userInfo.ID,
+		PreferredLanguage: "ID",
+		Role:              models.RoleUser,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+	}
+	if userInfo.PictureURL != "" {
+		user.AvatarURL = &userInfo.PictureURL
+	}
+	if err := h.db.CreateUserWithIdentity(ctx, user, identity); err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
 }
 
-func generateState(n int) string {
-	b := make([]byte, n)
-	rand.Read(b)
-	return hex.EncodeToString(b)
+func (h *AuthHandlers) writeTokens(w http.ResponseWriter, tokens *auth.TokenPair, redirectTo string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TokenResponse{
+		Token:            tokens.AccessToken,
+		ExpirySeconds:    int(h.config.TokenExpiryMinutes * 60),
+		ExpiresAt:        tokens.AccessExpiresAt.Format(time.RFC3339),
+		RefreshToken:     tokens.RefreshToken,
+		RefreshExpiresAt: tokens.RefreshExpiresAt.Format(time.RFC3339),
+		SessionID:        tokens.SessionID,
+		RedirectTo:       redirectTo,
+	})
 }
 
-func generateCallbackState(n int) string {
+func generateState(n int) string {
 	b := make([]byte, n)
 	rand.Read(b)
 	return hex.EncodeToString(b)
 }
 
-func buildCallbackURL(baseURL, state, code string) string {
+func buildCallbackURL(baseURL, state, code, provider string) string {
 	params := url.Values{}
 	params.Set("state", state)
 	params.Set("code", code)
+	params.Set("provider", provider)
 	return fmt.Sprintf("%s/auth/callback?%s", baseURL, params.Encode())
 }
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// eventStream writes a streamed response as Server-Sent Events, or as
+// newline-delimited JSON when the client accepts application/x-ndjson. Every
+// event body carries its own type so both formats hold the same information.
+type eventStream struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	ndjson bool
+}
+
+// startEventStream writes the headers of a 200 response; errors after this
+// point have to be sent as events.
+func startEventStream(w http.ResponseWriter, r *http.Request) *eventStream {
+	ndjson := strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	return &eventStream{w: w, rc: http.NewResponseController(w), ndjson: ndjson}
+}
+
+func (s *eventStream) send(eventType string, event any) {
+	data, _ := json.Marshal(event)
+	if s.ndjson {
+		fmt.Fprintf(s.w, "%s\n", data)
+	} else {
+		fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", eventType, data)
+	}
+	s.rc.Flush()
+}
@@ -1,18 +1,41 @@
 package handlers_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"golang.org/x/oauth2"
+
 	"github.com/gemini-hackathon/app/internal/auth"
 	"github.com/gemini-hackathon/app/internal/config"
+	"github.com/gemini-hackathon/app/internal/gemini"
 	"github.com/gemini-hackathon/app/internal/handlers"
+	"github.com/gemini-hackathon/app/internal/imageproc"
+	"github.com/gemini-hackathon/app/internal/knowledge"
 	"github.com/gemini-hackathon/app/internal/middleware"
 	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
 	"github.com/gemini-hackathon/app/internal/testutil"
 )
 
@@ -64,6 +87,110 @@ func TestTokenService(t *testing.T) {
 	})
 }
 
+func TestURLSigner(t *testing.T) {
+	signer := auth.NewURLSigner("test-secret", 15)
+
+	t.Run("SignAndVerify", func(t *testing.T) {
+		signed := signer.Sign("/v1/scans/1/image", 42)
+		u, _ := url.Parse(signed)
+
+		userID, err := signer.Verify(u.Path, u.Query())
+		if err != nil {
+			t.Fatalf("Failed to verify signed URL: %v", err)
+		}
+		if userID != 42 {
+			t.Errorf("Expected user ID 42, got %d", userID)
+		}
+	})
+
+	t.Run("VerifyDifferentPath", func(t *testing.T) {
+		u, _ := url.Parse(signer.Sign("/v1/scans/1/image", 42))
+		if _, err := signer.Verify("/v1/scans/2/image", u.Query()); err != auth.ErrSignatureInvalid {
+			t.Errorf("Expected ErrSignatureInvalid, got %v", err)
+		}
+	})
+
+	t.Run("VerifyTamperedUser", func(t *testing.T) {
+		u, _ := url.Parse(signer.Sign("/v1/scans/1/image", 42))
+		q := u.Query()
+		q.Set("uid", "43")
+		if _, err := signer.Verify(u.Path, q); err != auth.ErrSignatureInvalid {
+			t.Errorf("Expected ErrSignatureInvalid, got %v", err)
+		}
+	})
+
+	t.Run("VerifyExpired", func(t *testing.T) {
+		expired := auth.NewURLSigner("test-secret", -1)
+		u, _ := url.Parse(expired.Sign("/v1/scans/1/image", 42))
+		if _, err := signer.Verify(u.Path, u.Query()); err != auth.ErrSignatureExpired {
+			t.Errorf("Expected ErrSignatureExpired, got %v", err)
+		}
+	})
+}
+
+func TestTokenKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	privateDER, _ := x509.MarshalPKCS8PrivateKey(private)
+	publicDER, _ := x509.MarshalPKIXPublicKey(public)
+	privatePath := filepath.Join(dir, "ed25519.pem")
+	publicPath := filepath.Join(dir, "ed25519.pub.pem")
+	os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600)
+	os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o644)
+
+	oldKeys, _ := auth.LoadKeySet(&config.Config{JWTSigningAlg: "HS256", JWTKeyID: "old", JWTSecret: "old-secret"})
+	oldService := auth.NewTokenServiceWithKeys(oldKeys, 30)
+	oldToken, _, _ := oldService.GenerateToken(7)
+
+	newKeys, err := auth.LoadKeySet(&config.Config{
+		JWTSigningAlg:     "EdDSA",
+		JWTKeyID:          "new",
+		JWTPrivateKeyFile: privatePath,
+		JWTVerifyKeys:     []string{"old:HS256:old-secret"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+	newService := auth.NewTokenServiceWithKeys(newKeys, 30)
+
+	t.Run("AcceptsTokensFromPreviousKey", func(t *testing.T) {
+		if userID, err := newService.ValidateToken(oldToken); err != nil || userID != 7 {
+			t.Errorf("Expected old token to validate for user 7, got %d, %v", userID, err)
+		}
+	})
+
+	t.Run("SignsWithCurrentKey", func(t *testing.T) {
+		token, _, _ := newService.GenerateToken(8)
+		if _, err := oldService.ValidateToken(token); err == nil {
+			t.Error("Expected a service without the new key to reject its token")
+		}
+		verifyOnly, _ := auth.LoadPublicKey("new", "EdDSA", publicPath)
+		keys, _ := auth.NewKeySet(auth.NewHMACKey("other", "x"), verifyOnly)
+		if userID, err := auth.NewTokenServiceWithKeys(keys, 30).ValidateToken(token); err != nil || userID != 8 {
+			t.Errorf("Expected the public key to verify the token, got %d, %v", userID, err)
+		}
+	})
+
+	t.Run("RejectsUnknownKey", func(t *testing.T) {
+		otherKeys, _ := auth.NewKeySet(auth.NewHMACKey("unknown", "old-secret"))
+		token, _, _ := auth.NewTokenServiceWithKeys(otherKeys, 30).GenerateToken(9)
+		if _, err := newService.ValidateToken(token); err == nil {
+			t.Error("Expected a token with an unknown kid to be rejected")
+		}
+	})
+
+	t.Run("JWKS", func(t *testing.T) {
+		jwks := newService.JWKS()
+		if len(jwks.Keys) != 1 {
+			t.Fatalf("Expected only the public key to be published, got %+v", jwks.Keys)
+		}
+		key := jwks.Keys[0]
+		if key.KeyID != "new" || key.KeyType != "OKP" || key.Algorithm != "EdDSA" || key.X == "" {
+			t.Errorf("Unexpected JWK: %+v", key)
+		}
+	})
+}
+
 func TestGetUserIDMiddleware(t *testing.T) {
 	tokenService := auth.NewTokenService("test-secret", 30)
 	authMiddleware := middleware.NewAuthMiddleware(tokenService)
@@ -230,6 +357,58 @@ func TestUserHandlers(t *testing.T) {
 	})
 }
 
+func TestAuthMiddlewareWithSignedURL(t *testing.T) {
+	tokenService := auth.NewTokenService("test-secret", 30)
+	signer := auth.NewURLSigner("signing-secret", 15)
+	authMiddleware := middleware.NewAuthMiddleware(tokenService).WithURLSigner(signer)
+
+	t.Run("ValidSignature", func(t *testing.T) {
+		req := httptest.NewRequest("GET", signer.Sign("/v1/scans/1/image", 7), nil)
+
+		var gotUserID int64
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotUserID = middleware.GetUserID(r.Context())
+		})
+
+		authMiddleware.Handle(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+		if gotUserID != 7 {
+			t.Errorf("Expected user ID 7, got %d", gotUserID)
+		}
+	})
+
+	t.Run("SignatureForOtherPath", func(t *testing.T) {
+		signed := signer.Sign("/v1/scans/1/image", 7)
+		req := httptest.NewRequest("GET", strings.Replace(signed, "/v1/scans/1/image", "/v1/scans/2/image", 1), nil)
+
+		handler := authMiddleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("Handler should not be called with a signature for another path")
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("SignatureIgnoredForPost", func(t *testing.T) {
+		req := httptest.NewRequest("POST", signer.Sign("/v1/scans/1/image", 7), nil)
+
+		handler := authMiddleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("Handler should not be called for a signed POST")
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", rec.Code)
+		}
+	})
+}
+
 func TestAuthMiddlewareWithXToken(t *testing.T) {
 	tokenService := auth.NewTokenService("test-secret", 30)
 	authMiddleware := middleware.NewAuthMiddleware(tokenService)
@@ -256,8 +435,81 @@ func TestAuthMiddlewareWithXToken(t *testing.T) {
 
 func TestScanHandlers(t *testing.T) {
 	mockDB := testutil.NewMockDB()
-	cfg := &config.Config{DefaultPageSize: 20, MaxUploadSize: 10 * 1024 * 1024}
-	scanHandlers := handlers.NewScanHandlers(mockDB, nil, nil, cfg)
+	cfg := &config.Config{DefaultPageSize: 20, MaxUploadSize: 10 * 1024 * 1024, MaxRequestSize: 64 * 1024, MaxPagesPerScan: 2, ImageMaxSide: 1000, ImageMaxPixels: 100000}
+	scanHandlers := handlers.NewScanHandlers(mockDB, nil, nil, nil, nil, nil, auth.NewURLSigner("test-secret", 15), cfg)
+
+	var pngBuf bytes.Buffer
+	png.Encode(&pngBuf, image.NewGray(image.Rect(0, 0, 8, 8)))
+	pngData := pngBuf.Bytes()
+	pdfData := []byte("%PDF-1.4\ntrailer\n<<>>\n%%EOF\n")
+
+	type uploadFile struct {
+		contentType string
+		data        []byte
+	}
+
+	newUploadRequest := func(files ...uploadFile) *http.Request {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for i, file := range files {
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="image"; filename="page%d"`, i+1))
+			header.Set("Content-Type", file.contentType)
+			part, _ := writer.CreatePart(header)
+			part.Write(file.data)
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/v1/scans", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req.WithContext(middleware.WithUserID(req.Context(), 1))
+	}
+
+	uploadTests := []struct {
+		name       string
+		files      []uploadFile
+		wantStatus int
+		wantCode   string
+	}{
+		{"MissingFile", nil, http.StatusBadRequest, handlers.ErrCodeMissingFile},
+		{"TooManyPages", []uploadFile{{"image/png", pngData}, {"image/png", pngData}, {"image/png", pngData}}, http.StatusBadRequest, handlers.ErrCodeTooManyFiles},
+		{"PDFWithImages", []uploadFile{{"image/png", pngData}, {"application/pdf", pdfData}}, http.StatusBadRequest, handlers.ErrCodePDFNotAlone},
+		{"UnsupportedType", []uploadFile{{"text/plain", []byte("hello")}}, http.StatusUnsupportedMediaType, handlers.ErrCodeUnsupportedType},
+		{"TypeMismatch", []uploadFile{{"image/jpeg", pngData}}, http.StatusBadRequest, handlers.ErrCodeTypeMismatch},
+		{"Polyglot", []uploadFile{{"image/png", append(bytes.Clone(pngData), "<?php echo 1; ?>"...)}}, http.StatusBadRequest, handlers.ErrCodePolyglotFile},
+		{"RequestTooLarge", []uploadFile{{"image/png", make([]byte, 128*1024)}}, http.StatusRequestEntityTooLarge, handlers.ErrCodeRequestTooLarge},
+	}
+
+	for _, tt := range uploadTests {
+		t.Run("CreateScanAPI_"+tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			scanHandlers.CreateScanAPI(rec, newUploadRequest(tt.files...))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("Expected error code %s, got %s", tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("CreateScanAPI_DecompressionBomb", func(t *testing.T) {
+		// 2000x2000 of a single colour compresses to a few KB but exceeds
+		// the configured side limit.
+		var bomb bytes.Buffer
+		png.Encode(&bomb, image.NewGray(image.Rect(0, 0, 2000, 2000)))
+
+		rec := httptest.NewRecorder()
+		scanHandlers.CreateScanAPI(rec, newUploadRequest(uploadFile{"image/png", bomb.Bytes()}))
+
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status 413, got %d", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), handlers.ErrCodeDimensionsTooLarge) {
+			t.Errorf("Expected dimensions error code, got %s", rec.Body.String())
+		}
+	})
 
 	t.Run("GetScansAPI_Unauthorized", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/v1/scans", nil)
@@ -340,74 +592,2314 @@ func TestScanHandlers(t *testing.T) {
 	})
 }
 
-func TestAnnotationHandlers(t *testing.T) {
+func TestCreateScanBatchAPI(t *testing.T) {
 	mockDB := testutil.NewMockDB()
-	cfg := &config.Config{DefaultPageSize: 20}
-	annotationHandlers := handlers.NewAnnotationHandlers(mockDB, cfg)
+	fileStorage, err := storage.NewLocalFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file storage: %v", err)
+	}
+	cfg := &config.Config{MaxUploadSize: 1024 * 1024, MaxRequestSize: 4 * 1024 * 1024, MaxBatchFiles: 3, OCRConcurrency: 1, ImageMaxSide: 1000, ImageMaxPixels: 1000000}
+	geminiClient := &testutil.MockGeminiClient{OCRText: "お知らせ", Language: "JP"}
+	scanHandlers := handlers.NewScanHandlers(mockDB, fileStorage, geminiClient, nil, nil, nil, auth.NewURLSigner("test-secret", 15), cfg)
+
+	var pngBuf bytes.Buffer
+	png.Encode(&pngBuf, image.NewGray(image.Rect(0, 0, 8, 8)))
+
+	newBatchRequest := func(count int, data ...[]byte) *http.Request {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for i := 0; i < count; i++ {
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="image"; filename="IMG_%d.png"`, i))
+			header.Set("Content-Type", "image/png")
+			part, _ := writer.CreatePart(header)
+			part.Write(data[i%len(data)])
+		}
+		writer.Close()
 
-	t.Run("GetAnnotationsAPI_Unauthorized", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/v1/annotations", nil)
+		req := httptest.NewRequest("POST", "/v1/scans/batch", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req.WithContext(middleware.WithUserID(req.Context(), 1))
+	}
+
+	t.Run("PartialFailure", func(t *testing.T) {
 		rec := httptest.NewRecorder()
-		annotationHandlers.GetAnnotationsAPI(rec, req)
+		scanHandlers.CreateScanBatchAPI(rec, newBatchRequest(3, pngBuf.Bytes(), []byte("not an image")))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+
+		var response handlers.BatchScanResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.Created != 2 || response.Rejected != 1 || len(response.Results) != 3 {
+			t.Fatalf("Expected 2 created and 1 rejected, got %+v", response)
+		}
+		if response.Results[1].Status != handlers.BatchStatusRejected || response.Results[1].Error.Code != handlers.ErrCodeUnsupportedType {
+			t.Errorf("Expected second file rejected as unsupported, got %+v", response.Results[1])
+		}
+		if response.Results[0].Scan == nil || response.Results[0].Filename != "IMG_0.png" {
+			t.Errorf("Expected first file to create a scan, got %+v", response.Results[0])
+		}
+	})
+
+	t.Run("TooManyFiles", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.CreateScanBatchAPI(rec, newBatchRequest(4, pngBuf.Bytes()))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/scans/batch", nil)
+		rec := httptest.NewRecorder()
+		scanHandlers.CreateScanBatchAPI(rec, req)
 
 		if rec.Code != http.StatusUnauthorized {
 			t.Errorf("Expected status 401, got %d", rec.Code)
 		}
 	})
+}
 
-	t.Run("GetAnnotationsAPI_Authorized", func(t *testing.T) {
-		req := httptest.NewRequest("GET", "/v1/annotations?page=1&size=10", nil)
-		ctx := middleware.WithUserID(req.Context(), 1)
-		req = req.WithContext(ctx)
+// failingProcessor fails preprocessing, like an image the decoder rejects.
+type failingProcessor struct {
+	imageproc.Processor
+}
 
+func (failingProcessor) Process(data []byte, mimeType string) (*imageproc.Result, error) {
+	return nil, errors.New("decode failed")
+}
+
+func TestScanUploadStripsMetadata(t *testing.T) {
+	var pngBuf bytes.Buffer
+	png.Encode(&pngBuf, image.NewGray(image.Rect(0, 0, 8, 8)))
+	text := []byte("tEXtGPSPosition\x0035.68,139.76")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)-4))
+	chunk = binary.BigEndian.AppendUint32(append(chunk, text...), crc32.ChecksumIEEE(text))
+	upload := append(append(bytes.Clone(pngBuf.Bytes()[:33]), chunk...), pngBuf.Bytes()[33:]...)
+
+	for name, processor := range map[string]imageproc.Processor{
+		"Preprocessed":        imageproc.NewProcessor(imageproc.Options{OutputFormat: "png"}),
+		"PreprocessingFailed": failingProcessor{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockDB := testutil.NewMockDB()
+			fileStorage, err := storage.NewLocalFileStorage(t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to create file storage: %v", err)
+			}
+			cfg := &config.Config{MaxUploadSize: 1024 * 1024, MaxRequestSize: 4 * 1024 * 1024, MaxPagesPerScan: 1, OCRConcurrency: 1, ImageMaxSide: 1000, ImageMaxPixels: 1000000}
+			geminiClient := &testutil.MockGeminiClient{OCRText: "お知らせ", Language: "JP"}
+			scanHandlers := handlers.NewScanHandlers(mockDB, fileStorage, geminiClient, nil, processor, nil, auth.NewURLSigner("test-secret", 15), cfg)
+
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", `form-data; name="image"; filename="IMG_1.png"`)
+			header.Set("Content-Type", "image/png")
+			part, _ := writer.CreatePart(header)
+			part.Write(upload)
+			writer.Close()
+			req := httptest.NewRequest("POST", "/v1/scans", &body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+			rec := httptest.NewRecorder()
+			scanHandlers.CreateScanAPI(rec, req)
+
+			var response handlers.CreateScanResponse
+			json.NewDecoder(rec.Body).Decode(&response)
+			pages, _ := mockDB.GetScanPages(context.Background(), response.ScanID)
+			if len(pages) != 1 {
+				t.Fatalf("Expected one stored page, got %d (status %d)", len(pages), rec.Code)
+			}
+			paths := []string{pages[0].ImageURL}
+			if pages[0].OriginalImageURL != nil {
+				paths = append(paths, *pages[0].OriginalImageURL)
+			}
+			if name == "Preprocessed" && len(paths) != 2 {
+				t.Fatalf("Expected the original to be kept, got %+v", pages[0])
+			}
+			for _, path := range paths {
+				data, err := fileStorage.OpenImage(path)
+				if err != nil {
+					t.Fatalf("Failed to open %s: %v", path, err)
+				}
+				if bytes.Contains(data, []byte("35.68")) {
+					t.Errorf("Expected %s to be stored without its metadata", path)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateScanFromSource(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	cfg := &config.Config{MaxUploadSize: 1024 * 1024, MaxRequestSize: 64 * 1024, MaxTextLength: 20, URLFetchTimeout: 1, ImageMaxSide: 1000, ImageMaxPixels: 1000000}
+	scanHandlers := handlers.NewScanHandlers(mockDB, nil, nil, nil, nil, nil, auth.NewURLSigner("test-secret", 15), cfg)
+
+	newRequest := func(body string) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/scans", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req.WithContext(middleware.WithUserID(req.Context(), 1))
+	}
+
+	t.Run("Text", func(t *testing.T) {
 		rec := httptest.NewRecorder()
-		annotationHandlers.GetAnnotationsAPI(rec, req)
+		scanHandlers.CreateScanAPI(rec, newRequest(`{"text": "  お疲れ様です  "}`))
+
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var response handlers.CreateScanResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if response.FullText != "お疲れ様です" || response.SourceType != models.SourceTypeText || response.DocumentType != models.DocumentTypeText {
+			t.Errorf("Unexpected response: %+v", response)
+		}
+
+		scan, _ := mockDB.GetScanByID(context.Background(), response.ScanID)
+		if scan.FullOCRText == nil || *scan.FullOCRText != "お疲れ様です" || scan.DetectedLanguage == nil || *scan.DetectedLanguage != "JP" {
+			t.Errorf("Expected scan text and language to be stored, got %+v", scan)
+		}
+		revisions, _ := mockDB.GetOCRRevisions(context.Background(), response.ScanID)
+		if len(revisions) != 1 || revisions[0].Source != models.OCRSourceText {
+			t.Errorf("Expected one text revision, got %+v", revisions)
+		}
+	})
+
+	errorTests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"BothSources", `{"text": "a", "imageUrl": "https://example.com/a.png"}`, http.StatusBadRequest, handlers.ErrCodeInvalidSource},
+		{"NoSource", `{}`, http.StatusBadRequest, handlers.ErrCodeInvalidSource},
+		{"EmptyText", `{"text": "   "}`, http.StatusBadRequest, handlers.ErrCodeInvalidSource},
+		{"TextTooLong", `{"text": "` + strings.Repeat("あ", 21) + `"}`, http.StatusRequestEntityTooLarge, handlers.ErrCodeTextTooLong},
+		{"NonHTTPURL", `{"imageUrl": "file:///etc/passwd"}`, http.StatusBadRequest, handlers.ErrCodeInvalidURL},
+		{"LoopbackURL", `{"imageUrl": "http://127.0.0.1/image.png"}`, http.StatusBadRequest, handlers.ErrCodeForbiddenURL},
+		{"MetadataURL", `{"imageUrl": "http://169.254.169.254/latest/meta-data/"}`, http.StatusBadRequest, handlers.ErrCodeForbiddenURL},
+	}
+
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			scanHandlers.CreateScanAPI(rec, newRequest(tt.body))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantCode) {
+				t.Errorf("Expected error code %q, got %s", tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+}
+
+// countingGeminiClient counts translation calls to check that stored
+// translations are reused.
+type countingGeminiClient struct {
+	testutil.MockGeminiClient
+	translations int
+}
+
+func (c *countingGeminiClient) TranslateDocument(ctx context.Context, paragraphs []string, targetLanguage string) (*gemini.TranslationResponse, error) {
+	c.translations++
+	return c.MockGeminiClient.TranslateDocument(ctx, paragraphs, targetLanguage)
+}
+
+func TestTranslateScanAPI(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	geminiClient := &countingGeminiClient{}
+	scanHandlers := handlers.NewScanHandlers(mockDB, nil, geminiClient, nil, nil, nil, auth.NewURLSigner("test-secret", 15), &config.Config{OCRConcurrency: 1, MaxTextLength: 100})
+
+	ctx := context.Background()
+	mockDB.CreateUser(ctx, &models.User{Email: "a@example.com", PreferredLanguage: "EN"})
+	text, language := "来週までに書類を提出してください。\n\n印鑑を持参してください。", "JP"
+	scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, DocumentType: models.DocumentTypeText, PageCount: 1, FullOCRText: &text, CreatedAt: time.Now()})
+	mockDB.CreateScanPage(ctx, &models.ScanPage{ScanID: scanID, PageNumber: 1, OCRText: &text, DetectedLanguage: &language})
+	emptyScanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, DocumentType: models.DocumentTypeImage, PageCount: 1, CreatedAt: time.Now()})
+
+	newRequest := func(method, target, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		return req.WithContext(middleware.WithUserID(req.Context(), 1))
+	}
+	decode := func(t *testing.T, rec *httptest.ResponseRecorder) handlers.ScanTranslationResponse {
+		t.Helper()
+		var response handlers.ScanTranslationResponse
+		if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return response
+	}
+	target := fmt.Sprintf("/v1/scans/%d/translate", scanID)
+
+	t.Run("NotTranslatedYet", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("GET", fmt.Sprintf("/v1/scans/%d/translation", scanID), ""))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Translate", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", target, ""))
 
 		if rec.Code != http.StatusOK {
-			t.Errorf("Expected status 200, got %d", rec.Code)
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		response := decode(t, rec)
+		if response.TargetLanguage != "EN" || len(response.Paragraphs) != 2 {
+			t.Fatalf("Expected two EN paragraphs, got %+v", response)
+		}
+		if response.Paragraphs[1].Source != "印鑑を持参してください。" || response.Paragraphs[1].Translation != "EN: 印鑑を持参してください。" {
+			t.Errorf("Paragraphs not aligned: %+v", response.Paragraphs)
 		}
+		if len(response.ActionItems) != 1 || response.ActionItems[0].DueDate != "2026-01-31" {
+			t.Errorf("Expected one action item, got %+v", response.ActionItems)
+		}
+	})
 
-		body := rec.Body.String()
-		if !strings.Contains(body, "data") {
-			t.Error("Response should contain 'data' field")
+	t.Run("ReusesStoredTranslation", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", target, ""))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if geminiClient.translations != 1 {
+			t.Errorf("Expected 1 model call, got %d", geminiClient.translations)
+		}
+
+		rec = httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", target, `{"refresh": true}`))
+		if geminiClient.translations != 2 {
+			t.Errorf("Expected refresh to call the model again, got %d calls", geminiClient.translations)
 		}
 	})
 
-	t.Run("CreateAnnotationAPI_MissingHighlightedText", func(t *testing.T) {
-		body := `{"scanId": 1, "contextText": "test context"}`
-		req := httptest.NewRequest("POST", "/v1/annotations", strings.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
-		ctx := middleware.WithUserID(req.Context(), 1)
-		req = req.WithContext(ctx)
+	t.Run("StaleAfterTextChange", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("PATCH", fmt.Sprintf("/v1/scans/%d", scanID), `{"fullText": "修正した文"}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		rec = httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("GET", fmt.Sprintf("/v1/scans/%d/translation", scanID), ""))
+		if response := decode(t, rec); !response.Stale {
+			t.Errorf("Expected stored translation to be stale, got %+v", response)
+		}
+
+		rec = httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", target, ""))
+		if response := decode(t, rec); response.Stale || len(response.Paragraphs) != 1 {
+			t.Errorf("Expected a fresh translation of the corrected text, got %+v", response)
+		}
+	})
 
+	t.Run("NoText", func(t *testing.T) {
 		rec := httptest.NewRecorder()
-		annotationHandlers.CreateAnnotationAPI(rec, req)
+		scanHandlers.ScanAPI(rec, newRequest("POST", fmt.Sprintf("/v1/scans/%d/translate", emptyScanID), ""))
 
-		if rec.Code != http.StatusBadRequest {
-			t.Errorf("Expected status 400, got %d", rec.Code)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("Expected status 409, got %d", rec.Code)
 		}
 	})
+}
 
-	t.Run("CreateAnnotationAPI_Success", func(t *testing.T) {
-		body := `{"scanId": 1, "highlightedText": "test text", "contextText": "test context", "nuanceData": {"meaning": "test meaning", "usageExample": "test example", "usageTiming": "test timing", "wordBreakdown": "test breakdown", "alternativeMeaning": "test alt"}}`
-		req := httptest.NewRequest("POST", "/v1/annotations", strings.NewReader(body))
-		req.Header.Set("Content-Type", "application/json")
-		ctx := middleware.WithUserID(req.Context(), 1)
-		req = req.WithContext(ctx)
+func TestScanVocabulary(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "knowledge.csv")
+	os.WriteFile(csvPath, []byte("Kosakata,Kana,Arti (EN / ID),Cara Baca,Deskripsi,Bidang Pekerjaan,Industri,Konteks\n見積もり,みつもり,estimate,mitsumori,perkiraan harga,Bisnis,Manufacturing,\n"), 0o644)
+	knowledgeSvc, err := knowledge.NewService(csvPath)
+	if err != nil {
+		t.Fatalf("Failed to load knowledge: %v", err)
+	}
 
+	mockDB := testutil.NewMockDB()
+	geminiClient := &testutil.MockGeminiClient{Vocabulary: []gemini.VocabularyItem{
+		{Term: "提出", Reading: "ていしゅつ", Meaning: "submission", Kind: "word", JLPTLevel: "N3"},
+		{Term: "締め切り", Reading: "しめきり", Meaning: "deadline", Kind: "word", JLPTLevel: "n2"},
+		{Term: "存在しない", Reading: "そんざいしない", Meaning: "hallucinated", Kind: "word", JLPTLevel: "N1"},
+	}}
+	scanHandlers := handlers.NewScanHandlers(mockDB, nil, geminiClient, knowledgeSvc, nil, nil, auth.NewURLSigner("test-secret", 15), &config.Config{OCRConcurrency: 1})
+
+	ctx := context.Background()
+	mockDB.CreateUser(ctx, &models.User{Email: "a@example.com", PreferredLanguage: "EN"})
+	text := "見積もりを提出してください。締め切りは金曜日です。\n見積もりは二部です。"
+	scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, DocumentType: models.DocumentTypeText, PageCount: 1, FullOCRText: &text, CreatedAt: time.Now()})
+	mockDB.CreateScanPage(ctx, &models.ScanPage{ScanID: scanID, PageNumber: 1, OCRText: &text})
+
+	newRequest := func(method, target, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		return req.WithContext(middleware.WithUserID(req.Context(), 1))
+	}
+	target := fmt.Sprintf("/v1/scans/%d/vocabulary", scanID)
+
+	t.Run("SaveBeforeExtract", func(t *testing.T) {
 		rec := httptest.NewRecorder()
-		annotationHandlers.CreateAnnotationAPI(rec, req)
+		scanHandlers.ScanAPI(rec, newRequest("POST", target+"/annotations", `{"terms": ["提出"]}`))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Extract", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", target, ""))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var response handlers.ScanVocabularyResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		terms := make([]string, len(response.Items))
+		for i, item := range response.Items {
+			terms[i] = item.Term
+		}
+		// 見積もり: knowledge base and two occurrences; 締め切り: N2; 提出: N3.
+		if strings.Join(terms, ",") != "見積もり,締め切り,提出" {
+			t.Fatalf("Unexpected ranking: %v", terms)
+		}
+		first := response.Items[0]
+		if first.Rank != 1 || !first.InKnowledgeBase || first.Reading != "みつもり" || first.Occurrences != 2 || first.Sentence != "見積もりを提出してください。" {
+			t.Errorf("Unexpected knowledge item: %+v", first)
+		}
+		if response.Items[1].JLPTLevel != "N2" || response.Items[1].Sentence != "締め切りは金曜日です。" {
+			t.Errorf("Unexpected item: %+v", response.Items[1])
+		}
+	})
+
+	t.Run("SaveAsAnnotations", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", target+"/annotations", `{"terms": ["提出", "締め切り", "不明"]}`))
 
 		if rec.Code != http.StatusCreated {
-			t.Errorf("Expected status 201, got %d", rec.Code)
+			t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response handlers.SaveVocabularyResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if len(response.Created) != 2 || len(response.Skipped) != 1 || response.Skipped[0].Reason != handlers.SkipReasonNotExtracted {
+			t.Errorf("Unexpected response: %+v", response)
 		}
 
-		responseBody := rec.Body.String()
-		if !strings.Contains(responseBody, "annotationId") {
-			t.Error("Response should contain 'annotationId'")
+		annotations, _ := mockDB.GetAnnotationsByScanID(ctx, scanID)
+		if len(annotations) != 2 || annotations[0].NuanceData.Meaning != "submission" || annotations[0].PageNumber == nil || *annotations[0].PageNumber != 1 {
+			t.Errorf("Unexpected annotations: %+v", annotations)
 		}
-		if !strings.Contains(responseBody, "saved") {
-			t.Error("Response should contain 'status': 'saved'")
+
+		rec = httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", target+"/annotations", `{"terms": ["提出"]}`))
+		json.NewDecoder(rec.Body).Decode(&response)
+		if rec.Code != http.StatusOK || len(response.Created) != 0 || response.Skipped[0].Reason != handlers.SkipReasonAlreadySaved {
+			t.Errorf("Expected already saved term to be skipped, got %d %+v", rec.Code, response)
+		}
+	})
+}
+
+func TestScanOCRRevisions(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	fileStorage, err := storage.NewLocalFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file storage: %v", err)
+	}
+	cfg := &config.Config{OCRModels: []string{"gemini-2.5-flash", "gemini-2.5-pro"}, OCRConcurrency: 1, MaxTextLength: 10, ReprocessScanLimit: 2, ReprocessUserLimit: 3}
+	geminiClient := &testutil.MockGeminiClient{OCRText: "再処理", Language: "JP"}
+	scanHandlers := handlers.NewScanHandlers(mockDB, fileStorage, geminiClient, nil, nil, nil, auth.NewURLSigner("test-secret", 15), cfg)
+
+	ctx := context.Background()
+	scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, DocumentType: models.DocumentTypeImage, PageCount: 1, CreatedAt: time.Now()})
+	imagePath, _, _ := fileStorage.SaveImage("1", []byte("image"), "image/png")
+	originalText, language := "誤読", "JP"
+	mockDB.CreateScanPage(ctx, &models.ScanPage{ScanID: scanID, PageNumber: 1, ImageURL: imagePath, OCRText: &originalText, DetectedLanguage: &language})
+
+	newRequest := func(method, target, body string, userID int64) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req.WithContext(middleware.WithUserID(req.Context(), userID))
+	}
+
+	t.Run("PatchText", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("PATCH", "/v1/scans/1", `{"fullText": "正しい文"}`, 1))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), `"fullText":"正しい文"`) {
+			t.Errorf("Expected corrected text in response, got %s", rec.Body.String())
+		}
+
+		rec = httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("GET", "/v1/scans/1/revisions", "", 1))
+
+		var response handlers.GetScanRevisionsResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if len(response.Data) != 1 || response.Data[0].Source != models.OCRSourceManual || response.Data[0].CreatedBy == nil || *response.Data[0].CreatedBy != 1 {
+			t.Errorf("Expected one manual revision by user 1, got %+v", response.Data)
+		}
+	})
+
+	t.Run("PatchMissingText", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("PATCH", "/v1/scans/1", `{}`, 1))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("PatchTextTooLong", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("PATCH", "/v1/scans/1", `{"fullText": "十一文字の長すぎる修正文"}`, 1))
+
+		if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), handlers.ErrCodeTextTooLong) {
+			t.Errorf("Expected status 413 text_too_long, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("PatchRevisionFails", func(t *testing.T) {
+		mockDB.RevisionErr = errors.New("insert failed")
+		defer func() { mockDB.RevisionErr = nil }()
+
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("PATCH", "/v1/scans/1", `{"fullText": "保存されない"}`, 1))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500, got %d", rec.Code)
+		}
+
+		pages, _ := mockDB.GetScanPages(ctx, scanID)
+		if *pages[0].OCRText != "正しい文" {
+			t.Errorf("Expected the page text to be unchanged, got %q", *pages[0].OCRText)
+		}
+	})
+
+	t.Run("PatchPageOutOfRange", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("PATCH", "/v1/scans/1", `{"fullText": "x", "pageNumber": 2}`, 1))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("PatchOtherUser", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("PATCH", "/v1/scans/1", `{"fullText": "x"}`, 2))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("ReprocessUnsupportedModel", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", "/v1/scans/1/reprocess", `{"model": "some-other-model"}`, 1))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("ReprocessInvalidLanguageHint", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", "/v1/scans/1/reprocess", `{"languageHint": "ja; drop"}`, 1))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Reprocess", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("POST", "/v1/scans/1/reprocess", `{"model": "gemini-2.5-pro", "languageHint": "ja"}`, 1))
+
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		// OCR runs in the background; wait for its revision to land.
+		var revisions []*models.OCRRevision
+		for deadline := time.Now().Add(2 * time.Second); len(revisions) < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			revisions, _ = mockDB.GetOCRRevisions(ctx, scanID)
+		}
+
+		if len(revisions) != 2 {
+			t.Fatalf("Expected 2 revisions, got %d", len(revisions))
+		}
+		latest := revisions[0]
+		if latest.Source != models.OCRSourceReprocess || latest.Text != "再処理" || latest.Model == nil || *latest.Model != "gemini-2.5-pro" {
+			t.Errorf("Expected reprocess revision from gemini-2.5-pro, got %+v", latest)
+		}
+	})
+
+	t.Run("ReprocessLimit", func(t *testing.T) {
+		reprocess := func(scanID int64) int {
+			rec := httptest.NewRecorder()
+			scanHandlers.ScanAPI(rec, newRequest("POST", fmt.Sprintf("/v1/scans/%d/reprocess", scanID), "", 1))
+			return rec.Code
+		}
+		otherID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, DocumentType: models.DocumentTypeImage, PageCount: 1, CreatedAt: time.Now()})
+		mockDB.CreateScanPage(ctx, &models.ScanPage{ScanID: otherID, PageNumber: 1, ImageURL: imagePath})
+
+		// The scan was reprocessed once above; it allows two an hour.
+		if code := reprocess(scanID); code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d", code)
+		}
+		if code := reprocess(scanID); code != http.StatusTooManyRequests {
+			t.Errorf("Expected status 429 for the scan's third request, got %d", code)
+		}
+		// The user allows three an hour across scans.
+		if code := reprocess(otherID); code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d", code)
+		}
+		if code := reprocess(otherID); code != http.StatusTooManyRequests {
+			t.Errorf("Expected status 429 for the user's fourth request, got %d", code)
+		}
+	})
+}
+
+func TestGetScanImageAPI(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	fileStorage, err := storage.NewLocalFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file storage: %v", err)
+	}
+	cfg := &config.Config{DefaultPageSize: 20, MaxUploadSize: 10 * 1024 * 1024, ThumbnailSize: 320}
+	scanHandlers := handlers.NewScanHandlers(mockDB, fileStorage, nil, nil, nil, nil, auth.NewURLSigner("test-secret", 15), cfg)
+
+	imageData := []byte("0123456789abcdef")
+	scan := &models.Scan{UserID: 1, CreatedAt: time.Now()}
+	scanID, _ := mockDB.CreateScan(context.Background(), scan)
+	storagePath, hash, _ := fileStorage.SaveImage("1", imageData, "image/png")
+	mockDB.UpdateScanImage(context.Background(), scanID, storagePath, hash)
+
+	newRequest := func(userID int64) *http.Request {
+		req := httptest.NewRequest("GET", "/v1/scans/1/image", nil)
+		return req.WithContext(middleware.WithUserID(req.Context(), userID))
+	}
+
+	t.Run("Owner", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest(1))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+			t.Errorf("Expected Content-Type image/png, got %q", ct)
+		}
+		if rec.Header().Get("ETag") != `"`+*hash+`"` {
+			t.Errorf("Unexpected ETag %q", rec.Header().Get("ETag"))
+		}
+		if rec.Body.String() != string(imageData) {
+			t.Error("Response body should be the image")
+		}
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		req := newRequest(1)
+		req.Header.Set("Range", "bytes=0-3")
+
+		rec := httptest.NewRecorder()
+		scanHandlers.GetScanImageAPI(rec, req)
+
+		if rec.Code != http.StatusPartialContent {
+			t.Fatalf("Expected status 206, got %d", rec.Code)
+		}
+		if rec.Body.String() != "0123" {
+			t.Errorf("Expected partial body 0123, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("IfNoneMatch", func(t *testing.T) {
+		req := newRequest(1)
+		req.Header.Set("If-None-Match", `"`+*hash+`"`)
+
+		rec := httptest.NewRecorder()
+		scanHandlers.GetScanImageAPI(rec, req)
+
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("Expected status 304, got %d", rec.Code)
+		}
+	})
+
+	t.Run("OtherUser", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.GetScanImageAPI(rec, newRequest(2))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Thumbnail", func(t *testing.T) {
+		thumbPath, thumbHash, _ := fileStorage.SaveImage("1-thumb-320", []byte("thumb"), "image/jpeg")
+		mockDB.UpsertScanThumbnail(context.Background(), &models.ScanThumbnail{
+			ScanID: scanID, Size: 320, ImageURL: thumbPath, ImageHash: thumbHash, CreatedAt: time.Now(),
+		})
+
+		req := httptest.NewRequest("GET", "/v1/scans/1/thumbnails/320", nil)
+		req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, req)
+
+		if rec.Code != http.StatusOK || rec.Body.String() != "thumb" {
+			t.Errorf("Expected thumbnail body with status 200, got %d %q", rec.Code, rec.Body.String())
+		}
+
+		req = httptest.NewRequest("GET", "/v1/scans/1/thumbnails/640", nil)
+		req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+		rec = httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for missing size, got %d", rec.Code)
+		}
+
+		req = httptest.NewRequest("GET", "/v1/scans", nil)
+		req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+		rec = httptest.NewRecorder()
+		scanHandlers.GetScansAPI(rec, req)
+
+		if !strings.Contains(rec.Body.String(), `"thumbnailUrl":"/v1/scans/1/thumbnails/320?`) {
+			t.Errorf("Expected thumbnailUrl in scan list, got %s", rec.Body.String())
+		}
+	})
+
+	t.Run("PageImage", func(t *testing.T) {
+		pagePath, pageHash, _ := fileStorage.SaveImage("1-p2", []byte("page two"), "image/png")
+		mockDB.CreateScanPage(context.Background(), &models.ScanPage{
+			ScanID: scanID, PageNumber: 2, ImageURL: pagePath, ImageHash: pageHash, CreatedAt: time.Now(),
+		})
+
+		req := httptest.NewRequest("GET", "/v1/scans/1/pages/2/image", nil)
+		req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, req)
+
+		if rec.Code != http.StatusOK || rec.Body.String() != "page two" {
+			t.Errorf("Expected page body with status 200, got %d %q", rec.Code, rec.Body.String())
+		}
+
+		req = httptest.NewRequest("GET", "/v1/scans/1/pages/3/image", nil)
+		req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+		rec = httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for missing page, got %d", rec.Code)
+		}
+	})
+
+	t.Run("ScanResponseHasSignedURL", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/scans/1", nil)
+		req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, req)
+
+		if !strings.Contains(rec.Body.String(), "/v1/scans/1/image?") {
+			t.Errorf("Expected signed image URL in response, got %s", rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), "/v1/scans/1/pages/2/image?") {
+			t.Errorf("Expected signed page image URL in response, got %s", rec.Body.String())
+		}
+	})
+}
+
+func TestAnnotationHandlers(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	cfg := &config.Config{DefaultPageSize: 20}
+	annotationHandlers := handlers.NewAnnotationHandlers(mockDB, &testutil.MockGeminiClient{}, nil, cfg)
+
+	t.Run("GetAnnotationsAPI_Unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/annotations", nil)
+		rec := httptest.NewRecorder()
+		annotationHandlers.GetAnnotationsAPI(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("GetAnnotationsAPI_Authorized", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/annotations?page=1&size=10", nil)
+		ctx := middleware.WithUserID(req.Context(), 1)
+		req = req.WithContext(ctx)
+
+		rec := httptest.NewRecorder()
+		annotationHandlers.GetAnnotationsAPI(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", rec.Code)
+		}
+
+		body := rec.Body.String()
+		if !strings.Contains(body, "data") {
+			t.Error("Response should contain 'data' field")
+		}
+	})
+
+	t.Run("CreateAnnotationAPI_MissingHighlightedText", func(t *testing.T) {
+		body := `{"scanId": 1, "contextText": "test context"}`
+		req := httptest.NewRequest("POST", "/v1/annotations", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		ctx := middleware.WithUserID(req.Context(), 1)
+		req = req.WithContext(ctx)
+
+		rec := httptest.NewRecorder()
+		annotationHandlers.CreateAnnotationAPI(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("CreateAnnotationAPI_PageOutOfRange", func(t *testing.T) {
+		mockDB.CreateScan(context.Background(), &models.Scan{UserID: 1, PageCount: 2, CreatedAt: time.Now()})
+
+		body := `{"scanId": 1, "pageNumber": 3, "highlightedText": "test text"}`
+		req := httptest.NewRequest("POST", "/v1/annotations", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		ctx := middleware.WithUserID(req.Context(), 1)
+		req = req.WithContext(ctx)
+
+		rec := httptest.NewRecorder()
+		annotationHandlers.CreateAnnotationAPI(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("CreateAnnotationAPI_Success", func(t *testing.T) {
+		body := `{"scanId": 1, "highlightedText": "test text", "contextText": "test context", "nuanceData": {"meaning": "test meaning", "usageExample": "test example", "usageTiming": "test timing", "wordBreakdown": "test breakdown", "alternativeMeaning": "test alt"}}`
+		req := httptest.NewRequest("POST", "/v1/annotations", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		ctx := middleware.WithUserID(req.Context(), 1)
+		req = req.WithContext(ctx)
+
+		rec := httptest.NewRecorder()
+		annotationHandlers.CreateAnnotationAPI(rec, req)
+
+		if rec.Code != http.StatusCreated {
+			t.Errorf("Expected status 201, got %d", rec.Code)
+		}
+
+		responseBody := rec.Body.String()
+		if !strings.Contains(responseBody, "annotationId") {
+			t.Error("Response should contain 'annotationId'")
+		}
+		if !strings.Contains(responseBody, "saved") {
+			t.Error("Response should contain 'status': 'saved'")
+		}
+	})
+
+	t.Run("CreateAnnotationAPI_OtherUsersScan", func(t *testing.T) {
+		for _, scanID := range []int{1, 99} {
+			body := fmt.Sprintf(`{"scanId": %d, "highlightedText": "test text"}`, scanID)
+			req := httptest.NewRequest("POST", "/v1/annotations", strings.NewReader(body))
+			req = req.WithContext(middleware.WithUserID(req.Context(), 2))
+
+			rec := httptest.NewRecorder()
+			annotationHandlers.CreateAnnotationAPI(rec, req)
+
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("Expected status 404 for scan %d, got %d", scanID, rec.Code)
+			}
+		}
+	})
+
+	t.Run("GetAnnotationAPI_OtherUser", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/annotations/1", nil)
+		req = req.WithContext(middleware.WithUserID(req.Context(), 2))
+
+		rec := httptest.NewRecorder()
+		annotationHandlers.AnnotationAPI(rec, req)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("CreateAnnotationAPI_InvalidStructuredField", func(t *testing.T) {
+		body := `{"scanId": 1, "highlightedText": "test text", "nuanceData": {"meaning": "m", "politenessRegister": "rude"}}`
+		req := httptest.NewRequest("POST", "/v1/annotations", strings.NewReader(body))
+		req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+
+		rec := httptest.NewRecorder()
+		annotationHandlers.CreateAnnotationAPI(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("GetAnnotationsAPI_Filter", func(t *testing.T) {
+		for _, body := range []string{
+			`{"scanId": 1, "highlightedText": "申し上げます", "nuanceData": {"meaning": "say", "jlptLevel": "n2", "politenessRegister": "kenjougo", "partOfSpeech": "verb", "reading": "もうしあげます"}}`,
+			`{"scanId": 1, "highlightedText": "いらっしゃる", "nuanceData": {"meaning": "come", "jlptLevel": "N3", "politenessRegister": "sonkeigo", "partOfSpeech": "verb"}}`,
+			`{"scanId": 1, "highlightedText": "稟議", "nuanceData": {"meaning": "approval", "jlptLevel": "N1", "partOfSpeech": "noun"}}`,
+		} {
+			req := httptest.NewRequest("POST", "/v1/annotations", strings.NewReader(body))
+			req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+			rec := httptest.NewRecorder()
+			annotationHandlers.CreateAnnotationAPI(rec, req)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+			}
+		}
+
+		list := func(t *testing.T, query string) []string {
+			t.Helper()
+			req := httptest.NewRequest("GET", "/v1/annotations?"+query, nil)
+			req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+			rec := httptest.NewRecorder()
+			annotationHandlers.GetAnnotationsAPI(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+
+			var response handlers.GetAnnotationsResponse
+			json.NewDecoder(rec.Body).Decode(&response)
+			texts := make([]string, len(response.Data))
+			for i, item := range response.Data {
+				texts[i] = item.HighlightedText
+			}
+			return texts
+		}
+
+		if got := list(t, "politenessRegister=kenjougo"); len(got) != 1 || got[0] != "申し上げます" {
+			t.Errorf("Expected only the kenjougo annotation, got %v", got)
+		}
+		if got := list(t, "jlptLevel=N2&partOfSpeech=verb"); len(got) != 1 || got[0] != "申し上げます" {
+			t.Errorf("Expected the normalized N2 verb, got %v", got)
+		}
+		if got := list(t, "partOfSpeech=verb&sort=jlptLevel"); strings.Join(got, ",") != "申し上げます,いらっしゃる" {
+			t.Errorf("Expected verbs hardest first, got %v", got)
+		}
+		if got := list(t, "sort=jlptLevel"); len(got) < 3 || got[0] != "稟議" {
+			t.Errorf("Expected N1 annotation first, got %v", got)
+		}
+	})
+
+	t.Run("GetAnnotationsAPI_InvalidFilter", func(t *testing.T) {
+		for _, query := range []string{"jlptLevel=N6", "partOfSpeech=pronoun", "sort=random"} {
+			req := httptest.NewRequest("GET", "/v1/annotations?"+query, nil)
+			req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+			rec := httptest.NewRecorder()
+			annotationHandlers.GetAnnotationsAPI(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status 400, got %d", query, rec.Code)
+			}
+		}
+	})
+}
+
+func TestAnalyzeStreamAPI(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "knowledge.csv")
+	os.WriteFile(csvPath, []byte("Kosakata,Kana,Arti (EN / ID),Cara Baca,Deskripsi,Bidang Pekerjaan,Industri,Konteks\n"), 0o644)
+	knowledgeSvc, err := knowledge.NewService(csvPath)
+	if err != nil {
+		t.Fatalf("Failed to load knowledge: %v", err)
+	}
+
+	mockDB := testutil.NewMockDB()
+	mockDB.CreateUser(context.Background(), &models.User{Email: "a@example.com"})
+	aiHandlers := handlers.NewAIHandlers(mockDB, &testutil.MockGeminiClient{}, knowledgeSvc)
+
+	newRequest := func(body, accept string) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/ai/analyze/stream", strings.NewReader(body))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		return req.WithContext(middleware.WithUserID(req.Context(), 1))
+	}
+
+	t.Run("ServerSentEvents", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		aiHandlers.AnalyzeStreamAPI(rec, newRequest(`{"textToAnalyze": "お疲れ様です", "context": "お疲れ様です。"}`, ""))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("Expected text/event-stream, got %s", ct)
+		}
+		body := rec.Body.String()
+		fieldAt := strings.Index(body, "event: field\ndata: {\"type\":\"field\",\"field\":\"meaning\",\"value\":\"meaning of お疲れ様です\"}\n\n")
+		doneAt := strings.Index(body, "event: done\n")
+		if fieldAt == -1 || doneAt < fieldAt {
+			t.Errorf("Expected a field event followed by done, got %q", body)
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		aiHandlers.AnalyzeStreamAPI(rec, newRequest(`{"textToAnalyze": "お疲れ様です"}`, "application/x-ndjson"))
+
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("Expected 2 lines, got %q", lines)
+		}
+		var done handlers.AnalyzeStreamEvent
+		json.Unmarshal([]byte(lines[1]), &done)
+		if done.Type != handlers.AnalyzeEventDone || done.Result == nil || done.Result.Meaning != "meaning of お疲れ様です" {
+			t.Errorf("Unexpected done event: %s", lines[1])
+		}
+	})
+
+	t.Run("MissingText", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		aiHandlers.AnalyzeStreamAPI(rec, newRequest(`{}`, ""))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+}
+
+func TestAnnotationMessages(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	annotationHandlers := handlers.NewAnnotationHandlers(mockDB, &testutil.MockGeminiClient{}, nil, &config.Config{DefaultPageSize: 20})
+
+	ctx := context.Background()
+	mockDB.CreateUser(ctx, &models.User{Email: "a@example.com", PreferredLanguage: "EN"})
+	mockDB.CreateUser(ctx, &models.User{Email: "b@example.com"})
+	contextText := "お疲れ様です。"
+	annotationID, _ := mockDB.CreateAnnotation(ctx, &models.Annotation{
+		UserID:          1,
+		HighlightedText: "お疲れ様です",
+		ContextText:     &contextText,
+		NuanceData:      models.NuanceData{Meaning: "thanks for your work"},
+		CreatedAt:       time.Now(),
+	})
+
+	newRequest := func(method, body string, userID int64) *http.Request {
+		req := httptest.NewRequest(method, fmt.Sprintf("/v1/annotations/%d/messages", annotationID), strings.NewReader(body))
+		req.Header.Set("Accept", "application/x-ndjson")
+		return req.WithContext(middleware.WithUserID(req.Context(), userID))
+	}
+	send := func(content string) []handlers.AnnotationChatEvent {
+		rec := httptest.NewRecorder()
+		annotationHandlers.AnnotationAPI(rec, newRequest("POST", fmt.Sprintf(`{"content": %q}`, content), 1))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var events []handlers.AnnotationChatEvent
+		for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+			var event handlers.AnnotationChatEvent
+			json.Unmarshal([]byte(line), &event)
+			events = append(events, event)
+		}
+		return events
+	}
+
+	t.Run("StreamsReply", func(t *testing.T) {
+		events := send("Can I say this to my boss?")
+		if len(events) != 3 || events[0].Type != handlers.ChatEventDelta || events[2].Type != handlers.ChatEventDone {
+			t.Fatalf("Unexpected events: %+v", events)
+		}
+		if events[2].Reply == nil || events[2].Reply.Content != "reply to Can I say this to my boss? after 0 turns" {
+			t.Errorf("Unexpected reply: %+v", events[2].Reply)
+		}
+	})
+
+	t.Run("SendsHistory", func(t *testing.T) {
+		events := send("And to a customer?")
+		if done := events[len(events)-1]; done.Reply == nil || !strings.HasSuffix(done.Reply.Content, "after 2 turns") {
+			t.Errorf("Expected earlier turns to be sent, got %+v", done)
+		}
+	})
+
+	t.Run("ListMessages", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		annotationHandlers.AnnotationAPI(rec, newRequest("GET", "", 1))
+
+		var response handlers.AnnotationMessagesResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if len(response.Data) != 4 || response.Data[0].Role != models.MessageRoleUser || response.Data[1].Role != models.MessageRoleModel {
+			t.Errorf("Unexpected messages: %+v", response.Data)
+		}
+	})
+
+	t.Run("EmptyContent", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		annotationHandlers.AnnotationAPI(rec, newRequest("POST", `{"content": "  "}`, 1))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("OtherUser", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		annotationHandlers.AnnotationAPI(rec, newRequest("POST", `{"content": "hi"}`, 2))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+}
+
+func TestCreateAnnotationFromAnalysis(t *testing.T) {
+	csvPath := filepath.Join(t.TempDir(), "knowledge.csv")
+	os.WriteFile(csvPath, []byte("Kosakata,Kana,Arti (EN / ID),Cara Baca,Deskripsi,Bidang Pekerjaan,Industri,Konteks\n"), 0o644)
+	knowledgeSvc, err := knowledge.NewService(csvPath)
+	if err != nil {
+		t.Fatalf("Failed to load knowledge: %v", err)
+	}
+
+	mockDB := testutil.NewMockDB()
+	aiHandlers := handlers.NewAIHandlers(mockDB, &testutil.MockGeminiClient{}, knowledgeSvc)
+	cfg := &config.Config{DefaultPageSize: 20, RequireAnalysisID: true}
+	annotationHandlers := handlers.NewAnnotationHandlers(mockDB, &testutil.MockGeminiClient{}, nil, cfg)
+
+	ctx := context.Background()
+	mockDB.CreateUser(ctx, &models.User{Email: "a@example.com"})
+	mockDB.CreateUser(ctx, &models.User{Email: "b@example.com"})
+	text := "お疲れ様です。"
+	scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, PageCount: 1, FullOCRText: &text, CreatedAt: time.Now()})
+	otherScanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, PageCount: 1, FullOCRText: &text, CreatedAt: time.Now()})
+
+	newRequest := func(target, body string, userID int64) *http.Request {
+		req := httptest.NewRequest("POST", target, strings.NewReader(body))
+		return req.WithContext(middleware.WithUserID(req.Context(), userID))
+	}
+	analyze := func() int64 {
+		rec := httptest.NewRecorder()
+		aiHandlers.AnalyzeAPI(rec, newRequest("/v1/ai/analyze", fmt.Sprintf(`{"textToAnalyze": "お疲れ様です", "context": %q, "scanId": %d}`, text, scanID), 1))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response handlers.AnalyzeResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if response.AnalysisID == 0 {
+			t.Fatalf("Expected analysisId in response, got %+v", response)
+		}
+		return response.AnalysisID
+	}
+	create := func(body string, userID int64) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		annotationHandlers.CreateAnnotationAPI(rec, newRequest("/v1/annotations", body, userID))
+		return rec
+	}
+
+	t.Run("AnalyzeForeignScan", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		aiHandlers.AnalyzeAPI(rec, newRequest("/v1/ai/analyze", fmt.Sprintf(`{"textToAnalyze": "x", "scanId": %d}`, scanID), 2))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("RequiresAnalysis", func(t *testing.T) {
+		rec := create(`{"highlightedText": "お疲れ様です", "nuanceData": {"meaning": "made up"}}`, 1)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("SavesStoredNuance", func(t *testing.T) {
+		analysisID := analyze()
+		rec := create(fmt.Sprintf(`{"analysisId": %d, "nuanceData": {"meaning": "made up"}}`, analysisID), 1)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var response handlers.CreateAnnotationResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		annotation, _ := mockDB.GetAnnotationByID(ctx, response.AnnotationID)
+		if annotation.NuanceData.Meaning != "meaning of お疲れ様です" || annotation.HighlightedText != "お疲れ様です" || annotation.ScanID == nil || *annotation.ScanID != scanID {
+			t.Errorf("Unexpected annotation: %+v", annotation)
+		}
+
+		rec = create(fmt.Sprintf(`{"analysisId": %d}`, analysisID), 1)
+		if rec.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 for a second save, got %d", rec.Code)
+		}
+	})
+
+	t.Run("AnalyzeWithLanguage", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		aiHandlers.AnalyzeWithLanguageAPI(rec, newRequest("/v1/ai/analyze", `{"textToAnalyze": "お疲れ様です"}`, 1))
+		var response handlers.AnalyzeResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if rec.Code != http.StatusOK || response.AnalysisID == 0 {
+			t.Fatalf("Expected an analysisId, got %d %+v", rec.Code, response)
+		}
+		if rec := create(fmt.Sprintf(`{"analysisId": %d}`, response.AnalysisID), 1); rec.Code != http.StatusCreated {
+			t.Errorf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("OtherUsersAnalysis", func(t *testing.T) {
+		rec := create(fmt.Sprintf(`{"analysisId": %d}`, analyze()), 2)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("DifferentScan", func(t *testing.T) {
+		rec := create(fmt.Sprintf(`{"analysisId": %d, "scanId": %d}`, analyze(), otherScanID), 1)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("DifferentText", func(t *testing.T) {
+		rec := create(fmt.Sprintf(`{"analysisId": %d, "highlightedText": "something else"}`, analyze()), 1)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+}
+
+func TestScanAnnotations(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	annotationHandlers := handlers.NewAnnotationHandlers(mockDB, &testutil.MockGeminiClient{}, nil, &config.Config{DefaultPageSize: 20})
+	scanHandlers := handlers.NewScanHandlers(mockDB, nil, &testutil.MockGeminiClient{}, nil, nil, nil, auth.NewURLSigner("test-secret", 15), &config.Config{OCRConcurrency: 1})
+
+	ctx := context.Background()
+	mockDB.CreateUser(ctx, &models.User{Email: "a@example.com"})
+	text := "見積もりを提出。見積もりは二部。"
+	scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, PageCount: 1, FullOCRText: &text, CreatedAt: time.Now()})
+
+	newRequest := func(method, target, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		return req.WithContext(middleware.WithUserID(req.Context(), 1))
+	}
+	create := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		annotationHandlers.CreateAnnotationAPI(rec, newRequest("POST", "/v1/annotations", body))
+		return rec
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		for _, body := range []string{
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "見積もり", "startOffset": 8, "endOffset": 12, "boundingBox": {"x": 0.1, "y": 0.5, "width": 0.2, "height": 0.05}}`, scanID),
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "見積もり", "startOffset": 0, "endOffset": 4}`, scanID),
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "二部"}`, scanID),
+		} {
+			if rec := create(body); rec.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+			}
+		}
+	})
+
+	t.Run("InvalidLocation", func(t *testing.T) {
+		for _, body := range []string{
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "見積もり", "startOffset": 1, "endOffset": 5}`, scanID),
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "見積もり", "startOffset": 8}`, scanID),
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "見積もり", "startOffset": 14, "endOffset": 18}`, scanID),
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "見積もり", "boundingBox": {"x": 0.9, "y": 0, "width": 0.2, "height": 0.1}}`, scanID),
+			`{"highlightedText": "見積もり", "startOffset": 0, "endOffset": 4}`,
+		} {
+			if rec := create(body); rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 for %s, got %d", body, rec.Code)
+			}
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("GET", fmt.Sprintf("/v1/scans/%d/annotations", scanID), ""))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+
+		var response handlers.ScanAnnotationsResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if len(response.Data) != 3 {
+			t.Fatalf("Expected 3 highlights, got %+v", response.Data)
+		}
+		first, second, last := response.Data[0], response.Data[1], response.Data[2]
+		if *first.StartOffset != 0 || *second.StartOffset != 8 || second.BoundingBox == nil || last.StartOffset != nil {
+			t.Errorf("Unexpected order: %+v", response.Data)
+		}
+		if first.Stale || second.Stale {
+			t.Errorf("Expected highlights not to be stale: %+v", response.Data)
+		}
+	})
+
+	t.Run("StaleAfterTextChange", func(t *testing.T) {
+		mockDB.UpdateScanOCR(ctx, scanID, "見積書類を提出。見積もりは二部。", "JP")
+
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("GET", fmt.Sprintf("/v1/scans/%d/annotations", scanID), ""))
+
+		var response handlers.ScanAnnotationsResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if !response.Data[0].Stale || response.Data[1].Stale {
+			t.Errorf("Expected only the first highlight to be stale: %+v", response.Data)
+		}
+	})
+
+	t.Run("SurrogatePair", func(t *testing.T) {
+		// 𠮟 is outside the BMP: two UTF-16 code units, as JavaScript counts.
+		text := "𠮟責の件。𠮟る前に"
+		scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: 1, PageCount: 1, FullOCRText: &text, CreatedAt: time.Now()})
+
+		for body, want := range map[string]int{
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "𠮟る", "startOffset": 6, "endOffset": 9}`, scanID): http.StatusCreated,
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "𠮟る", "startOffset": 5, "endOffset": 7}`, scanID): http.StatusBadRequest,
+			fmt.Sprintf(`{"scanId": %d, "highlightedText": "る", "startOffset": 7, "endOffset": 9}`, scanID):  http.StatusBadRequest,
+		} {
+			if rec := create(body); rec.Code != want {
+				t.Errorf("Expected status %d for %s, got %d", want, body, rec.Code)
+			}
+		}
+
+		rec := httptest.NewRecorder()
+		scanHandlers.ScanAPI(rec, newRequest("GET", fmt.Sprintf("/v1/scans/%d/annotations", scanID), ""))
+		var response handlers.ScanAnnotationsResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if len(response.Data) != 1 || response.Data[0].Stale {
+			t.Errorf("Expected one current highlight, got %+v", response.Data)
+		}
+	})
+}
+
+func TestRefreshTokens(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	tokenService := auth.NewTokenService("test-secret", 30)
+	sessions := auth.NewSessionService(mockDB, tokenService, 30)
+	authHandlers := handlers.NewAuthHandlers(nil, nil, tokenService, sessions, mockDB, &config.Config{TokenExpiryMinutes: 30})
+	authMiddleware := middleware.NewAuthMiddleware(tokenService)
+
+	ctx := context.Background()
+	mockDB.CreateUser(ctx, &models.User{Email: "a@example.com"})
+
+	post := func(handler http.HandlerFunc, refreshToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/auth/refresh", strings.NewReader(fmt.Sprintf(`{"refreshToken": %q}`, refreshToken)))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	refresh := func(t *testing.T, refreshToken string) handlers.TokenResponse {
+		t.Helper()
+		rec := post(authHandlers.RefreshAPI, refreshToken)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response handlers.TokenResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response
+	}
+	listSessions := func(t *testing.T, accessToken string) []handlers.SessionResponse {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/v1/users/me/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		authMiddleware.Handle(http.HandlerFunc(authHandlers.SessionsAPI)).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response handlers.SessionsResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response.Data
+	}
+
+	t.Run("RotatesToken", func(t *testing.T) {
+		first, err := sessions.Start(ctx, 1, auth.Client{UserAgent: "phone"})
+		if err != nil {
+			t.Fatalf("Failed to start session: %v", err)
+		}
+
+		second := refresh(t, first.RefreshToken)
+		if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+			t.Errorf("Expected a new refresh token, got %q", second.RefreshToken)
+		}
+		if second.SessionID != first.SessionID {
+			t.Errorf("Expected session %s to continue, got %s", first.SessionID, second.SessionID)
+		}
+		if userID, err := tokenService.ValidateToken(second.Token); err != nil || userID != 1 {
+			t.Errorf("Expected a valid access token for user 1, got %d, %v", userID, err)
+		}
+		refresh(t, second.RefreshToken)
+	})
+
+	t.Run("ReuseRevokesFamily", func(t *testing.T) {
+		first, _ := sessions.Start(ctx, 1, auth.Client{})
+		second := refresh(t, first.RefreshToken)
+
+		if rec := post(authHandlers.RefreshAPI, first.RefreshToken); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for a reused token, got %d", rec.Code)
+		}
+		if rec := post(authHandlers.RefreshAPI, second.RefreshToken); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for the rest of the family, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		first, _ := sessions.Start(ctx, 1, auth.Client{})
+
+		if rec := post(authHandlers.LogoutAPI, first.RefreshToken); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204, got %d", rec.Code)
+		}
+		if rec := post(authHandlers.RefreshAPI, first.RefreshToken); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 after logout, got %d", rec.Code)
+		}
+		if rec := post(authHandlers.LogoutAPI, first.RefreshToken); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected logging out twice to succeed, got %d", rec.Code)
+		}
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		if rec := post(authHandlers.RefreshAPI, "not-a-token"); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", rec.Code)
+		}
+		if rec := post(authHandlers.RefreshAPI, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("ListAndRevokeSessions", func(t *testing.T) {
+		laptop, _ := sessions.Start(ctx, 2, auth.Client{UserAgent: "laptop"})
+		phone, _ := sessions.Start(ctx, 2, auth.Client{UserAgent: "phone"})
+
+		data := listSessions(t, laptop.AccessToken)
+		if len(data) != 2 {
+			t.Fatalf("Expected 2 sessions, got %d", len(data))
+		}
+		for _, session := range data {
+			if session.Current != (session.ID == laptop.SessionID) {
+				t.Errorf("Expected only the laptop session to be current: %+v", session)
+			}
+		}
+
+		deleteSession := func(userID int64, sessionID string) int {
+			req := httptest.NewRequest("DELETE", "/v1/users/me/sessions/"+sessionID, nil)
+			req = req.WithContext(middleware.WithUserID(req.Context(), userID))
+			rec := httptest.NewRecorder()
+			authHandlers.SessionAPI(rec, req)
+			return rec.Code
+		}
+		if code := deleteSession(1, phone.SessionID); code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for another user's session, got %d", code)
+		}
+		if code := deleteSession(2, phone.SessionID); code != http.StatusNoContent {
+			t.Errorf("Expected status 204, got %d", code)
+		}
+		if rec := post(authHandlers.RefreshAPI, phone.RefreshToken); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for a revoked session, got %d", rec.Code)
+		}
+		if data := listSessions(t, laptop.AccessToken); len(data) != 1 || data[0].ID != laptop.SessionID {
+			t.Errorf("Expected only the laptop session to remain, got %+v", data)
+		}
+	})
+}
+
+func TestTokenRevocation(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	redis := testutil.NewMockRedisClient()
+	tokenService := auth.NewTokenService("test-secret", 30).WithDenylist(redis)
+	sessions := auth.NewSessionService(mockDB, tokenService, 30)
+	authHandlers := handlers.NewAuthHandlers(nil, nil, tokenService, sessions, mockDB, &config.Config{TokenExpiryMinutes: 30})
+	authMiddleware := middleware.NewAuthMiddleware(tokenService)
+	mockDB.CreateUser(context.Background(), &models.User{Email: "a@example.com"})
+
+	authenticate := func(accessToken string) int {
+		req := httptest.NewRequest("GET", "/v1/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		authMiddleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("LogoutRevokesAccessToken", func(t *testing.T) {
+		tokens, _ := sessions.Start(context.Background(), 1, auth.Client{})
+		other, _ := sessions.Start(context.Background(), 1, auth.Client{})
+
+		req := httptest.NewRequest("POST", "/v1/auth/logout", strings.NewReader(fmt.Sprintf(`{"refreshToken": %q}`, tokens.RefreshToken)))
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		rec := httptest.NewRecorder()
+		authHandlers.LogoutAPI(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rec.Code)
+		}
+
+		if code := authenticate(tokens.AccessToken); code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for a revoked token, got %d", code)
+		}
+		if code := authenticate(other.AccessToken); code != http.StatusOK {
+			t.Errorf("Expected another session's token to stay valid, got %d", code)
+		}
+	})
+
+	t.Run("DeletedSessionRevokesAccessTokens", func(t *testing.T) {
+		tokens, _ := sessions.Start(context.Background(), 1, auth.Client{})
+		other, _ := sessions.Start(context.Background(), 1, auth.Client{})
+
+		// A refresh issues a second access token for the same session.
+		rec := httptest.NewRecorder()
+		authHandlers.RefreshAPI(rec, httptest.NewRequest("POST", "/v1/auth/refresh", strings.NewReader(fmt.Sprintf(`{"refreshToken": %q}`, tokens.RefreshToken))))
+		var refreshed handlers.TokenResponse
+		json.NewDecoder(rec.Body).Decode(&refreshed)
+		if rec.Code != http.StatusOK || refreshed.Token == "" {
+			t.Fatalf("Expected status 200 with a token, got %d", rec.Code)
+		}
+
+		req := httptest.NewRequest("DELETE", "/v1/users/me/sessions/"+tokens.SessionID, nil)
+		req = req.WithContext(middleware.WithUserID(req.Context(), 1))
+		rec = httptest.NewRecorder()
+		authHandlers.SessionAPI(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rec.Code)
+		}
+
+		for _, token := range []string{tokens.AccessToken, refreshed.Token} {
+			if code := authenticate(token); code != http.StatusUnauthorized {
+				t.Errorf("Expected status 401 for a token of the deleted session, got %d", code)
+			}
+		}
+		if code := authenticate(other.AccessToken); code != http.StatusOK {
+			t.Errorf("Expected another session's token to stay valid, got %d", code)
+		}
+	})
+
+	t.Run("DenylistUnavailable", func(t *testing.T) {
+		token, _, _ := tokenService.GenerateToken(1)
+		redis.Err = errors.New("connection refused")
+		defer func() { redis.Err = nil }()
+
+		if code := authenticate(token); code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %d", code)
+		}
+	})
+}
+
+// fakeProvider is an auth.Provider that accepts the code "good".
+type fakeProvider struct{}
+
+func (fakeProvider) Name() string { return "fake" }
+
+func (fakeProvider) GetAuthURL(state string, req *auth.AuthRequest) string {
+	return "https://provider.test/auth?state=" + state
+}
+
+func (fakeProvider) ExchangeCode(ctx context.Context, code string, req *auth.AuthRequest) (*oauth2.Token, error) {
+	if code != "good" {
+		return nil, errors.New("bad code")
+	}
+	return &oauth2.Token{AccessToken: "token"}, nil
+}
+
+func (fakeProvider) GetUserInfo(ctx context.Context, token *oauth2.Token, req *auth.AuthRequest) (*auth.UserInfo, error) {
+	return &auth.UserInfo{ID: "fake-1", Email: "fake@example.com", EmailVerified: true}, nil
+}
+
+func TestOAuthProviders(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	redis := testutil.NewMockRedisClient()
+	tokenService := auth.NewTokenService("test-secret", 30)
+	sessions := auth.NewSessionService(mockDB, tokenService, 30)
+	authHandlers := handlers.NewAuthHandlers([]auth.Provider{fakeProvider{}}, redis, tokenService, sessions, mockDB, &config.Config{AppBaseURL: "http://app.test", TokenExpiryMinutes: 30, OAuthRedirectAllowlist: []string{"https://admin.example/app"}})
+
+	// browser is the binding cookie of the browser signing in.
+	var browser *http.Cookie
+	getState := func(t *testing.T, query string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/v1/auth/fake/state"+query, nil)
+		if browser != nil {
+			req.AddCookie(browser)
+		}
+		rec := httptest.NewRecorder()
+		authHandlers.OAuthAPI(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if cookies := rec.Result().Cookies(); len(cookies) > 0 {
+			browser = cookies[0]
+		}
+		var response handlers.OAuthStateResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		u, _ := url.Parse(response.SSORedirection)
+		return u.Query().Get("state")
+	}
+	callbackWith := func(cookie *http.Cookie, state, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/auth/fake/callback", strings.NewReader(fmt.Sprintf(`{"state": %q, "code": %q}`, state, code)))
+		req.Header.Set("Content-Type", "application/json")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		authHandlers.OAuthAPI(rec, req)
+		return rec
+	}
+	callback := func(state, code string) *httptest.ResponseRecorder {
+		return callbackWith(browser, state, code)
+	}
+
+	t.Run("SignsIn", func(t *testing.T) {
+		rec := callback(getState(t, "?redirect=/scans/7"), "good")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response handlers.TokenResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if userID, err := tokenService.ValidateToken(response.Token); err != nil || userID == 0 {
+			t.Fatalf("Expected a valid token, got %v", err)
+		}
+		if response.RedirectTo != "/scans/7" {
+			t.Errorf("Expected redirectTo /scans/7, got %q", response.RedirectTo)
+		}
+		user, _ := mockDB.GetUserByProvider(context.Background(), "fake", "fake-1")
+		if user == nil || user.Email != "fake@example.com" {
+			t.Errorf("Expected the fake account to be created, got %+v", user)
+		}
+	})
+
+	t.Run("FormPostRedirectsToFrontend", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/auth/fake/callback", strings.NewReader("state=abc&code=xyz"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		authHandlers.OAuthAPI(rec, req)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("Expected status 302, got %d", rec.Code)
+		}
+		if location := rec.Header().Get("Location"); !strings.Contains(location, "code=xyz") || !strings.Contains(location, "provider=fake") {
+			t.Errorf("Unexpected redirect: %s", location)
+		}
+	})
+
+	t.Run("EscapesRedirectParameters", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		authHandlers.OAuthAPI(rec, httptest.NewRequest("GET", "/v1/auth/fake/callback?state=abc%26code%3Devil&code=xyz", nil))
+		u, _ := url.Parse(rec.Header().Get("Location"))
+		if u.Query().Get("state") != "abc&code=evil" || u.Query().Get("code") != "xyz" {
+			t.Errorf("Expected parameters to be escaped, got %s", u)
+		}
+	})
+
+	t.Run("StateIsSingleUse", func(t *testing.T) {
+		state := getState(t, "")
+		if rec := callback(state, "good"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		if rec := callback(state, "good"); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected a reused state to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("StateIsBoundToBrowser", func(t *testing.T) {
+		state := getState(t, "")
+		other := &http.Cookie{Name: browser.Name, Value: strings.Repeat("0", 64)}
+		if rec := callbackWith(other, state, "good"); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected a state from another browser to be rejected, got %d", rec.Code)
+		}
+		if rec := callbackWith(nil, getState(t, ""), "good"); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected a callback without the cookie to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("RejectsRedirectTargets", func(t *testing.T) {
+		for _, target := range []string{"https://evil.example/", "https://admin.example/application", "//evil.example/x", "/\\evil.example", "javascript:alert(1)"} {
+			rec := httptest.NewRecorder()
+			authHandlers.OAuthAPI(rec, httptest.NewRequest("GET", "/v1/auth/fake/state?redirect="+url.QueryEscape(target), nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Expected redirect %q to be rejected, got %d", target, rec.Code)
+			}
+		}
+		rec := callback(getState(t, "?redirect="+url.QueryEscape("https://admin.example/app/home")), "good")
+		var response handlers.TokenResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if response.RedirectTo != "https://admin.example/app/home" {
+			t.Errorf("Expected an allowlisted redirect, got %q", response.RedirectTo)
+		}
+	})
+
+	t.Run("RejectsUnknownState", func(t *testing.T) {
+		if rec := callback("unknown", "good"); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("RejectsOtherProvidersState", func(t *testing.T) {
+		redis.SetState(context.Background(), "google-state", `{"provider": "google"}`, time.Minute)
+		if rec := callback("google-state", "good"); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("UnknownProvider", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		authHandlers.OAuthAPI(rec, httptest.NewRequest("GET", "/v1/auth/other/state", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+}
+
+func TestMagicLink(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	redis := testutil.NewMockRedisClient()
+	sender := &testutil.MockMailSender{}
+	tokenService := auth.NewTokenService("test-secret", 30)
+	sessions := auth.NewSessionService(mockDB, tokenService, 30)
+	cfg := &config.Config{TokenExpiryMinutes: 30, MagicLinkCooldown: 60, MagicLinkIPLimit: 2}
+	authHandlers := handlers.NewAuthHandlers(nil, redis, tokenService, sessions, mockDB, cfg).
+		WithMagicLinks(auth.NewMagicLinkService(redis, sender, "http://app.test", 15))
+
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("POST", "/v1/auth/email", strings.NewReader(body)))
+		return rec
+	}
+
+	t.Run("InvalidEmail", func(t *testing.T) {
+		if rec := post(authHandlers.MagicLinkStartAPI, `{"email": "Someone <a@example.com>"}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("SignsInOnce", func(t *testing.T) {
+		if rec := post(authHandlers.MagicLinkStartAPI, `{"email": " User@Example.com "}`); rec.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d", rec.Code)
+		}
+		if len(sender.Messages) != 1 || sender.Messages[0].To != "user@example.com" {
+			t.Fatalf("Expected one mail to user@example.com, got %+v", sender.Messages)
+		}
+
+		text := sender.Messages[0].Text
+		start := strings.Index(text, "http://app.test/auth/magic-link?")
+		if start == -1 {
+			t.Fatalf("Mail has no link: %s", text)
+		}
+		link, _ := url.Parse(strings.Fields(text[start:])[0])
+		body := fmt.Sprintf(`{"token": %q}`, link.Query().Get("token"))
+
+		rec := post(authHandlers.MagicLinkVerifyAPI, body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		user, _ := mockDB.GetUserByProvider(context.Background(), auth.MagicLinkProvider, "user@example.com")
+		if user == nil {
+			t.Error("Expected an email account to be created")
+		}
+
+		if rec := post(authHandlers.MagicLinkVerifyAPI, body); rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 when the link is reused, got %d", rec.Code)
+		}
+	})
+
+	t.Run("RateLimited", func(t *testing.T) {
+		sender.Messages = nil
+
+		// The address from SignsInOnce is still cooling down.
+		if rec := post(authHandlers.MagicLinkStartAPI, `{"email": "user@example.com"}`); rec.Code != http.StatusAccepted {
+			t.Errorf("Expected status 202 during the cooldown, got %d", rec.Code)
+		}
+		if len(sender.Messages) != 0 {
+			t.Errorf("Expected no mail during the cooldown, got %+v", sender.Messages)
+		}
+
+		// The IP has one request left of its two.
+		post(authHandlers.MagicLinkStartAPI, `{"email": "second@example.com"}`)
+		if rec := post(authHandlers.MagicLinkStartAPI, `{"email": "third@example.com"}`); rec.Code != http.StatusAccepted {
+			t.Errorf("Expected status 202 over the IP limit, got %d", rec.Code)
+		}
+		if len(sender.Messages) != 1 || sender.Messages[0].To != "second@example.com" {
+			t.Errorf("Expected one mail to second@example.com, got %+v", sender.Messages)
+		}
+	})
+}
+
+// stubProvider is an auth.Provider that signs in as info for any code.
+type stubProvider struct {
+	name string
+	info auth.UserInfo
+}
+
+func (p *stubProvider) Name() string { return p.name }
+
+func (p *stubProvider) GetAuthURL(state string, req *auth.AuthRequest) string {
+	return "https://" + p.name + ".test/auth?state=" + state
+}
+
+func (p *stubProvider) ExchangeCode(ctx context.Context, code string, req *auth.AuthRequest) (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "token"}, nil
+}
+
+func (p *stubProvider) GetUserInfo(ctx context.Context, token *oauth2.Token, req *auth.AuthRequest) (*auth.UserInfo, error) {
+	info := p.info
+	return &info, nil
+}
+
+func TestUserIdentities(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	redis := testutil.NewMockRedisClient()
+	tokenService := auth.NewTokenService("test-secret", 30)
+	sessions := auth.NewSessionService(mockDB, tokenService, 30)
+	google := &stubProvider{name: "google", info: auth.UserInfo{ID: "g-1", Email: "Ana@Example.com", EmailVerified: true}}
+	github := &stubProvider{name: "github", info: auth.UserInfo{ID: "gh-1", Email: "ana@example.com", EmailVerified: true}}
+	authHandlers := handlers.NewAuthHandlers([]auth.Provider{google, github}, redis, tokenService, sessions, mockDB, &config.Config{TokenExpiryMinutes: 30})
+
+	// All requests come from one browser, which keeps its binding cookie.
+	var browser *http.Cookie
+	send := func(userID int64, handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if browser != nil {
+			req.AddCookie(browser)
+		}
+		if userID != 0 {
+			req = req.WithContext(middleware.WithUserID(req.Context(), userID))
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if cookies := rec.Result().Cookies(); len(cookies) > 0 {
+			browser = cookies[0]
+		}
+		return rec
+	}
+	asUser := func(userID int64, handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+		return send(userID, handler, method, path, body)
+	}
+	// callbackBody starts a sign-in (userID 0) or a link and returns the body
+	// the frontend posts to the callback.
+	callbackBody := func(userID int64, handler http.HandlerFunc, path string) string {
+		rec := send(userID, handler, "GET", path, "")
+		var response handlers.OAuthStateResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		u, _ := url.Parse(response.SSORedirection)
+		return fmt.Sprintf(`{"state": %q, "code": "code"}`, u.Query().Get("state"))
+	}
+	signIn := func(t *testing.T, provider string) int64 {
+		t.Helper()
+		body := callbackBody(0, authHandlers.OAuthAPI, "/v1/auth/"+provider+"/state")
+		rec := send(0, authHandlers.OAuthAPI, "POST", "/v1/auth/"+provider+"/callback", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response handlers.TokenResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		userID, err := tokenService.ValidateToken(response.Token)
+		if err != nil {
+			t.Fatalf("Expected a valid token, got %v", err)
+		}
+		return userID
+	}
+	identities := func(t *testing.T, userID int64) []handlers.IdentityResponse {
+		t.Helper()
+		rec := asUser(userID, authHandlers.IdentitiesAPI, "GET", "/v1/users/me/identities", "")
+		var response handlers.IdentitiesResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response.Data
+	}
+
+	ana := signIn(t, "google")
+
+	t.Run("LinksByVerifiedEmail", func(t *testing.T) {
+		if userID := signIn(t, "github"); userID != ana {
+			t.Fatalf("Expected GitHub to sign in user %d, got %d", ana, userID)
+		}
+		if got := identities(t, ana); len(got) != 2 || got[0].Provider != "google" || got[1].Provider != "github" {
+			t.Errorf("Expected google and github identities, got %+v", got)
+		}
+	})
+
+	t.Run("UnverifiedEmailCreatesAccount", func(t *testing.T) {
+		github.info = auth.UserInfo{ID: "gh-2", Email: "ana@example.com"}
+		if userID := signIn(t, "github"); userID == ana {
+			t.Error("Expected an unverified email not to link to the existing user")
+		}
+	})
+
+	t.Run("LinkAndUnlink", func(t *testing.T) {
+		google.info = auth.UserInfo{ID: "g-2", Email: "bob@example.com", EmailVerified: true}
+		bob := signIn(t, "google")
+		github.info = auth.UserInfo{ID: "gh-3", Email: "bob@work.example", EmailVerified: true}
+
+		linkBody := func() string {
+			return callbackBody(bob, authHandlers.IdentityAPI, "/v1/users/me/identities/github/state")
+		}
+		if rec := asUser(ana, authHandlers.IdentityAPI, "POST", "/v1/users/me/identities/github/callback", linkBody()); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected another user's state to be rejected, got %d", rec.Code)
+		}
+		if rec := send(0, authHandlers.OAuthAPI, "POST", "/v1/auth/github/callback", linkBody()); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected a linking state not to sign in, got %d", rec.Code)
+		}
+		rec := asUser(bob, authHandlers.IdentityAPI, "POST", "/v1/users/me/identities/github/callback", linkBody())
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var linked handlers.IdentityResponse
+		json.NewDecoder(rec.Body).Decode(&linked)
+
+		github.info = auth.UserInfo{ID: "gh-1"}
+		if rec := asUser(bob, authHandlers.IdentityAPI, "POST", "/v1/users/me/identities/github/callback", linkBody()); rec.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 for another user's account, got %d", rec.Code)
+		}
+
+		path := fmt.Sprintf("/v1/users/me/identities/%d", linked.ID)
+		if rec := asUser(ana, authHandlers.IdentityAPI, "DELETE", path, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for another user's identity, got %d", rec.Code)
+		}
+		if rec := asUser(bob, authHandlers.IdentityAPI, "DELETE", path, ""); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rec.Code)
+		}
+		remaining := identities(t, bob)
+		if len(remaining) != 1 {
+			t.Fatalf("Expected one identity left, got %+v", remaining)
+		}
+		last := fmt.Sprintf("/v1/users/me/identities/%d", remaining[0].ID)
+		if rec := asUser(bob, authHandlers.IdentityAPI, "DELETE", last, ""); rec.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 for the last identity, got %d", rec.Code)
+		}
+	})
+}
+
+func TestReadyz(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	redis := testutil.NewMockRedisClient()
+	redisClient := storage.NewFailoverClient(redis, storage.NewMemoryClient(), "memory", time.Hour)
+	defer redisClient.Close()
+	healthHandlers := handlers.NewHealthHandlers(mockDB, redisClient)
+
+	probe := func(t *testing.T, wantCode int) handlers.ReadinessResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		healthHandlers.ReadyzAPI(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != wantCode {
+			t.Fatalf("Expected status %d, got %d", wantCode, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "connection refused") {
+			t.Errorf("Expected backend errors not to be exposed, got %s", rec.Body.String())
+		}
+		var response handlers.ReadinessResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		return response
+	}
+
+	if response := probe(t, http.StatusOK); response.Status != "ready" {
+		t.Errorf("Expected ready, got %+v", response)
+	}
+
+	redis.Err = errors.New("connection refused")
+	redisClient.Check(context.Background())
+	response := probe(t, http.StatusOK)
+	if response.Status != "degraded" || response.Backends["redis"].Status != "degraded" || response.Backends["redis"].Fallback != "memory" {
+		t.Errorf("Expected Redis degraded to the memory fallback, got %+v", response)
+	}
+
+	mockDB.PingErr = errors.New("connection refused")
+	if response := probe(t, http.StatusServiceUnavailable); response.Backends["postgres"].Status != "down" {
+		t.Errorf("Expected postgres down, got %+v", response)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	tokenService := auth.NewTokenService("test-secret", 30)
+	sessions := auth.NewSessionService(mockDB, tokenService, 30)
+	cfg := &config.Config{TokenExpiryMinutes: 30, ImpersonationMinutes: 15, DefaultPageSize: 20}
+	adminHandlers := handlers.NewAdminHandlers(mockDB, tokenService, cfg)
+	userHandlers := handlers.NewUserHandlers(mockDB)
+	authHandlers := handlers.NewAuthHandlers(nil, nil, tokenService, sessions, mockDB, cfg)
+	authMiddleware := middleware.NewAuthMiddleware(tokenService).WithUsers(mockDB)
+
+	adminOnly := middleware.RequireRole(models.RoleAdmin)
+	authMux := http.NewServeMux()
+	authMux.HandleFunc("/v1/users/me", userHandlers.UsersMeAPI)
+	authMux.HandleFunc("/v1/users/me/identities/", authHandlers.IdentityAPI)
+	authMux.Handle("/v1/admin/users", adminOnly(http.HandlerFunc(adminHandlers.UsersAPI)))
+	authMux.Handle("/v1/admin/users/", adminOnly(http.HandlerFunc(adminHandlers.UserAPI)))
+	authMux.Handle("/v1/admin/stats", adminOnly(http.HandlerFunc(adminHandlers.StatsAPI)))
+	authMux.Handle("/v1/admin/audit", adminOnly(http.HandlerFunc(adminHandlers.AuditAPI)))
+	authMux.Handle("/v1/admin/scans/failed", middleware.RequireRole(models.RoleAdmin, models.RoleContentEditor)(http.HandlerFunc(adminHandlers.FailedScansAPI)))
+	server := authMiddleware.Handle(authMux)
+
+	ctx := context.Background()
+	admin := &models.User{Email: "admin@example.com", Role: models.RoleAdmin, CreatedAt: time.Now()}
+	editor := &models.User{Email: "editor@example.com", Role: models.RoleContentEditor, CreatedAt: time.Now()}
+	user := &models.User{Email: "user@example.com", CreatedAt: time.Now()}
+	for _, u := range []*models.User{admin, editor, user} {
+		mockDB.CreateUser(ctx, u)
+	}
+	tokenFor := func(userID int64) string {
+		token, _, _ := tokenService.GenerateToken(userID)
+		return token
+	}
+	send := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("RolesEnforced", func(t *testing.T) {
+		tests := []struct {
+			userID int64
+			path   string
+			want   int
+		}{
+			{user.ID, "/v1/admin/users", http.StatusForbidden},
+			{editor.ID, "/v1/admin/users", http.StatusForbidden},
+			{editor.ID, "/v1/admin/scans/failed", http.StatusOK},
+			{user.ID, "/v1/admin/scans/failed", http.StatusForbidden},
+			{admin.ID, "/v1/admin/users", http.StatusOK},
+			{admin.ID, "/v1/admin/stats", http.StatusOK},
+		}
+		for _, tt := range tests {
+			if rec := send(tokenFor(tt.userID), "GET", tt.path, ""); rec.Code != tt.want {
+				t.Errorf("User %d GET %s: expected status %d, got %d", tt.userID, tt.path, tt.want, rec.Code)
+			}
+		}
+	})
+
+	t.Run("ListUsers", func(t *testing.T) {
+		rec := send(tokenFor(admin.ID), "GET", "/v1/admin/users?q=EDITOR", "")
+		var response handlers.AdminUsersResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if len(response.Data) != 1 || response.Data[0].Email != "editor@example.com" || response.Data[0].Role != models.RoleContentEditor {
+			t.Errorf("Expected only the editor, got %+v", response.Data)
+		}
+	})
+
+	t.Run("FailedScans", func(t *testing.T) {
+		scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: user.ID, CreatedAt: time.Now()})
+		page := 2
+		mockDB.CreateScanFailure(ctx, &models.ScanFailure{ScanID: scanID, PageNumber: &page, Source: models.OCRSourcePipeline, Error: "quota exceeded", CreatedAt: time.Now()})
+
+		rec := send(tokenFor(editor.ID), "GET", "/v1/admin/scans/failed", "")
+		var response handlers.ScanFailuresResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if len(response.Data) != 1 || response.Data[0].UserID != user.ID || *response.Data[0].PageNumber != 2 || response.Data[0].Error != "quota exceeded" {
+			t.Errorf("Expected the failure of page 2, got %+v", response.Data)
+		}
+
+		rec = send(tokenFor(admin.ID), "GET", "/v1/admin/stats?days=7", "")
+		var stats handlers.UsageStatsResponse
+		json.NewDecoder(rec.Body).Decode(&stats)
+		if stats.Users.Total != 3 || stats.Users.ByRole[models.RoleAdmin] != 1 || stats.Scans.Failed != 1 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("ChangeRole", func(t *testing.T) {
+		rec := send(tokenFor(admin.ID), "PATCH", fmt.Sprintf("/v1/admin/users/%d", editor.ID), `{"role": "superuser"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for an unknown role, got %d", rec.Code)
+		}
+		rec = send(tokenFor(admin.ID), "PATCH", fmt.Sprintf("/v1/admin/users/%d", admin.ID), `{"role": "user"}`)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 when demoting yourself, got %d", rec.Code)
+		}
+
+		rec = send(tokenFor(admin.ID), "PATCH", fmt.Sprintf("/v1/admin/users/%d", editor.ID), `{"role": "user"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if code := send(tokenFor(editor.ID), "GET", "/v1/admin/scans/failed", "").Code; code != http.StatusForbidden {
+			t.Errorf("Expected the demoted editor to be refused, got %d", code)
+		}
+	})
+
+	t.Run("DisableAccount", func(t *testing.T) {
+		tokens, _ := sessions.Start(ctx, user.ID, auth.Client{})
+
+		rec := send(tokenFor(admin.ID), "PATCH", fmt.Sprintf("/v1/admin/users/%d", user.ID), `{"disabled": true}`)
+		var response handlers.AdminUserResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+		if rec.Code != http.StatusOK || !response.Disabled {
+			t.Fatalf("Expected the user disabled, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		if code := send(tokens.AccessToken, "GET", "/v1/users/me", "").Code; code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for a disabled account, got %d", code)
+		}
+		if _, err := sessions.Refresh(ctx, tokens.RefreshToken, auth.Client{}); err == nil {
+			t.Error("Expected the disabled user's sessions to be revoked")
+		}
+
+		send(tokenFor(admin.ID), "PATCH", fmt.Sprintf("/v1/admin/users/%d", user.ID), `{"disabled": false}`)
+		if code := send(tokenFor(user.ID), "GET", "/v1/users/me", "").Code; code != http.StatusOK {
+			t.Errorf("Expected the re-enabled user to be let in, got %d", code)
+		}
+	})
+
+	t.Run("Impersonate", func(t *testing.T) {
+		path := fmt.Sprintf("/v1/admin/users/%d/impersonate", user.ID)
+		if code := send(tokenFor(admin.ID), "POST", path, `{}`).Code; code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 without a reason, got %d", code)
+		}
+		if code := send(tokenFor(admin.ID), "POST", fmt.Sprintf("/v1/admin/users/%d/impersonate", admin.ID), `{"reason": "x"}`).Code; code != http.StatusForbidden {
+			t.Errorf("Expected status 403 impersonating an admin, got %d", code)
+		}
+
+		rec := send(tokenFor(admin.ID), "POST", path, `{"reason": "ticket 42"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response handlers.ImpersonateResponse
+		json.NewDecoder(rec.Body).Decode(&response)
+
+		rec = send(response.Token, "GET", "/v1/users/me", "")
+		var profile handlers.GetUserProfileResponse
+		json.NewDecoder(rec.Body).Decode(&profile)
+		if rec.Code != http.StatusOK || !profile.Impersonated || profile.Role != models.RoleUser {
+			t.Errorf("Expected to act as the user, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if code := send(response.Token, "GET", "/v1/admin/users", "").Code; code != http.StatusForbidden {
+			t.Errorf("Expected the impersonation token to lose admin access, got %d", code)
+		}
+		if code := send(response.Token, "GET", "/v1/users/me/identities/google/state", "").Code; code != http.StatusForbidden {
+			t.Errorf("Expected linking identities to be refused while impersonating, got %d", code)
+		}
+		send(response.Token, "PATCH", "/v1/users/me", `{"preferredLanguage": "EN"}`)
+
+		rec = send(tokenFor(admin.ID), "GET", fmt.Sprintf("/v1/admin/audit?userId=%d", user.ID), "")
+		var audit handlers.AuditEntriesResponse
+		json.NewDecoder(rec.Body).Decode(&audit)
+		actions := make([]string, len(audit.Data))
+		for i, entry := range audit.Data {
+			actions[i] = entry.Action
+		}
+		want := []string{models.AuditActionImpersonatedRequest, models.AuditActionImpersonate, models.AuditActionEnableUser, models.AuditActionDisableUser}
+		if strings.Join(actions, ",") != strings.Join(want, ",") {
+			t.Fatalf("Expected audit actions %v, got %v", want, actions)
+		}
+		if audit.Data[1].Details["reason"] != "ticket 42" || audit.Data[0].ActorUserID != admin.ID || audit.Data[0].Details["method"] != "PATCH" {
+			t.Errorf("Unexpected audit entries %+v", audit.Data[:2])
+		}
+	})
+}
+
+func TestOrganizations(t *testing.T) {
+	mockDB := testutil.NewMockDB()
+	orgHandlers := handlers.NewOrgHandlers(mockDB)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/orgs", orgHandlers.OrgsAPI)
+	mux.HandleFunc("/v1/orgs/", orgHandlers.OrgAPI)
+	mux.HandleFunc("/v1/invitations", orgHandlers.InvitationsAPI)
+	mux.HandleFunc("/v1/invitations/", orgHandlers.InvitationAPI)
+
+	ctx := context.Background()
+	owner := &models.User{Email: "owner@example.com", CreatedAt: time.Now()}
+	mentor := &models.User{Email: "mentor@example.com", CreatedAt: time.Now()}
+	trainee := &models.User{Email: "trainee@example.com", CreatedAt: time.Now()}
+	outsider := &models.User{Email: "outsider@example.com", CreatedAt: time.Now()}
+	for _, u := range []*models.User{owner, mentor, trainee, outsider} {
+		mockDB.CreateUser(ctx, u)
+		mockDB.CreateUserIdentity(ctx, &models.UserIdentity{UserID: u.ID, Provider: "google", ProviderID: u.Email, Email: u.Email, EmailVerified: true})
+	}
+	// claimant signed up with an address nobody verified.
+	claimant := &models.User{Email: "nobody@example.com", CreatedAt: time.Now()}
+	mockDB.CreateUser(ctx, claimant)
+	mockDB.CreateUserIdentity(ctx, &models.UserIdentity{UserID: claimant.ID, Provider: "github", ProviderID: "claimant", Email: claimant.Email})
+	send := func(userID int64, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(middleware.WithUserID(req.Context(), userID))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(owner.ID, "POST", "/v1/orgs", `{"name": "April cohort"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var org handlers.OrgResponse
+	json.NewDecoder(rec.Body).Decode(&org)
+	if org.Role != models.OrgRoleOwner {
+		t.Errorf("Expected creator to be owner, got %+v", org)
+	}
+	base := fmt.Sprintf("/v1/orgs/%d", org.ID)
+
+	invite := func(email, role string) *httptest.ResponseRecorder {
+		return send(owner.ID, "POST", base+"/invitations", fmt.Sprintf(`{"email": %q, "role": %q}`, email, role))
+	}
+	pendingFor := func(userID int64) []handlers.InvitationResponse {
+		var invitations handlers.InvitationsResponse
+		json.NewDecoder(send(userID, "GET", "/v1/invitations", "").Body).Decode(&invitations)
+		return invitations.Data
+	}
+
+	t.Run("Invitations", func(t *testing.T) {
+		if rec := send(owner.ID, "POST", base+"/members", `{"email": "trainee@example.com"}`); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected members to be added only by invitation, got %d", rec.Code)
+		}
+		if rec := send(outsider.ID, "POST", base+"/invitations", `{"email": "outsider@example.com"}`); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a non-member inviting, got %d", rec.Code)
+		}
+
+		// Inviting an unknown email looks the same as inviting a user.
+		known, unknown := invite("Trainee@example.com", ""), invite("nobody@example.com", "")
+		if known.Code != http.StatusCreated || unknown.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 for both, got %d and %d", known.Code, unknown.Code)
+		}
+		if rec := send(trainee.ID, "GET", base+"/members", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected the invitee not to be a member before accepting, got %d", rec.Code)
+		}
+
+		// An unverified email is not enough to see or accept an invitation.
+		var unverified handlers.InvitationResponse
+		if err := json.NewDecoder(unknown.Body).Decode(&unverified); err != nil || unverified.ID == 0 {
+			t.Fatalf("Expected the invitation in the response, got %+v: %v", unverified, err)
+		}
+		if pending := pendingFor(claimant.ID); len(pending) != 0 {
+			t.Errorf("Expected no invitations for an unverified email, got %+v", pending)
+		}
+		if rec := send(claimant.ID, "POST", fmt.Sprintf("/v1/invitations/%d/accept", unverified.ID), ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for an unverified email accepting, got %d", rec.Code)
+		}
+		if rec := send(claimant.ID, "DELETE", fmt.Sprintf("/v1/invitations/%d", unverified.ID), ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for an unverified email declining, got %d", rec.Code)
+		}
+
+		pending := pendingFor(trainee.ID)
+		if len(pending) != 1 || pending[0].OrgName != "April cohort" || pending[0].Role != models.OrgRoleMember {
+			t.Fatalf("Unexpected invitations: %+v", pending)
+		}
+		accept := fmt.Sprintf("/v1/invitations/%d/accept", pending[0].ID)
+		if rec := send(outsider.ID, "POST", accept, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for someone else's invitation, got %d", rec.Code)
+		}
+		rec := send(trainee.ID, "POST", accept, "")
+		var joined handlers.OrgResponse
+		json.NewDecoder(rec.Body).Decode(&joined)
+		if rec.Code != http.StatusOK || joined.ID != org.ID || joined.Role != models.OrgRoleMember {
+			t.Errorf("Expected to join, got %d %+v", rec.Code, joined)
+		}
+		if rec := send(trainee.ID, "POST", accept, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected the invitation to be single-use, got %d", rec.Code)
+		}
+
+		invite("mentor@example.com", models.OrgRoleMentor)
+		invite("outsider@example.com", "")
+		send(mentor.ID, "POST", fmt.Sprintf("/v1/invitations/%d/accept", pendingFor(mentor.ID)[0].ID), "")
+		if rec := send(mentor.ID, "POST", base+"/invitations", `{"email": "outsider@example.com"}`); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for a mentor inviting, got %d", rec.Code)
+		}
+		if rec := send(outsider.ID, "DELETE", fmt.Sprintf("/v1/invitations/%d", pendingFor(outsider.ID)[0].ID), ""); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204 for declining, got %d", rec.Code)
+		}
+		if rec := send(outsider.ID, "GET", base+"/members", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for a non-member, got %d", rec.Code)
+		}
+
+		var orgs handlers.OrgsResponse
+		json.NewDecoder(send(mentor.ID, "GET", "/v1/orgs", "").Body).Decode(&orgs)
+		if len(orgs.Data) != 1 || orgs.Data[0].Role != models.OrgRoleMentor {
+			t.Errorf("Unexpected organizations: %+v", orgs.Data)
+		}
+	})
+
+	t.Run("LastOwner", func(t *testing.T) {
+		if rec := send(owner.ID, "DELETE", base+"/members/me", ""); rec.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 for the last owner leaving, got %d", rec.Code)
+		}
+		path := fmt.Sprintf("%s/members/%d", base, owner.ID)
+		if rec := send(owner.ID, "PATCH", path, `{"role": "mentor"}`); rec.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 for demoting the last owner, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Sharing", func(t *testing.T) {
+		path := fmt.Sprintf("%s/members/%d", base, trainee.ID)
+		if rec := send(mentor.ID, "PATCH", path, `{"shareAnnotations": true}`); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for sharing on someone else's behalf, got %d", rec.Code)
+		}
+		rec := send(trainee.ID, "PATCH", base+"/members/me", `{"shareAnnotations": true}`)
+		var member handlers.OrgMemberResponse
+		json.NewDecoder(rec.Body).Decode(&member)
+		if rec.Code != http.StatusOK || !member.ShareAnnotations {
+			t.Errorf("Expected sharing to be turned on, got %d %+v", rec.Code, member)
+		}
+	})
+
+	t.Run("Glossary", func(t *testing.T) {
+		body := `{"term": "稟議", "reading": "りんぎ", "meaning": "approval request circulated to managers"}`
+		if rec := send(trainee.ID, "POST", base+"/glossary", body); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for a member editing the glossary, got %d", rec.Code)
+		}
+		rec := send(mentor.ID, "POST", base+"/glossary", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var entry handlers.GlossaryEntryResponse
+		json.NewDecoder(rec.Body).Decode(&entry)
+		if rec := send(mentor.ID, "POST", base+"/glossary", body); rec.Code != http.StatusConflict {
+			t.Errorf("Expected status 409 for a duplicate term, got %d", rec.Code)
+		}
+
+		rec = send(trainee.ID, "GET", base+"/glossary", "")
+		var glossary handlers.GlossaryEntriesResponse
+		json.NewDecoder(rec.Body).Decode(&glossary)
+		if len(glossary.Data) != 1 || glossary.Data[0].ID != entry.ID {
+			t.Errorf("Unexpected glossary: %+v", glossary.Data)
+		}
+
+		// The glossary feeds the knowledge lookups of members, not outsiders.
+		scanHandlers := handlers.NewScanHandlers(mockDB, nil, &testutil.MockGeminiClient{}, knowledge.NewEmptyService(), nil, nil, auth.NewURLSigner("test-secret", 15), &config.Config{OCRConcurrency: 1})
+		text := "稟議を回してください。"
+		for _, userID := range []int64{trainee.ID, outsider.ID} {
+			scanID, _ := mockDB.CreateScan(ctx, &models.Scan{UserID: userID, DocumentType: models.DocumentTypeText, PageCount: 1, FullOCRText: &text, CreatedAt: time.Now()})
+			mockDB.CreateScanPage(ctx, &models.ScanPage{ScanID: scanID, PageNumber: 1, OCRText: &text})
+			req := httptest.NewRequest("POST", fmt.Sprintf("/v1/scans/%d/vocabulary", scanID), nil)
+			req = req.WithContext(middleware.WithUserID(req.Context(), userID))
+			rec := httptest.NewRecorder()
+			scanHandlers.ScanAPI(rec, req)
+
+			var vocabulary handlers.ScanVocabularyResponse
+			json.NewDecoder(rec.Body).Decode(&vocabulary)
+			found := len(vocabulary.Items) == 1 && vocabulary.Items[0].InKnowledgeBase && vocabulary.Items[0].Reading == "りんぎ"
+			if found != (userID == trainee.ID) {
+				t.Errorf("User %d: unexpected vocabulary %+v", userID, vocabulary.Items)
+			}
+		}
+
+		path := fmt.Sprintf("%s/glossary/%d", base, entry.ID)
+		if rec := send(mentor.ID, "DELETE", path, ""); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Insights", func(t *testing.T) {
+		for _, a := range []struct {
+			userID int64
+			text   string
+		}{
+			{trainee.ID, "稟議"},
+			{trainee.ID, "根回し"},
+			{mentor.ID, "稟議"},
+		} {
+			mockDB.CreateAnnotation(ctx, &models.Annotation{
+				UserID:          a.userID,
+				HighlightedText: a.text,
+				NuanceData:      models.NuanceData{JLPTLevel: "N1", PolitenessRegister: models.PolitenessTeineigo},
+				CreatedAt:       time.Now(),
+			})
+		}
+
+		if rec := send(trainee.ID, "GET", base+"/insights", ""); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for a member, got %d", rec.Code)
+		}
+		rec := send(mentor.ID, "GET", base+"/insights?days=7", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var insights handlers.OrgInsightsResponse
+		json.NewDecoder(rec.Body).Decode(&insights)
+		// Only the trainee shares annotations; the mentor's are left out.
+		if insights.Members != 3 || insights.SharingMembers != 1 || insights.Annotations != 2 || insights.JLPTLevels["N1"] != 2 {
+			t.Errorf("Unexpected insights: %+v", insights)
+		}
+		if len(insights.TopTerms) != 2 || insights.TopTerms[0].Members != 1 {
+			t.Errorf("Unexpected top terms: %+v", insights.TopTerms)
 		}
 	})
 }
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/storage"
+)
+
+type HealthHandlers struct {
+	db    storage.DB
+	redis *storage.FailoverClient
+}
+
+func NewHealthHandlers(db storage.DB, redis *storage.FailoverClient) *HealthHandlers {
+	return &HealthHandlers{
+		db:    db,
+		redis: redis,
+	}
+}
+
+// Backend states reported by the readiness probe. A degraded backend is down
+// but replaced by a fallback.
+const (
+	backendUp       = "up"
+	backendDegraded = "degraded"
+	backendDown     = "down"
+)
+
+// BackendHealth is what the unauthenticated probe shows of a backend. Errors
+// are logged rather than returned, as they can reveal hosts and addresses.
+type BackendHealth struct {
+	Status   string `json:"status"`
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// ReadinessResponse is "ready" when every backend is up, "degraded" when the
+// app works with some down or on a fallback, and "unavailable" when the
+// database is down.
+type ReadinessResponse struct {
+	Status   string                   `json:"status"`
+	Backends map[string]BackendHealth `json:"backends"`
+}
+
+// ReadyzAPI is the readiness probe. It responds 503 only when the database
+// is down; Redis being down degrades sign-in but leaves the app usable.
+func (h *HealthHandlers) ReadyzAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+	response := ReadinessResponse{Status: "ready", Backends: make(map[string]BackendHealth)}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	postgres := BackendHealth{Status: backendUp}
+	if err := h.db.Ping(ctx); err != nil {
+		log.ErrorWithErr(err, "Readiness probe: postgres is down")
+		postgres = BackendHealth{Status: backendDown}
+	}
+	response.Backends["postgres"] = postgres
+
+	status := h.redis.Status()
+	redis := BackendHealth{Status: backendUp}
+	if !status.Up {
+		redis.Status = backendDown
+		if status.Fallback != "" {
+			redis.Status = backendDegraded
+			redis.Fallback = status.Fallback
+		}
+		log.Warnf("Readiness probe: redis is down since %s: %v", status.Since.Format(time.RFC3339), status.Err)
+	}
+	response.Backends["redis"] = redis
+
+	code := http.StatusOK
+	switch {
+	case postgres.Status == backendDown:
+		response.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	case redis.Status != backendUp:
+		response.Status = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/auth"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+)
+
+// IdentityResponse is one way the user can sign in.
+type IdentityResponse struct {
+	ID            int64  `json:"id"`
+	Provider      string `json:"provider"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+type IdentitiesResponse struct {
+	Data []IdentityResponse `json:"data"`
+}
+
+// IdentitiesAPI lists the identities the user can sign in with.
+func (h *AuthHandlers) IdentitiesAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	identities, err := h.db.GetUserIdentities(r.Context(), userID)
+	if err != nil {
+		logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(userID).ErrorWithErr(err, "Failed to get identities")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]IdentityResponse, len(identities))
+	for i, identity := range identities {
+		data[i] = toIdentityResponse(identity)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(IdentitiesResponse{Data: data})
+}
+
+// IdentityAPI routes /v1/users/me/identities/{provider}/state and
+// /v1/users/me/identities/{provider}/callback, which link another provider
+// account, and DELETE /v1/users/me/identities/{id}, which unlinks one.
+func (h *AuthHandlers) IdentityAPI(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// An admin impersonating the user must not be able to give themselves a
+	// way to sign in as them.
+	if middleware.GetImpersonatorID(r.Context()) != 0 {
+		http.Error(w, "Not allowed while impersonating", http.StatusForbidden)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/users/me/identities/"), "/")
+	switch len(parts) {
+	case 1:
+		identityID, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			http.Error(w, "Identity not found", http.StatusNotFound)
+			return
+		}
+		h.unlinkIdentity(w, r, userID, identityID)
+	case 2:
+		provider, ok := h.providers[parts[0]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		switch parts[1] {
+		case "state":
+			h.linkIdentityState(w, r, userID, provider)
+		case "callback":
+			h.linkIdentityCallback(w, r, userID, provider)
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *AuthHandlers) linkIdentityState(w http.ResponseWriter, r *http.Request, userID int64, provider auth.Provider) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(userID)
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The state records the user, so it can neither sign in nor link the
+	// account to anyone else.
+	redirectURL, err := h.startOAuth(w, r, provider, userID, "")
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to store state in Redis")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OAuthStateResponse{
+		SSORedirection: redirectURL,
+	})
+}
+
+func (h *AuthHandlers) linkIdentityCallback(w http.ResponseWriter, r *http.Request, userID int64, provider auth.Provider) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(userID)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req OAuthCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" || req.State == "" {
+		http.Error(w, "Code and state are required", http.StatusBadRequest)
+		return
+	}
+
+	state, err := h.consumeOAuthState(r, req.State, provider, userID)
+	if errors.Is(err, errInvalidState) {
+		log.Warnf("Invalid state for linking %s", provider.Name())
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.ErrorWithErr(err, "State validation failed")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	oauthToken, err := provider.ExchangeCode(r.Context(), req.Code, &state.AuthRequest)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to exchange code with provider")
+		http.Error(w, "Failed to exchange code", http.StatusInternalServerError)
+		return
+	}
+	userInfo, err := provider.GetUserInfo(r.Context(), oauthToken, &state.AuthRequest)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get user info from provider")
+		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
+		return
+	}
+
+	identity := &models.UserIdentity{
+		UserID:        userID,
+		Provider:      provider.Name(),
+		ProviderID:    userInfo.ID,
+		Email:         userInfo.Email,
+		EmailVerified: userInfo.EmailVerified,
+		CreatedAt:     time.Now(),
+	}
+	if _, err := h.db.CreateUserIdentity(r.Context(), identity); err != nil {
+		if errors.Is(err, storage.ErrIdentityTaken) {
+			// Linking the account again is a no-op; taking it from another
+			// user is not allowed.
+			existing, getErr := h.db.GetUserIdentity(r.Context(), identity.Provider, identity.ProviderID)
+			if getErr == nil && existing != nil && existing.UserID == userID {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(toIdentityResponse(existing))
+				return
+			}
+			http.Error(w, "This account is linked to another user", http.StatusConflict)
+			return
+		}
+		log.ErrorWithErr(err, "Failed to link identity")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof("Linked %s identity", provider.Name())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toIdentityResponse(identity))
+}
+
+// unlinkIdentity removes an identity. The last one cannot be removed, as the
+// user could no longer sign in.
+func (h *AuthHandlers) unlinkIdentity(w http.ResponseWriter, r *http.Request, userID, identityID int64) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(userID)
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	identities, err := h.db.GetUserIdentities(r.Context(), userID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get identities")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, identity := range identities {
+		found = found || identity.ID == identityID
+	}
+	if !found {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+
+	deleted, err := h.db.DeleteUserIdentity(r.Context(), userID, identityID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to unlink identity")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Cannot remove the last sign-in method", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toIdentityResponse(identity *models.UserIdentity) IdentityResponse {
+	return IdentityResponse{
+		ID:            identity.ID,
+		Provider:      identity.Provider,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		CreatedAt:     identity.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/auth"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+)
+
+type MagicLinkStartRequest struct {
+	Email string `json:"email"`
+}
+
+type MagicLinkVerifyRequest struct {
+	Token string `json:"token"`
+}
+
+// MagicLinkStartAPI emails a sign-in link. It answers 202 whether or not the
+// address has an account, so it cannot be used to find out who does. An
+// address gets at most one link per MAGIC_LINK_COOLDOWN_SECONDS, and an IP
+// address MAGIC_LINK_LIMIT_PER_IP per hour.
+func (h *AuthHandlers) MagicLinkStartAPI(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req MagicLinkStartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email, err := auth.NormalizeEmail(req.Email)
+	if err != nil {
+		http.Error(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+
+	// Requests over a limit are answered like any other, so the limits do
+	// not reveal which addresses have been asked for recently.
+	ipAddress := requestClient(r).IPAddress
+	allowed, err := h.db.RecordMagicLinkRequest(r.Context(), auth.HashToken(email), ipAddress,
+		time.Duration(h.config.MagicLinkCooldown)*time.Second, time.Hour, h.config.MagicLinkIPLimit)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to record magic link request")
+		http.Error(w, "Failed to send sign-in link", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		log.Warnf("Magic link request from %s over the rate limit", ipAddress)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if err := h.magicLinks.Send(r.Context(), email); err != nil {
+		log.ErrorWithErr(err, "Failed to send magic link")
+		http.Error(w, "Failed to send sign-in link", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// MagicLinkVerifyAPI signs in with the token from a magic link, creating the
+// account on first use. Each link works once.
+func (h *AuthHandlers) MagicLinkVerifyAPI(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req MagicLinkVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	userInfo, err := h.magicLinks.Verify(r.Context(), req.Token)
+	if errors.Is(err, auth.ErrMagicLinkInvalid) {
+		http.Error(w, "Unauthorized: invalid or expired link", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to verify magic link")
+		http.Error(w, "Failed to verify link", http.StatusInternalServerError)
+		return
+	}
+
+	h.signIn(w, r, auth.MagicLinkProvider, userInfo, "")
+}
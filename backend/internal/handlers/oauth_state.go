@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/auth"
+)
+
+const (
+	oauthStateTTL = 10 * time.Minute
+
+	// oauthBrowserCookie holds a secret whose hash is stored with each state
+	// the browser starts, so a state only completes in the browser that
+	// started it.
+	oauthBrowserCookie = "oauth_browser"
+)
+
+var (
+	errInvalidState       = errors.New("invalid OAuth state")
+	errRedirectNotAllowed = errors.New("redirect target not allowed")
+)
+
+// oauthState is stored under a state while the browser is at the provider.
+// LinkUserID is set when a signed-in user links another account rather than
+// signing in.
+type oauthState struct {
+	Provider string `json:"provider"`
+	auth.AuthRequest
+	BrowserHash string `json:"browserHash"`
+	LinkUserID  int64  `json:"linkUserId,omitempty"`
+	RedirectTo  string `json:"redirectTo,omitempty"`
+}
+
+// startOAuth stores a new state for provider and returns the provider's
+// auth URL for it.
+func (h *AuthHandlers) startOAuth(w http.ResponseWriter, r *http.Request, provider auth.Provider, linkUserID int64, redirectTo string) (string, error) {
+	authReq, err := auth.NewAuthRequest()
+	if err != nil {
+		return "", err
+	}
+	browser := h.browserBinding(w, r)
+
+	value, err := json.Marshal(oauthState{
+		Provider:    provider.Name(),
+		AuthRequest: *authReq,
+		BrowserHash: auth.HashToken(browser),
+		LinkUserID:  linkUserID,
+		RedirectTo:  redirectTo,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	state := generateState(32)
+	if err := h.redis.SetState(r.Context(), state, string(value), oauthStateTTL); err != nil {
+		return "", err
+	}
+	return provider.GetAuthURL(state, authReq), nil
+}
+
+// consumeOAuthState takes the state of a callback, which then cannot be used
+// again. It returns errInvalidState unless the state was issued for provider
+// and linkUserID (0 when signing in) to the browser making r.
+func (h *AuthHandlers) consumeOAuthState(r *http.Request, state string, provider auth.Provider, linkUserID int64) (*oauthState, error) {
+	value, err := h.redis.ConsumeState(r.Context(), state)
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, errInvalidState
+	}
+
+	var stored oauthState
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return nil, errInvalidState
+	}
+	if stored.Provider != provider.Name() || stored.LinkUserID != linkUserID {
+		return nil, errInvalidState
+	}
+	cookie, err := r.Cookie(oauthBrowserCookie)
+	if err != nil || auth.HashToken(cookie.Value) != stored.BrowserHash {
+		return nil, errInvalidState
+	}
+	return &stored, nil
+}
+
+// browserBinding returns the browser's binding secret, setting the cookie
+// if the browser has none yet.
+func (h *AuthHandlers) browserBinding(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(oauthBrowserCookie); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value
+	}
+
+	secret := generateState(32)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthBrowserCookie,
+		Value:    secret,
+		Path:     "/v1/",
+		MaxAge:   int(oauthStateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.config.AppBaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return secret
+}
+
+// checkRedirect validates where the frontend may send the user after
+// signing in: a path on the app itself, or a URL under one of
+// OAUTH_REDIRECT_ALLOWLIST.
+func (h *AuthHandlers) checkRedirect(target string) error {
+	if target == "" {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return errRedirectNotAllowed
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		// Reject "//host" and "/\host", which browsers treat as another host.
+		if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+			return errRedirectNotAllowed
+		}
+		return nil
+	}
+
+	for _, entry := range h.config.OAuthRedirectAllowlist {
+		allowed, err := url.Parse(entry)
+		if err != nil {
+			continue
+		}
+		prefix := strings.TrimSuffix(allowed.Path, "/")
+		if u.Scheme == allowed.Scheme && strings.EqualFold(u.Host, allowed.Host) && u.User == nil &&
+			(u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/")) {
+			return nil
+		}
+	}
+	return errRedirectNotAllowed
+}
@@ -0,0 +1,966 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/auth"
+	"github.com/gemini-hackathon/app/internal/authz"
+	"github.com/gemini-hackathon/app/internal/knowledge"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+)
+
+// insightsTopTerms is how many of the most annotated terms insights list.
+const insightsTopTerms = 20
+
+// invitationExpiry is how long an invitation can be accepted.
+const invitationExpiry = 7 * 24 * time.Hour
+
+// OrgHandlers serve organizations: their members, shared glossary and the
+// mentors' view of what the team annotates. Users who are not members get
+// 404 for everything under an organization.
+type OrgHandlers struct {
+	db storage.DB
+}
+
+func NewOrgHandlers(db storage.DB) *OrgHandlers {
+	return &OrgHandlers{db: db}
+}
+
+type CreateOrgRequest struct {
+	Name string `json:"name"`
+}
+
+// OrgResponse is an organization as seen by one of its members, with that
+// member's role and sharing choice.
+type OrgResponse struct {
+	ID               int64  `json:"id"`
+	Name             string `json:"name"`
+	Role             string `json:"role"`
+	ShareAnnotations bool   `json:"shareAnnotations"`
+	JoinedAt         string `json:"joinedAt"`
+}
+
+type OrgsResponse struct {
+	Data []OrgResponse `json:"data"`
+}
+
+type InviteOrgMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// InvitationResponse is an invitation as seen by the organization's owners,
+// who know the email, or by the invitee, who needs the organization's name.
+type InvitationResponse struct {
+	ID        int64  `json:"id"`
+	OrgID     int64  `json:"orgId"`
+	OrgName   string `json:"orgName"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+type InvitationsResponse struct {
+	Data []InvitationResponse `json:"data"`
+}
+
+// UpdateOrgMemberRequest changes the fields that are set. Only owners change
+// roles, and only members themselves choose whether to share annotations.
+type UpdateOrgMemberRequest struct {
+	Role             *string `json:"role"`
+	ShareAnnotations *bool   `json:"shareAnnotations"`
+}
+
+type OrgMemberResponse struct {
+	UserID           int64  `json:"userId"`
+	Email            string `json:"email"`
+	Role             string `json:"role"`
+	ShareAnnotations bool   `json:"shareAnnotations"`
+	JoinedAt         string `json:"joinedAt"`
+}
+
+type OrgMembersResponse struct {
+	Data []OrgMemberResponse `json:"data"`
+}
+
+type GlossaryEntryRequest struct {
+	Term        string `json:"term"`
+	Reading     string `json:"reading"`
+	Meaning     string `json:"meaning"`
+	Romaji      string `json:"romaji"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+}
+
+type GlossaryEntryResponse struct {
+	ID          int64  `json:"id"`
+	Term        string `json:"term"`
+	Reading     string `json:"reading"`
+	Meaning     string `json:"meaning"`
+	Romaji      string `json:"romaji"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+	CreatedAt   string `json:"createdAt"`
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+type GlossaryEntriesResponse struct {
+	Data []GlossaryEntryResponse `json:"data"`
+}
+
+type TermStatResponse struct {
+	Term        string `json:"term"`
+	Reading     string `json:"reading,omitempty"`
+	JLPTLevel   string `json:"jlptLevel,omitempty"`
+	Annotations int    `json:"annotations"`
+	Members     int    `json:"members"`
+}
+
+// OrgInsightsResponse aggregates the annotations made in the last Days days
+// by the SharingMembers who opted in. Nothing identifies a member.
+type OrgInsightsResponse struct {
+	Days                int                `json:"days"`
+	Since               string             `json:"since"`
+	Members             int                `json:"members"`
+	SharingMembers      int                `json:"sharingMembers"`
+	Annotations         int                `json:"annotations"`
+	TopTerms            []TermStatResponse `json:"topTerms"`
+	JLPTLevels          map[string]int     `json:"jlptLevels"`
+	PolitenessRegisters map[string]int     `json:"politenessRegisters"`
+	PartsOfSpeech       map[string]int     `json:"partsOfSpeech"`
+}
+
+// OrgsAPI lists the organizations the user belongs to (GET) or creates one
+// with the user as its owner (POST).
+func (h *OrgHandlers) OrgsAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := middleware.GetUserID(ctx)
+	if userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		memberships, err := h.db.GetUserOrgMemberships(ctx, userID)
+		if err != nil {
+			h.logger(r).ErrorWithErr(err, "Failed to get organizations")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		data := make([]OrgResponse, len(memberships))
+		for i, member := range memberships {
+			data[i] = toOrgResponse(member)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(OrgsResponse{Data: data})
+
+	case http.MethodPost:
+		var req CreateOrgRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > 255 {
+			http.Error(w, "name must be 1 to 255 characters", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		org := &models.Organization{Name: req.Name, CreatedBy: &userID, CreatedAt: now}
+		if _, err := h.db.CreateOrganization(ctx, org, userID); err != nil {
+			h.logger(r).ErrorWithErr(err, "Failed to create organization")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger(r).Infof("Created organization %d", org.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(OrgResponse{
+			ID:       org.ID,
+			Name:     org.Name,
+			Role:     models.OrgRoleOwner,
+			JoinedAt: now.Format(time.RFC3339),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// OrgAPI routes /v1/orgs/{id}, its /members and /members/{userId|me}, its
+// /invitations and /invitations/{invitationId}, its /glossary and
+// /glossary/{entryId}, and its /insights.
+func (h *OrgHandlers) OrgAPI(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/orgs/"), "/")
+	orgID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	member, ok := h.member(w, r, orgID)
+	if !ok {
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toOrgResponse(member))
+	case parts[1] == "members" && len(parts) == 2:
+		h.members(w, r, member)
+	case parts[1] == "members":
+		h.memberAPI(w, r, member, parts[2])
+	case parts[1] == "invitations" && len(parts) == 2:
+		h.invitations(w, r, member)
+	case parts[1] == "invitations":
+		invitationID, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.orgInvitation(w, r, member, invitationID)
+	case parts[1] == "glossary" && len(parts) == 2:
+		h.glossary(w, r, member)
+	case parts[1] == "glossary":
+		entryID, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.glossaryEntry(w, r, member, entryID)
+	case parts[1] == "insights" && len(parts) == 2:
+		h.insights(w, r, member)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// members lists the organization's members. Users join by accepting an
+// invitation; see invitations.
+func (h *OrgHandlers) members(w http.ResponseWriter, r *http.Request, member *models.OrgMember) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	members, err := h.db.GetOrgMembers(r.Context(), member.OrgID)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get organization members")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	data := make([]OrgMemberResponse, len(members))
+	for i, m := range members {
+		data[i] = toOrgMemberResponse(m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OrgMembersResponse{Data: data})
+}
+
+// invitations lets owners list the pending invitations (GET) or invite an
+// email (POST). The response to an invitation is the same whether or not
+// anyone has signed up with the email, so it cannot be used to look up
+// accounts.
+func (h *OrgHandlers) invitations(w http.ResponseWriter, r *http.Request, member *models.OrgMember) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireRole(w, member, models.OrgRoleOwner) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		invitations, err := h.db.GetOrgInvitations(ctx, member.OrgID)
+		if err != nil {
+			h.logger(r).ErrorWithErr(err, "Failed to get organization invitations")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		h.writeInvitations(w, invitations)
+		return
+	}
+
+	var req InviteOrgMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	email, err := auth.NormalizeEmail(req.Email)
+	if err != nil || len(email) > 255 {
+		http.Error(w, "Invalid email", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.OrgRoleMember
+	}
+	if !models.IsValidOrgRole(req.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	invitation := &models.OrgInvitation{
+		OrgID:     member.OrgID,
+		OrgName:   member.OrgName,
+		Email:     email,
+		Role:      req.Role,
+		InvitedBy: &member.UserID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(invitationExpiry),
+	}
+	if _, err := h.db.CreateOrgInvitation(ctx, invitation); err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to create organization invitation")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger(r).Infof("Invited an email to organization %d as %s", member.OrgID, req.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toInvitationResponse(invitation))
+}
+
+// orgInvitation lets owners withdraw (DELETE) a pending invitation.
+func (h *OrgHandlers) orgInvitation(w http.ResponseWriter, r *http.Request, member *models.OrgMember, invitationID int64) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireRole(w, member, models.OrgRoleOwner) {
+		return
+	}
+
+	invitation, err := h.db.GetOrgInvitation(r.Context(), invitationID)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get organization invitation")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if invitation == nil || invitation.OrgID != member.OrgID {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err := h.db.DeleteOrgInvitation(r.Context(), invitation.ID); err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to delete organization invitation")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InvitationsAPI lists the pending invitations to the user's verified
+// emails.
+func (h *OrgHandlers) InvitationsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	user, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+	emails, ok := h.verifiedEmails(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	invitations := []*models.OrgInvitation{}
+	for _, email := range emails {
+		forEmail, err := h.db.GetInvitationsForEmail(r.Context(), email)
+		if err != nil {
+			h.logger(r).ErrorWithErr(err, "Failed to get invitations")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		invitations = append(invitations, forEmail...)
+	}
+	h.writeInvitations(w, invitations)
+}
+
+// InvitationAPI routes /v1/invitations/{id}/accept (POST), which makes the
+// user a member, and /v1/invitations/{id} (DELETE), which declines. Only a
+// user with a verified identity for the invited email can do either; to
+// anyone else the invitation does not exist.
+func (h *OrgHandlers) InvitationAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/invitations/"), "/")
+	invitationID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "accept") {
+		http.NotFound(w, r)
+		return
+	}
+	accept := len(parts) == 2
+	if (accept && r.Method != http.MethodPost) || (!accept && r.Method != http.MethodDelete) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+	emails, ok := h.verifiedEmails(w, r, user.ID)
+	if !ok {
+		return
+	}
+	invitation, err := h.db.GetOrgInvitation(ctx, invitationID)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get invitation")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if invitation == nil || !slices.Contains(emails, invitation.Email) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+
+	if !accept {
+		if err := h.db.DeleteOrgInvitation(ctx, invitation.ID); err != nil {
+			h.logger(r).ErrorWithErr(err, "Failed to decline invitation")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	err = h.db.AcceptOrgInvitation(ctx, invitation.ID, user.ID)
+	if errors.Is(err, storage.ErrInvitationNotFound) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, storage.ErrAlreadyMember) {
+		if err := h.db.DeleteOrgInvitation(ctx, invitation.ID); err != nil {
+			h.logger(r).ErrorWithErr(err, "Failed to delete invitation")
+		}
+		http.Error(w, "Already a member", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to accept invitation")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	member, err := h.db.GetOrgMember(ctx, invitation.OrgID, user.ID)
+	if err != nil || member == nil {
+		h.logger(r).ErrorWithErr(err, "Failed to reload organization member")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger(r).Infof("Joined organization %d as %s", member.OrgID, member.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toOrgResponse(member))
+}
+
+// memberAPI changes (PATCH) or removes (DELETE) the member with userID, or
+// the requesting user for "me". Owners change roles and remove anyone;
+// members choose whether to share their annotations and may leave. The last
+// owner can neither leave nor be demoted.
+func (h *OrgHandlers) memberAPI(w http.ResponseWriter, r *http.Request, member *models.OrgMember, userID string) {
+	ctx := r.Context()
+
+	target := member
+	if userID != "me" {
+		id, err := strconv.ParseInt(userID, 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if id != member.UserID {
+			var ok bool
+			if target, ok = h.targetMember(w, r, member.OrgID, id); !ok {
+				return
+			}
+		}
+	}
+	self := target.UserID == member.UserID
+
+	switch r.Method {
+	case http.MethodPatch:
+		var req UpdateOrgMemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.ShareAnnotations != nil && !self {
+			http.Error(w, "Only members choose whether to share their annotations", http.StatusForbidden)
+			return
+		}
+		if req.Role != nil {
+			if !h.requireRole(w, member, models.OrgRoleOwner) {
+				return
+			}
+			if !models.IsValidOrgRole(*req.Role) {
+				http.Error(w, "Invalid role", http.StatusBadRequest)
+				return
+			}
+			if *req.Role != models.OrgRoleOwner && !h.keepsOwner(w, r, target) {
+				return
+			}
+		}
+
+		if req.Role != nil && *req.Role != target.Role {
+			if err := h.db.UpdateOrgMemberRole(ctx, target.OrgID, target.UserID, *req.Role); err != nil {
+				h.logger(r).ErrorWithErr(err, "Failed to update organization member role")
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if req.ShareAnnotations != nil && *req.ShareAnnotations != target.ShareAnnotations {
+			if err := h.db.SetOrgMemberSharing(ctx, target.OrgID, target.UserID, *req.ShareAnnotations); err != nil {
+				h.logger(r).ErrorWithErr(err, "Failed to update annotation sharing")
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		updated, err := h.db.GetOrgMember(ctx, target.OrgID, target.UserID)
+		if err != nil || updated == nil {
+			h.logger(r).ErrorWithErr(err, "Failed to reload organization member")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toOrgMemberResponse(updated))
+
+	case http.MethodDelete:
+		if !self && !h.requireRole(w, member, models.OrgRoleOwner) {
+			return
+		}
+		if !h.keepsOwner(w, r, target) {
+			return
+		}
+		if err := h.db.RemoveOrgMember(ctx, target.OrgID, target.UserID); err != nil {
+			h.logger(r).ErrorWithErr(err, "Failed to remove organization member")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger(r).Infof("Removed user %d from organization %d", target.UserID, target.OrgID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// glossary lists the organization's glossary (GET) or lets an owner or
+// mentor add a term to it (POST).
+func (h *OrgHandlers) glossary(w http.ResponseWriter, r *http.Request, member *models.OrgMember) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := h.db.GetGlossaryEntries(ctx, member.OrgID)
+		if err != nil {
+			h.logger(r).ErrorWithErr(err, "Failed to get glossary")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		data := make([]GlossaryEntryResponse, len(entries))
+		for i, entry := range entries {
+			data[i] = toGlossaryEntryResponse(entry)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GlossaryEntriesResponse{Data: data})
+
+	case http.MethodPost:
+		if !h.requireRole(w, member, models.OrgRoleOwner, models.OrgRoleMentor) {
+			return
+		}
+
+		req, ok := decodeGlossaryEntry(w, r)
+		if !ok {
+			return
+		}
+
+		now := time.Now()
+		entry := &models.GlossaryEntry{OrgID: member.OrgID, CreatedBy: &member.UserID, CreatedAt: now}
+		applyGlossaryEntry(entry, req, now)
+		if _, err := h.db.CreateGlossaryEntry(ctx, entry); err != nil {
+			h.glossaryError(w, r, err, "Failed to create glossary entry")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(toGlossaryEntryResponse(entry))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// glossaryEntry lets an owner or mentor replace (PUT) or remove (DELETE) a
+// glossary entry.
+func (h *OrgHandlers) glossaryEntry(w http.ResponseWriter, r *http.Request, member *models.OrgMember, entryID int64) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entry, err := h.db.GetGlossaryEntry(ctx, entryID)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get glossary entry")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if entry == nil || entry.OrgID != member.OrgID {
+		http.Error(w, "Glossary entry not found", http.StatusNotFound)
+		return
+	}
+	if !h.requireRole(w, member, models.OrgRoleOwner, models.OrgRoleMentor) {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := h.db.DeleteGlossaryEntry(ctx, entry.ID); err != nil {
+			h.logger(r).ErrorWithErr(err, "Failed to delete glossary entry")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	req, ok := decodeGlossaryEntry(w, r)
+	if !ok {
+		return
+	}
+	applyGlossaryEntry(entry, req, time.Now())
+	if err := h.db.UpdateGlossaryEntry(ctx, entry); err != nil {
+		h.glossaryError(w, r, err, "Failed to update glossary entry")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toGlossaryEntryResponse(entry))
+}
+
+// insights shows owners and mentors what the team annotated in the last days
+// days, 30 by default. Only the annotations of members who opted in count,
+// and only in aggregate.
+func (h *OrgHandlers) insights(w http.ResponseWriter, r *http.Request, member *models.OrgMember) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.requireRole(w, member, models.OrgRoleOwner, models.OrgRoleMentor) {
+		return
+	}
+
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 365 {
+			http.Error(w, "days must be between 1 and 365", http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	insights, err := h.db.GetOrgInsights(r.Context(), member.OrgID, since, insightsTopTerms)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get organization insights")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	topTerms := make([]TermStatResponse, len(insights.TopTerms))
+	for i, stat := range insights.TopTerms {
+		topTerms[i] = TermStatResponse{
+			Term:        stat.Term,
+			Reading:     stat.Reading,
+			JLPTLevel:   stat.JLPTLevel,
+			Annotations: stat.Annotations,
+			Members:     stat.Members,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(OrgInsightsResponse{
+		Days:                days,
+		Since:               since.Format(time.RFC3339),
+		Members:             insights.Members,
+		SharingMembers:      insights.SharingMembers,
+		Annotations:         insights.Annotations,
+		TopTerms:            topTerms,
+		JLPTLevels:          insights.JLPTLevels,
+		PolitenessRegisters: insights.PolitenessRegisters,
+		PartsOfSpeech:       insights.PartsOfSpeech,
+	})
+}
+
+// member returns the requesting user's membership of orgID. Otherwise it
+// responds with an error and returns false.
+func (h *OrgHandlers) member(w http.ResponseWriter, r *http.Request, orgID int64) (*models.OrgMember, bool) {
+	member, err := authz.OrgMember(r.Context(), h.db, middleware.GetUserID(r.Context()), orgID)
+	if errors.Is(err, authz.ErrNotFound) {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get organization membership")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return member, true
+}
+
+// user returns the requesting user. Otherwise it responds with an error and
+// returns false.
+func (h *OrgHandlers) user(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID := middleware.GetUserID(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	return user, true
+}
+
+// verifiedEmails returns the emails of the user's identities whose provider
+// verified them, the only ones invitations are matched against. The email on
+// the user itself may be unverified.
+func (h *OrgHandlers) verifiedEmails(w http.ResponseWriter, r *http.Request, userID int64) ([]string, bool) {
+	identities, err := h.db.GetUserIdentities(r.Context(), userID)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get identities")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	var emails []string
+	for _, identity := range identities {
+		email := strings.ToLower(identity.Email)
+		if identity.EmailVerified && email != "" && !slices.Contains(emails, email) {
+			emails = append(emails, email)
+		}
+	}
+	return emails, true
+}
+
+func (h *OrgHandlers) writeInvitations(w http.ResponseWriter, invitations []*models.OrgInvitation) {
+	data := make([]InvitationResponse, len(invitations))
+	for i, invitation := range invitations {
+		data[i] = toInvitationResponse(invitation)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(InvitationsResponse{Data: data})
+}
+
+// targetMember returns the membership of userID in orgID. Otherwise it
+// responds with an error and returns false.
+func (h *OrgHandlers) targetMember(w http.ResponseWriter, r *http.Request, orgID, userID int64) (*models.OrgMember, bool) {
+	target, err := h.db.GetOrgMember(r.Context(), orgID, userID)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get organization member")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if target == nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return nil, false
+	}
+	return target, true
+}
+
+// requireRole reports whether member has one of roles, responding 403 if
+// not.
+func (h *OrgHandlers) requireRole(w http.ResponseWriter, member *models.OrgMember, roles ...string) bool {
+	if slices.Contains(roles, member.Role) {
+		return true
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+// keepsOwner reports whether the organization still has an owner without
+// target, responding 409 if not.
+func (h *OrgHandlers) keepsOwner(w http.ResponseWriter, r *http.Request, target *models.OrgMember) bool {
+	if target.Role != models.OrgRoleOwner {
+		return true
+	}
+	members, err := h.db.GetOrgMembers(r.Context(), target.OrgID)
+	if err != nil {
+		h.logger(r).ErrorWithErr(err, "Failed to get organization members")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	for _, m := range members {
+		if m.Role == models.OrgRoleOwner && m.UserID != target.UserID {
+			return true
+		}
+	}
+	http.Error(w, "An organization needs an owner", http.StatusConflict)
+	return false
+}
+
+func (h *OrgHandlers) glossaryError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if errors.Is(err, storage.ErrGlossaryTermExists) {
+		http.Error(w, "The glossary already has this term", http.StatusConflict)
+		return
+	}
+	h.logger(r).ErrorWithErr(err, msg)
+	http.Error(w, "Database error", http.StatusInternalServerError)
+}
+
+func (h *OrgHandlers) logger(r *http.Request) *logger.Logger {
+	return logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(middleware.GetUserID(r.Context()))
+}
+
+// userKnowledge returns base with the glossaries of the user's organizations
+// in front, so their definitions win. If the glossaries cannot be loaded the
+// lookup goes on with base alone.
+func userKnowledge(ctx context.Context, db storage.DB, base knowledge.Service, userID int64) knowledge.Service {
+	glossary, err := db.GetGlossaryEntriesForUser(ctx, userID)
+	if err != nil {
+		logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(ctx)).WithUserID(userID).ErrorWithErr(err, "Failed to get organization glossaries")
+		return base
+	}
+	if len(glossary) == 0 {
+		return base
+	}
+
+	entries := make([]knowledge.Entry, len(glossary))
+	for i, entry := range glossary {
+		entries[i] = knowledge.Entry{
+			Kosakata:  entry.Term,
+			Kana:      entry.Reading,
+			Arti:      entry.Meaning,
+			CaraBaca:  entry.Romaji,
+			Deskripsi: entry.Description,
+			Konteks:   entry.Context,
+		}
+	}
+	return knowledge.Merge(knowledge.NewEntriesService(entries), base)
+}
+
+func decodeGlossaryEntry(w http.ResponseWriter, r *http.Request) (GlossaryEntryRequest, bool) {
+	var req GlossaryEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	req.Term = strings.TrimSpace(req.Term)
+	req.Meaning = strings.TrimSpace(req.Meaning)
+	if req.Term == "" || req.Meaning == "" {
+		http.Error(w, "term and meaning are required", http.StatusBadRequest)
+		return req, false
+	}
+	if len(req.Term) > 255 || len(req.Reading) > 255 || len(req.Romaji) > 255 {
+		http.Error(w, "term, reading and romaji must be at most 255 characters", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func applyGlossaryEntry(entry *models.GlossaryEntry, req GlossaryEntryRequest, now time.Time) {
+	entry.Term = req.Term
+	entry.Reading = strings.TrimSpace(req.Reading)
+	entry.Meaning = req.Meaning
+	entry.Romaji = strings.TrimSpace(req.Romaji)
+	entry.Description = strings.TrimSpace(req.Description)
+	entry.Context = strings.TrimSpace(req.Context)
+	entry.UpdatedAt = now
+}
+
+func toOrgResponse(member *models.OrgMember) OrgResponse {
+	return OrgResponse{
+		ID:               member.OrgID,
+		Name:             member.OrgName,
+		Role:             member.Role,
+		ShareAnnotations: member.ShareAnnotations,
+		JoinedAt:         member.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func toOrgMemberResponse(member *models.OrgMember) OrgMemberResponse {
+	return OrgMemberResponse{
+		UserID:           member.UserID,
+		Email:            member.Email,
+		Role:             member.Role,
+		ShareAnnotations: member.ShareAnnotations,
+		JoinedAt:         member.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func toInvitationResponse(invitation *models.OrgInvitation) InvitationResponse {
+	return InvitationResponse{
+		ID:        invitation.ID,
+		OrgID:     invitation.OrgID,
+		OrgName:   invitation.OrgName,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		CreatedAt: invitation.CreatedAt.Format(time.RFC3339),
+		ExpiresAt: invitation.ExpiresAt.Format(time.RFC3339),
+	}
+}
+
+func toGlossaryEntryResponse(entry *models.GlossaryEntry) GlossaryEntryResponse {
+	return GlossaryEntryResponse{
+		ID:          entry.ID,
+		Term:        entry.Term,
+		Reading:     entry.Reading,
+		Meaning:     entry.Meaning,
+		Romaji:      entry.Romaji,
+		Description: entry.Description,
+		Context:     entry.Context,
+		CreatedAt:   entry.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   entry.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -3,48 +3,112 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gemini-hackathon/app/internal/auth"
+	"github.com/gemini-hackathon/app/internal/authz"
 	"github.com/gemini-hackathon/app/internal/config"
+	"github.com/gemini-hackathon/app/internal/fetcher"
 	"github.com/gemini-hackathon/app/internal/gemini"
+	"github.com/gemini-hackathon/app/internal/imageproc"
+	"github.com/gemini-hackathon/app/internal/knowledge"
 	"github.com/gemini-hackathon/app/internal/logger"
 	"github.com/gemini-hackathon/app/internal/middleware"
 	"github.com/gemini-hackathon/app/internal/models"
 	"github.com/gemini-hackathon/app/internal/storage"
+	"github.com/gemini-hackathon/app/internal/thumbnail"
 )
 
 type ScanHandlers struct {
-	db           storage.DB
-	fileStorage  storage.FileStorage
-	geminiClient gemini.Client
-	config       *config.Config
+	db             storage.DB
+	fileStorage    storage.FileStorage
+	geminiClient   gemini.Client
+	knowledge      knowledge.Service
+	imageProcessor imageproc.Processor
+	thumbnails     *thumbnail.Generator
+	urlSigner      *auth.URLSigner
+	config         *config.Config
+	ocrSlots       chan struct{}
+	fetcher        *fetcher.Fetcher
 }
 
-func NewScanHandlers(db storage.DB, fileStorage storage.FileStorage, geminiClient gemini.Client, cfg *config.Config) *ScanHandlers {
+func NewScanHandlers(db storage.DB, fileStorage storage.FileStorage, geminiClient gemini.Client, knowledgeSvc knowledge.Service, imageProcessor imageproc.Processor, thumbnails *thumbnail.Generator, urlSigner *auth.URLSigner, cfg *config.Config) *ScanHandlers {
 	return &ScanHandlers{
-		db:           db,
-		fileStorage:  fileStorage,
-		geminiClient: geminiClient,
-		config:       cfg,
+		db:             db,
+		fileStorage:    fileStorage,
+		geminiClient:   geminiClient,
+		knowledge:      knowledgeSvc,
+		imageProcessor: imageProcessor,
+		thumbnails:     thumbnails,
+		urlSigner:      urlSigner,
+		config:         cfg,
+		ocrSlots:       make(chan struct{}, max(cfg.OCRConcurrency, 1)),
+		fetcher: fetcher.New(fetcher.Options{
+			Timeout:  time.Duration(cfg.URLFetchTimeout) * time.Second,
+			MaxBytes: cfg.MaxUploadSize,
+		}),
 	}
 }
 
+const pdfMIMEType = imageproc.MIMETypePDF
+
 type CreateScanResponse struct {
-	ScanID   int64  `json:"scanId"`
-	FullText string `json:"fullText,omitempty"`
-	ImageURL string `json:"imageUrl"`
+	ScanID       int64  `json:"scanId"`
+	FullText     string `json:"fullText,omitempty"`
+	ImageURL     string `json:"imageUrl"`
+	DocumentType string `json:"documentType"`
+	DocumentURL  string `json:"documentUrl,omitempty"`
+	PageCount    int    `json:"pageCount"`
+	SourceType   string `json:"sourceType"`
+}
+
+type BatchScanResponse struct {
+	Results  []BatchScanResult `json:"results"`
+	Created  int               `json:"created"`
+	Rejected int               `json:"rejected"`
+	Failed   int               `json:"failed"`
+}
+
+// BatchScanResult reports the outcome for one file of a batch upload, in the
+// order the files were sent.
+type BatchScanResult struct {
+	Index    int                 `json:"index"`
+	Filename string              `json:"filename"`
+	Status   string              `json:"status"`
+	Scan     *CreateScanResponse `json:"scan,omitempty"`
+	Error    *ErrorResponse      `json:"error,omitempty"`
+}
+
+const (
+	BatchStatusCreated  = "created"
+	BatchStatusRejected = "rejected"
+	BatchStatusFailed   = "failed"
+)
+
+// pageUpload is one uploaded page waiting to be stored and OCR'd.
+type pageUpload struct {
+	data     []byte
+	mimeType string
 }
 
 type ScanListItem struct {
-	ID               int64   `json:"id"`
-	ImageURL         string  `json:"imageUrl"`
-	DetectedLanguage *string `json:"detectedLanguage,omitempty"`
-	CreatedAt        string  `json:"createdAt"`
+	ID               int64             `json:"id"`
+	ImageURL         string            `json:"imageUrl"`
+	ThumbnailURL     string            `json:"thumbnailUrl,omitempty"`
+	Thumbnails       map[string]string `json:"thumbnails,omitempty"`
+	DocumentType     string            `json:"documentType"`
+	PageCount        int               `json:"pageCount"`
+	SourceType       string            `json:"sourceType"`
+	DetectedLanguage *string           `json:"detectedLanguage,omitempty"`
+	CreatedAt        string            `json:"createdAt"`
 }
 
 type GetScansResponse struct {
@@ -60,18 +124,57 @@ type PaginationMeta struct {
 }
 
 type GetScanResponse struct {
-	ID               int64   `json:"id"`
+	ID               int64          `json:"id"`
+	FullText         string         `json:"fullText,omitempty"`
+	ImageURL         string         `json:"imageUrl"`
+	DocumentType     string         `json:"documentType"`
+	DocumentURL      string         `json:"documentUrl,omitempty"`
+	PageCount        int            `json:"pageCount"`
+	SourceType       string         `json:"sourceType"`
+	SourceURL        *string        `json:"sourceUrl,omitempty"`
+	Pages            []ScanPageItem `json:"pages"`
+	DetectedLanguage *string        `json:"detectedLanguage,omitempty"`
+	CreatedAt        string         `json:"createdAt"`
+}
+
+type ScanPageItem struct {
+	PageNumber       int     `json:"pageNumber"`
+	ImageURL         string  `json:"imageUrl,omitempty"`
 	FullText         string  `json:"fullText,omitempty"`
-	ImageURL         string  `json:"imageUrl"`
 	DetectedLanguage *string `json:"detectedLanguage,omitempty"`
-	CreatedAt        string  `json:"createdAt"`
 }
 
 type ErrorResponse struct {
 	Error   string `json:"error"`
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message"`
 }
 
+// Error codes set in ErrorResponse.Code so clients can tell upload
+// rejections apart without parsing the message.
+const (
+	ErrCodeRequestTooLarge    = "request_too_large"
+	ErrCodeInvalidForm        = "invalid_form"
+	ErrCodeMissingFile        = "missing_file"
+	ErrCodeTooManyFiles       = "too_many_files"
+	ErrCodeFileTooLarge       = "file_too_large"
+	ErrCodeUnsupportedType    = "unsupported_type"
+	ErrCodeTypeMismatch       = "type_mismatch"
+	ErrCodePolyglotFile       = "polyglot_file"
+	ErrCodeDimensionsTooLarge = "image_dimensions_too_large"
+	ErrCodeCorruptFile        = "corrupt_file"
+	ErrCodePDFNotAlone        = "pdf_not_alone"
+	ErrCodeInvalidSource      = "invalid_source"
+	ErrCodeTextTooLong        = "text_too_long"
+	ErrCodeReprocessLimit     = "reprocess_limit"
+	ErrCodeInvalidURL         = "invalid_url"
+	ErrCodeForbiddenURL       = "forbidden_url"
+	ErrCodeFetchFailed        = "fetch_failed"
+)
+
+// CreateScanAPI creates a scan from one or more page images (sent as repeated
+// "image" fields, in page order) or from a single PDF. A JSON body instead
+// creates the scan from pasted text or an image URL; see createScanFromJSON.
 func (h *ScanHandlers) CreateScanAPI(w http.ResponseWriter, r *http.Request) {
 	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
 
@@ -88,87 +191,248 @@ func (h *ScanHandlers) CreateScanAPI(w http.ResponseWriter, r *http.Request) {
 
 	log = log.WithUserID(userID)
 
-	if err := r.ParseMultipartForm(h.config.MaxUploadSize); err != nil {
-		log.Warnf("Failed to parse multipart form: %v", err)
-		h.writeJSONError(w, http.StatusBadRequest, "Failed to parse form")
+	if isJSONRequest(r) {
+		h.createScanFromJSON(w, r, userID)
 		return
 	}
 
-	file, header, err := r.FormFile("image")
+	headers, ok := h.parseUploadForm(w, r, h.config.MaxPagesPerScan)
+	if !ok {
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	pages := make([]pageUpload, len(headers))
+	for i, header := range headers {
+		page, uploadErr := h.readUpload(r.Context(), header)
+		if uploadErr != nil {
+			h.writeJSONErrorCode(w, uploadErr.status, uploadErr.code, uploadErr.message)
+			return
+		}
+
+		if page.mimeType == pdfMIMEType && len(headers) > 1 {
+			log.Warn("PDF uploaded together with other files")
+			h.writeJSONErrorCode(w, http.StatusBadRequest, ErrCodePDFNotAlone, "A PDF must be uploaded on its own.")
+			return
+		}
+
+		pages[i] = page
+	}
+
+	response, err := h.createScan(r.Context(), userID, pages, models.SourceTypeUpload, nil)
 	if err != nil {
-		log.Warnf("Failed to get image from form: %v", err)
-		h.writeJSONError(w, http.StatusBadRequest, "Please select an image to upload")
+		log.ErrorWithErr(err, "Failed to create scan")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to save upload")
 		return
 	}
-	defer file.Close()
 
-	mimeType := header.Header.Get("Content-Type")
-	if !isValidImageType(mimeType) {
-		log.Warnf("Invalid image type received: %s", mimeType)
-		h.writeJSONError(w, http.StatusBadRequest, "Invalid image type. Please use JPEG, PNG, or WebP.")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateScanBatchAPI creates one scan per uploaded file. Files are validated
+// and stored independently, so a rejected file is reported in its result
+// without failing the rest of the batch.
+func (h *ScanHandlers) CreateScanBatchAPI(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	if header.Size > h.config.MaxUploadSize {
-		log.Warnf("Image size %d exceeds max size %d", header.Size, h.config.MaxUploadSize)
-		h.writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("File too large. Maximum size is %v MB.", h.config.MaxUploadSize/(1024*1024)))
+	userID := middleware.GetUserID(r.Context())
+	if userID == 0 {
+		h.writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	imageData, err := io.ReadAll(file)
-	if err != nil {
-		log.ErrorWithErr(err, "Failed to read uploaded file")
-		h.writeJSONError(w, http.StatusBadRequest, "Failed to read uploaded file")
+	log = log.WithUserID(userID)
+
+	headers, ok := h.parseUploadForm(w, r, h.config.MaxBatchFiles)
+	if !ok {
 		return
 	}
+	defer r.MultipartForm.RemoveAll()
+
+	response := BatchScanResponse{Results: make([]BatchScanResult, len(headers))}
+	for i, header := range headers {
+		result := BatchScanResult{Index: i, Filename: header.Filename}
+
+		page, uploadErr := h.readUpload(r.Context(), header)
+		if uploadErr != nil {
+			result.Status = BatchStatusRejected
+			result.Error = &ErrorResponse{
+				Error:   http.StatusText(uploadErr.status),
+				Code:    uploadErr.code,
+				Message: uploadErr.message,
+			}
+			response.Rejected++
+			response.Results[i] = result
+			continue
+		}
 
-	log.Infof("Received image upload: size=%d bytes, type=%s", len(imageData), mimeType)
+		scan, err := h.createScan(r.Context(), userID, []pageUpload{page}, models.SourceTypeUpload, nil)
+		if err != nil {
+			log.ErrorWithErr(err, fmt.Sprintf("Failed to create scan for batch file %d", i))
+			result.Status = BatchStatusFailed
+			result.Error = &ErrorResponse{
+				Error:   http.StatusText(http.StatusInternalServerError),
+				Message: "Failed to save upload",
+			}
+			response.Failed++
+			response.Results[i] = result
+			continue
+		}
 
-	now := time.Now()
-	scan := &models.Scan{
-		UserID:    userID,
-		ImageURL:  "",
-		CreatedAt: now,
+		result.Status = BatchStatusCreated
+		result.Scan = scan
+		response.Created++
+		response.Results[i] = result
+	}
+
+	log.Infof("Batch upload finished: files=%d, created=%d, rejected=%d, failed=%d",
+		len(headers), response.Created, response.Rejected, response.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseUploadForm caps the request body, parses the multipart form and
+// returns the "image" files, rejecting requests with none or more than
+// maxFiles. It writes the error response and returns false when the request
+// should stop.
+func (h *ScanHandlers) parseUploadForm(w http.ResponseWriter, r *http.Request, maxFiles int) ([]*multipart.FileHeader, bool) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.config.MaxRequestSize)
+	if err := r.ParseMultipartForm(h.config.MaxUploadSize); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Warnf("Upload request exceeds %d bytes", maxBytesErr.Limit)
+			h.writeJSONErrorCode(w, http.StatusRequestEntityTooLarge, ErrCodeRequestTooLarge, fmt.Sprintf("Upload too large. Maximum request size is %v MB.", h.config.MaxRequestSize/(1024*1024)))
+			return nil, false
+		}
+		log.Warnf("Failed to parse multipart form: %v", err)
+		h.writeJSONErrorCode(w, http.StatusBadRequest, ErrCodeInvalidForm, "Failed to parse form")
+		return nil, false
+	}
+
+	headers := r.MultipartForm.File["image"]
+	if len(headers) == 0 {
+		r.MultipartForm.RemoveAll()
+		log.Warn("No image in upload form")
+		h.writeJSONErrorCode(w, http.StatusBadRequest, ErrCodeMissingFile, "Please select an image to upload")
+		return nil, false
+	}
+
+	if len(headers) > maxFiles {
+		r.MultipartForm.RemoveAll()
+		log.Warnf("Upload has %d files, max is %d", len(headers), maxFiles)
+		h.writeJSONErrorCode(w, http.StatusBadRequest, ErrCodeTooManyFiles, fmt.Sprintf("Too many files. Maximum is %d per request.", maxFiles))
+		return nil, false
+	}
+
+	return headers, true
+}
+
+// readUpload reads one uploaded file and validates its real format. The
+// client's Content-Type is only a claim; the file contents decide.
+func (h *ScanHandlers) readUpload(ctx context.Context, header *multipart.FileHeader) (pageUpload, *uploadError) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(ctx))
+
+	if header.Size > h.config.MaxUploadSize {
+		log.Warnf("Image size %d exceeds max size %d", header.Size, h.config.MaxUploadSize)
+		return pageUpload{}, &uploadError{http.StatusRequestEntityTooLarge, ErrCodeFileTooLarge, fmt.Sprintf("File too large. Maximum size is %v MB.", h.config.MaxUploadSize/(1024*1024))}
 	}
 
-	scanID, err := h.db.CreateScan(r.Context(), scan)
+	data, err := readMultipartFile(header)
 	if err != nil {
-		log.ErrorWithErr(err, "Failed to create scan in database")
-		h.writeJSONError(w, http.StatusInternalServerError, "Failed to initialize scan")
-		return
+		log.ErrorWithErr(err, "Failed to read uploaded file")
+		return pageUpload{}, &uploadError{http.StatusBadRequest, ErrCodeInvalidForm, "Failed to read uploaded file"}
 	}
 
-	storagePath, _, err := h.fileStorage.SaveImage(strconv.FormatInt(scanID, 10), imageData, mimeType)
+	limits := imageproc.Limits{MaxSide: h.config.ImageMaxSide, MaxPixels: h.config.ImageMaxPixels}
+	mimeType, err := imageproc.Validate(data, header.Header.Get("Content-Type"), limits)
 	if err != nil {
-		log.ErrorWithErr(err, "Failed to save image to storage")
-		h.writeJSONError(w, http.StatusInternalServerError, "Failed to save uploaded image")
-		return
+		log.Warnf("Rejected upload %q: %v", header.Filename, err)
+		return pageUpload{}, uploadRejection(err)
 	}
 
-	imageURL := fmt.Sprintf("/uploads/%s", storagePath)
+	return pageUpload{data: data, mimeType: mimeType}, nil
+}
 
-	err = h.db.UpdateScanOCR(r.Context(), scanID, "", "")
+// createScan stores validated uploads as a new scan and starts OCR in the
+// background. pages is either a single PDF or one image per page. sourceURL
+// is set for files fetched from a URL.
+func (h *ScanHandlers) createScan(ctx context.Context, userID int64, pages []pageUpload, sourceType string, sourceURL *string) (*CreateScanResponse, error) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(ctx)).WithUserID(userID)
+
+	documentType := models.DocumentTypeImage
+	pageCount := len(pages)
+	if pages[0].mimeType == pdfMIMEType {
+		// The page count of a PDF is only known once the model has read it.
+		documentType = models.DocumentTypePDF
+		pageCount = 0
+	}
+
+	log.Infof("Received upload: type=%s, files=%d, source=%s", documentType, len(pages), sourceType)
+
+	scan := &models.Scan{
+		UserID:       userID,
+		ImageURL:     "",
+		DocumentType: documentType,
+		PageCount:    pageCount,
+		SourceType:   sourceType,
+		SourceURL:    sourceURL,
+		CreatedAt:    time.Now(),
+	}
+
+	scanID, err := h.db.CreateScan(ctx, scan)
 	if err != nil {
-		log.ErrorWithErr(err, "Failed to update scan with image URL")
+		return nil, fmt.Errorf("failed to create scan in database: %w", err)
 	}
 
-	log.WithFields(map[string]any{
-		"scan_id":   scanID,
-		"user_id":   userID,
-		"image_url": imageURL,
-	}).Infof("Scan created successfully, starting OCR processing")
+	response := &CreateScanResponse{
+		ScanID:       scanID,
+		FullText:     "",
+		DocumentType: documentType,
+		PageCount:    pageCount,
+		SourceType:   sourceType,
+	}
+
+	if documentType == models.DocumentTypePDF {
+		documentPath, _, err := h.fileStorage.SaveImage(strconv.FormatInt(scanID, 10), pages[0].data, pdfMIMEType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save document to storage: %w", err)
+		}
+		if err := h.db.UpdateScanDocument(ctx, scanID, documentPath); err != nil {
+			return nil, fmt.Errorf("failed to record document: %w", err)
+		}
 
-	go h.processOCR(context.Background(), scanID, imageData, mimeType, storagePath)
+		response.DocumentURL = h.signedDocumentURL(scanID, userID)
+		go h.processDocumentOCR(context.Background(), scanID, pages[0].data, ocrRun{source: models.OCRSourcePipeline})
+	} else {
+		processed := make([]pageUpload, len(pages))
+		for i, page := range pages {
+			processedData, processedMIME, err := h.storePageImages(ctx, scanID, i+1, page.data, page.mimeType)
+			if err != nil {
+				return nil, fmt.Errorf("failed to save image to storage: %w", err)
+			}
+			processed[i] = pageUpload{data: processedData, mimeType: processedMIME}
+		}
 
-	response := CreateScanResponse{
-		ScanID:   scanID,
-		FullText: "",
-		ImageURL: imageURL,
+		response.ImageURL = h.signedImageURL(scanID, userID)
+		go h.processPagesOCR(context.Background(), scanID, processed, ocrRun{source: models.OCRSourcePipeline})
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+	log.WithFields(map[string]any{
+		"scan_id":    scanID,
+		"page_count": pageCount,
+	}).Infof("Scan created successfully, queued for OCR processing")
+
+	return response, nil
 }
 
 func (h *ScanHandlers) GetScansAPI(w http.ResponseWriter, r *http.Request) {
@@ -207,14 +471,30 @@ func (h *ScanHandlers) GetScansAPI(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("Retrieved %d scans for user (page=%d, size=%d)", len(scans), page, size)
 
+	scanIDs := make([]int64, len(scans))
+	for i, scan := range scans {
+		scanIDs[i] = scan.ID
+	}
+
+	thumbnails, err := h.db.GetScanThumbnails(r.Context(), scanIDs)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan thumbnails from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get scans")
+		return
+	}
+
 	data := make([]ScanListItem, len(scans))
 	for i, scan := range scans {
 		data[i] = ScanListItem{
 			ID:               scan.ID,
-			ImageURL:         scan.ImageURL,
+			ImageURL:         h.scanImageURL(scan),
+			DocumentType:     scan.DocumentType,
+			PageCount:        scan.PageCount,
+			SourceType:       scan.SourceType,
 			DetectedLanguage: scan.DetectedLanguage,
 			CreatedAt:        scan.CreatedAt.Format(time.RFC3339),
 		}
+		h.setThumbnailURLs(&data[i], scan, thumbnails[scan.ID])
 	}
 
 	var nextPage, prevPage *int
@@ -242,49 +522,50 @@ func (h *ScanHandlers) GetScansAPI(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *ScanHandlers) GetScanAPI(w http.ResponseWriter, r *http.Request) {
-	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
-
 	if r.Method != http.MethodGet {
 		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	userID := middleware.GetUserID(r.Context())
-	if userID == 0 {
-		h.writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
 		return
 	}
 
-	log = log.WithUserID(userID)
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(scan.UserID).WithField("scan_id", scan.ID)
 
-	path := strings.TrimPrefix(r.URL.Path, "/v1/scans/")
-	scanIDStr := strings.TrimSuffix(path, "/")
-	scanID, err := strconv.ParseInt(scanIDStr, 10, 64)
+	response, err := h.scanResponse(r.Context(), scan)
 	if err != nil {
-		log.Warnf("Invalid scan ID format: %s", scanIDStr)
-		h.writeJSONError(w, http.StatusBadRequest, "Invalid scan ID")
+		log.ErrorWithErr(err, "Failed to get scan pages from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get scan")
 		return
 	}
 
-	log = log.WithField("scan_id", scanID)
+	log.Infof("Successfully retrieved scan: id=%d, pages=%d, has_ocr=%v", scan.ID, len(response.Pages), response.FullText != "")
 
-	scan, err := h.db.GetScanByID(r.Context(), scanID)
-	if err != nil {
-		log.ErrorWithErr(err, "Failed to get scan by ID from database")
-		h.writeJSONError(w, http.StatusNotFound, "Scan not found")
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	if scan == nil {
-		log.Warn("Scan not found")
-		h.writeJSONError(w, http.StatusNotFound, "Scan not found")
-		return
+// scanResponse builds the GetScanResponse for scan, including its pages.
+func (h *ScanHandlers) scanResponse(ctx context.Context, scan *models.Scan) (*GetScanResponse, error) {
+	pages, err := h.db.GetScanPages(ctx, scan.ID)
+	if err != nil {
+		return nil, err
 	}
 
-	if scan.UserID != userID {
-		log.Warn("User attempted to access scan belonging to another user")
-		h.writeJSONError(w, http.StatusForbidden, "Access denied")
-		return
+	pageItems := make([]ScanPageItem, len(pages))
+	for i, page := range pages {
+		pageItems[i] = ScanPageItem{
+			PageNumber:       page.PageNumber,
+			DetectedLanguage: page.DetectedLanguage,
+		}
+		if page.ImageURL != "" {
+			pageItems[i].ImageURL = h.signedPageImageURL(scan.ID, scan.UserID, page.PageNumber)
+		}
+		if page.OCRText != nil {
+			pageItems[i].FullText = *page.OCRText
+		}
 	}
 
 	fullText := ""
@@ -292,60 +573,365 @@ func (h *ScanHandlers) GetScanAPI(w http.ResponseWriter, r *http.Request) {
 		fullText = *scan.FullOCRText
 	}
 
-	log.Infof("Successfully retrieved scan: id=%d, has_ocr=%v", scanID, fullText != "")
-
-	response := GetScanResponse{
+	response := &GetScanResponse{
 		ID:               scan.ID,
 		FullText:         fullText,
-		ImageURL:         scan.ImageURL,
+		ImageURL:         h.scanImageURL(scan),
+		DocumentType:     scan.DocumentType,
+		PageCount:        scan.PageCount,
+		SourceType:       scan.SourceType,
+		SourceURL:        scan.SourceURL,
+		Pages:            pageItems,
 		DetectedLanguage: scan.DetectedLanguage,
 		CreatedAt:        scan.CreatedAt.Format(time.RFC3339),
 	}
+	if scan.DocumentURL != nil {
+		response.DocumentURL = h.signedDocumentURL(scan.ID, scan.UserID)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	return response, nil
 }
 
-func (h *ScanHandlers) processOCR(ctx context.Context, scanID int64, imageData []byte, mimeType string, storagePath string) {
-	log := logger.GetDefaultLogger().WithField("scan_id", scanID)
+// GetScanImageAPI streams the scan image to its owner. Requests are
+// authenticated either by bearer token or by a URL from signedImageURL.
+func (h *ScanHandlers) GetScanImageAPI(w http.ResponseWriter, r *http.Request) {
+	scan, ok := h.scanForImage(w, r)
+	if !ok {
+		return
+	}
 
-	log.Infof("Starting OCR processing: image_size=%d bytes, mime_type=%s", len(imageData), mimeType)
-	ocrResp, err := h.geminiClient.OCR(ctx, imageData, mimeType)
+	if scan.ImageURL == "" {
+		h.writeJSONError(w, http.StatusNotFound, "Image not available")
+		return
+	}
+
+	h.serveImage(w, r, scan.ImageURL, scan.ImageHash, scan.CreatedAt)
+}
+
+// GetScanThumbnailAPI streams /v1/scans/{id}/thumbnails/{size}.
+func (h *ScanHandlers) GetScanThumbnailAPI(w http.ResponseWriter, r *http.Request) {
+	scan, ok := h.scanForImage(w, r)
+	if !ok {
+		return
+	}
+
+	_, action := splitScanPath(r.URL.Path)
+	size, err := strconv.Atoi(strings.TrimPrefix(action, "thumbnails/"))
 	if err != nil {
-		log.ErrorWithErr(err, "OCR processing failed")
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid thumbnail size")
 		return
 	}
-	log.Infof("OCR completed successfully: language=%s, text_length=%d", ocrResp.Language, len(ocrResp.RawText))
 
-	imageURL := fmt.Sprintf("/uploads/%s", storagePath)
+	thumbnails, err := h.db.GetScanThumbnails(r.Context(), []int64{scan.ID})
+	if err != nil {
+		logger.GetDefaultLogger().ErrorWithErr(err, "Failed to get scan thumbnails from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get thumbnail")
+		return
+	}
+
+	for _, thumb := range thumbnails[scan.ID] {
+		if thumb.Size == size {
+			h.serveImage(w, r, thumb.ImageURL, thumb.ImageHash, thumb.CreatedAt)
+			return
+		}
+	}
 
-	_ = imageURL
+	h.writeJSONError(w, http.StatusNotFound, "Thumbnail not available")
+}
 
-	if err := h.db.UpdateScanOCR(ctx, scanID, ocrResp.RawText, ocrResp.Language); err != nil {
-		log.ErrorWithErr(err, "Failed to update scan OCR in database")
+// GetScanPageImageAPI streams /v1/scans/{id}/pages/{n}/image.
+func (h *ScanHandlers) GetScanPageImageAPI(w http.ResponseWriter, r *http.Request) {
+	scan, ok := h.scanForImage(w, r)
+	if !ok {
 		return
 	}
 
-	log.Infof("OCR results saved to database successfully")
+	_, action := splitScanPath(r.URL.Path)
+	pageNumber, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(action, "pages/"), "/image"))
+	if err != nil {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid page number")
+		return
+	}
+
+	pages, err := h.db.GetScanPages(r.Context(), scan.ID)
+	if err != nil {
+		logger.GetDefaultLogger().ErrorWithErr(err, "Failed to get scan pages from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get page image")
+		return
+	}
+
+	for _, page := range pages {
+		if page.PageNumber == pageNumber && page.ImageURL != "" {
+			h.serveImage(w, r, page.ImageURL, page.ImageHash, page.CreatedAt)
+			return
+		}
+	}
+
+	h.writeJSONError(w, http.StatusNotFound, "Page not available")
+}
+
+// GetScanDocumentAPI streams the uploaded PDF of a document scan.
+func (h *ScanHandlers) GetScanDocumentAPI(w http.ResponseWriter, r *http.Request) {
+	scan, ok := h.scanForImage(w, r)
+	if !ok {
+		return
+	}
+
+	if scan.DocumentURL == nil {
+		h.writeJSONError(w, http.StatusNotFound, "Document not available")
+		return
+	}
+
+	h.serveImage(w, r, *scan.DocumentURL, nil, scan.CreatedAt)
+}
+
+// scanForImage loads the scan addressed by an image request and checks that
+// it belongs to the caller. It writes the error response and returns false
+// when the request should stop.
+func (h *ScanHandlers) scanForImage(w http.ResponseWriter, r *http.Request) (*models.Scan, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return nil, false
+	}
+
+	return h.ownedScan(w, r)
+}
+
+// ownedScan loads the scan addressed by /v1/scans/{id}/... and checks that
+// it belongs to the caller. Other users' scans are reported as not found. It
+// writes the error response and returns false when the request should stop.
+func (h *ScanHandlers) ownedScan(w http.ResponseWriter, r *http.Request) (*models.Scan, bool) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == 0 {
+		h.writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+
+	log = log.WithUserID(userID)
+
+	scanIDStr, _ := splitScanPath(r.URL.Path)
+	scanID, err := strconv.ParseInt(scanIDStr, 10, 64)
+	if err != nil {
+		log.Warnf("Invalid scan ID format: %s", scanIDStr)
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid scan ID")
+		return nil, false
+	}
+
+	log = log.WithField("scan_id", scanID)
+
+	scan, err := authz.Scan(r.Context(), h.db, userID, scanID)
+	if err != nil {
+		switch {
+		case errors.Is(err, authz.ErrNotOwner):
+			log.Warn("User attempted to access scan belonging to another user")
+		case errors.Is(err, authz.ErrNotFound):
+			log.Warn("Scan not found")
+		default:
+			log.ErrorWithErr(err, "Failed to get scan by ID from database")
+			h.writeJSONError(w, http.StatusInternalServerError, "Failed to get scan")
+			return nil, false
+		}
+		h.writeJSONError(w, http.StatusNotFound, "Scan not found")
+		return nil, false
+	}
+
+	return scan, true
+}
+
+// serveImage streams a stored image. http.ServeContent takes care of Range,
+// If-None-Match and If-Modified-Since.
+func (h *ScanHandlers) serveImage(w http.ResponseWriter, r *http.Request, storagePath string, hash *string, modTime time.Time) {
+	reader, err := h.fileStorage.OpenImageReader(storagePath)
+	if err != nil {
+		logger.GetDefaultLogger().ErrorWithErr(err, "Failed to open image from storage")
+		h.writeJSONError(w, http.StatusNotFound, "Image not available")
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", storage.MimeTypeFromExtension(path.Ext(storagePath)))
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if hash != nil {
+		w.Header().Set("ETag", fmt.Sprintf("%q", *hash))
+	}
+
+	http.ServeContent(w, r, "", modTime, reader)
+}
+
+// storePageImages runs one uploaded page through the preprocessing pipeline
+// and stores the result as the page image, keeping the upload as the
+// original. Page 1 is also the scan's own image and the source of its
+// thumbnails. If preprocessing fails the upload is stored instead. Uploads
+// are only ever stored with their metadata stripped, so no copy keeps the
+// GPS position. It returns the image bytes that should be sent to OCR.
+func (h *ScanHandlers) storePageImages(ctx context.Context, scanID int64, pageNumber int, imageData []byte, mimeType string) ([]byte, string, error) {
+	log := logger.GetDefaultLogger().WithFields(map[string]any{"scan_id": scanID, "page": pageNumber})
+	pageKey := pageStorageKey(scanID, pageNumber)
+
+	page := &models.ScanPage{
+		ScanID:     scanID,
+		PageNumber: pageNumber,
+		CreatedAt:  time.Now(),
+	}
+
+	upload, err := imageproc.StripMetadata(imageData, mimeType)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to strip image metadata: %w", err)
+	}
+
+	processedData, processedMIME := upload, mimeType
+	if h.imageProcessor != nil {
+		result, err := h.imageProcessor.Process(imageData, mimeType)
+		if err != nil {
+			log.Warnf("Image preprocessing failed, storing upload without metadata: %v", err)
+		} else {
+			log.Infof("Preprocessed image: %dx%d, orientation=%d, skew=%.2f, size=%d->%d bytes",
+				result.Width, result.Height, result.Orientation, result.SkewAngle, len(imageData), len(result.Data))
+
+			originalPath, _, err := h.fileStorage.SaveImage(pageKey+"-original", upload, mimeType)
+			if err != nil {
+				return nil, "", err
+			}
+			page.OriginalImageURL = &originalPath
+			processedData, processedMIME = result.Data, result.MIMEType
+		}
+	}
+
+	storagePath, imageHash, err := h.fileStorage.SaveImage(pageKey, processedData, processedMIME)
+	if err != nil {
+		return nil, "", err
+	}
+	page.ImageURL = storagePath
+	page.ImageHash = imageHash
+
+	if _, err := h.db.CreateScanPage(ctx, page); err != nil {
+		return nil, "", err
+	}
+
+	if pageNumber == 1 {
+		if page.OriginalImageURL != nil {
+			if err := h.db.UpdateScanOriginalImage(ctx, scanID, *page.OriginalImageURL); err != nil {
+				return nil, "", err
+			}
+		}
+		if err := h.db.UpdateScanImage(ctx, scanID, storagePath, imageHash); err != nil {
+			return nil, "", err
+		}
+
+		if h.thumbnails != nil {
+			if err := h.thumbnails.Generate(ctx, scanID, processedData); err != nil {
+				log.Warnf("Thumbnail generation failed: %v", err)
+			}
+		}
+	}
+
+	return processedData, processedMIME, nil
 }
 
 func (h *ScanHandlers) writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	h.writeJSONErrorCode(w, statusCode, "", message)
+}
+
+func (h *ScanHandlers) writeJSONErrorCode(w http.ResponseWriter, statusCode int, code, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(ErrorResponse{
 		Error:   http.StatusText(statusCode),
+		Code:    code,
 		Message: message,
 	})
 }
 
-func isValidImageType(mimeType string) bool {
-	validTypes := []string{"image/jpeg", "image/jpg", "image/png", "image/webp"}
-	for _, valid := range validTypes {
-		if mimeType == valid {
-			return true
+// scanImageURL returns a signed image URL for scan, or "" if no image has
+// been stored yet.
+func (h *ScanHandlers) scanImageURL(scan *models.Scan) string {
+	if scan.ImageURL == "" {
+		return ""
+	}
+	return h.signedImageURL(scan.ID, scan.UserID)
+}
+
+// setThumbnailURLs fills the signed thumbnail URLs of item. thumbnailUrl is
+// the configured default size; thumbnails maps every available size so
+// clients can build a srcset.
+func (h *ScanHandlers) setThumbnailURLs(item *ScanListItem, scan *models.Scan, thumbnails []*models.ScanThumbnail) {
+	if len(thumbnails) == 0 {
+		return
+	}
+
+	item.Thumbnails = make(map[string]string, len(thumbnails))
+	for _, thumb := range thumbnails {
+		signedURL := h.urlSigner.Sign(fmt.Sprintf("/v1/scans/%d/thumbnails/%d", scan.ID, thumb.Size), scan.UserID)
+		item.Thumbnails[strconv.Itoa(thumb.Size)] = signedURL
+		if thumb.Size == h.config.ThumbnailSize {
+			item.ThumbnailURL = signedURL
 		}
 	}
-	return false
+}
+
+func (h *ScanHandlers) signedImageURL(scanID, userID int64) string {
+	return h.urlSigner.Sign(fmt.Sprintf("/v1/scans/%d/image", scanID), userID)
+}
+
+func (h *ScanHandlers) signedPageImageURL(scanID, userID int64, pageNumber int) string {
+	return h.urlSigner.Sign(fmt.Sprintf("/v1/scans/%d/pages/%d/image", scanID, pageNumber), userID)
+}
+
+func (h *ScanHandlers) signedDocumentURL(scanID, userID int64) string {
+	return h.urlSigner.Sign(fmt.Sprintf("/v1/scans/%d/document", scanID), userID)
+}
+
+// pageStorageKey names the stored image of a page. Page 1 keeps the plain
+// scan ID used before scans had pages.
+func pageStorageKey(scanID int64, pageNumber int) string {
+	if pageNumber == 1 {
+		return strconv.FormatInt(scanID, 10)
+	}
+	return fmt.Sprintf("%d-p%d", scanID, pageNumber)
+}
+
+func readMultipartFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// splitScanPath splits /v1/scans/{id}/{action} into its id and action parts.
+func splitScanPath(urlPath string) (string, string) {
+	rest := strings.Trim(strings.TrimPrefix(urlPath, "/v1/scans/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	return id, action
+}
+
+// uploadError is a rejected upload file, ready to be reported to the client.
+type uploadError struct {
+	status  int
+	code    string
+	message string
+}
+
+// uploadRejection maps an imageproc validation error to the response status,
+// error code and message for the client.
+func uploadRejection(err error) *uploadError {
+	switch {
+	case errors.Is(err, imageproc.ErrUnsupportedFormat):
+		return &uploadError{http.StatusUnsupportedMediaType, ErrCodeUnsupportedType, "Invalid file type. Please use JPEG, PNG, WebP, or PDF."}
+	case errors.Is(err, imageproc.ErrTypeMismatch):
+		return &uploadError{http.StatusBadRequest, ErrCodeTypeMismatch, "File contents do not match the declared file type."}
+	case errors.Is(err, imageproc.ErrPolyglot):
+		return &uploadError{http.StatusBadRequest, ErrCodePolyglotFile, "File contains unexpected embedded data."}
+	case errors.Is(err, imageproc.ErrDimensionsTooLarge):
+		return &uploadError{http.StatusRequestEntityTooLarge, ErrCodeDimensionsTooLarge, "Image dimensions are too large."}
+	default:
+		return &uploadError{http.StatusBadRequest, ErrCodeCorruptFile, "File is damaged or could not be read."}
+	}
 }
 
 func (h *ScanHandlers) ScansAPI(w http.ResponseWriter, r *http.Request) {
@@ -358,3 +944,44 @@ func (h *ScanHandlers) ScansAPI(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
+
+// ScanAPI routes /v1/scans/{id} and its sub-resources.
+func (h *ScanHandlers) ScanAPI(w http.ResponseWriter, r *http.Request) {
+	_, action := splitScanPath(r.URL.Path)
+	switch action {
+	case "":
+		if r.Method == http.MethodPatch {
+			h.UpdateScanTextAPI(w, r)
+			return
+		}
+		h.GetScanAPI(w, r)
+	case "reprocess":
+		h.ReprocessScanAPI(w, r)
+	case "revisions":
+		h.GetScanRevisionsAPI(w, r)
+	case "translate":
+		h.TranslateScanAPI(w, r)
+	case "translation":
+		h.GetScanTranslationAPI(w, r)
+	case "vocabulary":
+		h.ScanVocabularyAPI(w, r)
+	case "vocabulary/annotations":
+		h.SaveScanVocabularyAPI(w, r)
+	case "annotations":
+		h.GetScanAnnotationsAPI(w, r)
+	case "image":
+		h.GetScanImageAPI(w, r)
+	case "document":
+		h.GetScanDocumentAPI(w, r)
+	default:
+		if strings.HasPrefix(action, "thumbnails/") {
+			h.GetScanThumbnailAPI(w, r)
+			return
+		}
+		if strings.HasPrefix(action, "pages/") && strings.HasSuffix(action, "/image") {
+			h.GetScanPageImageAPI(w, r)
+			return
+		}
+		h.writeJSONError(w, http.StatusNotFound, "Not found")
+	}
+}
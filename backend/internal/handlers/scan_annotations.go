@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/models"
+)
+
+// maxOCRBlockLength matches annotations.ocr_block.
+const maxOCRBlockLength = 100
+
+// ScanHighlight is an annotation as drawn on the scan view. Stale is true
+// when the scan text changed and no longer has HighlightedText at the
+// stored offsets.
+type ScanHighlight struct {
+	ID                 int64               `json:"id"`
+	PageNumber         *int                `json:"pageNumber,omitempty"`
+	HighlightedText    string              `json:"highlightedText"`
+	StartOffset        *int                `json:"startOffset,omitempty"`
+	EndOffset          *int                `json:"endOffset,omitempty"`
+	OCRBlock           *string             `json:"ocrBlock,omitempty"`
+	BoundingBox        *models.BoundingBox `json:"boundingBox,omitempty"`
+	NuanceSummary      string              `json:"nuanceSummary"`
+	JLPTLevel          string              `json:"jlptLevel,omitempty"`
+	PolitenessRegister string              `json:"politenessRegister,omitempty"`
+	Stale              bool                `json:"stale"`
+	CreatedAt          string              `json:"createdAt"`
+}
+
+type ScanAnnotationsResponse struct {
+	ScanID int64           `json:"scanId"`
+	Data   []ScanHighlight `json:"data"`
+}
+
+// GetScanAnnotationsAPI returns the user's annotations on a scan for
+// rendering, ordered by position in the text. Annotations without offsets
+// come last, oldest first.
+func (h *ScanHandlers) GetScanAnnotationsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
+		return
+	}
+
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(scan.UserID).WithField("scan_id", scan.ID)
+
+	annotations, err := h.db.GetAnnotationsByScanID(r.Context(), scan.ID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan annotations from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get annotations")
+		return
+	}
+
+	text := scanText(scan)
+	data := make([]ScanHighlight, 0, len(annotations))
+	for _, annotation := range annotations {
+		if annotation.UserID != scan.UserID {
+			continue
+		}
+		highlight := ScanHighlight{
+			ID:                 annotation.ID,
+			PageNumber:         annotation.PageNumber,
+			HighlightedText:    annotation.HighlightedText,
+			StartOffset:        annotation.StartOffset,
+			EndOffset:          annotation.EndOffset,
+			OCRBlock:           annotation.OCRBlock,
+			BoundingBox:        annotation.BoundingBox,
+			NuanceSummary:      summarizeNuance(annotation.NuanceData),
+			JLPTLevel:          annotation.NuanceData.JLPTLevel,
+			PolitenessRegister: annotation.NuanceData.PolitenessRegister,
+			CreatedAt:          annotation.CreatedAt.Format(time.RFC3339),
+		}
+		if annotation.StartOffset != nil && annotation.EndOffset != nil {
+			highlight.Stale = textAt(text, *annotation.StartOffset, *annotation.EndOffset) != annotation.HighlightedText
+		}
+		data = append(data, highlight)
+	}
+
+	sort.SliceStable(data, func(i, j int) bool {
+		a, b := data[i].StartOffset, data[j].StartOffset
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return *a < *b
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ScanAnnotationsResponse{ScanID: scan.ID, Data: data})
+}
+
+// validateHighlight checks the location fields of req against the scan: the
+// offsets must select exactly highlightedText in the scan's full text, and
+// the bounding box must lie within the page image.
+func validateHighlight(scan *models.Scan, req *CreateAnnotationRequest) error {
+	if (req.StartOffset == nil) != (req.EndOffset == nil) {
+		return errors.New("startOffset and endOffset must be given together")
+	}
+	if req.StartOffset != nil {
+		start, end := *req.StartOffset, *req.EndOffset
+		text := scanText(scan)
+		if start < 0 || end <= start || end > utf16Len(text) {
+			return errors.New("startOffset and endOffset are out of range for this scan's text")
+		}
+		if textAt(text, start, end) != req.HighlightedText {
+			return errors.New("highlightedText does not match the scan text at startOffset and endOffset")
+		}
+	}
+
+	if req.OCRBlock != nil {
+		block := strings.TrimSpace(*req.OCRBlock)
+		if block == "" || len(block) > maxOCRBlockLength {
+			return fmt.Errorf("ocrBlock must be 1 to %d characters", maxOCRBlockLength)
+		}
+		req.OCRBlock = &block
+	}
+
+	if box := req.BoundingBox; box != nil {
+		if box.X < 0 || box.Y < 0 || box.Width <= 0 || box.Height <= 0 || box.X+box.Width > 1 || box.Y+box.Height > 1 {
+			return errors.New("boundingBox must lie within the page, as fractions between 0 and 1")
+		}
+		if req.PageNumber == nil && scan.PageCount > 1 {
+			return errors.New("boundingBox requires pageNumber for multi-page scans")
+		}
+	}
+	return nil
+}
+
+// textAt returns text from the UTF-16 offset start to end, or "" when the
+// range is outside text or splits a surrogate pair.
+func textAt(text string, start, end int) string {
+	if start < 0 || end <= start {
+		return ""
+	}
+	from, to, units := -1, -1, 0
+	for i, r := range text {
+		if units == start {
+			from = i
+		}
+		if units == end {
+			to = i
+			break
+		}
+		units += utf16.RuneLen(r)
+	}
+	if units == end && to == -1 {
+		to = len(text)
+	}
+	if from == -1 || to == -1 {
+		return ""
+	}
+	return text[from:to]
+}
+
+// textOffsets returns the UTF-16 offsets of the first occurrence of substr
+// in text.
+func textOffsets(text, substr string) (int, int, bool) {
+	i := strings.Index(text, substr)
+	if i == -1 || substr == "" {
+		return 0, 0, false
+	}
+	start := utf16Len(text[:i])
+	return start, start + utf16Len(substr), true
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the unit highlight
+// offsets are counted in.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,488 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gemini-hackathon/app/internal/gemini"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+)
+
+// languageHintPattern accepts BCP 47 style codes such as "ja", "zh-Hant" or
+// "ja-JP".
+var languageHintPattern = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+type ReprocessScanRequest struct {
+	Model        string `json:"model,omitempty"`
+	LanguageHint string `json:"languageHint,omitempty"`
+}
+
+type ReprocessScanResponse struct {
+	ScanID int64  `json:"scanId"`
+	Status string `json:"status"`
+}
+
+type UpdateScanTextRequest struct {
+	FullText   *string `json:"fullText"`
+	PageNumber *int    `json:"pageNumber,omitempty"`
+}
+
+type OCRRevisionItem struct {
+	ID           int64   `json:"id"`
+	PageNumber   int     `json:"pageNumber"`
+	Text         string  `json:"text"`
+	Language     *string `json:"language,omitempty"`
+	Source       string  `json:"source"`
+	Model        *string `json:"model,omitempty"`
+	LanguageHint *string `json:"languageHint,omitempty"`
+	CreatedBy    *int64  `json:"createdBy,omitempty"`
+	CreatedAt    string  `json:"createdAt"`
+}
+
+type GetScanRevisionsResponse struct {
+	Data []OCRRevisionItem `json:"data"`
+}
+
+// ocrRun describes why OCR is running so each stored text can be traced back
+// to what produced it.
+type ocrRun struct {
+	source  string
+	options gemini.OCROptions
+	userID  *int64
+}
+
+// ReprocessScanAPI re-runs OCR on every page of a scan, optionally with a
+// different model or a language hint. OCR runs in the background; the new
+// text replaces the current one and is added to the revision history.
+// Requests are limited per scan and per user over an hour (429).
+func (h *ScanHandlers) ReprocessScanAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
+		return
+	}
+
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(scan.UserID).WithField("scan_id", scan.ID)
+
+	if scan.DocumentType == models.DocumentTypeText {
+		h.writeJSONError(w, http.StatusConflict, "Text scans have no image to reprocess")
+		return
+	}
+
+	var req ReprocessScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Model != "" && !slices.Contains(h.config.OCRModels, req.Model) {
+		h.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported model. Choose one of: %s", strings.Join(h.config.OCRModels, ", ")))
+		return
+	}
+	if req.LanguageHint != "" && !languageHintPattern.MatchString(req.LanguageHint) {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid languageHint")
+		return
+	}
+
+	userID := scan.UserID
+	allowed, err := h.db.RecordReprocess(r.Context(), scan.ID, userID, time.Hour, h.config.ReprocessScanLimit, h.config.ReprocessUserLimit)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to record reprocessing request")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to reprocess scan")
+		return
+	}
+	if !allowed {
+		h.writeJSONErrorCode(w, http.StatusTooManyRequests, ErrCodeReprocessLimit, "Too many reprocessing requests. Try again later.")
+		return
+	}
+
+	run := ocrRun{
+		source:  models.OCRSourceReprocess,
+		options: gemini.OCROptions{Model: req.Model, LanguageHint: req.LanguageHint},
+		userID:  &userID,
+	}
+
+	if scan.DocumentType == models.DocumentTypePDF {
+		if scan.DocumentURL == nil {
+			h.writeJSONError(w, http.StatusConflict, "Document not available")
+			return
+		}
+		documentData, err := h.fileStorage.OpenImage(*scan.DocumentURL)
+		if err != nil {
+			log.ErrorWithErr(err, "Failed to open document from storage")
+			h.writeJSONError(w, http.StatusInternalServerError, "Failed to reprocess scan")
+			return
+		}
+		go h.processDocumentOCR(context.Background(), scan.ID, documentData, run)
+	} else {
+		pages, err := h.db.GetScanPages(r.Context(), scan.ID)
+		if err != nil {
+			log.ErrorWithErr(err, "Failed to get scan pages from database")
+			h.writeJSONError(w, http.StatusInternalServerError, "Failed to reprocess scan")
+			return
+		}
+		if len(pages) == 0 {
+			h.writeJSONError(w, http.StatusConflict, "No page images to reprocess")
+			return
+		}
+
+		uploads := make([]pageUpload, len(pages))
+		for i, page := range pages {
+			data, err := h.fileStorage.OpenImage(page.ImageURL)
+			if err != nil {
+				log.ErrorWithErr(err, fmt.Sprintf("Failed to open page %d from storage", page.PageNumber))
+				h.writeJSONError(w, http.StatusInternalServerError, "Failed to reprocess scan")
+				return
+			}
+			uploads[i] = pageUpload{data: data, mimeType: storage.MimeTypeFromExtension(path.Ext(page.ImageURL))}
+		}
+		go h.processPagesOCR(context.Background(), scan.ID, uploads, run)
+	}
+
+	log.Infof("Queued OCR reprocessing: model=%q, language_hint=%q", req.Model, req.LanguageHint)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ReprocessScanResponse{ScanID: scan.ID, Status: "queued"})
+}
+
+// UpdateScanTextAPI replaces the OCR text of one page with the user's
+// correction. pageNumber may be omitted for single-page scans.
+func (h *ScanHandlers) UpdateScanTextAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
+		return
+	}
+
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(scan.UserID).WithField("scan_id", scan.ID)
+
+	var req UpdateScanTextRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.FullText == nil {
+		h.writeJSONError(w, http.StatusBadRequest, "fullText is required")
+		return
+	}
+	if utf8.RuneCountInString(*req.FullText) > h.config.MaxTextLength {
+		h.writeJSONErrorCode(w, http.StatusRequestEntityTooLarge, ErrCodeTextTooLong, fmt.Sprintf("Text too long. Maximum is %d characters.", h.config.MaxTextLength))
+		return
+	}
+
+	pageNumber := 1
+	if req.PageNumber != nil {
+		pageNumber = *req.PageNumber
+	} else if scan.PageCount > 1 {
+		h.writeJSONError(w, http.StatusBadRequest, "pageNumber is required for multi-page scans")
+		return
+	}
+
+	pages, err := h.db.GetScanPages(r.Context(), scan.ID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan pages from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to update scan")
+		return
+	}
+
+	var page *models.ScanPage
+	for _, p := range pages {
+		if p.PageNumber == pageNumber {
+			page = p
+		}
+	}
+	if page == nil {
+		h.writeJSONError(w, http.StatusBadRequest, "pageNumber is out of range for this scan")
+		return
+	}
+
+	language := ""
+	if page.DetectedLanguage != nil {
+		language = *page.DetectedLanguage
+	}
+
+	userID := scan.UserID
+	revision := newRevision(scan.ID, pageNumber, *req.FullText, language, "", ocrRun{source: models.OCRSourceManual, userID: &userID})
+	if err := h.db.UpdateScanPageOCR(r.Context(), revision); err != nil {
+		log.ErrorWithErr(err, "Failed to update page OCR in database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to update scan")
+		return
+	}
+
+	if err := h.refreshScanText(r.Context(), scan.ID); err != nil {
+		log.ErrorWithErr(err, "Failed to update scan OCR in database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to update scan")
+		return
+	}
+
+	log.Infof("Saved manual OCR correction for page %d", pageNumber)
+
+	scan, err = h.db.GetScanByID(r.Context(), scan.ID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to reload scan")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to update scan")
+		return
+	}
+
+	response, err := h.scanResponse(r.Context(), scan)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan pages from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to update scan")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetScanRevisionsAPI lists the OCR revisions of a scan, newest first. The
+// optional pageNumber query parameter limits the list to one page.
+func (h *ScanHandlers) GetScanRevisionsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
+		return
+	}
+
+	pageFilter := 0
+	if value := r.URL.Query().Get("pageNumber"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			h.writeJSONError(w, http.StatusBadRequest, "Invalid pageNumber")
+			return
+		}
+		pageFilter = n
+	}
+
+	revisions, err := h.db.GetOCRRevisions(r.Context(), scan.ID)
+	if err != nil {
+		logger.GetDefaultLogger().ErrorWithErr(err, "Failed to get OCR revisions from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get revisions")
+		return
+	}
+
+	data := make([]OCRRevisionItem, 0, len(revisions))
+	for _, revision := range revisions {
+		if pageFilter != 0 && revision.PageNumber != pageFilter {
+			continue
+		}
+		data = append(data, OCRRevisionItem{
+			ID:           revision.ID,
+			PageNumber:   revision.PageNumber,
+			Text:         revision.Text,
+			Language:     revision.Language,
+			Source:       revision.Source,
+			Model:        revision.Model,
+			LanguageHint: revision.LanguageHint,
+			CreatedBy:    revision.CreatedBy,
+			CreatedAt:    revision.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetScanRevisionsResponse{Data: data})
+}
+
+// acquireOCRSlot blocks until fewer than OCRConcurrency scans are being
+// OCR'd, so a large batch upload queues up instead of flooding the model.
+func (h *ScanHandlers) acquireOCRSlot() func() {
+	h.ocrSlots <- struct{}{}
+	return func() { <-h.ocrSlots }
+}
+
+// processPagesOCR runs OCR on each page image in order and stores the page
+// texts plus their concatenation as the scan's full text. A failed page is
+// logged and keeps its previous text so the others still come through.
+func (h *ScanHandlers) processPagesOCR(ctx context.Context, scanID int64, pages []pageUpload, run ocrRun) {
+	log := logger.GetDefaultLogger().WithField("scan_id", scanID)
+
+	release := h.acquireOCRSlot()
+	defer release()
+
+	succeeded := 0
+	for i, page := range pages {
+		pageNumber := i + 1
+		log.Infof("Starting OCR processing: page=%d, image_size=%d bytes, mime_type=%s, source=%s", pageNumber, len(page.data), page.mimeType, run.source)
+
+		ocrResp, err := h.geminiClient.OCR(ctx, page.data, page.mimeType, run.options)
+		if err != nil {
+			log.ErrorWithErr(err, fmt.Sprintf("OCR processing failed for page %d", pageNumber))
+			h.recordFailure(ctx, scanID, &pageNumber, err, run)
+			continue
+		}
+		log.Infof("OCR completed successfully: page=%d, language=%s, text_length=%d", pageNumber, ocrResp.Language, len(ocrResp.RawText))
+
+		revision := newRevision(scanID, pageNumber, ocrResp.RawText, ocrResp.Language, ocrResp.Model, run)
+		if err := h.db.UpdateScanPageOCR(ctx, revision); err != nil {
+			log.ErrorWithErr(err, "Failed to update page OCR in database")
+			continue
+		}
+		succeeded++
+	}
+
+	if succeeded == 0 {
+		log.Error("OCR failed for every page")
+		return
+	}
+
+	if err := h.refreshScanText(ctx, scanID); err != nil {
+		log.ErrorWithErr(err, "Failed to update scan OCR in database")
+		return
+	}
+
+	log.Infof("OCR results saved to database successfully")
+}
+
+// processDocumentOCR sends a PDF to the model as a whole, then brings the
+// scan's page rows, page count and full text in line with the result.
+func (h *ScanHandlers) processDocumentOCR(ctx context.Context, scanID int64, documentData []byte, run ocrRun) {
+	log := logger.GetDefaultLogger().WithField("scan_id", scanID)
+
+	release := h.acquireOCRSlot()
+	defer release()
+
+	log.Infof("Starting document OCR processing: size=%d bytes, source=%s", len(documentData), run.source)
+	ocrResp, err := h.geminiClient.OCRDocument(ctx, documentData, pdfMIMEType, run.options)
+	if err != nil {
+		log.ErrorWithErr(err, "Document OCR processing failed")
+		h.recordFailure(ctx, scanID, nil, err, run)
+		return
+	}
+	log.Infof("Document OCR completed successfully: language=%s, pages=%d", ocrResp.Language, len(ocrResp.Pages))
+
+	existing, err := h.db.GetScanPages(ctx, scanID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan pages from database")
+		return
+	}
+
+	for i, pageText := range ocrResp.Pages {
+		pageNumber := i + 1
+		text := pageText.RawText
+		revision := newRevision(scanID, pageNumber, text, ocrResp.Language, ocrResp.Model, run)
+		if pageNumber <= len(existing) {
+			err = h.db.UpdateScanPageOCR(ctx, revision)
+		} else {
+			_, err = h.db.CreateScanPage(ctx, &models.ScanPage{
+				ScanID:           scanID,
+				PageNumber:       pageNumber,
+				OCRText:          &text,
+				DetectedLanguage: &ocrResp.Language,
+				CreatedAt:        time.Now(),
+			})
+			if err == nil {
+				_, err = h.db.CreateOCRRevision(ctx, revision)
+			}
+		}
+		if err != nil {
+			log.ErrorWithErr(err, "Failed to save document page in database")
+			return
+		}
+	}
+
+	if err := h.db.DeleteScanPagesAfter(ctx, scanID, len(ocrResp.Pages)); err != nil {
+		log.ErrorWithErr(err, "Failed to remove stale document pages from database")
+		return
+	}
+
+	if err := h.db.UpdateScanPageCount(ctx, scanID, len(ocrResp.Pages)); err != nil {
+		log.ErrorWithErr(err, "Failed to update scan page count in database")
+		return
+	}
+
+	if err := h.refreshScanText(ctx, scanID); err != nil {
+		log.ErrorWithErr(err, "Failed to update scan OCR in database")
+		return
+	}
+
+	log.Infof("Document OCR results saved to database successfully")
+}
+
+// newRevision returns the revision history entry for a page's new OCR text.
+func newRevision(scanID int64, pageNumber int, text, language, model string, run ocrRun) *models.OCRRevision {
+	revision := &models.OCRRevision{
+		ScanID:     scanID,
+		PageNumber: pageNumber,
+		Text:       text,
+		Source:     run.source,
+		CreatedBy:  run.userID,
+		CreatedAt:  time.Now(),
+	}
+	if language != "" {
+		revision.Language = &language
+	}
+	if model != "" {
+		revision.Model = &model
+	}
+	if run.options.LanguageHint != "" {
+		revision.LanguageHint = &run.options.LanguageHint
+	}
+
+	return revision
+}
+
+// recordFailure saves a failed OCR attempt for admins and content editors
+// to review. pageNumber is nil when the whole document failed.
+func (h *ScanHandlers) recordFailure(ctx context.Context, scanID int64, pageNumber *int, ocrErr error, run ocrRun) {
+	failure := &models.ScanFailure{
+		ScanID:     scanID,
+		PageNumber: pageNumber,
+		Source:     run.source,
+		Error:      ocrErr.Error(),
+		CreatedAt:  time.Now(),
+	}
+	if _, err := h.db.CreateScanFailure(ctx, failure); err != nil {
+		logger.GetDefaultLogger().WithField("scan_id", scanID).ErrorWithErr(err, "Failed to save OCR failure")
+	}
+}
+
+// refreshScanText rebuilds the scan's full text from its pages, joined by a
+// blank line, and takes the language of the first page that has one.
+func (h *ScanHandlers) refreshScanText(ctx context.Context, scanID int64) error {
+	pages, err := h.db.GetScanPages(ctx, scanID)
+	if err != nil {
+		return err
+	}
+
+	texts := make([]string, 0, len(pages))
+	language := ""
+	for _, page := range pages {
+		if page.OCRText == nil {
+			continue
+		}
+		texts = append(texts, *page.OCRText)
+		if language == "" && page.DetectedLanguage != nil {
+			language = *page.DetectedLanguage
+		}
+	}
+
+	return h.db.UpdateScanOCR(ctx, scanID, strings.Join(texts, "\n\n"), language)
+}
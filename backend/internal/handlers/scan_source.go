@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gemini-hackathon/app/internal/fetcher"
+	"github.com/gemini-hackathon/app/internal/imageproc"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/models"
+)
+
+// CreateScanFromSourceRequest is the JSON body of POST /v1/scans for scans
+// that are not file uploads. Exactly one of Text or ImageURL must be set.
+type CreateScanFromSourceRequest struct {
+	Text     *string `json:"text,omitempty"`
+	ImageURL *string `json:"imageUrl,omitempty"`
+	Language string  `json:"language,omitempty"`
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// createScanFromJSON handles the JSON form of CreateScanAPI: pasted text is
+// stored as the scan's text without running OCR, and an image URL is fetched
+// server-side and then treated like an upload.
+func (h *ScanHandlers) createScanFromJSON(w http.ResponseWriter, r *http.Request, userID int64) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(userID)
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.config.MaxRequestSize)
+	var req CreateScanFromSourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.writeJSONErrorCode(w, http.StatusRequestEntityTooLarge, ErrCodeRequestTooLarge, fmt.Sprintf("Request too large. Maximum request size is %v MB.", h.config.MaxRequestSize/(1024*1024)))
+			return
+		}
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if (req.Text == nil) == (req.ImageURL == nil) {
+		h.writeJSONErrorCode(w, http.StatusBadRequest, ErrCodeInvalidSource, "Provide exactly one of text or imageUrl")
+		return
+	}
+	if req.Language != "" && !languageHintPattern.MatchString(req.Language) {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid language")
+		return
+	}
+
+	var response *CreateScanResponse
+	if req.Text != nil {
+		text := strings.TrimSpace(*req.Text)
+		if text == "" {
+			h.writeJSONErrorCode(w, http.StatusBadRequest, ErrCodeInvalidSource, "text cannot be empty")
+			return
+		}
+		if !utf8.ValidString(text) {
+			h.writeJSONErrorCode(w, http.StatusBadRequest, ErrCodeInvalidSource, "text must be valid UTF-8")
+			return
+		}
+		if utf8.RuneCountInString(text) > h.config.MaxTextLength {
+			h.writeJSONErrorCode(w, http.StatusRequestEntityTooLarge, ErrCodeTextTooLong, fmt.Sprintf("Text too long. Maximum is %d characters.", h.config.MaxTextLength))
+			return
+		}
+
+		var err error
+		response, err = h.createTextScan(r, userID, text, req.Language)
+		if err != nil {
+			log.ErrorWithErr(err, "Failed to create text scan")
+			h.writeJSONError(w, http.StatusInternalServerError, "Failed to save text")
+			return
+		}
+	} else {
+		var ok bool
+		response, ok = h.createURLScan(w, r, userID, *req.ImageURL)
+		if !ok {
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// createTextScan stores pasted text as a single-page scan. The text is the
+// page's OCR text right away, so the scan can be annotated immediately.
+func (h *ScanHandlers) createTextScan(r *http.Request, userID int64, text, language string) (*CreateScanResponse, error) {
+	ctx := r.Context()
+	if language == "" {
+		language = guessLanguage(text)
+	}
+
+	scan := &models.Scan{
+		UserID:       userID,
+		ImageURL:     "",
+		DocumentType: models.DocumentTypeText,
+		PageCount:    1,
+		SourceType:   models.SourceTypeText,
+		CreatedAt:    time.Now(),
+	}
+	scanID, err := h.db.CreateScan(ctx, scan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scan in database: %w", err)
+	}
+
+	page := &models.ScanPage{
+		ScanID:     scanID,
+		PageNumber: 1,
+		OCRText:    &text,
+		CreatedAt:  time.Now(),
+	}
+	if language != "" {
+		page.DetectedLanguage = &language
+	}
+	if _, err := h.db.CreateScanPage(ctx, page); err != nil {
+		return nil, fmt.Errorf("failed to create scan page: %w", err)
+	}
+	if err := h.db.UpdateScanOCR(ctx, scanID, text, language); err != nil {
+		return nil, fmt.Errorf("failed to store scan text: %w", err)
+	}
+	revision := newRevision(scanID, 1, text, language, "", ocrRun{source: models.OCRSourceText, userID: &userID})
+	if _, err := h.db.CreateOCRRevision(ctx, revision); err != nil {
+		return nil, fmt.Errorf("failed to save OCR revision: %w", err)
+	}
+
+	logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(ctx)).WithUserID(userID).
+		WithField("scan_id", scanID).Infof("Text scan created: length=%d, language=%s", utf8.RuneCountInString(text), language)
+
+	return &CreateScanResponse{
+		ScanID:       scanID,
+		FullText:     text,
+		DocumentType: models.DocumentTypeText,
+		PageCount:    1,
+		SourceType:   models.SourceTypeText,
+	}, nil
+}
+
+// createURLScan fetches an image or PDF from a user-supplied URL and stores
+// it like an upload. The fetcher refuses private and loopback destinations,
+// and the downloaded bytes go through the same validation as uploads. It
+// writes the error response and returns false on failure.
+func (h *ScanHandlers) createURLScan(w http.ResponseWriter, r *http.Request, userID int64, rawURL string) (*CreateScanResponse, bool) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(userID)
+
+	result, err := h.fetcher.Fetch(r.Context(), rawURL)
+	if err != nil {
+		log.Warnf("Failed to fetch scan URL: %v", err)
+		switch {
+		case errors.Is(err, fetcher.ErrInvalidURL):
+			h.writeJSONErrorCode(w, http.StatusBadRequest, ErrCodeInvalidURL, "imageUrl must be an http or https URL")
+		case errors.Is(err, fetcher.ErrForbiddenAddress):
+			h.writeJSONErrorCode(w, http.StatusBadRequest, ErrCodeForbiddenURL, "imageUrl points to an address that is not allowed")
+		case errors.Is(err, fetcher.ErrTooLarge):
+			h.writeJSONErrorCode(w, http.StatusRequestEntityTooLarge, ErrCodeFileTooLarge, fmt.Sprintf("File too large. Maximum size is %v MB.", h.config.MaxUploadSize/(1024*1024)))
+		default:
+			h.writeJSONErrorCode(w, http.StatusBadGateway, ErrCodeFetchFailed, "Could not download imageUrl")
+		}
+		return nil, false
+	}
+
+	// The remote Content-Type is not trusted; the contents decide the format.
+	limits := imageproc.Limits{MaxSide: h.config.ImageMaxSide, MaxPixels: h.config.ImageMaxPixels}
+	mimeType, err := imageproc.Validate(result.Data, "", limits)
+	if err != nil {
+		log.Warnf("Rejected file fetched from URL: %v", err)
+		uploadErr := uploadRejection(err)
+		h.writeJSONErrorCode(w, uploadErr.status, uploadErr.code, uploadErr.message)
+		return nil, false
+	}
+
+	sourceURL := result.FinalURL
+	response, err := h.createScan(r.Context(), userID, []pageUpload{{data: result.Data, mimeType: mimeType}}, models.SourceTypeURL, &sourceURL)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to create scan")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to save upload")
+		return nil, false
+	}
+	return response, true
+}
+
+// guessLanguage returns "JP", the code the OCR model reports for Japanese,
+// when text contains kana or kanji, and "" otherwise.
+func guessLanguage(text string) string {
+	for _, r := range text {
+		if unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Han) {
+			return "JP"
+		}
+	}
+	return ""
+}
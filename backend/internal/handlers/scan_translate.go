@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/gemini"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/models"
+)
+
+// paragraphSeparator splits text on blank lines, which is also how
+// refreshScanText joins the pages of a scan.
+var paragraphSeparator = regexp.MustCompile(`\n[ \t\r\f\v　]*\n`)
+
+type TranslateScanRequest struct {
+	Refresh bool `json:"refresh,omitempty"`
+}
+
+type ScanTranslationResponse struct {
+	ScanID         int64                        `json:"scanId"`
+	TargetLanguage string                       `json:"targetLanguage"`
+	Paragraphs     []models.TranslatedParagraph `json:"paragraphs"`
+	Summary        string                       `json:"summary"`
+	ActionItems    []models.ActionItem          `json:"actionItems"`
+	Model          *string                      `json:"model,omitempty"`
+	Stale          bool                         `json:"stale"`
+	CreatedAt      string                       `json:"createdAt"`
+	UpdatedAt      string                       `json:"updatedAt"`
+}
+
+// TranslateScanAPI translates the whole scan text into the user's preferred
+// language. The result is stored per language; it is returned as-is on later
+// calls until the scan text changes or the client asks for a refresh.
+func (h *ScanHandlers) TranslateScanAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
+		return
+	}
+
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(scan.UserID).WithField("scan_id", scan.ID)
+
+	var req TranslateScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	paragraphs := splitParagraphs(scanText(scan))
+	if len(paragraphs) == 0 {
+		h.writeJSONError(w, http.StatusConflict, "Scan text is not available yet")
+		return
+	}
+
+	targetLanguage, err := h.targetLanguage(r, scan.UserID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get user")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to translate scan")
+		return
+	}
+
+	sourceHash := textHash(scanText(scan))
+	existing, err := h.db.GetScanTranslation(r.Context(), scan.ID, targetLanguage)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan translation from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to translate scan")
+		return
+	}
+	if existing != nil && existing.SourceHash == sourceHash && !req.Refresh {
+		log.Infof("Returning stored translation: language=%s", targetLanguage)
+		h.writeTranslation(w, existing, sourceHash)
+		return
+	}
+
+	log.Infof("Translating scan: language=%s, paragraphs=%d", targetLanguage, len(paragraphs))
+
+	resp, err := h.geminiClient.TranslateDocument(r.Context(), paragraphs, targetLanguage)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to translate scan")
+		h.writeJSONError(w, http.StatusBadGateway, "Failed to translate scan")
+		return
+	}
+
+	translation, err := alignTranslation(scan.ID, targetLanguage, sourceHash, paragraphs, resp)
+	if err != nil {
+		log.ErrorWithErr(err, "Model returned an unusable translation")
+		h.writeJSONError(w, http.StatusBadGateway, "Failed to translate scan")
+		return
+	}
+
+	if err := h.db.UpsertScanTranslation(r.Context(), translation); err != nil {
+		log.ErrorWithErr(err, "Failed to save scan translation")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to translate scan")
+		return
+	}
+
+	log.Infof("Scan translated: language=%s, action_items=%d", targetLanguage, len(translation.ActionItems))
+
+	h.writeTranslation(w, translation, sourceHash)
+}
+
+// GetScanTranslationAPI returns the stored translation in the user's
+// preferred language without calling the model. stale is true when the scan
+// text changed after it was translated.
+func (h *ScanHandlers) GetScanTranslationAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
+		return
+	}
+
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(scan.UserID).WithField("scan_id", scan.ID)
+
+	targetLanguage, err := h.targetLanguage(r, scan.UserID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get user")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get translation")
+		return
+	}
+
+	translation, err := h.db.GetScanTranslation(r.Context(), scan.ID, targetLanguage)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan translation from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get translation")
+		return
+	}
+	if translation == nil {
+		h.writeJSONError(w, http.StatusNotFound, "Scan has not been translated")
+		return
+	}
+
+	h.writeTranslation(w, translation, textHash(scanText(scan)))
+}
+
+// targetLanguage is the user's preferred language, defaulting to Indonesian
+// like AnalyzeAPI.
+func (h *ScanHandlers) targetLanguage(r *http.Request, userID int64) (string, error) {
+	user, err := h.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return "", err
+	}
+	if user == nil || user.PreferredLanguage == "" {
+		return "ID", nil
+	}
+	return user.PreferredLanguage, nil
+}
+
+func (h *ScanHandlers) writeTranslation(w http.ResponseWriter, translation *models.ScanTranslation, currentHash string) {
+	actionItems := translation.ActionItems
+	if actionItems == nil {
+		actionItems = []models.ActionItem{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ScanTranslationResponse{
+		ScanID:         translation.ScanID,
+		TargetLanguage: translation.TargetLanguage,
+		Paragraphs:     translation.Paragraphs,
+		Summary:        translation.Summary,
+		ActionItems:    actionItems,
+		Model:          translation.Model,
+		Stale:          translation.SourceHash != currentHash,
+		CreatedAt:      translation.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      translation.UpdatedAt.Format(time.RFC3339),
+	})
+}
+
+// alignTranslation pairs every source paragraph with the model's translation
+// of the same index. A translation missing any paragraph is rejected rather
+// than stored out of alignment.
+func alignTranslation(scanID int64, targetLanguage, sourceHash string, paragraphs []string, resp *gemini.TranslationResponse) (*models.ScanTranslation, error) {
+	translated := make([]string, len(paragraphs))
+	found := make([]bool, len(paragraphs))
+	for _, p := range resp.Paragraphs {
+		if p.Index < 0 || p.Index >= len(paragraphs) {
+			continue
+		}
+		translated[p.Index] = p.Translation
+		found[p.Index] = true
+	}
+
+	aligned := make([]models.TranslatedParagraph, len(paragraphs))
+	for i, source := range paragraphs {
+		if !found[i] {
+			return nil, fmt.Errorf("translation is missing paragraph %d of %d", i, len(paragraphs))
+		}
+		aligned[i] = models.TranslatedParagraph{Source: source, Translation: translated[i]}
+	}
+
+	actionItems := make([]models.ActionItem, 0, len(resp.ActionItems))
+	for _, item := range resp.ActionItems {
+		if strings.TrimSpace(item.Description) == "" {
+			continue
+		}
+		actionItem := models.ActionItem{Type: item.Type, Description: item.Description}
+		switch item.Type {
+		case models.ActionItemDeadline, models.ActionItemDocument, models.ActionItemTask:
+		default:
+			actionItem.Type = models.ActionItemTask
+		}
+		if _, err := time.Parse(time.DateOnly, item.DueDate); err == nil {
+			actionItem.DueDate = item.DueDate
+		}
+		actionItems = append(actionItems, actionItem)
+	}
+
+	now := time.Now()
+	translation := &models.ScanTranslation{
+		ScanID:         scanID,
+		TargetLanguage: targetLanguage,
+		SourceHash:     sourceHash,
+		Paragraphs:     aligned,
+		Summary:        resp.Summary,
+		ActionItems:    actionItems,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if resp.Model != "" {
+		translation.Model = &resp.Model
+	}
+	return translation, nil
+}
+
+func scanText(scan *models.Scan) string {
+	if scan.FullOCRText == nil {
+		return ""
+	}
+	return *scan.FullOCRText
+}
+
+// splitParagraphs splits text on blank lines and drops empty paragraphs.
+func splitParagraphs(text string) []string {
+	var paragraphs []string
+	for _, p := range paragraphSeparator.Split(strings.ReplaceAll(text, "\r\n", "\n"), -1) {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
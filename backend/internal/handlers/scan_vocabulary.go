@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gemini-hackathon/app/internal/gemini"
+	"github.com/gemini-hackathon/app/internal/knowledge"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+	"github.com/gemini-hackathon/app/internal/models"
+)
+
+// maxStoredVocabulary bounds the stored list: the terms the model extracted
+// plus those added only because they are in the knowledge base. It also caps
+// the terms saved in one request, since they come from that list.
+const maxStoredVocabulary = 50
+
+var jlptLevelPattern = regexp.MustCompile(`^N[1-5]$`)
+
+type ExtractVocabularyRequest struct {
+	Refresh bool `json:"refresh,omitempty"`
+}
+
+type VocabularyItemResponse struct {
+	Rank int `json:"rank"`
+	models.VocabularyItem
+}
+
+type ScanVocabularyResponse struct {
+	ScanID         int64                    `json:"scanId"`
+	TargetLanguage string                   `json:"targetLanguage"`
+	Items          []VocabularyItemResponse `json:"items"`
+	Stale          bool                     `json:"stale"`
+	CreatedAt      string                   `json:"createdAt"`
+	UpdatedAt      string                   `json:"updatedAt"`
+}
+
+type SaveVocabularyRequest struct {
+	Terms []string `json:"terms"`
+}
+
+type SaveVocabularyResponse struct {
+	Created []SavedVocabularyItem   `json:"created"`
+	Skipped []SkippedVocabularyItem `json:"skipped"`
+}
+
+type SavedVocabularyItem struct {
+	Term         string `json:"term"`
+	AnnotationID int64  `json:"annotationId"`
+}
+
+type SkippedVocabularyItem struct {
+	Term   string `json:"term"`
+	Reason string `json:"reason"`
+}
+
+// Reasons a term in SaveVocabularyRequest was not saved.
+const (
+	SkipReasonAlreadySaved = "already_saved"
+	SkipReasonNotExtracted = "not_extracted"
+)
+
+// ScanVocabularyAPI routes /v1/scans/{id}/vocabulary: GET returns the stored
+// list, POST extracts it.
+func (h *ScanHandlers) ScanVocabularyAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.GetScanVocabularyAPI(w, r)
+	case http.MethodPost:
+		h.ExtractScanVocabularyAPI(w, r)
+	default:
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// ExtractScanVocabularyAPI builds the ranked "words to learn" list of a scan.
+// The model proposes terms with a JLPT estimate; terms from the knowledge base
+// that appear in the text are added and ranked higher, since they are the
+// workplace vocabulary the app is curated for. Like translations, the list is
+// stored per language and reused until the scan text changes.
+func (h *ScanHandlers) ExtractScanVocabularyAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
+		return
+	}
+
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(scan.UserID).WithField("scan_id", scan.ID)
+
+	var req ExtractVocabularyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	text := scanText(scan)
+	if strings.TrimSpace(text) == "" {
+		h.writeJSONError(w, http.StatusConflict, "Scan text is not available yet")
+		return
+	}
+
+	targetLanguage, err := h.targetLanguage(r, scan.UserID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get user")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to extract vocabulary")
+		return
+	}
+
+	sourceHash := textHash(text)
+	existing, err := h.db.GetScanVocabulary(r.Context(), scan.ID, targetLanguage)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan vocabulary from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to extract vocabulary")
+		return
+	}
+	if existing != nil && existing.SourceHash == sourceHash && !req.Refresh {
+		log.Infof("Returning stored vocabulary: language=%s", targetLanguage)
+		h.writeVocabulary(w, existing, sourceHash)
+		return
+	}
+
+	resp, err := h.geminiClient.ExtractVocabulary(r.Context(), text, targetLanguage)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to extract vocabulary")
+		h.writeJSONError(w, http.StatusBadGateway, "Failed to extract vocabulary")
+		return
+	}
+
+	now := time.Now()
+	vocabulary := &models.ScanVocabulary{
+		ScanID:         scan.ID,
+		TargetLanguage: targetLanguage,
+		SourceHash:     sourceHash,
+		Items:          rankVocabulary(text, resp.Items, userKnowledge(r.Context(), h.db, h.knowledge, scan.UserID)),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if resp.Model != "" {
+		vocabulary.Model = &resp.Model
+	}
+
+	if err := h.db.UpsertScanVocabulary(r.Context(), vocabulary); err != nil {
+		log.ErrorWithErr(err, "Failed to save scan vocabulary")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to extract vocabulary")
+		return
+	}
+
+	log.Infof("Vocabulary extracted: language=%s, proposed=%d, items=%d", targetLanguage, len(resp.Items), len(vocabulary.Items))
+
+	h.writeVocabulary(w, vocabulary, sourceHash)
+}
+
+// GetScanVocabularyAPI returns the stored vocabulary list without calling the
+// model.
+func (h *ScanHandlers) GetScanVocabularyAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
+		return
+	}
+
+	vocabulary, ok := h.storedVocabulary(w, r, scan)
+	if !ok {
+		return
+	}
+
+	h.writeVocabulary(w, vocabulary, textHash(scanText(scan)))
+}
+
+// SaveScanVocabularyAPI saves the chosen terms of the stored vocabulary list
+// as bookmarked annotations on the scan. Only extracted terms can be saved,
+// so the annotation content comes from the server, and terms already
+// annotated on the scan are skipped.
+func (h *ScanHandlers) SaveScanVocabularyAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	scan, ok := h.ownedScan(w, r)
+	if !ok {
+		return
+	}
+
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(scan.UserID).WithField("scan_id", scan.ID)
+
+	var req SaveVocabularyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Terms) == 0 {
+		h.writeJSONError(w, http.StatusBadRequest, "terms is required")
+		return
+	}
+	if len(req.Terms) > maxStoredVocabulary {
+		h.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Too many terms. Maximum is %d per request.", maxStoredVocabulary))
+		return
+	}
+
+	vocabulary, ok := h.storedVocabulary(w, r, scan)
+	if !ok {
+		return
+	}
+
+	existing, err := h.db.GetAnnotationsByScanID(r.Context(), scan.ID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan annotations from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to save vocabulary")
+		return
+	}
+	saved := make(map[string]bool, len(existing))
+	for _, annotation := range existing {
+		saved[annotation.HighlightedText] = true
+	}
+
+	pages, err := h.db.GetScanPages(r.Context(), scan.ID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan pages from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to save vocabulary")
+		return
+	}
+
+	response := SaveVocabularyResponse{Created: []SavedVocabularyItem{}, Skipped: []SkippedVocabularyItem{}}
+	for _, term := range req.Terms {
+		index := slices.IndexFunc(vocabulary.Items, func(item models.VocabularyItem) bool { return item.Term == term })
+		if index < 0 {
+			response.Skipped = append(response.Skipped, SkippedVocabularyItem{Term: term, Reason: SkipReasonNotExtracted})
+			continue
+		}
+		if saved[term] {
+			response.Skipped = append(response.Skipped, SkippedVocabularyItem{Term: term, Reason: SkipReasonAlreadySaved})
+			continue
+		}
+
+		annotation := vocabularyAnnotation(scan, pages, vocabulary.Items[index])
+		annotationID, err := h.db.CreateAnnotation(r.Context(), annotation)
+		if err != nil {
+			log.ErrorWithErr(err, "Failed to create annotation")
+			h.writeJSONError(w, http.StatusInternalServerError, "Failed to save vocabulary")
+			return
+		}
+		saved[term] = true
+		response.Created = append(response.Created, SavedVocabularyItem{Term: term, AnnotationID: annotationID})
+	}
+
+	log.Infof("Saved vocabulary as annotations: created=%d, skipped=%d", len(response.Created), len(response.Skipped))
+
+	status := http.StatusOK
+	if len(response.Created) > 0 {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// storedVocabulary loads the vocabulary list in the user's language, writing
+// a 404 when it has not been extracted yet.
+func (h *ScanHandlers) storedVocabulary(w http.ResponseWriter, r *http.Request, scan *models.Scan) (*models.ScanVocabulary, bool) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(scan.UserID).WithField("scan_id", scan.ID)
+
+	targetLanguage, err := h.targetLanguage(r, scan.UserID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get user")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get vocabulary")
+		return nil, false
+	}
+
+	vocabulary, err := h.db.GetScanVocabulary(r.Context(), scan.ID, targetLanguage)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to get scan vocabulary from database")
+		h.writeJSONError(w, http.StatusInternalServerError, "Failed to get vocabulary")
+		return nil, false
+	}
+	if vocabulary == nil {
+		h.writeJSONError(w, http.StatusNotFound, "Vocabulary has not been extracted for this scan")
+		return nil, false
+	}
+	return vocabulary, true
+}
+
+func (h *ScanHandlers) writeVocabulary(w http.ResponseWriter, vocabulary *models.ScanVocabulary, currentHash string) {
+	items := make([]VocabularyItemResponse, len(vocabulary.Items))
+	for i, item := range vocabulary.Items {
+		items[i] = VocabularyItemResponse{Rank: i + 1, VocabularyItem: item}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ScanVocabularyResponse{
+		ScanID:         vocabulary.ScanID,
+		TargetLanguage: vocabulary.TargetLanguage,
+		Items:          items,
+		Stale:          vocabulary.SourceHash != currentHash,
+		CreatedAt:      vocabulary.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      vocabulary.UpdatedAt.Format(time.RFC3339),
+	})
+}
+
+// rankVocabulary merges the model's proposals with knowledge base terms found
+// in text and sorts them by score. Proposed terms that do not occur in the
+// text are dropped.
+func rankVocabulary(text string, proposed []gemini.VocabularyItem, knowledgeSvc knowledge.Service) []models.VocabularyItem {
+	var knowledgeEntries []knowledge.Entry
+	if knowledgeSvc != nil {
+		for _, entry := range knowledgeSvc.Lookup(text) {
+			if strings.Contains(text, entry.Kosakata) {
+				knowledgeEntries = append(knowledgeEntries, entry)
+			}
+		}
+	}
+
+	var items []models.VocabularyItem
+	seen := make(map[string]bool)
+	for _, p := range proposed {
+		term := strings.TrimSpace(p.Term)
+		if term == "" || seen[term] || !strings.Contains(text, term) {
+			continue
+		}
+		seen[term] = true
+
+		item := models.VocabularyItem{
+			Term:      term,
+			Reading:   p.Reading,
+			Meaning:   p.Meaning,
+			Kind:      p.Kind,
+			JLPTLevel: strings.ToUpper(strings.TrimSpace(p.JLPTLevel)),
+			Sentence:  p.Sentence,
+		}
+		if item.Kind != models.VocabularyKindPhrase {
+			item.Kind = models.VocabularyKindWord
+		}
+		if !jlptLevelPattern.MatchString(item.JLPTLevel) {
+			item.JLPTLevel = ""
+		}
+		for _, entry := range knowledgeEntries {
+			if entry.Kosakata == term {
+				item.InKnowledgeBase = true
+				if item.Reading == "" {
+					item.Reading = entry.Kana
+				}
+				if item.Meaning == "" {
+					item.Meaning = entry.Arti
+				}
+			}
+		}
+		items = append(items, item)
+	}
+
+	for _, entry := range knowledgeEntries {
+		if seen[entry.Kosakata] {
+			continue
+		}
+		seen[entry.Kosakata] = true
+		items = append(items, models.VocabularyItem{
+			Term:            entry.Kosakata,
+			Reading:         entry.Kana,
+			Meaning:         entry.Arti,
+			Kind:            models.VocabularyKindWord,
+			InKnowledgeBase: true,
+		})
+	}
+
+	for i := range items {
+		item := &items[i]
+		item.Occurrences = strings.Count(text, item.Term)
+		if item.Sentence == "" {
+			item.Sentence = sentenceContaining(text, item.Term)
+		}
+		item.Score = vocabularyScore(*item)
+	}
+
+	slices.SortStableFunc(items, func(a, b models.VocabularyItem) int {
+		if a.Score != b.Score {
+			return b.Score - a.Score
+		}
+		return strings.Index(text, a.Term) - strings.Index(text, b.Term)
+	})
+	if len(items) > maxStoredVocabulary {
+		items = items[:maxStoredVocabulary]
+	}
+	return items
+}
+
+// vocabularyScore ranks harder terms first, then knowledge base terms, then
+// terms that come up repeatedly. A term without a JLPT estimate counts as N3.
+func vocabularyScore(item models.VocabularyItem) int {
+	difficulty := 3
+	if item.JLPTLevel != "" {
+		difficulty = 6 - int(item.JLPTLevel[1]-'0')
+	}
+
+	score := difficulty * 2
+	if item.InKnowledgeBase {
+		score += 3
+	}
+	score += min(item.Occurrences, 4) - 1
+	return score
+}
+
+// sentenceContaining returns the sentence or line of text that contains term.
+func sentenceContaining(text, term string) string {
+	index := strings.Index(text, term)
+	if index < 0 {
+		return ""
+	}
+
+	start := strings.LastIndexAny(text[:index], "。！？!?\n")
+	if start < 0 {
+		start = 0
+	} else {
+		_, size := utf8.DecodeRuneInString(text[start:])
+		start += size
+	}
+	end := strings.IndexAny(text[index:], "。！？!?\n")
+	if end < 0 {
+		end = len(text)
+	} else {
+		end = index + end
+		if text[end] != '\n' {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+	}
+	return strings.TrimSpace(text[start:end])
+}
+
+// vocabularyAnnotation turns a vocabulary item into a bookmarked annotation,
+// placed on the first page whose text contains the term.
+func vocabularyAnnotation(scan *models.Scan, pages []*models.ScanPage, item models.VocabularyItem) *models.Annotation {
+	scanID := scan.ID
+	annotation := &models.Annotation{
+		UserID:          scan.UserID,
+		ScanID:          &scanID,
+		HighlightedText: item.Term,
+		NuanceData: models.NuanceData{
+			Meaning:      item.Meaning,
+			UsageExample: item.Sentence,
+			JLPTLevel:    item.JLPTLevel,
+			Reading:      item.Reading,
+		},
+		IsBookmarked: true,
+		CreatedAt:    time.Now(),
+	}
+	if item.Sentence != "" {
+		annotation.ContextText = &item.Sentence
+	}
+	if item.Reading != "" {
+		annotation.NuanceData.WordBreakdown = fmt.Sprintf("%s (%s)", item.Term, item.Reading)
+	}
+	if item.Kind == models.VocabularyKindPhrase {
+		annotation.NuanceData.PartOfSpeech = "expression"
+	}
+
+	if start, end, ok := textOffsets(scanText(scan), item.Term); ok {
+		annotation.StartOffset = &start
+		annotation.EndOffset = &end
+	}
+
+	for _, page := range pages {
+		if page.OCRText != nil && strings.Contains(*page.OCRText, item.Term) {
+			pageNumber := page.PageNumber
+			annotation.PageNumber = &pageNumber
+			break
+		}
+	}
+	return annotation
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/auth"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/middleware"
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// SessionResponse is one signed-in device. LastUsedAt is when its tokens
+// were last issued; Current marks the session of the calling request.
+type SessionResponse struct {
+	ID         string  `json:"id"`
+	UserAgent  *string `json:"userAgent,omitempty"`
+	IPAddress  *string `json:"ipAddress,omitempty"`
+	StartedAt  string  `json:"startedAt"`
+	LastUsedAt string  `json:"lastUsedAt"`
+	ExpiresAt  string  `json:"expiresAt"`
+	Current    bool    `json:"current"`
+}
+
+type SessionsResponse struct {
+	Data []SessionResponse `json:"data"`
+}
+
+// RefreshAPI exchanges a refresh token for a new access and refresh token.
+// Presenting a refresh token that was already exchanged signs the device out.
+func (h *AuthHandlers) RefreshAPI(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refreshToken, ok := decodeRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	tokens, err := h.sessions.Refresh(r.Context(), refreshToken, requestClient(r))
+	switch {
+	case errors.Is(err, auth.ErrRefreshTokenReused):
+		log.Warn("Refresh token reused; session revoked")
+		http.Error(w, "Unauthorized: invalid refresh token", http.StatusUnauthorized)
+		return
+	case errors.Is(err, auth.ErrRefreshTokenInvalid):
+		http.Error(w, "Unauthorized: invalid refresh token", http.StatusUnauthorized)
+		return
+	case err != nil:
+		log.ErrorWithErr(err, "Failed to refresh token")
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTokens(w, tokens, "")
+}
+
+// LogoutAPI revokes the session of a refresh token. The access token sent
+// with the request, if any, is revoked too; other access tokens issued for
+// the session remain valid until they expire.
+func (h *AuthHandlers) LogoutAPI(w http.ResponseWriter, r *http.Request) {
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context()))
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refreshToken, ok := decodeRefreshToken(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.sessions.Revoke(r.Context(), refreshToken); err != nil {
+		log.ErrorWithErr(err, "Failed to revoke session")
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+	if accessToken := middleware.ExtractToken(r); accessToken != "" {
+		if err := h.tokenService.RevokeToken(r.Context(), accessToken); err != nil {
+			log.ErrorWithErr(err, "Failed to revoke access token")
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// JWKSAPI publishes the public keys access tokens are signed with, for
+// services that verify them without sharing a secret. It is empty while
+// tokens are signed with HS256.
+func (h *AuthHandlers) JWKSAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.tokenService.JWKS())
+}
+
+// SessionsAPI lists the user's active sessions, most recently used first.
+func (h *AuthHandlers) SessionsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := h.db.GetActiveRefreshTokens(r.Context(), userID)
+	if err != nil {
+		logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(userID).ErrorWithErr(err, "Failed to get sessions")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	currentSession := middleware.GetSessionID(r.Context())
+	data := make([]SessionResponse, len(tokens))
+	for i, token := range tokens {
+		data[i] = SessionResponse{
+			ID:         token.FamilyID,
+			UserAgent:  token.UserAgent,
+			IPAddress:  token.IPAddress,
+			StartedAt:  token.SessionStartedAt.Format(time.RFC3339),
+			LastUsedAt: token.CreatedAt.Format(time.RFC3339),
+			ExpiresAt:  token.ExpiresAt.Format(time.RFC3339),
+			Current:    token.FamilyID == currentSession,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SessionsResponse{Data: data})
+}
+
+// SessionAPI handles DELETE /v1/users/me/sessions/{id}, signing that device
+// out: its refresh token is revoked and its access tokens are denied.
+func (h *AuthHandlers) SessionAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := middleware.GetUserID(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID := strings.TrimPrefix(r.URL.Path, "/v1/users/me/sessions/")
+	if sessionID == "" || strings.Contains(sessionID, "/") {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	log := logger.GetDefaultLogger().WithRequestID(middleware.GetRequestID(r.Context())).WithUserID(userID)
+	revoked, err := h.db.RevokeRefreshTokenFamily(r.Context(), userID, sessionID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to revoke session")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	// The session's access tokens stop working now, not when they expire.
+	if err := h.tokenService.RevokeSession(r.Context(), sessionID); err != nil {
+		log.ErrorWithErr(err, "Failed to revoke session access tokens")
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeRefreshToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+	if req.RefreshToken == "" {
+		http.Error(w, "refreshToken is required", http.StatusBadRequest)
+		return "", false
+	}
+	return req.RefreshToken, true
+}
+
+// requestClient describes the device making r for the session list.
+func requestClient(r *http.Request) auth.Client {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return auth.Client{UserAgent: r.UserAgent(), IPAddress: ip}
+}
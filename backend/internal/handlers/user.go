@@ -25,8 +25,12 @@ type GetLanguagesResponse struct {
 	Languages []Language `json:"languages"`
 }
 
+// GetUserProfileResponse describes the signed-in user. Role tells the
+// frontend whether to offer the admin dashboard.
 type GetUserProfileResponse struct {
 	PreferredLanguage string `json:"preferredLanguage"`
+	Role              string `json:"role"`
+	Impersonated      bool   `json:"impersonated,omitempty"`
 }
 
 type UpdateUserPreferencesRequest struct {
@@ -81,6 +85,8 @@ func (h *UserHandlers) GetUserProfileAPI(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(GetUserProfileResponse{
 		PreferredLanguage: user.PreferredLanguage,
+		Role:              user.Role,
+		Impersonated:      middleware.GetImpersonatorID(r.Context()) != 0,
 	})
 }
 
@@ -0,0 +1,125 @@
+package imageproc
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	maxSkewDegrees  = 10.0
+	skewStepDegrees = 0.25
+	skewSampleWidth = 800
+	minSkewDegrees  = 0.3
+)
+
+// detectSkew estimates the angle in degrees (counter-clockwise positive) by
+// which text lines in img are tilted. It uses a projection profile: when the
+// image is rotated back by the right angle, dark pixels concentrate into few
+// rows, which maximizes the sum of squared row counts.
+func detectSkew(img *image.NRGBA) float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+
+	step := max(w/skewSampleWidth, 1)
+
+	var sum, n int
+	for y := 0; y < h; y += step {
+		for x := 0; x < w; x += step {
+			o := img.PixOffset(x, y)
+			sum += int(luminance(img.Pix[o], img.Pix[o+1], img.Pix[o+2]))
+			n++
+		}
+	}
+	threshold := uint8(sum / n * 3 / 4)
+
+	type point struct{ x, y float64 }
+	var dark []point
+	cx, cy := float64(w)/2, float64(h)/2
+	for y := 0; y < h; y += step {
+		for x := 0; x < w; x += step {
+			o := img.PixOffset(x, y)
+			if luminance(img.Pix[o], img.Pix[o+1], img.Pix[o+2]) < threshold {
+				dark = append(dark, point{float64(x) - cx, float64(y) - cy})
+			}
+		}
+	}
+	if len(dark) == 0 {
+		return 0
+	}
+
+	diag := int(math.Hypot(float64(w), float64(h)))/step + 2
+	bins := make([]int, diag)
+
+	best, bestScore := 0.0, -1.0
+	for deg := -maxSkewDegrees; deg <= maxSkewDegrees; deg += skewStepDegrees {
+		rad := deg * math.Pi / 180
+		sin, cos := math.Sin(rad), math.Cos(rad)
+		clear(bins)
+		for _, p := range dark {
+			// Row of the point after rotating the image clockwise by deg.
+			row := int((p.x*sin+p.y*cos)/float64(step)) + diag/2
+			if row >= 0 && row < diag {
+				bins[row]++
+			}
+		}
+		var score float64
+		for _, c := range bins {
+			score += float64(c) * float64(c)
+		}
+		if score > bestScore {
+			best, bestScore = deg, score
+		}
+	}
+
+	if math.Abs(best) < minSkewDegrees {
+		return 0
+	}
+	return best
+}
+
+// rotate turns img counter-clockwise by deg around its center, keeping the
+// original dimensions and filling uncovered corners with white.
+func rotate(img *image.NRGBA, deg float64) *image.NRGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	rad := deg * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	cx, cy := float64(w-1)/2, float64(h-1)/2
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Inverse mapping: find the source pixel that lands on (x, y).
+			dx, dy := float64(x)-cx, float64(y)-cy
+			sx := dx*cos - dy*sin + cx
+			sy := dx*sin + dy*cos + cy
+			o := dst.PixOffset(x, y)
+			sampleBilinear(img, sx, sy, dst.Pix[o:o+4])
+		}
+	}
+	return dst
+}
+
+func sampleBilinear(img *image.NRGBA, x, y float64, out []uint8) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if x < 0 || y < 0 || x > float64(w-1) || y > float64(h-1) {
+		out[0], out[1], out[2], out[3] = 255, 255, 255, 255
+		return
+	}
+
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, w-1), min(y0+1, h-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	p00 := img.Pix[img.PixOffset(x0, y0):]
+	p10 := img.Pix[img.PixOffset(x1, y0):]
+	p01 := img.Pix[img.PixOffset(x0, y1):]
+	p11 := img.Pix[img.PixOffset(x1, y1):]
+	for c := 0; c < 4; c++ {
+		top := float64(p00[c])*(1-fx) + float64(p10[c])*fx
+		bottom := float64(p01[c])*(1-fx) + float64(p11[c])*fx
+		out[c] = uint8(top*(1-fy) + bottom*fy + 0.5)
+	}
+}
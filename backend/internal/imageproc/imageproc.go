@@ -0,0 +1,196 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const thumbnailQuality = 80
+
+// Options controls the preprocessing applied to uploaded photos before OCR.
+type Options struct {
+	MaxDimension    int    // longest edge in pixels; 0 disables downscaling
+	OutputFormat    string // "jpeg" or "png"
+	JPEGQuality     int
+	Deskew          bool
+	EnhanceContrast bool
+}
+
+// Result is a processed image ready to be stored and sent to OCR.
+type Result struct {
+	Data        []byte
+	MIMEType    string
+	Width       int
+	Height      int
+	Orientation int     // EXIF orientation that was applied (1 = none)
+	SkewAngle   float64 // degrees the content was rotated to straighten it
+}
+
+// Processor normalizes uploaded photos: it applies the EXIF orientation,
+// drops all metadata (including GPS), bounds the resolution and re-encodes
+// to a single canonical format.
+type Processor interface {
+	Process(data []byte, mimeType string) (*Result, error)
+	// Thumbnails returns a JPEG per size, each fitted so its longest edge
+	// is at most that many pixels.
+	Thumbnails(data []byte, sizes []int) (map[int][]byte, error)
+}
+
+type processor struct {
+	opts Options
+}
+
+func NewProcessor(opts Options) Processor {
+	if opts.OutputFormat == "" {
+		opts.OutputFormat = "jpeg"
+	}
+	if opts.JPEGQuality <= 0 || opts.JPEGQuality > 100 {
+		opts.JPEGQuality = 85
+	}
+	return &processor{opts: opts}
+}
+
+func (p *processor) Process(data []byte, mimeType string) (*Result, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	orientation := readOrientation(data)
+	img := applyOrientation(toNRGBA(src), orientation)
+
+	if p.opts.MaxDimension > 0 {
+		img = downscale(img, p.opts.MaxDimension)
+	}
+
+	var skew float64
+	if p.opts.Deskew {
+		skew = detectSkew(img)
+		if skew != 0 {
+			img = rotate(img, -skew)
+		}
+	}
+
+	if p.opts.EnhanceContrast {
+		stretchContrast(img)
+	}
+
+	// Re-encoding from decoded pixels is what strips EXIF/GPS: neither
+	// encoder writes any metadata chunks.
+	var buf bytes.Buffer
+	outMIME := "image/jpeg"
+	switch p.opts.OutputFormat {
+	case "png":
+		outMIME = "image/png"
+		err = png.Encode(&buf, img)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: p.opts.JPEGQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	return &Result{
+		Data:        buf.Bytes(),
+		MIMEType:    outMIME,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Orientation: orientation,
+		SkewAngle:   skew,
+	}, nil
+}
+
+func (p *processor) Thumbnails(data []byte, sizes []int) (map[int][]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	img := applyOrientation(toNRGBA(src), readOrientation(data))
+
+	thumbs := make(map[int][]byte, len(sizes))
+	for _, size := range sizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, downscale(img, size), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, fmt.Errorf("failed to encode %dpx thumbnail: %w", size, err)
+		}
+		thumbs[size] = buf.Bytes()
+	}
+	return thumbs, nil
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	if img, ok := src.(*image.NRGBA); ok && img.Rect.Min == (image.Point{}) {
+		return img
+	}
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// downscale shrinks img so its longest edge is at most maxDim.
+func downscale(img *image.NRGBA, maxDim int) *image.NRGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w <= maxDim && h <= maxDim {
+		return img
+	}
+
+	nw, nh := maxDim, h*maxDim/w
+	if h > w {
+		nw, nh = w*maxDim/h, maxDim
+	}
+	nw, nh = max(nw, 1), max(nh, 1)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, nw, nh))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// stretchContrast performs an auto-levels pass: the 1st and 99th luminance
+// percentiles are stretched to black and white.
+func stretchContrast(img *image.NRGBA) {
+	var hist [256]int
+	pix := img.Pix
+	for i := 0; i < len(pix); i += 4 {
+		hist[luminance(pix[i], pix[i+1], pix[i+2])]++
+	}
+
+	total := len(pix) / 4
+	lo, hi := percentile(hist, total, 0.01), percentile(hist, total, 0.99)
+	if hi-lo < 16 || (lo == 0 && hi == 255) {
+		return
+	}
+
+	var lut [256]uint8
+	for v := range lut {
+		scaled := (v - lo) * 255 / (hi - lo)
+		lut[v] = uint8(min(max(scaled, 0), 255))
+	}
+	for i := 0; i < len(pix); i += 4 {
+		pix[i], pix[i+1], pix[i+2] = lut[pix[i]], lut[pix[i+1]], lut[pix[i+2]]
+	}
+}
+
+func percentile(hist [256]int, total int, p float64) int {
+	target := int(float64(total) * p)
+	seen := 0
+	for v, count := range hist {
+		seen += count
+		if seen > target {
+			return v
+		}
+	}
+	return 255
+}
+
+func luminance(r, g, b uint8) uint8 {
+	return uint8((299*int(r) + 587*int(g) + 114*int(b)) / 1000)
+}
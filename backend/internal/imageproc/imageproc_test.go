@@ -0,0 +1,358 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+// jpegWithOrientation encodes img as JPEG and injects an APP1 Exif segment
+// carrying the given orientation and a GPS IFD pointer.
+func jpegWithOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("MM")
+	binary.Write(tiff, binary.BigEndian, uint16(42))
+	binary.Write(tiff, binary.BigEndian, uint32(8))
+	binary.Write(tiff, binary.BigEndian, uint16(2))
+	// Orientation: tag, SHORT, count 1, value
+	binary.Write(tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(tiff, binary.BigEndian, uint32(1))
+	binary.Write(tiff, binary.BigEndian, []uint16{orientation, 0})
+	// GPSInfo IFD pointer
+	binary.Write(tiff, binary.BigEndian, []uint16{0x8825, 4})
+	binary.Write(tiff, binary.BigEndian, []uint32{1, 0})
+	binary.Write(tiff, binary.BigEndian, uint32(0))
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	jpg := buf.Bytes()
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func TestReadOrientation(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for _, o := range []uint16{1, 3, 6, 8} {
+		data := jpegWithOrientation(t, img, o)
+		if got := readOrientation(data); got != int(o) {
+			t.Errorf("readOrientation() = %d; want %d", got, o)
+		}
+	}
+
+	if got := readOrientation([]byte("not an image")); got != 1 {
+		t.Errorf("readOrientation(garbage) = %d; want 1", got)
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// 2x1 image: red on the left, blue on the right.
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	img.SetNRGBA(0, 0, red)
+	img.SetNRGBA(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		first       color.NRGBA // pixel at (0,0)
+	}{
+		{orientation: 1, w: 2, h: 1, first: red},
+		{orientation: 2, w: 2, h: 1, first: blue},
+		{orientation: 3, w: 2, h: 1, first: blue},
+		{orientation: 6, w: 1, h: 2, first: red},
+		{orientation: 8, w: 1, h: 2, first: blue},
+	}
+
+	for _, tt := range tests {
+		got := applyOrientation(img, tt.orientation)
+		if got.Rect.Dx() != tt.w || got.Rect.Dy() != tt.h {
+			t.Errorf("orientation %d: size %dx%d; want %dx%d", tt.orientation, got.Rect.Dx(), got.Rect.Dy(), tt.w, tt.h)
+			continue
+		}
+		if c := got.NRGBAAt(0, 0); c != tt.first {
+			t.Errorf("orientation %d: pixel (0,0) = %v; want %v", tt.orientation, c, tt.first)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	data := jpegWithOrientation(t, img, 6)
+
+	p := NewProcessor(Options{MaxDimension: 100})
+	result, err := p.Process(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	// Rotated 90 degrees then bounded to 100px on the long edge.
+	if result.Width != 50 || result.Height != 100 {
+		t.Errorf("Process() size = %dx%d; want 50x100", result.Width, result.Height)
+	}
+	if result.MIMEType != "image/jpeg" {
+		t.Errorf("Process() MIMEType = %q; want image/jpeg", result.MIMEType)
+	}
+	if result.Orientation != 6 {
+		t.Errorf("Process() Orientation = %d; want 6", result.Orientation)
+	}
+	if bytes.Contains(result.Data, []byte("Exif")) {
+		t.Error("Processed image should not contain EXIF metadata")
+	}
+	if readOrientation(result.Data) != 1 {
+		t.Error("Processed image should have no orientation tag")
+	}
+}
+
+func TestProcess_InvalidImage(t *testing.T) {
+	p := NewProcessor(Options{})
+	if _, err := p.Process([]byte("not an image"), "image/jpeg"); err == nil {
+		t.Error("Expected error for undecodable input")
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	data := jpegWithOrientation(t, img, 6)
+	xmp := append([]byte{0xFF, 0xE1, 0, 0}, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"...)
+	binary.BigEndian.PutUint16(xmp[2:], uint16(len(xmp)-2))
+	data = append(append(bytes.Clone(data[:2]), xmp...), data[2:]...)
+	// A phone-style preview appended after the image carries its own Exif.
+	data = append(data, jpegWithOrientation(t, img, 1)...)
+
+	stripped, err := StripMetadata(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("StripMetadata() error = %v", err)
+	}
+	for _, b := range [][]byte{{0x88, 0x25}, []byte("xmpmeta")} {
+		if bytes.Contains(stripped, b) {
+			t.Errorf("Stripped JPEG still contains %q", b)
+		}
+	}
+	if got := readOrientation(stripped); got != 6 {
+		t.Errorf("Stripped JPEG orientation = %d; want 6", got)
+	}
+	if _, err := Validate(stripped, "image/jpeg", Limits{}); err != nil {
+		t.Errorf("Validate() on stripped JPEG error = %v", err)
+	}
+
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	text := []byte("tEXtLocation\x0035.68,139.76")
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)-4))
+	chunk = append(append(chunk, text...), 0, 0, 0, 0)
+	pngData := append(append(bytes.Clone(pngBuf.Bytes()[:33]), chunk...), pngBuf.Bytes()[33:]...)
+
+	stripped, err = StripMetadata(pngData, "image/png")
+	if err != nil {
+		t.Fatalf("StripMetadata() error = %v", err)
+	}
+	if !bytes.Equal(stripped, pngBuf.Bytes()) {
+		t.Error("Stripped PNG should equal the PNG without its text chunk")
+	}
+
+	if _, err := StripMetadata(data[:len(data)/4], "image/jpeg"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("StripMetadata() on a truncated JPEG error = %v; want ErrCorrupt", err)
+	}
+}
+
+func TestDeskew(t *testing.T) {
+	// White page with evenly spaced horizontal black "text lines".
+	img := image.NewNRGBA(image.Rect(0, 0, 600, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 600; x++ {
+			v := uint8(255)
+			if y%40 < 6 && x > 50 && x < 550 {
+				v = 0
+			}
+			o := img.PixOffset(x, y)
+			img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = v, v, v, 255
+		}
+	}
+
+	if got := detectSkew(img); got != 0 {
+		t.Errorf("detectSkew(straight) = %v; want 0", got)
+	}
+
+	skewed := rotate(img, 3)
+	got := detectSkew(skewed)
+	if math.Abs(got-3) > 0.5 {
+		t.Errorf("detectSkew(rotated 3deg) = %v; want ~3", got)
+	}
+
+	if residual := detectSkew(rotate(skewed, -got)); residual != 0 {
+		t.Errorf("detectSkew(deskewed) = %v; want 0", residual)
+	}
+}
+
+func TestStretchContrast(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for i := 0; i < len(img.Pix); i += 4 {
+		v := uint8(100)
+		if i/4%2 == 0 {
+			v = 150
+		}
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = v, v, v, 255
+	}
+
+	stretchContrast(img)
+
+	if img.Pix[0] != 255 || img.Pix[4] != 0 {
+		t.Errorf("stretchContrast() = %d, %d; want 255, 0", img.Pix[0], img.Pix[4])
+	}
+}
+
+func TestThumbnails(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 800, 400))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+
+	thumbs, err := NewProcessor(Options{}).Thumbnails(buf.Bytes(), []int{100, 1000})
+	if err != nil {
+		t.Fatalf("Thumbnails() error = %v", err)
+	}
+
+	want := map[int]image.Point{100: {100, 50}, 1000: {800, 400}}
+	for size, dims := range want {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumbs[size]))
+		if err != nil {
+			t.Fatalf("Failed to decode %dpx thumbnail: %v", size, err)
+		}
+		if cfg.Width != dims.X || cfg.Height != dims.Y {
+			t.Errorf("%dpx thumbnail = %dx%d; want %dx%d", size, cfg.Width, cfg.Height, dims.X, dims.Y)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var jpegBuf, pngBuf bytes.Buffer
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	if err := jpeg.Encode(&jpegBuf, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	jpegData, pngData := jpegBuf.Bytes(), pngBuf.Bytes()
+	pdfData := []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+
+	tests := []struct {
+		name     string
+		data     []byte
+		declared string
+		limits   Limits
+		want     string
+		wantErr  error
+	}{
+		{"jpeg", jpegData, "image/jpeg", Limits{}, MIMETypeJPEG, nil},
+		{"jpg alias", jpegData, "image/jpg", Limits{}, MIMETypeJPEG, nil},
+		{"png undeclared", pngData, "", Limits{}, MIMETypePNG, nil},
+		{"pdf", pdfData, "application/pdf", Limits{}, MIMETypePDF, nil},
+		{"unknown", []byte("GIF89a......"), "image/gif", Limits{}, "", ErrUnsupportedFormat},
+		{"mismatch", pngData, "image/jpeg", Limits{}, "", ErrTypeMismatch},
+		{"appended zip", append(bytes.Clone(jpegData), "PK\x03\x04zipdata"...), "image/jpeg", Limits{}, "", ErrPolyglot},
+		{"appended jpeg", append(bytes.Clone(jpegData), jpegData...), "image/jpeg", Limits{}, MIMETypeJPEG, nil},
+		{"appended truncated jpeg", append(bytes.Clone(jpegData), jpegData[:len(jpegData)/2]...), "image/jpeg", Limits{}, "", ErrCorrupt},
+		{"zip after appended jpeg", append(append(bytes.Clone(jpegData), jpegData...), "PK\x03\x04zipdata"...), "image/jpeg", Limits{}, "", ErrPolyglot},
+		{"appended to png", append(bytes.Clone(pngData), "trailing"...), "image/png", Limits{}, "", ErrPolyglot},
+		{"padding allowed", append(bytes.Clone(pngData), 0, 0, '\n'), "image/png", Limits{}, MIMETypePNG, nil},
+		{"pdf after eof", append(bytes.Clone(pdfData), "<html>"...), "application/pdf", Limits{}, "", ErrPolyglot},
+		{"truncated", jpegData[:len(jpegData)/2], "image/jpeg", Limits{}, "", ErrCorrupt},
+		{"too wide", pngData, "image/png", Limits{MaxSide: 50}, "", ErrDimensionsTooLarge},
+		{"too many pixels", pngData, "image/png", Limits{MaxPixels: 1000}, "", ErrDimensionsTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Validate(tt.data, tt.declared, tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v; want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Validate() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate_PolyglotMarker(t *testing.T) {
+	// A PNG whose text chunk carries markup is rejected even though the
+	// image itself is well-formed.
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	data := buf.Bytes()
+
+	text := []byte("tEXtComment\x00<script>alert(1)</script>")
+	chunk := make([]byte, 4, 4+len(text)+4)
+	binary.BigEndian.PutUint32(chunk, uint32(len(text)-4))
+	chunk = append(chunk, text...)
+	chunk = append(chunk, 0, 0, 0, 0)
+
+	polyglot := append(bytes.Clone(data[:33]), chunk...)
+	polyglot = append(polyglot, data[33:]...)
+
+	if _, err := Validate(polyglot, "image/png", Limits{}); !errors.Is(err, ErrPolyglot) {
+		t.Errorf("Validate() error = %v; want ErrPolyglot", err)
+	}
+}
+
+func TestValidate_NoisyJPEG(t *testing.T) {
+	// Compressed pixel data is effectively random, so a large photo can
+	// contain marker bytes by chance. Only metadata is searched.
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewRGBA(image.Rect(0, 0, 1200, 1200))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Uint32())
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	data := buf.Bytes()
+	if len(data) < 1<<20 {
+		t.Fatalf("encoded %d bytes; want a JPEG of at least 1 MiB", len(data))
+	}
+
+	// Plant a marker in the middle of the entropy-coded data.
+	noisy := bytes.Clone(data)
+	copy(noisy[len(noisy)/2:], "<SVG")
+	if _, err := Validate(noisy, "image/jpeg", Limits{}); err != nil {
+		t.Errorf("Validate() error = %v; want nil", err)
+	}
+
+	// The same marker in a comment segment is still rejected.
+	comment := []byte("<svg onload=alert(1)>")
+	segment := []byte{0xFF, 0xFE, 0, byte(len(comment) + 2)}
+	polyglot := append(bytes.Clone(data[:2]), append(segment, comment...)...)
+	polyglot = append(polyglot, data[2:]...)
+	if _, err := Validate(polyglot, "image/jpeg", Limits{}); !errors.Is(err, ErrPolyglot) {
+		t.Errorf("Validate() error = %v; want ErrPolyglot", err)
+	}
+}
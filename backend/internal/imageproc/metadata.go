@@ -0,0 +1,142 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// StripMetadata removes EXIF (including GPS), XMP, IPTC and text metadata
+// from an image without re-encoding it, for uploads that are stored as they
+// were sent. Data after the end of the image, such as the previews phones
+// append to JPEGs, is dropped too. A JPEG keeps its orientation so it still
+// displays upright.
+func StripMetadata(data []byte, mimeType string) ([]byte, error) {
+	var out []byte
+	var err error
+	switch mimeType {
+	case MIMETypeJPEG:
+		out, err = stripJPEG(data)
+	case MIMETypePNG:
+		out, err = stripPNG(data)
+	case MIMETypeWebP:
+		out, err = stripWebP(data)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return out, nil
+}
+
+// stripJPEG keeps only the JFIF, ICC profile and Adobe segments ahead of
+// the image data, and replaces the Exif segment with one holding just the
+// orientation.
+func stripJPEG(data []byte) ([]byte, error) {
+	end, err := jpegEnd(data)
+	if err != nil {
+		return nil, err
+	}
+	orientation := readOrientation(data)
+
+	out := []byte{0xFF, 0xD8}
+	if orientation != 1 {
+		out = append(out, orientationSegment(orientation)...)
+	}
+	pos := 2
+	for pos+4 <= end {
+		marker := data[pos+1]
+		if data[pos] != 0xFF || marker == 0xDA {
+			break
+		}
+		segEnd := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if segEnd > end {
+			return nil, fmt.Errorf("truncated segment")
+		}
+		payload := data[pos+4 : segEnd]
+		isMetadata := marker == 0xFE || (marker >= 0xE1 && marker <= 0xEF)
+		keep := !isMetadata ||
+			(marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))) ||
+			(marker == 0xEE && bytes.HasPrefix(payload, []byte("Adobe")))
+		if keep {
+			out = append(out, data[pos:segEnd]...)
+		}
+		pos = segEnd
+	}
+	return append(out, data[pos:end]...), nil
+}
+
+// orientationSegment returns an APP1 Exif segment whose IFD0 only holds the
+// orientation tag.
+func orientationSegment(orientation int) []byte {
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("MM")
+	binary.Write(tiff, binary.BigEndian, uint16(42))
+	binary.Write(tiff, binary.BigEndian, uint32(8))
+	binary.Write(tiff, binary.BigEndian, uint16(1))
+	// Orientation: tag, SHORT, count 1, value
+	binary.Write(tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(tiff, binary.BigEndian, uint32(1))
+	binary.Write(tiff, binary.BigEndian, []uint16{uint16(orientation), 0})
+	binary.Write(tiff, binary.BigEndian, uint32(0))
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// pngMetadataChunks are the ancillary chunks that may carry EXIF, XMP or
+// free text.
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPNG(data []byte) ([]byte, error) {
+	end, err := pngEnd(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.Clone(data[:8])
+	for pos := 8; pos < end; {
+		chunkEnd := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+		if !pngMetadataChunks[string(data[pos+4:pos+8])] {
+			out = append(out, data[pos:chunkEnd]...)
+		}
+		pos = chunkEnd
+	}
+	return out, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks, clears their flags in the VP8X
+// header and rewrites the RIFF size.
+func stripWebP(data []byte) ([]byte, error) {
+	end, err := webpEnd(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.Clone(data[:12])
+	for pos := 12; pos+8 <= end; {
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		chunkEnd := min(pos+8+size+size%2, end)
+		if chunkEnd < pos+8 {
+			return nil, fmt.Errorf("truncated %s chunk", fourCC)
+		}
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := bytes.Clone(data[pos:chunkEnd])
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[pos:chunkEnd]...)
+		}
+		pos = chunkEnd
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
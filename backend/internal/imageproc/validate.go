@@ -0,0 +1,292 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+const (
+	MIMETypeJPEG = "image/jpeg"
+	MIMETypePNG  = "image/png"
+	MIMETypeWebP = "image/webp"
+	MIMETypePDF  = "application/pdf"
+)
+
+// Validation errors. Handlers map these to the error codes returned to
+// clients, so each one stands for a distinct rejection reason.
+var (
+	ErrUnsupportedFormat  = errors.New("unsupported file format")
+	ErrTypeMismatch       = errors.New("declared content type does not match file contents")
+	ErrPolyglot           = errors.New("file contains data of another format")
+	ErrDimensionsTooLarge = errors.New("image dimensions exceed the allowed limit")
+	ErrCorrupt            = errors.New("file could not be decoded")
+)
+
+// Limits bounds the decoded size of an uploaded image. Checking the header
+// before decoding keeps a small, highly compressed file from expanding into
+// gigabytes of pixels.
+type Limits struct {
+	MaxSide   int // widest allowed width or height in pixels
+	MaxPixels int // largest allowed width*height
+}
+
+// markers that must never show up in the metadata of an uploaded image. They
+// indicate a file crafted to also be interpreted as a document or script.
+// Pixel data is not searched: compressed data is effectively random and
+// contains these byte sequences by chance in a few percent of photos.
+var polyglotMarkers = [][]byte{
+	[]byte("%PDF-"),
+	[]byte("<script"),
+	[]byte("<html"),
+	[]byte("<?php"),
+	[]byte("<svg"),
+}
+
+// DetectFormat returns the MIME type implied by the magic bytes at the start
+// of data, or "" if it is not one of the accepted formats.
+func DetectFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return MIMETypeJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return MIMETypePNG
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return MIMETypeWebP
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return MIMETypePDF
+	default:
+		return ""
+	}
+}
+
+// Validate checks that data really is the kind of file the client declared
+// and that it is safe to hand to the decoders. It returns the detected MIME
+// type. An empty or application/octet-stream declaredType accepts whatever
+// format is detected.
+func Validate(data []byte, declaredType string, limits Limits) (string, error) {
+	detected := DetectFormat(data)
+	if detected == "" {
+		return "", ErrUnsupportedFormat
+	}
+
+	if declaredType == "image/jpg" {
+		declaredType = MIMETypeJPEG
+	}
+	if declaredType != "" && declaredType != "application/octet-stream" && declaredType != detected {
+		return "", fmt.Errorf("%w: declared %s, detected %s", ErrTypeMismatch, declaredType, detected)
+	}
+
+	if detected == MIMETypePDF {
+		if err := checkPDFTrailer(data); err != nil {
+			return "", err
+		}
+		return detected, nil
+	}
+
+	if err := checkImageEnd(data, detected); err != nil {
+		return "", err
+	}
+	for _, segment := range metadataSegments(data, detected) {
+		lower := bytes.ToLower(segment)
+		for _, marker := range polyglotMarkers {
+			if bytes.Contains(lower, marker) {
+				return "", fmt.Errorf("%w: found %q", ErrPolyglot, marker)
+			}
+		}
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return "", fmt.Errorf("%w: empty image", ErrCorrupt)
+	}
+	if limits.MaxSide > 0 && (cfg.Width > limits.MaxSide || cfg.Height > limits.MaxSide) {
+		return "", fmt.Errorf("%w: %dx%d", ErrDimensionsTooLarge, cfg.Width, cfg.Height)
+	}
+	if limits.MaxPixels > 0 && cfg.Width*cfg.Height > limits.MaxPixels {
+		return "", fmt.Errorf("%w: %dx%d", ErrDimensionsTooLarge, cfg.Width, cfg.Height)
+	}
+
+	return detected, nil
+}
+
+// checkImageEnd rejects images with data appended after the end of the
+// image stream, the usual way of gluing a second file onto an image. JPEGs
+// may be followed by further complete JPEGs: phones append previews and
+// depth maps that way (CIPA Multi-Picture Format).
+func checkImageEnd(data []byte, mimeType string) error {
+	var end int
+	var err error
+	switch mimeType {
+	case MIMETypeJPEG:
+		end, err = jpegEnd(data)
+		for err == nil && bytes.HasPrefix(data[end:], []byte{0xFF, 0xD8, 0xFF}) {
+			var next int
+			next, err = jpegEnd(data[end:])
+			end += next
+		}
+	case MIMETypePNG:
+		end, err = pngEnd(data)
+	case MIMETypeWebP:
+		end, err = webpEnd(data)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	if len(bytes.Trim(data[end:], "\x00\r\n\t ")) > 0 {
+		return fmt.Errorf("%w: %d bytes after end of image", ErrPolyglot, len(data)-end)
+	}
+	return nil
+}
+
+// metadataSegments returns the parts of an image that carry metadata or
+// comments rather than pixels: JPEG APPn and COM segments (of every JPEG in
+// the file), PNG ancillary chunks and WebP EXIF and XMP chunks. The file's
+// structure must already have been checked by checkImageEnd.
+func metadataSegments(data []byte, mimeType string) [][]byte {
+	var segments [][]byte
+	switch mimeType {
+	case MIMETypeJPEG:
+		for start := 0; bytes.HasPrefix(data[start:], []byte{0xFF, 0xD8, 0xFF}); {
+			segments = append(segments, jpegMetadata(data[start:])...)
+			end, err := jpegEnd(data[start:])
+			if err != nil {
+				break
+			}
+			start += end
+		}
+	case MIMETypePNG:
+		for pos := 8; pos+12 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[pos:]))
+			end := pos + 12 + length
+			if length < 0 || end > len(data) {
+				break
+			}
+			// Ancillary chunk types start with a lowercase letter.
+			if data[pos+4]&0x20 != 0 {
+				segments = append(segments, data[pos+4:pos+8+length])
+			}
+			pos = end
+		}
+	case MIMETypeWebP:
+		for pos := 12; pos+8 <= len(data); {
+			size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+			end := pos + 8 + size
+			if size < 0 || end > len(data) {
+				break
+			}
+			if fourCC := string(data[pos : pos+4]); fourCC == "EXIF" || fourCC == "XMP " {
+				segments = append(segments, data[pos+8:end])
+			}
+			pos = end + size%2
+		}
+	}
+	return segments
+}
+
+// jpegMetadata returns the APPn and COM segments before the first scan.
+func jpegMetadata(data []byte) [][]byte {
+	var segments [][]byte
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		marker := data[pos+1]
+		switch {
+		case marker == 0xFF:
+			pos++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			return segments
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			pos += 2
+			continue
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end > len(data) {
+			break
+		}
+		if marker == 0xFE || (marker >= 0xE0 && marker <= 0xEF) {
+			segments = append(segments, data[pos+4:end])
+		}
+		pos = end
+	}
+	return segments
+}
+
+// checkPDFTrailer rejects PDFs with content after the final %%EOF.
+func checkPDFTrailer(data []byte) error {
+	idx := bytes.LastIndex(data, []byte("%%EOF"))
+	if idx < 0 {
+		return fmt.Errorf("%w: missing %%%%EOF", ErrCorrupt)
+	}
+	if len(bytes.TrimSpace(data[idx+len("%%EOF"):])) > 0 {
+		return fmt.Errorf("%w: data after end of document", ErrPolyglot)
+	}
+	return nil
+}
+
+// jpegEnd walks the JPEG marker segments and entropy-coded scans and returns
+// the offset just past the EOI marker.
+func jpegEnd(data []byte) (int, error) {
+	pos := 2
+	for pos+1 < len(data) {
+		if data[pos] != 0xFF {
+			return 0, fmt.Errorf("expected marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		pos += 2
+
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker.
+			pos--
+		case marker == 0xD9:
+			return pos, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Standalone markers without a length.
+		default:
+			if pos+2 > len(data) {
+				return 0, fmt.Errorf("truncated segment")
+			}
+			pos += int(binary.BigEndian.Uint16(data[pos:]))
+			if marker == 0xDA {
+				// Skip entropy-coded data up to the next real marker.
+				for pos+1 < len(data) && (data[pos] != 0xFF || data[pos+1] == 0x00 || (data[pos+1] >= 0xD0 && data[pos+1] <= 0xD7)) {
+					pos++
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("missing end of image marker")
+}
+
+// pngEnd walks the PNG chunks and returns the offset just past IEND.
+func pngEnd(data []byte) (int, error) {
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		pos += 12 + length
+		if length < 0 || pos > len(data) {
+			return 0, fmt.Errorf("truncated %s chunk", chunkType)
+		}
+		if chunkType == "IEND" {
+			return pos, nil
+		}
+	}
+	return 0, fmt.Errorf("missing IEND chunk")
+}
+
+// webpEnd returns the end of the RIFF container as declared in its header.
+func webpEnd(data []byte) (int, error) {
+	size := int(binary.LittleEndian.Uint32(data[4:8]))
+	end := 8 + size + size%2
+	if size < 4 || end > len(data)+size%2 {
+		return 0, fmt.Errorf("RIFF size %d does not match file size %d", size, len(data))
+	}
+	return min(end, len(data)), nil
+}
@@ -221,3 +221,30 @@ func TestNewEmptyService(t *testing.T) {
 		t.Errorf("Empty service should return no results, got %d", len(results))
 	}
 }
+
+func TestMerge(t *testing.T) {
+	glossary := NewEntriesService([]Entry{
+		{Kosakata: "請求", Arti: "billing (team usage)"},
+		{Kosakata: "稟議", Arti: "approval request"},
+		{Arti: "skipped without a term"},
+	})
+	base := NewEntriesService([]Entry{
+		{Kosakata: "請求", Arti: "claim"},
+		{Kosakata: "請求書", Arti: "invoice"},
+	})
+	svc := Merge(glossary, nil, base)
+
+	results := svc.Lookup("請求書と稟議")
+	var terms []string
+	for _, r := range results {
+		terms = append(terms, r.Kosakata+"="+r.Arti)
+	}
+	expected := []string{"請求=billing (team usage)", "稟議=approval request", "請求書=invoice"}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("Lookup() = %v; want %v", terms, expected)
+	}
+
+	if results := Merge().Lookup("請求"); len(results) != 0 {
+		t.Errorf("Empty merge should return no results, got %d", len(results))
+	}
+}
@@ -0,0 +1,50 @@
+package knowledge
+
+// NewEntriesService creates a knowledge service from entries held in memory,
+// such as an organization's glossary. Entries without Kosakata are skipped.
+func NewEntriesService(entries []Entry) Service {
+	svc := &csvService{
+		entries: make([]Entry, 0, len(entries)),
+		index:   make(map[string]*Entry),
+	}
+	for _, entry := range entries {
+		if entry.Kosakata != "" {
+			svc.entries = append(svc.entries, entry)
+		}
+	}
+	for i := range svc.entries {
+		svc.index[svc.entries[i].Kosakata] = &svc.entries[i]
+	}
+	return svc
+}
+
+// mergedService looks terms up in several services in turn.
+type mergedService []Service
+
+// Merge combines services into one whose Lookup returns the results of each
+// in order, leaving out terms an earlier service already returned, so the
+// first service's definitions win. Nil services are skipped.
+func Merge(services ...Service) Service {
+	var merged mergedService
+	for _, svc := range services {
+		if svc != nil {
+			merged = append(merged, svc)
+		}
+	}
+	return merged
+}
+
+func (m mergedService) Lookup(text string) []Entry {
+	var results []Entry
+	seen := make(map[string]bool)
+	for _, svc := range m {
+		for _, entry := range svc.Lookup(text) {
+			if seen[entry.Kosakata] {
+				continue
+			}
+			seen[entry.Kosakata] = true
+			results = append(results, entry)
+		}
+	}
+	return results
+}
@@ -2,18 +2,36 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gemini-hackathon/app/internal/auth"
+	"github.com/gemini-hackathon/app/internal/logger"
+	"github.com/gemini-hackathon/app/internal/models"
 )
 
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey         contextKey = "userID"
+	sessionIDKey      contextKey = "sessionID"
+	userKey           contextKey = "user"
+	impersonatorIDKey contextKey = "impersonatorID"
+)
+
+// UserStore is the part of storage.DB the middleware uses to load users and
+// audit impersonation.
+type UserStore interface {
+	GetUserByID(ctx context.Context, userID int64) (*models.User, error)
+	CreateAuditEntry(ctx context.Context, entry *models.AuditEntry) (int64, error)
+}
 
 type AuthMiddleware struct {
 	tokenService *auth.TokenService
+	urlSigner    *auth.URLSigner
+	users        UserStore
 }
 
 func NewAuthMiddleware(tokenService *auth.TokenService) *AuthMiddleware {
@@ -22,26 +40,130 @@ func NewAuthMiddleware(tokenService *auth.TokenService) *AuthMiddleware {
 	}
 }
 
+// WithURLSigner lets GET/HEAD requests without a token authenticate with a
+// signed URL issued by signer instead.
+func (m *AuthMiddleware) WithURLSigner(signer *auth.URLSigner) *AuthMiddleware {
+	m.urlSigner = signer
+	return m
+}
+
+// WithUsers loads the user of every request from users, for GetUser and
+// RequireRole. Requests of unknown users are rejected with 401 and those of
+// disabled accounts with 403. Requests made while impersonating that can
+// change data are added to the audit log first.
+func (m *AuthMiddleware) WithUsers(users UserStore) *AuthMiddleware {
+	m.users = users
+	return m
+}
+
 func (m *AuthMiddleware) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := extractToken(r)
+		token := ExtractToken(r)
+		if token == "" && m.isSignedRequest(r) {
+			userID, err := m.urlSigner.Verify(r.URL.Path, r.URL.Query())
+			if err != nil {
+				http.Error(w, "Unauthorized: invalid signature", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userIDKey, userID)
+			m.serveUser(w, r.WithContext(ctx), next)
+			return
+		}
+
 		if token == "" {
 			http.Error(w, "Unauthorized: missing token", http.StatusUnauthorized)
 			return
 		}
 
-		userID, err := m.tokenService.ValidateToken(token)
+		claims, err := m.tokenService.Authenticate(r.Context(), token)
+		if errors.Is(err, auth.ErrDenylistUnavailable) {
+			logger.GetDefaultLogger().WithRequestID(GetRequestID(r.Context())).ErrorWithErr(err, "Failed to check token denylist")
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, auth.ErrTokenRevoked) {
+			http.Error(w, "Unauthorized: token revoked", http.StatusUnauthorized)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Unauthorized: invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userIDKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+		if claims.SessionID != "" {
+			ctx = context.WithValue(ctx, sessionIDKey, claims.SessionID)
+		}
+		if claims.ImpersonatorID != 0 {
+			ctx = context.WithValue(ctx, impersonatorIDKey, claims.ImpersonatorID)
+		}
+		m.serveUser(w, r.WithContext(ctx), next)
 	})
 }
 
-func extractToken(r *http.Request) string {
+// serveUser loads the authenticated user, when WithUsers is set, and serves
+// the request if the account may make it.
+func (m *AuthMiddleware) serveUser(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if m.users == nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	userID := GetUserID(ctx)
+	log := logger.GetDefaultLogger().WithRequestID(GetRequestID(ctx)).WithUserID(userID)
+
+	user, err := m.users.GetUserByID(ctx, userID)
+	if err != nil {
+		log.ErrorWithErr(err, "Failed to load user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Unauthorized: unknown user", http.StatusUnauthorized)
+		return
+	}
+	if user.DisabledAt != nil {
+		http.Error(w, "Forbidden: account disabled", http.StatusForbidden)
+		return
+	}
+
+	if impersonatorID := GetImpersonatorID(ctx); impersonatorID != 0 && !isSafeMethod(r.Method) {
+		_, err := m.users.CreateAuditEntry(ctx, &models.AuditEntry{
+			ActorUserID:  impersonatorID,
+			Action:       models.AuditActionImpersonatedRequest,
+			TargetUserID: &userID,
+			Details:      map[string]any{"method": r.Method, "path": r.URL.Path},
+			CreatedAt:    time.Now(),
+		})
+		if err != nil {
+			log.ErrorWithErr(err, "Failed to audit impersonated request")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, userKey, user)))
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func (m *AuthMiddleware) isSignedRequest(r *http.Request) bool {
+	if m.urlSigner == nil {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return r.URL.Query().Get("sig") != ""
+}
+
+// ExtractToken returns the access token sent in the x-token header or as a
+// bearer token, or "".
+func ExtractToken(r *http.Request) string {
 	authHeader := r.Header.Get("x-token")
 	if authHeader != "" {
 		return authHeader
@@ -68,3 +190,12 @@ func GetUserID(ctx context.Context) int64 {
 func WithUserID(ctx context.Context, userID int64) context.Context {
 	return context.WithValue(ctx, userIDKey, userID)
 }
+
+// GetSessionID returns the session the request's access token was issued
+// for, or "" when it has none.
+func GetSessionID(ctx context.Context) string {
+	if id, ok := ctx.Value(sessionIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
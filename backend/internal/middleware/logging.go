@@ -30,6 +30,12 @@ func (rw *responseWriter) Write(body []byte) (int, error) {
 	return rw.ResponseWriter.Write(body)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streaming handlers can flush through the middleware.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
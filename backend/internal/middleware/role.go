@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/gemini-hackathon/app/internal/models"
+)
+
+// RequireRole lets through requests of users with one of roles and rejects
+// the rest with 403. It relies on AuthMiddleware.WithUsers having loaded the
+// user.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := GetUser(r.Context())
+			if user == nil || !slices.Contains(roles, user.Role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetUser returns the user loaded by AuthMiddleware.WithUsers, or nil.
+func GetUser(ctx context.Context) *models.User {
+	if user, ok := ctx.Value(userKey).(*models.User); ok {
+		return user
+	}
+	return nil
+}
+
+// WithUser returns ctx carrying user as the authenticated user.
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	ctx = context.WithValue(ctx, userIDKey, user.ID)
+	return context.WithValue(ctx, userKey, user)
+}
+
+// GetImpersonatorID returns the admin impersonating the user of the request,
+// or 0 when nobody is.
+func GetImpersonatorID(ctx context.Context) int64 {
+	if id, ok := ctx.Value(impersonatorIDKey).(int64); ok {
+		return id
+	}
+	return 0
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Actions recorded in the admin audit log.
+const (
+	AuditActionSetRole             = "set_role"
+	AuditActionDisableUser         = "disable_user"
+	AuditActionEnableUser          = "enable_user"
+	AuditActionImpersonate         = "impersonate"
+	AuditActionImpersonatedRequest = "impersonated_request"
+)
+
+// AuditEntry is one admin action. TargetUserID is the user it affected;
+// Details holds action-specific fields such as the reason given.
+type AuditEntry struct {
+	ID           int64
+	ActorUserID  int64
+	Action       string
+	TargetUserID *int64
+	Details      map[string]any
+	CreatedAt    time.Time
+}
+
+// UsageStats counts users and their activity. The New and Active counts are
+// since the start of the period the stats were requested for.
+type UsageStats struct {
+	Users          int
+	UsersByRole    map[string]int
+	DisabledUsers  int
+	NewUsers       int
+	ActiveUsers    int
+	Scans          int
+	NewScans       int
+	FailedScans    int
+	Annotations    int
+	NewAnnotations int
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Analysis is the result of analyzing a piece of text, stored so that an
+// annotation can be saved from it by ID. AnnotationID is set once it has been
+// saved.
+type Analysis struct {
+	ID           int64
+	UserID       int64
+	ScanID       *int64
+	Text         string
+	ContextText  *string
+	NuanceData   NuanceData
+	AnnotationID *int64
+	CreatedAt    time.Time
+}
@@ -8,15 +8,98 @@ type NuanceData struct {
 	UsageTiming        string `json:"usageTiming"`
 	WordBreakdown      string `json:"wordBreakdown"`
 	AlternativeMeaning string `json:"alternativeMeaning"`
+
+	// Structured fields used to filter and sort annotations. Annotations
+	// saved before they existed leave them empty.
+	JLPTLevel          string `json:"jlptLevel,omitempty"`
+	PolitenessRegister string `json:"politenessRegister,omitempty"`
+	PartOfSpeech       string `json:"partOfSpeech,omitempty"`
+	Reading            string `json:"reading,omitempty"`
+}
+
+// JLPT levels, hardest first.
+var JLPTLevels = []string{"N1", "N2", "N3", "N4", "N5"}
+
+// Politeness registers of Japanese speech.
+const (
+	PolitenessSonkeigo = "sonkeigo" // respectful, raises the listener
+	PolitenessKenjougo = "kenjougo" // humble, lowers the speaker
+	PolitenessTeineigo = "teineigo" // polite desu/masu form
+	PolitenessCasual   = "casual"
+)
+
+var PolitenessRegisters = []string{PolitenessSonkeigo, PolitenessKenjougo, PolitenessTeineigo, PolitenessCasual}
+
+var PartsOfSpeech = []string{
+	"noun",
+	"verb",
+	"i-adjective",
+	"na-adjective",
+	"adverb",
+	"particle",
+	"conjunction",
+	"counter",
+	"expression",
+	"other",
+}
+
+// AnnotationFilter narrows a list of annotations by their structured nuance
+// fields. Empty fields match everything.
+type AnnotationFilter struct {
+	JLPTLevel          string
+	PolitenessRegister string
+	PartOfSpeech       string
+	Reading            string
+	Sort               string
 }
 
+// Sort orders for AnnotationFilter.Sort.
+const (
+	AnnotationSortNewest = "newest"
+	AnnotationSortJLPT   = "jlptLevel"
+)
+
+// Annotation is a saved explanation of a piece of text. StartOffset and
+// EndOffset locate the highlight in the scan's full OCR text, in UTF-16 code
+// units as JavaScript string indexes count them, end exclusive; they are nil
+// for annotations without a scan and for ones saved before offsets existed.
 type Annotation struct {
 	ID              int64
 	UserID          int64
 	ScanID          *int64
+	PageNumber      *int
 	HighlightedText string
 	ContextText     *string
 	NuanceData      NuanceData
 	IsBookmarked    bool
+	StartOffset     *int
+	EndOffset       *int
+	OCRBlock        *string
+	BoundingBox     *BoundingBox
 	CreatedAt       time.Time
 }
+
+// BoundingBox is the highlighted area of a page image, as fractions of the
+// image's width and height measured from its top-left corner.
+type BoundingBox struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Roles of an annotation chat message.
+const (
+	MessageRoleUser  = "user"
+	MessageRoleModel = "model"
+)
+
+// AnnotationMessage is one turn of the follow-up chat about an annotation.
+type AnnotationMessage struct {
+	ID           int64
+	AnnotationID int64
+	Role         string
+	Content      string
+	Model        *string
+	CreatedAt    time.Time
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Roles within an organization. Owners manage members; mentors maintain the
+// glossary and see the team's aggregated annotations; members use the
+// glossary.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleMentor = "mentor"
+	OrgRoleMember = "member"
+)
+
+var OrgRoles = []string{OrgRoleOwner, OrgRoleMentor, OrgRoleMember}
+
+func IsValidOrgRole(role string) bool {
+	return slices.Contains(OrgRoles, role)
+}
+
+// Organization is a team of users, such as a company's cohort of new
+// employees and their mentors.
+type Organization struct {
+	ID        int64
+	Name      string
+	CreatedBy *int64
+	CreatedAt time.Time
+}
+
+// OrgMember is a user's membership of an organization. ShareAnnotations is
+// the member's opt-in to their annotations counting towards the team's
+// insights. OrgName and Email are filled in when listing, for display.
+type OrgMember struct {
+	OrgID            int64
+	OrgName          string
+	UserID           int64
+	Email            string
+	Role             string
+	ShareAnnotations bool
+	CreatedAt        time.Time
+}
+
+// OrgInvitation invites whoever signs in with Email to join an organization
+// with Role. Email is stored lowercase. OrgName is filled in when listing.
+type OrgInvitation struct {
+	ID        int64
+	OrgID     int64
+	OrgName   string
+	Email     string
+	Role      string
+	InvitedBy *int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// GlossaryEntry is a term an organization defines for its members, looked up
+// alongside the knowledge base when explaining text.
+type GlossaryEntry struct {
+	ID          int64
+	OrgID       int64
+	Term        string
+	Reading     string
+	Meaning     string
+	Romaji      string
+	Description string
+	Context     string
+	CreatedBy   *int64
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TermStat is how often members annotated a term: Annotations in total, by
+// Members distinct members.
+type TermStat struct {
+	Term        string
+	Reading     string
+	JLPTLevel   string
+	Annotations int
+	Members     int
+}
+
+// OrgInsights aggregates the annotations of members who share them. No
+// figure identifies a member.
+type OrgInsights struct {
+	Members             int
+	SharingMembers      int
+	Annotations         int
+	TopTerms            []TermStat
+	JLPTLevels          map[string]int
+	PolitenessRegisters map[string]int
+	PartsOfSpeech       map[string]int
+}
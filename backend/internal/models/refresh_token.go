@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// RefreshToken is one token of a sign-in session. Only the SHA-256 hash of
+// the token is stored. Tokens of the same session share FamilyID; refreshing
+// revokes the token and points ReplacedBy at its successor.
+type RefreshToken struct {
+	ID               int64
+	UserID           int64
+	FamilyID         string
+	TokenHash        string
+	UserAgent        *string
+	IPAddress        *string
+	SessionStartedAt time.Time
+	CreatedAt        time.Time
+	ExpiresAt        time.Time
+	RevokedAt        *time.Time
+	ReplacedBy       *int64
+}
@@ -2,11 +2,88 @@ package models
 
 import "time"
 
+const (
+	DocumentTypeImage = "image"
+	DocumentTypePDF   = "pdf"
+	DocumentTypeText  = "text"
+)
+
+// Where a scan's content came from.
+const (
+	SourceTypeUpload = "upload"
+	SourceTypeText   = "text"
+	SourceTypeURL    = "url"
+)
+
 type Scan struct {
 	ID               int64
 	UserID           int64
 	ImageURL         string
+	ImageHash        *string
+	OriginalImageURL *string
 	FullOCRText      *string
 	DetectedLanguage *string
+	DocumentType     string
+	DocumentURL      *string
+	PageCount        int
+	SourceType       string
+	SourceURL        *string
+	CreatedAt        time.Time
+}
+
+// ScanPage is one page of a scan. For image scans page 1 mirrors the scan's
+// own image columns; PDF pages have no image of their own.
+type ScanPage struct {
+	ID               int64
+	ScanID           int64
+	PageNumber       int
+	ImageURL         string
+	ImageHash        *string
+	OriginalImageURL *string
+	OCRText          *string
+	DetectedLanguage *string
 	CreatedAt        time.Time
 }
+
+type ScanThumbnail struct {
+	ScanID    int64
+	Size      int
+	ImageURL  string
+	ImageHash *string
+	CreatedAt time.Time
+}
+
+// Sources of an OCR revision.
+const (
+	OCRSourcePipeline  = "ocr"
+	OCRSourceReprocess = "reprocess"
+	OCRSourceManual    = "manual"
+	OCRSourceText      = "text"
+)
+
+// OCRRevision is one version of a page's OCR text and what produced it.
+// CreatedBy is nil for the upload pipeline.
+type OCRRevision struct {
+	ID           int64
+	ScanID       int64
+	PageNumber   int
+	Text         string
+	Language     *string
+	Source       string
+	Model        *string
+	LanguageHint *string
+	CreatedBy    *int64
+	CreatedAt    time.Time
+}
+
+// ScanFailure is an OCR attempt that failed. PageNumber is nil when a whole
+// document failed at once; UserID is the scan's owner.
+type ScanFailure struct {
+	ID         int64
+	ScanID     int64
+	UserID     int64
+	PageNumber *int
+	Source     string
+	Error      string
+	CreatedAt  time.Time
+}
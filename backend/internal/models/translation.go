@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// ScanTranslation is the translation of a scan's full text into one target
+// language, with a summary and the action items the model found in it.
+type ScanTranslation struct {
+	ID             int64
+	ScanID         int64
+	TargetLanguage string
+	SourceHash     string
+	Paragraphs     []TranslatedParagraph
+	Summary        string
+	ActionItems    []ActionItem
+	Model          *string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// TranslatedParagraph pairs one paragraph of the OCR text with its
+// translation, in document order.
+type TranslatedParagraph struct {
+	Source      string `json:"source"`
+	Translation string `json:"translation"`
+}
+
+// ActionItem is something the reader has to do, such as meeting a deadline
+// or bringing a document.
+type ActionItem struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	DueDate     string `json:"dueDate,omitempty"`
+}
+
+const (
+	ActionItemDeadline = "deadline"
+	ActionItemDocument = "document"
+	ActionItemTask     = "task"
+)
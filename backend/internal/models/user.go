@@ -1,7 +1,25 @@
 package models
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
+// Roles a user can have. Content editors can review failed scans; admins can
+// do everything.
+const (
+	RoleUser          = "user"
+	RoleAdmin         = "admin"
+	RoleContentEditor = "content-editor"
+)
+
+var Roles = []string{RoleUser, RoleAdmin, RoleContentEditor}
+
+func IsValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
+
+// User is an account. DisabledAt is set while an admin has disabled it.
 type User struct {
 	ID                int64
 	Email             string
@@ -9,6 +27,20 @@ type User struct {
 	ProviderID        string
 	AvatarURL         *string
 	PreferredLanguage string
+	Role              string
+	DisabledAt        *time.Time
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
 }
+
+// UserIdentity is a provider account a user can sign in with. Email is
+// stored lowercase; EmailVerified says whether the provider confirmed it.
+type UserIdentity struct {
+	ID            int64
+	UserID        int64
+	Provider      string
+	ProviderID    string
+	Email         string
+	EmailVerified bool
+	CreatedAt     time.Time
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// ScanVocabulary is the ranked list of words and set phrases worth learning
+// from a scan, explained in one target language.
+type ScanVocabulary struct {
+	ID             int64
+	ScanID         int64
+	TargetLanguage string
+	SourceHash     string
+	Items          []VocabularyItem
+	Model          *string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// VocabularyItem is one extracted term. Items are stored in rank order, most
+// worth learning first.
+type VocabularyItem struct {
+	Term            string `json:"term"`
+	Reading         string `json:"reading"`
+	Meaning         string `json:"meaning"`
+	Kind            string `json:"kind"`
+	JLPTLevel       string `json:"jlptLevel,omitempty"`
+	Sentence        string `json:"sentence,omitempty"`
+	Occurrences     int    `json:"occurrences"`
+	InKnowledgeBase bool   `json:"inKnowledgeBase"`
+	Score           int    `json:"score"`
+}
+
+const (
+	VocabularyKindWord   = "word"
+	VocabularyKindPhrase = "phrase"
+)
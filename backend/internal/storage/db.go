@@ -4,29 +4,147 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/gemini-hackathon/app/internal/models"
 )
 
 type DB interface {
+	Ping(ctx context.Context) error
+
 	CreateUser(ctx context.Context, user *models.User) error
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
 	GetUserByProvider(ctx context.Context, provider, providerID string) (*models.User, error)
 	GetUserByID(ctx context.Context, userID int64) (*models.User, error)
 	UpdateUserLanguage(ctx context.Context, userID int64, language string) error
+	ListUsers(ctx context.Context, search string, page, size int) ([]*models.User, error)
+	UpdateUserRole(ctx context.Context, userID int64, role string, audit *models.AuditEntry) error
+	DisableUser(ctx context.Context, userID int64, audit *models.AuditEntry) error
+	EnableUser(ctx context.Context, userID int64, audit *models.AuditEntry) error
+	RecordImpersonation(ctx context.Context, userID int64, audit *models.AuditEntry) error
+	GetUsageStats(ctx context.Context, since time.Time) (*models.UsageStats, error)
+
+	CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error
+	GetUserIdentity(ctx context.Context, provider, providerID string) (*models.UserIdentity, error)
+	GetUserIdentities(ctx context.Context, userID int64) ([]*models.UserIdentity, error)
+	GetUserByVerifiedEmail(ctx context.Context, email string) (*models.User, error)
+	CreateUserIdentity(ctx context.Context, identity *models.UserIdentity) (int64, error)
+	DeleteUserIdentity(ctx context.Context, userID, identityID int64) (bool, error)
 
 	CreateScan(ctx context.Context, scan *models.Scan) (int64, error)
 	GetScanByID(ctx context.Context, scanID int64) (*models.Scan, error)
 	GetScansByUserID(ctx context.Context, userID int64, page, size int) ([]*models.Scan, error)
 	UpdateScanOCR(ctx context.Context, scanID int64, text, language string) error
+	UpdateScanImage(ctx context.Context, scanID int64, imageURL string, imageHash *string) error
+	UpdateScanOriginalImage(ctx context.Context, scanID int64, originalImageURL string) error
+	UpdateScanDocument(ctx context.Context, scanID int64, documentURL string) error
+	GetScansAfterID(ctx context.Context, afterID int64, limit int) ([]*models.Scan, error)
+
+	CreateScanFailure(ctx context.Context, failure *models.ScanFailure) (int64, error)
+	GetScanFailures(ctx context.Context, page, size int) ([]*models.ScanFailure, error)
+
+	CreateScanPage(ctx context.Context, page *models.ScanPage) (int64, error)
+	GetScanPages(ctx context.Context, scanID int64) ([]*models.ScanPage, error)
+	UpdateScanPageOCR(ctx context.Context, revision *models.OCRRevision) error
+	UpdateScanPageImage(ctx context.Context, scanID int64, pageNumber int, imageURL string, imageHash *string) error
+	UpdateScanPageOriginalImage(ctx context.Context, scanID int64, pageNumber int, originalImageURL string) error
+	UpdateScanPageCount(ctx context.Context, scanID int64, pageCount int) error
+	DeleteScanPagesAfter(ctx context.Context, scanID int64, pageNumber int) error
+
+	CreateOCRRevision(ctx context.Context, revision *models.OCRRevision) (int64, error)
+	GetOCRRevisions(ctx context.Context, scanID int64) ([]*models.OCRRevision, error)
+	RecordReprocess(ctx context.Context, scanID, userID int64, window time.Duration, scanLimit, userLimit int) (bool, error)
+
+	GetScanTranslation(ctx context.Context, scanID int64, targetLanguage string) (*models.ScanTranslation, error)
+	UpsertScanTranslation(ctx context.Context, translation *models.ScanTranslation) error
+
+	GetScanVocabulary(ctx context.Context, scanID int64, targetLanguage string) (*models.ScanVocabulary, error)
+	UpsertScanVocabulary(ctx context.Context, vocabulary *models.ScanVocabulary) error
+
+	UpsertScanThumbnail(ctx context.Context, thumbnail *models.ScanThumbnail) error
+	GetScanThumbnails(ctx context.Context, scanIDs []int64) (map[int64][]*models.ScanThumbnail, error)
 
 	CreateAnnotation(ctx context.Context, annotation *models.Annotation) (int64, error)
 	GetAnnotationByID(ctx context.Context, annotationID int64) (*models.Annotation, error)
-	GetAnnotationsByUserID(ctx context.Context, userID int64, page, size int) ([]*models.Annotation, error)
+	GetAnnotationsByUserID(ctx context.Context, userID int64, filter models.AnnotationFilter, page, size int) ([]*models.Annotation, error)
+	GetAnnotationsByScanID(ctx context.Context, scanID int64) ([]*models.Annotation, error)
+
+	CreateAnalysis(ctx context.Context, analysis *models.Analysis) (int64, error)
+	GetAnalysisByID(ctx context.Context, analysisID int64) (*models.Analysis, error)
+	CreateAnnotationFromAnalysis(ctx context.Context, annotation *models.Annotation, analysisID int64) (int64, error)
+
+	CreateAnnotationMessages(ctx context.Context, messages ...*models.AnnotationMessage) error
+	GetAnnotationMessages(ctx context.Context, annotationID int64) ([]*models.AnnotationMessage, error)
+
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) (int64, error)
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldID int64, next *models.RefreshToken) (int64, error)
+	RevokeRefreshTokenFamily(ctx context.Context, userID int64, familyID string) (bool, error)
+	GetActiveRefreshTokens(ctx context.Context, userID int64) ([]*models.RefreshToken, error)
+
+	RecordMagicLinkRequest(ctx context.Context, emailHash, ipAddress string, cooldown, window time.Duration, ipLimit int) (bool, error)
+
+	CreateAuditEntry(ctx context.Context, entry *models.AuditEntry) (int64, error)
+	GetAuditEntries(ctx context.Context, targetUserID int64, page, size int) ([]*models.AuditEntry, error)
+
+	CreateOrganization(ctx context.Context, org *models.Organization, ownerID int64) (int64, error)
+	GetOrganization(ctx context.Context, orgID int64) (*models.Organization, error)
+	GetUserOrgMemberships(ctx context.Context, userID int64) ([]*models.OrgMember, error)
+	GetOrgMember(ctx context.Context, orgID, userID int64) (*models.OrgMember, error)
+	GetOrgMembers(ctx context.Context, orgID int64) ([]*models.OrgMember, error)
+	AddOrgMember(ctx context.Context, member *models.OrgMember) error
+	UpdateOrgMemberRole(ctx context.Context, orgID, userID int64, role string) error
+	SetOrgMemberSharing(ctx context.Context, orgID, userID int64, share bool) error
+	RemoveOrgMember(ctx context.Context, orgID, userID int64) error
+	CreateOrgInvitation(ctx context.Context, invitation *models.OrgInvitation) (int64, error)
+	GetOrgInvitation(ctx context.Context, invitationID int64) (*models.OrgInvitation, error)
+	GetOrgInvitations(ctx context.Context, orgID int64) ([]*models.OrgInvitation, error)
+	GetInvitationsForEmail(ctx context.Context, email string) ([]*models.OrgInvitation, error)
+	AcceptOrgInvitation(ctx context.Context, invitationID, userID int64) error
+	DeleteOrgInvitation(ctx context.Context, invitationID int64) error
+	GetOrgInsights(ctx context.Context, orgID int64, since time.Time, limit int) (*models.OrgInsights, error)
+
+	CreateGlossaryEntry(ctx context.Context, entry *models.GlossaryEntry) (int64, error)
+	GetGlossaryEntry(ctx context.Context, entryID int64) (*models.GlossaryEntry, error)
+	GetGlossaryEntries(ctx context.Context, orgID int64) ([]*models.GlossaryEntry, error)
+	GetGlossaryEntriesForUser(ctx context.Context, userID int64) ([]*models.GlossaryEntry, error)
+	UpdateGlossaryEntry(ctx context.Context, entry *models.GlossaryEntry) error
+	DeleteGlossaryEntry(ctx context.Context, entryID int64) error
 }
 
+// ErrAnalysisAlreadySaved is returned by CreateAnnotationFromAnalysis when
+// the analysis has already been saved as an annotation.
+var ErrAnalysisAlreadySaved = errors.New("analysis has already been saved")
+
+// ErrIdentityTaken is returned by CreateUserIdentity when the provider
+// account already belongs to a user.
+var ErrIdentityTaken = errors.New("identity already linked")
+
+// ErrAlreadyMember is returned by AddOrgMember when the user already
+// belongs to the organization.
+var ErrAlreadyMember = errors.New("already a member of the organization")
+
+// ErrInvitationNotFound is returned by AcceptOrgInvitation when the
+// invitation does not exist or has expired.
+var ErrInvitationNotFound = errors.New("invitation not found")
+
+// ErrNotImpersonable is returned by RecordImpersonation when the user is an
+// admin, is disabled or no longer exists.
+var ErrNotImpersonable = errors.New("user cannot be impersonated")
+
+// ErrGlossaryTermExists is returned when an organization's glossary already
+// has an entry for the term.
+var ErrGlossaryTermExists = errors.New("glossary term already exists")
+
+// ErrRefreshTokenRevoked is returned by RotateRefreshToken when the token was
+// revoked or rotated before the call could replace it.
+var ErrRefreshTokenRevoked = errors.New("refresh token has been revoked")
+
 type postgresDB struct {
 	db *sql.DB
 }
@@ -35,10 +153,18 @@ func NewPostgresDB(db *sql.DB) DB {
 	return &postgresDB{db: db}
 }
 
+func (s *postgresDB) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// CreateUser inserts user, with RoleUser unless it has a role.
 func (s *postgresDB) CreateUser(ctx context.Context, user *models.User) error {
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
 	query := `
-		INSERT INTO users (email, provider, provider_id, avatar_url, preferred_language, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO users (email, provider, provider_id, avatar_url, preferred_language, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id
 	`
 	err := s.db.QueryRowContext(ctx, query,
@@ -47,15 +173,18 @@ func (s *postgresDB) CreateUser(ctx context.Context, user *models.User) error {
 		user.ProviderID,
 		user.AvatarURL,
 		user.PreferredLanguage,
+		user.Role,
 		user.CreatedAt,
 		user.UpdatedAt,
 	).Scan(&user.ID)
 	return err
 }
 
+const userColumns = `id, email, provider, provider_id, avatar_url, preferred_language, role, disabled_at, created_at, updated_at`
+
 func (s *postgresDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	query := `
-		SELECT id, email, provider, provider_id, avatar_url, preferred_language, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE email = $1
 	`
@@ -68,7 +197,7 @@ func (s *postgresDB) GetUserByEmail(ctx context.Context, email string) (*models.
 
 func (s *postgresDB) GetUserByProvider(ctx context.Context, provider, providerID string) (*models.User, error) {
 	query := `
-		SELECT id, email, provider, provider_id, avatar_url, preferred_language, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE provider = $1 AND provider_id = $2
 	`
@@ -81,7 +210,7 @@ func (s *postgresDB) GetUserByProvider(ctx context.Context, provider, providerID
 
 func (s *postgresDB) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
 	query := `
-		SELECT id, email, provider, provider_id, avatar_url, preferred_language, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE id = $1
 	`
@@ -102,9 +231,180 @@ func (s *postgresDB) UpdateUserLanguage(ctx context.Context, userID int64, langu
 	return err
 }
 
-func (s *postgresDB) scanUser(row *sql.Row) (*models.User, error) {
+// CreateUserWithIdentity creates a user together with the identity it
+// signed up with, with RoleUser unless it has a role.
+func (s *postgresDB) CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (email, provider, provider_id, avatar_url, preferred_language, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`,
+		user.Email,
+		user.Provider,
+		user.ProviderID,
+		user.AvatarURL,
+		user.PreferredLanguage,
+		user.Role,
+		user.CreatedAt,
+		user.UpdatedAt,
+	).Scan(&user.ID)
+	if err != nil {
+		return err
+	}
+
+	identity.UserID = user.ID
+	if _, err := insertUserIdentity(ctx, tx, identity); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const userIdentityColumns = `id, user_id, provider, provider_id, email, email_verified, created_at`
+
+func scanUserIdentity(row interface{ Scan(...any) error }) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	if err := row.Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.ProviderID,
+		&identity.Email,
+		&identity.EmailVerified,
+		&identity.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func insertUserIdentity(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, identity *models.UserIdentity) (int64, error) {
+	query := `
+		INSERT INTO user_identities (user_id, provider, provider_id, email, email_verified, created_at)
+		VALUES ($1, $2, $3, LOWER($4), $5, $6)
+		RETURNING id
+	`
+	err := db.QueryRowContext(ctx, query,
+		identity.UserID,
+		identity.Provider,
+		identity.ProviderID,
+		identity.Email,
+		identity.EmailVerified,
+		identity.CreatedAt,
+	).Scan(&identity.ID)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return 0, ErrIdentityTaken
+	}
+	return identity.ID, err
+}
+
+func (s *postgresDB) GetUserIdentity(ctx context.Context, provider, providerID string) (*models.UserIdentity, error) {
+	query := `SELECT ` + userIdentityColumns + ` FROM user_identities WHERE provider = $1 AND provider_id = $2`
+	identity, err := scanUserIdentity(s.db.QueryRowContext(ctx, query, provider, providerID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return identity, err
+}
+
+func (s *postgresDB) GetUserIdentities(ctx context.Context, userID int64) ([]*models.UserIdentity, error) {
+	query := `SELECT ` + userIdentityColumns + ` FROM user_identities WHERE user_id = $1 ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*models.UserIdentity
+	for rows.Next() {
+		identity, err := scanUserIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// GetUserByVerifiedEmail returns the user with a verified identity for
+// email, the oldest if there are several.
+func (s *postgresDB) GetUserByVerifiedEmail(ctx context.Context, email string) (*models.User, error) {
+	query := `
+		SELECT u.id, u.email, u.provider, u.provider_id, u.avatar_url, u.preferred_language, u.role, u.disabled_at, u.created_at, u.updated_at
+		FROM users u
+		JOIN user_identities i ON i.user_id = u.id
+		WHERE LOWER(i.email) = LOWER($1) AND i.email_verified
+		ORDER BY u.id
+		LIMIT 1
+	`
+	user, err := s.scanUser(s.db.QueryRowContext(ctx, query, email))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return user, err
+}
+
+func (s *postgresDB) CreateUserIdentity(ctx context.Context, identity *models.UserIdentity) (int64, error) {
+	return insertUserIdentity(ctx, s.db, identity)
+}
+
+// DeleteUserIdentity removes one of the user's identities unless it is
+// their last, and reports whether it did. The user row is locked first so
+// that two concurrent deletes cannot each see the other identity and leave
+// the user with none.
+func (s *postgresDB) DeleteUserIdentity(ctx context.Context, userID, identityID int64) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_identities WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		return false, err
+	}
+	if count <= 1 {
+		return false, nil
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM user_identities WHERE id = $1 AND user_id = $2
+	`, identityID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *postgresDB) scanUser(row interface{ Scan(...any) error }) (*models.User, error) {
 	var user models.User
 	var avatarURL sql.NullString
+	var disabledAt sql.NullTime
 	var preferredLanguage string
 	var createdAt, updatedAt time.Time
 
@@ -115,6 +415,8 @@ func (s *postgresDB) scanUser(row *sql.Row) (*models.User, error) {
 		&user.ProviderID,
 		&avatarURL,
 		&preferredLanguage,
+		&user.Role,
+		&disabledAt,
 		&createdAt,
 		&updatedAt,
 	)
@@ -125,6 +427,9 @@ func (s *postgresDB) scanUser(row *sql.Row) (*models.User, error) {
 	if avatarURL.Valid {
 		user.AvatarURL = &avatarURL.String
 	}
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
 	user.PreferredLanguage = preferredLanguage
 	user.CreatedAt = createdAt
 	user.UpdatedAt = updatedAt
@@ -134,8 +439,8 @@ func (s *postgresDB) scanUser(row *sql.Row) (*models.User, error) {
 
 func (s *postgresDB) CreateScan(ctx context.Context, scan *models.Scan) (int64, error) {
 	query := `
-		INSERT INTO scans (user_id, image_url, full_ocr_text, detected_language, created_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO scans (user_id, image_url, full_ocr_text, detected_language, document_type, page_count, source_type, source_url, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id
 	`
 	err := s.db.QueryRowContext(ctx, query,
@@ -143,6 +448,10 @@ func (s *postgresDB) CreateScan(ctx context.Context, scan *models.Scan) (int64,
 		scan.ImageURL,
 		scan.FullOCRText,
 		scan.DetectedLanguage,
+		scan.DocumentType,
+		scan.PageCount,
+		scan.SourceType,
+		scan.SourceURL,
 		scan.CreatedAt,
 	).Scan(&scan.ID)
 	return scan.ID, err
@@ -150,32 +459,51 @@ func (s *postgresDB) CreateScan(ctx context.Context, scan *models.Scan) (int64,
 
 func (s *postgresDB) GetScanByID(ctx context.Context, scanID int64) (*models.Scan, error) {
 	query := `
-		SELECT id, user_id, image_url, full_ocr_text, detected_language, created_at
+		SELECT id, user_id, image_url, image_hash, original_image_url, full_ocr_text, detected_language, document_type, document_url, page_count, source_type, source_url, created_at
 		FROM scans
 		WHERE id = $1
 	`
 	var scan models.Scan
-	var fullOCRText, detectedLanguage sql.NullString
+	var imageHash, originalImageURL, fullOCRText, detectedLanguage, documentURL, sourceURL sql.NullString
 	var createdAt time.Time
 
 	err := s.db.QueryRowContext(ctx, query, scanID).Scan(
 		&scan.ID,
 		&scan.UserID,
 		&scan.ImageURL,
+		&imageHash,
+		&originalImageURL,
 		&fullOCRText,
 		&detectedLanguage,
+		&scan.DocumentType,
+		&documentURL,
+		&scan.PageCount,
+		&scan.SourceType,
+		&sourceURL,
 		&createdAt,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	if imageHash.Valid {
+		scan.ImageHash = &imageHash.String
+	}
+	if originalImageURL.Valid {
+		scan.OriginalImageURL = &originalImageURL.String
+	}
 	if fullOCRText.Valid {
 		scan.FullOCRText = &fullOCRText.String
 	}
 	if detectedLanguage.Valid {
 		scan.DetectedLanguage = &detectedLanguage.String
 	}
+	if documentURL.Valid {
+		scan.DocumentURL = &documentURL.String
+	}
+	if sourceURL.Valid {
+		scan.SourceURL = &sourceURL.String
+	}
 	scan.CreatedAt = createdAt
 
 	return &scan, nil
@@ -184,7 +512,7 @@ func (s *postgresDB) GetScanByID(ctx context.Context, scanID int64) (*models.Sca
 func (s *postgresDB) GetScansByUserID(ctx context.Context, userID int64, page, size int) ([]*models.Scan, error) {
 	offset := (page - 1) * size
 	query := `
-		SELECT id, user_id, image_url, full_ocr_text, detected_language, created_at
+		SELECT id, user_id, image_url, image_hash, original_image_url, full_ocr_text, detected_language, document_type, document_url, page_count, source_type, source_url, created_at
 		FROM scans
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -196,30 +524,70 @@ func (s *postgresDB) GetScansByUserID(ctx context.Context, userID int64, page, s
 	}
 	defer rows.Close()
 
+	return s.scanScans(rows)
+}
+
+func (s *postgresDB) GetScansAfterID(ctx context.Context, afterID int64, limit int) ([]*models.Scan, error) {
+	query := `
+		SELECT id, user_id, image_url, image_hash, original_image_url, full_ocr_text, detected_language, document_type, document_url, page_count, source_type, source_url, created_at
+		FROM scans
+		WHERE id > $1
+		ORDER BY id ASC
+		LIMIT $2
+	`
+	rows, err := s.db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return s.scanScans(rows)
+}
+
+func (s *postgresDB) scanScans(rows *sql.Rows) ([]*models.Scan, error) {
 	var scans []*models.Scan
 	for rows.Next() {
 		var scan models.Scan
-		var fullOCRText, detectedLanguage sql.NullString
+		var imageHash, originalImageURL, fullOCRText, detectedLanguage, documentURL, sourceURL sql.NullString
 		var createdAt time.Time
 
 		err := rows.Scan(
 			&scan.ID,
 			&scan.UserID,
 			&scan.ImageURL,
+			&imageHash,
+			&originalImageURL,
 			&fullOCRText,
 			&detectedLanguage,
+			&scan.DocumentType,
+			&documentURL,
+			&scan.PageCount,
+			&scan.SourceType,
+			&sourceURL,
 			&createdAt,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		if imageHash.Valid {
+			scan.ImageHash = &imageHash.String
+		}
+		if originalImageURL.Valid {
+			scan.OriginalImageURL = &originalImageURL.String
+		}
 		if fullOCRText.Valid {
 			scan.FullOCRText = &fullOCRText.String
 		}
 		if detectedLanguage.Valid {
 			scan.DetectedLanguage = &detectedLanguage.String
 		}
+		if documentURL.Valid {
+			scan.DocumentURL = &documentURL.String
+		}
+		if sourceURL.Valid {
+			scan.SourceURL = &sourceURL.String
+		}
 		scan.CreatedAt = createdAt
 
 		scans = append(scans, &scan)
@@ -238,119 +606,1765 @@ func (s *postgresDB) UpdateScanOCR(ctx context.Context, scanID int64, text, lang
 	return err
 }
 
-func (s *postgresDB) CreateAnnotation(ctx context.Context, annotation *models.Annotation) (int64, error) {
-	nuanceJSON, err := json.Marshal(annotation.NuanceData)
-	if err != nil {
-		return 0, fmt.Errorf("failed to marshal nuance_data: %w", err)
-	}
+func (s *postgresDB) UpdateScanImage(ctx context.Context, scanID int64, imageURL string, imageHash *string) error {
+	query := `
+		UPDATE scans
+		SET image_url = $1, image_hash = $2
+		WHERE id = $3
+	`
+	_, err := s.db.ExecContext(ctx, query, imageURL, imageHash, scanID)
+	return err
+}
 
+func (s *postgresDB) UpdateScanOriginalImage(ctx context.Context, scanID int64, originalImageURL string) error {
 	query := `
-		INSERT INTO annotations (user_id, scan_id, highlighted_text, context_text, nuance_data, is_bookmarked, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id
+		UPDATE scans
+		SET original_image_url = $1
+		WHERE id = $2
 	`
-	err = s.db.QueryRowContext(ctx, query,
-		annotation.UserID,
-		annotation.ScanID,
-		annotation.HighlightedText,
-		annotation.ContextText,
-		nuanceJSON,
-		annotation.IsBookmarked,
-		annotation.CreatedAt,
-	).Scan(&annotation.ID)
-	return annotation.ID, err
+	_, err := s.db.ExecContext(ctx, query, originalImageURL, scanID)
+	return err
 }
 
-func (s *postgresDB) GetAnnotationByID(ctx context.Context, annotationID int64) (*models.Annotation, error) {
+func (s *postgresDB) UpdateScanDocument(ctx context.Context, scanID int64, documentURL string) error {
 	query := `
-		SELECT id, user_id, scan_id, highlighted_text, context_text, nuance_data, is_bookmarked, created_at
-		FROM annotations
-		WHERE id = $1
+		UPDATE scans
+		SET document_url = $1
+		WHERE id = $2
 	`
-	var annotation models.Annotation
-	var scanID sql.NullInt64
-	var contextText sql.NullString
-	var nuanceData []byte
-	var createdAt time.Time
+	_, err := s.db.ExecContext(ctx, query, documentURL, scanID)
+	return err
+}
 
-	err := s.db.QueryRowContext(ctx, query, annotationID).Scan(
-		&annotation.ID,
-		&annotation.UserID,
-		&scanID,
-		&annotation.HighlightedText,
-		&contextText,
-		&nuanceData,
-		&annotation.IsBookmarked,
-		&createdAt,
-	)
+func (s *postgresDB) CreateScanPage(ctx context.Context, page *models.ScanPage) (int64, error) {
+	query := `
+		INSERT INTO scan_pages (scan_id, page_number, image_url, image_hash, original_image_url, ocr_text, detected_language, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`
+	err := s.db.QueryRowContext(ctx, query,
+		page.ScanID,
+		page.PageNumber,
+		page.ImageURL,
+		page.ImageHash,
+		page.OriginalImageURL,
+		page.OCRText,
+		page.DetectedLanguage,
+		page.CreatedAt,
+	).Scan(&page.ID)
+	return page.ID, err
+}
+
+func (s *postgresDB) GetScanPages(ctx context.Context, scanID int64) ([]*models.ScanPage, error) {
+	query := `
+		SELECT id, scan_id, page_number, image_url, image_hash, original_image_url, ocr_text, detected_language, created_at
+		FROM scan_pages
+		WHERE scan_id = $1
+		ORDER BY page_number
+	`
+	rows, err := s.db.QueryContext(ctx, query, scanID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	if scanID.Valid {
-		annotation.ScanID = &scanID.Int64
+	var pages []*models.ScanPage
+	for rows.Next() {
+		var page models.ScanPage
+		var imageHash, originalImageURL, ocrText, detectedLanguage sql.NullString
+
+		if err := rows.Scan(
+			&page.ID,
+			&page.ScanID,
+			&page.PageNumber,
+			&page.ImageURL,
+			&imageHash,
+			&originalImageURL,
+			&ocrText,
+			&detectedLanguage,
+			&page.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if imageHash.Valid {
+			page.ImageHash = &imageHash.String
+		}
+		if originalImageURL.Valid {
+			page.OriginalImageURL = &originalImageURL.String
+		}
+		if ocrText.Valid {
+			page.OCRText = &ocrText.String
+		}
+		if detectedLanguage.Valid {
+			page.DetectedLanguage = &detectedLanguage.String
+		}
+
+		pages = append(pages, &page)
 	}
-	if contextText.Valid {
-		annotation.ContextText = &contextText.String
+
+	return pages, rows.Err()
+}
+
+// UpdateScanPageOCR sets a page's text and language to those of revision
+// and adds revision to the history, in one transaction so the page never
+// holds text its history does not have.
+func (s *postgresDB) UpdateScanPageOCR(ctx context.Context, revision *models.OCRRevision) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
 	}
-	if err := json.Unmarshal(nuanceData, &annotation.NuanceData); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal nuance_data: %w", err)
+	defer tx.Rollback()
+
+	language := ""
+	if revision.Language != nil {
+		language = *revision.Language
 	}
-	annotation.CreatedAt = createdAt
+	query := `
+		UPDATE scan_pages
+		SET ocr_text = $1, detected_language = $2
+		WHERE scan_id = $3 AND page_number = $4
+	`
+	if _, err := tx.ExecContext(ctx, query, revision.Text, language, revision.ScanID, revision.PageNumber); err != nil {
+		return err
+	}
+	if _, err := insertOCRRevision(ctx, tx, revision); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	return &annotation, nil
+func (s *postgresDB) UpdateScanPageImage(ctx context.Context, scanID int64, pageNumber int, imageURL string, imageHash *string) error {
+	query := `
+		UPDATE scan_pages
+		SET image_url = $1, image_hash = $2
+		WHERE scan_id = $3 AND page_number = $4
+	`
+	_, err := s.db.ExecContext(ctx, query, imageURL, imageHash, scanID, pageNumber)
+	return err
 }
 
-func (s *postgresDB) GetAnnotationsByUserID(ctx context.Context, userID int64, page, size int) ([]*models.Annotation, error) {
-	offset := (page - 1) * size
+func (s *postgresDB) UpdateScanPageOriginalImage(ctx context.Context, scanID int64, pageNumber int, originalImageURL string) error {
 	query := `
-		SELECT id, user_id, scan_id, highlighted_text, context_text, nuance_data, is_bookmarked, created_at
-		FROM annotations
-		WHERE user_id = $1
-		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3
+		UPDATE scan_pages
+		SET original_image_url = $1
+		WHERE scan_id = $2 AND page_number = $3
 	`
-	rows, err := s.db.QueryContext(ctx, query, userID, size, offset)
+	_, err := s.db.ExecContext(ctx, query, originalImageURL, scanID, pageNumber)
+	return err
+}
+
+func (s *postgresDB) UpdateScanPageCount(ctx context.Context, scanID int64, pageCount int) error {
+	query := `
+		UPDATE scans
+		SET page_count = $1
+		WHERE id = $2
+	`
+	_, err := s.db.ExecContext(ctx, query, pageCount, scanID)
+	return err
+}
+
+func (s *postgresDB) DeleteScanPagesAfter(ctx context.Context, scanID int64, pageNumber int) error {
+	query := `
+		DELETE FROM scan_pages
+		WHERE scan_id = $1 AND page_number > $2
+	`
+	_, err := s.db.ExecContext(ctx, query, scanID, pageNumber)
+	return err
+}
+
+func (s *postgresDB) CreateOCRRevision(ctx context.Context, revision *models.OCRRevision) (int64, error) {
+	return insertOCRRevision(ctx, s.db, revision)
+}
+
+func insertOCRRevision(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, revision *models.OCRRevision) (int64, error) {
+	query := `
+		INSERT INTO ocr_revisions (scan_id, page_number, text, language, source, model, language_hint, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+	err := db.QueryRowContext(ctx, query,
+		revision.ScanID,
+		revision.PageNumber,
+		revision.Text,
+		revision.Language,
+		revision.Source,
+		revision.Model,
+		revision.LanguageHint,
+		revision.CreatedBy,
+		revision.CreatedAt,
+	).Scan(&revision.ID)
+	return revision.ID, err
+}
+
+func (s *postgresDB) GetOCRRevisions(ctx context.Context, scanID int64) ([]*models.OCRRevision, error) {
+	query := `
+		SELECT id, scan_id, page_number, text, language, source, model, language_hint, created_by, created_at
+		FROM ocr_revisions
+		WHERE scan_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, scanID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var annotations []*models.Annotation
+	var revisions []*models.OCRRevision
 	for rows.Next() {
-		var annotation models.Annotation
-		var scanID sql.NullInt64
-		var contextText sql.NullString
-		var nuanceData []byte
-		var createdAt time.Time
+		var revision models.OCRRevision
+		var language, model, languageHint sql.NullString
+		var createdBy sql.NullInt64
 
-		err := rows.Scan(
-			&annotation.ID,
-			&annotation.UserID,
-			&scanID,
-			&annotation.HighlightedText,
-			&contextText,
-			&nuanceData,
-			&annotation.IsBookmarked,
-			&createdAt,
-		)
-		if err != nil {
+		if err := rows.Scan(
+			&revision.ID,
+			&revision.ScanID,
+			&revision.PageNumber,
+			&revision.Text,
+			&language,
+			&revision.Source,
+			&model,
+			&languageHint,
+			&createdBy,
+			&revision.CreatedAt,
+		); err != nil {
 			return nil, err
 		}
 
-		if scanID.Valid {
-			annotation.ScanID = &scanID.Int64
+		if language.Valid {
+			revision.Language = &language.String
 		}
-		if contextText.Valid {
-			annotation.ContextText = &contextText.String
+		if model.Valid {
+			revision.Model = &model.String
 		}
-		if err := json.Unmarshal(nuanceData, &annotation.NuanceData); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal nuance_data: %w", err)
+		if languageHint.Valid {
+			revision.LanguageHint = &languageHint.String
+		}
+		if createdBy.Valid {
+			revision.CreatedBy = &createdBy.Int64
 		}
-		annotation.CreatedAt = createdAt
 
-		annotations = append(annotations, &annotation)
+		revisions = append(revisions, &revision)
 	}
 
-	return annotations, rows.Err()
+	return revisions, rows.Err()
+}
+
+// RecordReprocess records a reprocessing request unless the scan already has
+// scanLimit or the user userLimit requests within window. It reports whether
+// the request was recorded, checking and inserting in one statement.
+func (s *postgresDB) RecordReprocess(ctx context.Context, scanID, userID int64, window time.Duration, scanLimit, userLimit int) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO scan_reprocess_requests (scan_id, user_id, created_at)
+		SELECT $1, $2, NOW()
+		WHERE (SELECT COUNT(*) FROM scan_reprocess_requests
+				WHERE scan_id = $1 AND created_at > NOW() - $3 * INTERVAL '1 millisecond') < $4
+			AND (SELECT COUNT(*) FROM scan_reprocess_requests
+				WHERE user_id = $2 AND created_at > NOW() - $3 * INTERVAL '1 millisecond') < $5
+	`, scanID, userID, window.Milliseconds(), scanLimit, userLimit)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *postgresDB) GetScanTranslation(ctx context.Context, scanID int64, targetLanguage string) (*models.ScanTranslation, error) {
+	query := `
+		SELECT id, scan_id, target_language, source_hash, paragraphs, summary, action_items, model, created_at, updated_at
+		FROM scan_translations
+		WHERE scan_id = $1 AND target_language = $2
+	`
+	var translation models.ScanTranslation
+	var paragraphs, actionItems []byte
+	var model sql.NullString
+
+	err := s.db.QueryRowContext(ctx, query, scanID, targetLanguage).Scan(
+		&translation.ID,
+		&translation.ScanID,
+		&translation.TargetLanguage,
+		&translation.SourceHash,
+		&paragraphs,
+		&translation.Summary,
+		&actionItems,
+		&model,
+		&translation.CreatedAt,
+		&translation.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(paragraphs, &translation.Paragraphs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal paragraphs: %w", err)
+	}
+	if err := json.Unmarshal(actionItems, &translation.ActionItems); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal action_items: %w", err)
+	}
+	if model.Valid {
+		translation.Model = &model.String
+	}
+
+	return &translation, nil
+}
+
+func (s *postgresDB) UpsertScanTranslation(ctx context.Context, translation *models.ScanTranslation) error {
+	paragraphs, err := json.Marshal(translation.Paragraphs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal paragraphs: %w", err)
+	}
+	actionItems := translation.ActionItems
+	if actionItems == nil {
+		actionItems = []models.ActionItem{}
+	}
+	actionItemsJSON, err := json.Marshal(actionItems)
+	if err != nil {
+		return fmt.Errorf("failed to marshal action_items: %w", err)
+	}
+
+	query := `
+		INSERT INTO scan_translations (scan_id, target_language, source_hash, paragraphs, summary, action_items, model, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (scan_id, target_language) DO UPDATE
+		SET source_hash = EXCLUDED.source_hash, paragraphs = EXCLUDED.paragraphs, summary = EXCLUDED.summary,
+			action_items = EXCLUDED.action_items, model = EXCLUDED.model, updated_at = EXCLUDED.updated_at
+		RETURNING id, created_at
+	`
+	return s.db.QueryRowContext(ctx, query,
+		translation.ScanID,
+		translation.TargetLanguage,
+		translation.SourceHash,
+		paragraphs,
+		translation.Summary,
+		actionItemsJSON,
+		translation.Model,
+		translation.CreatedAt,
+		translation.UpdatedAt,
+	).Scan(&translation.ID, &translation.CreatedAt)
+}
+
+func (s *postgresDB) GetScanVocabulary(ctx context.Context, scanID int64, targetLanguage string) (*models.ScanVocabulary, error) {
+	query := `
+		SELECT id, scan_id, target_language, source_hash, items, model, created_at, updated_at
+		FROM scan_vocabularies
+		WHERE scan_id = $1 AND target_language = $2
+	`
+	var vocabulary models.ScanVocabulary
+	var items []byte
+	var model sql.NullString
+
+	err := s.db.QueryRowContext(ctx, query, scanID, targetLanguage).Scan(
+		&vocabulary.ID,
+		&vocabulary.ScanID,
+		&vocabulary.TargetLanguage,
+		&vocabulary.SourceHash,
+		&items,
+		&model,
+		&vocabulary.CreatedAt,
+		&vocabulary.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(items, &vocabulary.Items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal items: %w", err)
+	}
+	if model.Valid {
+		vocabulary.Model = &model.String
+	}
+
+	return &vocabulary, nil
+}
+
+func (s *postgresDB) UpsertScanVocabulary(ctx context.Context, vocabulary *models.ScanVocabulary) error {
+	items := vocabulary.Items
+	if items == nil {
+		items = []models.VocabularyItem{}
+	}
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Errorf("failed to marshal items: %w", err)
+	}
+
+	query := `
+		INSERT INTO scan_vocabularies (scan_id, target_language, source_hash, items, model, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (scan_id, target_language) DO UPDATE
+		SET source_hash = EXCLUDED.source_hash, items = EXCLUDED.items, model = EXCLUDED.model, updated_at = EXCLUDED.updated_at
+		RETURNING id, created_at
+	`
+	return s.db.QueryRowContext(ctx, query,
+		vocabulary.ScanID,
+		vocabulary.TargetLanguage,
+		vocabulary.SourceHash,
+		itemsJSON,
+		vocabulary.Model,
+		vocabulary.CreatedAt,
+		vocabulary.UpdatedAt,
+	).Scan(&vocabulary.ID, &vocabulary.CreatedAt)
+}
+
+func (s *postgresDB) UpsertScanThumbnail(ctx context.Context, thumbnail *models.ScanThumbnail) error {
+	query := `
+		INSERT INTO scan_thumbnails (scan_id, size, image_url, image_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (scan_id, size) DO UPDATE
+		SET image_url = EXCLUDED.image_url, image_hash = EXCLUDED.image_hash, created_at = EXCLUDED.created_at
+	`
+	_, err := s.db.ExecContext(ctx, query,
+		thumbnail.ScanID,
+		thumbnail.Size,
+		thumbnail.ImageURL,
+		thumbnail.ImageHash,
+		thumbnail.CreatedAt,
+	)
+	return err
+}
+
+func (s *postgresDB) GetScanThumbnails(ctx context.Context, scanIDs []int64) (map[int64][]*models.ScanThumbnail, error) {
+	result := make(map[int64][]*models.ScanThumbnail)
+	if len(scanIDs) == 0 {
+		return result, nil
+	}
+
+	query := `
+		SELECT scan_id, size, image_url, image_hash, created_at
+		FROM scan_thumbnails
+		WHERE scan_id = ANY($1)
+		ORDER BY scan_id, size
+	`
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(scanIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var thumbnail models.ScanThumbnail
+		var imageHash sql.NullString
+
+		if err := rows.Scan(
+			&thumbnail.ScanID,
+			&thumbnail.Size,
+			&thumbnail.ImageURL,
+			&imageHash,
+			&thumbnail.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if imageHash.Valid {
+			thumbnail.ImageHash = &imageHash.String
+		}
+
+		result[thumbnail.ScanID] = append(result[thumbnail.ScanID], &thumbnail)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *postgresDB) CreateAnnotation(ctx context.Context, annotation *models.Annotation) (int64, error) {
+	return insertAnnotation(ctx, s.db, annotation)
+}
+
+// insertAnnotation inserts annotation with db, which is the database or a
+// transaction.
+func insertAnnotation(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, annotation *models.Annotation) (int64, error) {
+	nuanceJSON, err := json.Marshal(annotation.NuanceData)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal nuance_data: %w", err)
+	}
+	var boundingBox any
+	if annotation.BoundingBox != nil {
+		boundingBoxJSON, err := json.Marshal(annotation.BoundingBox)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal bounding_box: %w", err)
+		}
+		boundingBox = boundingBoxJSON
+	}
+
+	query := `
+		INSERT INTO annotations (user_id, scan_id, page_number, highlighted_text, context_text, nuance_data, is_bookmarked, start_offset, end_offset, ocr_block, bounding_box, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id
+	`
+	err = db.QueryRowContext(ctx, query,
+		annotation.UserID,
+		annotation.ScanID,
+		annotation.PageNumber,
+		annotation.HighlightedText,
+		annotation.ContextText,
+		nuanceJSON,
+		annotation.IsBookmarked,
+		annotation.StartOffset,
+		annotation.EndOffset,
+		annotation.OCRBlock,
+		boundingBox,
+		annotation.CreatedAt,
+	).Scan(&annotation.ID)
+	return annotation.ID, err
+}
+
+func (s *postgresDB) GetAnnotationByID(ctx context.Context, annotationID int64) (*models.Annotation, error) {
+	query := `
+		SELECT ` + annotationColumns + `
+		FROM annotations
+		WHERE id = $1
+	`
+	return scanAnnotation(s.db.QueryRowContext(ctx, query, annotationID))
+}
+
+func (s *postgresDB) GetAnnotationsByUserID(ctx context.Context, userID int64, filter models.AnnotationFilter, page, size int) ([]*models.Annotation, error) {
+	offset := (page - 1) * size
+	conditions := []string{"user_id = $1"}
+	args := []any{userID}
+	for _, f := range []struct {
+		field string
+		value string
+	}{
+		{"jlptLevel", filter.JLPTLevel},
+		{"politenessRegister", filter.PolitenessRegister},
+		{"partOfSpeech", filter.PartOfSpeech},
+		{"reading", filter.Reading},
+	} {
+		if f.value == "" {
+			continue
+		}
+		args = append(args, f.value)
+		conditions = append(conditions, fmt.Sprintf("nuance_data->>'%s' = $%d", f.field, len(args)))
+	}
+
+	orderBy := "created_at DESC, id DESC"
+	if filter.Sort == models.AnnotationSortJLPT {
+		// "N1" sorts before "N5", so ascending puts the hardest first.
+		orderBy = "NULLIF(nuance_data->>'jlptLevel', '') ASC NULLS LAST, created_at DESC, id DESC"
+	}
+
+	args = append(args, size, offset)
+	query := fmt.Sprintf(`
+		SELECT `+annotationColumns+`
+		FROM annotations
+		WHERE %s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d
+	`, strings.Join(conditions, " AND "), orderBy, len(args)-1, len(args))
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return s.scanAnnotations(rows)
+}
+
+func (s *postgresDB) GetAnnotationsByScanID(ctx context.Context, scanID int64) ([]*models.Annotation, error) {
+	query := `
+		SELECT ` + annotationColumns + `
+		FROM annotations
+		WHERE scan_id = $1
+		ORDER BY created_at ASC, id ASC
+	`
+	rows, err := s.db.QueryContext(ctx, query, scanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return s.scanAnnotations(rows)
+}
+
+// annotationColumns is the column list scanAnnotation reads.
+const annotationColumns = `id, user_id, scan_id, page_number, highlighted_text, context_text, nuance_data, is_bookmarked, start_offset, end_offset, ocr_block, bounding_box, created_at`
+
+func (s *postgresDB) scanAnnotations(rows *sql.Rows) ([]*models.Annotation, error) {
+	var annotations []*models.Annotation
+	for rows.Next() {
+		annotation, err := scanAnnotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, annotation)
+	}
+
+	return annotations, rows.Err()
+}
+
+// scanAnnotation reads one row of annotationColumns from a *sql.Row or
+// *sql.Rows.
+func scanAnnotation(row interface{ Scan(dest ...any) error }) (*models.Annotation, error) {
+	var annotation models.Annotation
+	var scanID, pageNumber, startOffset, endOffset sql.NullInt64
+	var contextText, ocrBlock sql.NullString
+	var nuanceData, boundingBox []byte
+	var createdAt time.Time
+
+	err := row.Scan(
+		&annotation.ID,
+		&annotation.UserID,
+		&scanID,
+		&pageNumber,
+		&annotation.HighlightedText,
+		&contextText,
+		&nuanceData,
+		&annotation.IsBookmarked,
+		&startOffset,
+		&endOffset,
+		&ocrBlock,
+		&boundingBox,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if scanID.Valid {
+		annotation.ScanID = &scanID.Int64
+	}
+	if pageNumber.Valid {
+		n := int(pageNumber.Int64)
+		annotation.PageNumber = &n
+	}
+	if contextText.Valid {
+		annotation.ContextText = &contextText.String
+	}
+	if startOffset.Valid && endOffset.Valid {
+		start, end := int(startOffset.Int64), int(endOffset.Int64)
+		annotation.StartOffset = &start
+		annotation.EndOffset = &end
+	}
+	if ocrBlock.Valid {
+		annotation.OCRBlock = &ocrBlock.String
+	}
+	if err := json.Unmarshal(nuanceData, &annotation.NuanceData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal nuance_data: %w", err)
+	}
+	if boundingBox != nil {
+		annotation.BoundingBox = &models.BoundingBox{}
+		if err := json.Unmarshal(boundingBox, annotation.BoundingBox); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal bounding_box: %w", err)
+		}
+	}
+	annotation.CreatedAt = createdAt
+
+	return &annotation, nil
+}
+
+func (s *postgresDB) CreateAnalysis(ctx context.Context, analysis *models.Analysis) (int64, error) {
+	nuanceJSON, err := json.Marshal(analysis.NuanceData)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal nuance_data: %w", err)
+	}
+
+	query := `
+		INSERT INTO analyses (user_id, scan_id, text_to_analyze, context_text, nuance_data, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	err = s.db.QueryRowContext(ctx, query,
+		analysis.UserID,
+		analysis.ScanID,
+		analysis.Text,
+		analysis.ContextText,
+		nuanceJSON,
+		analysis.CreatedAt,
+	).Scan(&analysis.ID)
+	return analysis.ID, err
+}
+
+func (s *postgresDB) GetAnalysisByID(ctx context.Context, analysisID int64) (*models.Analysis, error) {
+	query := `
+		SELECT id, user_id, scan_id, text_to_analyze, context_text, nuance_data, annotation_id, created_at
+		FROM analyses
+		WHERE id = $1
+	`
+	var analysis models.Analysis
+	var scanID, annotationID sql.NullInt64
+	var contextText sql.NullString
+	var nuanceData []byte
+
+	err := s.db.QueryRowContext(ctx, query, analysisID).Scan(
+		&analysis.ID,
+		&analysis.UserID,
+		&scanID,
+		&analysis.Text,
+		&contextText,
+		&nuanceData,
+		&annotationID,
+		&analysis.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if scanID.Valid {
+		analysis.ScanID = &scanID.Int64
+	}
+	if contextText.Valid {
+		analysis.ContextText = &contextText.String
+	}
+	if annotationID.Valid {
+		analysis.AnnotationID = &annotationID.Int64
+	}
+	if err := json.Unmarshal(nuanceData, &analysis.NuanceData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal nuance_data: %w", err)
+	}
+
+	return &analysis, nil
+}
+
+// CreateAnnotationFromAnalysis inserts the annotation and marks the analysis
+// as saved in one transaction, so an analysis is saved at most once.
+func (s *postgresDB) CreateAnnotationFromAnalysis(ctx context.Context, annotation *models.Annotation, analysisID int64) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := insertAnnotation(ctx, tx, annotation); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE analyses
+		SET annotation_id = $1
+		WHERE id = $2 AND annotation_id IS NULL
+	`, annotation.ID, analysisID)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrAnalysisAlreadySaved
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return annotation.ID, nil
+}
+
+// CreateAnnotationMessages saves messages in one transaction, so a question
+// is never stored without its reply.
+func (s *postgresDB) CreateAnnotationMessages(ctx context.Context, messages ...*models.AnnotationMessage) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO annotation_messages (annotation_id, role, content, model, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	for _, message := range messages {
+		err := tx.QueryRowContext(ctx, query,
+			message.AnnotationID,
+			message.Role,
+			message.Content,
+			message.Model,
+			message.CreatedAt,
+		).Scan(&message.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *postgresDB) GetAnnotationMessages(ctx context.Context, annotationID int64) ([]*models.AnnotationMessage, error) {
+	query := `
+		SELECT id, annotation_id, role, content, model, created_at
+		FROM annotation_messages
+		WHERE annotation_id = $1
+		ORDER BY id
+	`
+	rows, err := s.db.QueryContext(ctx, query, annotationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.AnnotationMessage
+	for rows.Next() {
+		var message models.AnnotationMessage
+		var model sql.NullString
+
+		if err := rows.Scan(
+			&message.ID,
+			&message.AnnotationID,
+			&message.Role,
+			&message.Content,
+			&model,
+			&message.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if model.Valid {
+			message.Model = &model.String
+		}
+		messages = append(messages, &message)
+	}
+	return messages, rows.Err()
+}
+
+const refreshTokenColumns = `id, user_id, family_id, token_hash, user_agent, ip_address, session_started_at, created_at, expires_at, revoked_at, replaced_by`
+
+func scanRefreshToken(row interface{ Scan(...any) error }) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	var userAgent, ipAddress sql.NullString
+	var revokedAt sql.NullTime
+	var replacedBy sql.NullInt64
+
+	if err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&userAgent,
+		&ipAddress,
+		&token.SessionStartedAt,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&revokedAt,
+		&replacedBy,
+	); err != nil {
+		return nil, err
+	}
+
+	if userAgent.Valid {
+		token.UserAgent = &userAgent.String
+	}
+	if ipAddress.Valid {
+		token.IPAddress = &ipAddress.String
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	if replacedBy.Valid {
+		token.ReplacedBy = &replacedBy.Int64
+	}
+	return &token, nil
+}
+
+func insertRefreshToken(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, token *models.RefreshToken) (int64, error) {
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, user_agent, ip_address, session_started_at, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`
+	err := db.QueryRowContext(ctx, query,
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.UserAgent,
+		token.IPAddress,
+		token.SessionStartedAt,
+		token.CreatedAt,
+		token.ExpiresAt,
+	).Scan(&token.ID)
+	return token.ID, err
+}
+
+func (s *postgresDB) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) (int64, error) {
+	return insertRefreshToken(ctx, s.db, token)
+}
+
+func (s *postgresDB) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query := `SELECT ` + refreshTokenColumns + ` FROM refresh_tokens WHERE token_hash = $1`
+	token, err := scanRefreshToken(s.db.QueryRowContext(ctx, query, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return token, err
+}
+
+// RotateRefreshToken revokes the token oldID and inserts next as its
+// replacement in one transaction. Two refreshes racing with the same token
+// cannot both succeed: the loser gets ErrRefreshTokenRevoked.
+func (s *postgresDB) RotateRefreshToken(ctx context.Context, oldID int64, next *models.RefreshToken) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := insertRefreshToken(ctx, tx, next); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = $1, replaced_by = $2
+		WHERE id = $3 AND revoked_at IS NULL
+	`, next.CreatedAt, next.ID, oldID)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrRefreshTokenRevoked
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return next.ID, nil
+}
+
+// RevokeRefreshTokenFamily revokes every unrevoked token of the user's
+// session familyID and reports whether there were any.
+func (s *postgresDB) RevokeRefreshTokenFamily(ctx context.Context, userID int64, familyID string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = $1
+		WHERE user_id = $2 AND family_id = $3 AND revoked_at IS NULL
+	`, time.Now(), userID, familyID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetActiveRefreshTokens returns the user's unrevoked, unexpired tokens,
+// one per session, most recently used first.
+func (s *postgresDB) GetActiveRefreshTokens(ctx context.Context, userID int64) ([]*models.RefreshToken, error) {
+	query := `
+		SELECT ` + refreshTokenColumns + `
+		FROM refresh_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY created_at DESC, id DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*models.RefreshToken
+	for rows.Next() {
+		token, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RecordMagicLinkRequest records a magic link request unless one was made
+// for the same email hash within cooldown or the IP address already made
+// ipLimit requests within window. It reports whether the request was
+// recorded, checking and inserting in one statement.
+func (s *postgresDB) RecordMagicLinkRequest(ctx context.Context, emailHash, ipAddress string, cooldown, window time.Duration, ipLimit int) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO magic_link_requests (email_hash, ip_address, created_at)
+		SELECT $1, $2, NOW()
+		WHERE NOT EXISTS (SELECT 1 FROM magic_link_requests
+				WHERE email_hash = $1 AND created_at > NOW() - $3 * INTERVAL '1 millisecond')
+			AND (SELECT COUNT(*) FROM magic_link_requests
+				WHERE ip_address = $2 AND created_at > NOW() - $4 * INTERVAL '1 millisecond') < $5
+	`, emailHash, ipAddress, cooldown.Milliseconds(), window.Milliseconds(), ipLimit)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ListUsers returns users whose email contains search, all of them when it
+// is empty, oldest first.
+func (s *postgresDB) ListUsers(ctx context.Context, search string, page, size int) ([]*models.User, error) {
+	offset := (page - 1) * size
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE $1 = '' OR email ILIKE '%' || $1 || '%'
+		ORDER BY id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.db.QueryContext(ctx, query, search, size, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := s.scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// UpdateUserRole changes the user's role and writes its audit entry in one
+// transaction.
+func (s *postgresDB) UpdateUserRole(ctx context.Context, userID int64, role string, audit *models.AuditEntry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET role = $1, updated_at = $2 WHERE id = $3
+	`, role, time.Now(), userID); err != nil {
+		return err
+	}
+	if _, err := insertAuditEntry(ctx, tx, audit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableUser disables the account, revokes all of its sessions and writes
+// the audit entry in one transaction.
+func (s *postgresDB) DisableUser(ctx context.Context, userID int64, audit *models.AuditEntry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET disabled_at = $1, updated_at = $1 WHERE id = $2 AND disabled_at IS NULL
+	`, now, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL
+	`, now, userID); err != nil {
+		return err
+	}
+	if _, err := insertAuditEntry(ctx, tx, audit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EnableUser re-enables the account and writes the audit entry in one
+// transaction.
+func (s *postgresDB) EnableUser(ctx context.Context, userID int64, audit *models.AuditEntry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET disabled_at = NULL, updated_at = $1 WHERE id = $2
+	`, time.Now(), userID); err != nil {
+		return err
+	}
+	if _, err := insertAuditEntry(ctx, tx, audit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordImpersonation writes the audit entry for impersonating the user. The
+// user row is locked while it checks that the user is still an enabled
+// non-admin, so a concurrent role change or disable cannot slip in between.
+func (s *postgresDB) RecordImpersonation(ctx context.Context, userID int64, audit *models.AuditEntry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var role string
+	var disabledAt sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT role, disabled_at FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&role, &disabledAt)
+	if err == sql.ErrNoRows || (err == nil && (role == models.RoleAdmin || disabledAt.Valid)) {
+		return ErrNotImpersonable
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := insertAuditEntry(ctx, tx, audit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetUsageStats counts users, scans and annotations, and how many of them
+// are new or active since since. A user is active when they signed in or
+// refreshed a session.
+func (s *postgresDB) GetUsageStats(ctx context.Context, since time.Time) (*models.UsageStats, error) {
+	stats := &models.UsageStats{UsersByRole: make(map[string]int)}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL),
+			(SELECT COUNT(*) FROM users WHERE created_at >= $1),
+			(SELECT COUNT(DISTINCT user_id) FROM refresh_tokens WHERE created_at >= $1),
+			(SELECT COUNT(*) FROM scans),
+			(SELECT COUNT(*) FROM scans WHERE created_at >= $1),
+			(SELECT COUNT(DISTINCT scan_id) FROM scan_failures WHERE created_at >= $1),
+			(SELECT COUNT(*) FROM annotations),
+			(SELECT COUNT(*) FROM annotations WHERE created_at >= $1)
+	`, since).Scan(
+		&stats.Users,
+		&stats.DisabledUsers,
+		&stats.NewUsers,
+		&stats.ActiveUsers,
+		&stats.Scans,
+		&stats.NewScans,
+		&stats.FailedScans,
+		&stats.Annotations,
+		&stats.NewAnnotations,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT role, COUNT(*) FROM users GROUP BY role`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role string
+		var count int
+		if err := rows.Scan(&role, &count); err != nil {
+			return nil, err
+		}
+		stats.UsersByRole[role] = count
+	}
+	return stats, rows.Err()
+}
+
+func (s *postgresDB) CreateScanFailure(ctx context.Context, failure *models.ScanFailure) (int64, error) {
+	query := `
+		INSERT INTO scan_failures (scan_id, page_number, source, error, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	err := s.db.QueryRowContext(ctx, query,
+		failure.ScanID,
+		failure.PageNumber,
+		failure.Source,
+		failure.Error,
+		failure.CreatedAt,
+	).Scan(&failure.ID)
+	return failure.ID, err
+}
+
+// GetScanFailures returns OCR failures of all users, newest first.
+func (s *postgresDB) GetScanFailures(ctx context.Context, page, size int) ([]*models.ScanFailure, error) {
+	offset := (page - 1) * size
+	query := `
+		SELECT f.id, f.scan_id, s.user_id, f.page_number, f.source, f.error, f.created_at
+		FROM scan_failures f
+		JOIN scans s ON s.id = f.scan_id
+		ORDER BY f.created_at DESC, f.id DESC
+		LIMIT $1 OFFSET $2
+	`
+	rows, err := s.db.QueryContext(ctx, query, size, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []*models.ScanFailure
+	for rows.Next() {
+		var failure models.ScanFailure
+		var pageNumber sql.NullInt64
+		if err := rows.Scan(
+			&failure.ID,
+			&failure.ScanID,
+			&failure.UserID,
+			&pageNumber,
+			&failure.Source,
+			&failure.Error,
+			&failure.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if pageNumber.Valid {
+			n := int(pageNumber.Int64)
+			failure.PageNumber = &n
+		}
+		failures = append(failures, &failure)
+	}
+	return failures, rows.Err()
+}
+
+func (s *postgresDB) CreateAuditEntry(ctx context.Context, entry *models.AuditEntry) (int64, error) {
+	return insertAuditEntry(ctx, s.db, entry)
+}
+
+func insertAuditEntry(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, entry *models.AuditEntry) (int64, error) {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+	if entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	query := `
+		INSERT INTO admin_audit_log (actor_user_id, action, target_user_id, details, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	err = db.QueryRowContext(ctx, query,
+		entry.ActorUserID,
+		entry.Action,
+		entry.TargetUserID,
+		details,
+		entry.CreatedAt,
+	).Scan(&entry.ID)
+	return entry.ID, err
+}
+
+// GetAuditEntries returns audit entries newest first, only those affecting
+// targetUserID unless it is 0.
+func (s *postgresDB) GetAuditEntries(ctx context.Context, targetUserID int64, page, size int) ([]*models.AuditEntry, error) {
+	offset := (page - 1) * size
+	query := `
+		SELECT id, actor_user_id, action, target_user_id, details, created_at
+		FROM admin_audit_log
+		WHERE $1 = 0 OR target_user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.db.QueryContext(ctx, query, targetUserID, size, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		var targetUserID sql.NullInt64
+		var details []byte
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.ActorUserID,
+			&entry.Action,
+			&targetUserID,
+			&details,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if targetUserID.Valid {
+			entry.TargetUserID = &targetUserID.Int64
+		}
+		if err := json.Unmarshal(details, &entry.Details); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit details: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+// CreateOrganization creates org with ownerID as its first owner.
+func (s *postgresDB) CreateOrganization(ctx context.Context, org *models.Organization, ownerID int64) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO organizations (name, created_by, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, org.Name, org.CreatedBy, org.CreatedAt).Scan(&org.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO organization_members (org_id, user_id, role, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, org.ID, ownerID, models.OrgRoleOwner, org.CreatedAt); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return org.ID, nil
+}
+
+func (s *postgresDB) GetOrganization(ctx context.Context, orgID int64) (*models.Organization, error) {
+	var org models.Organization
+	var createdBy sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, name, created_by, created_at FROM organizations WHERE id = $1
+	`, orgID).Scan(&org.ID, &org.Name, &createdBy, &org.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if createdBy.Valid {
+		org.CreatedBy = &createdBy.Int64
+	}
+	return &org, nil
+}
+
+const orgMemberQuery = `
+	SELECT m.org_id, o.name, m.user_id, u.email, m.role, m.share_annotations, m.created_at
+	FROM organization_members m
+	JOIN organizations o ON o.id = m.org_id
+	JOIN users u ON u.id = m.user_id
+`
+
+func scanOrgMember(row interface{ Scan(...any) error }) (*models.OrgMember, error) {
+	var member models.OrgMember
+	if err := row.Scan(
+		&member.OrgID,
+		&member.OrgName,
+		&member.UserID,
+		&member.Email,
+		&member.Role,
+		&member.ShareAnnotations,
+		&member.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (s *postgresDB) queryOrgMembers(ctx context.Context, query string, args ...any) ([]*models.OrgMember, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*models.OrgMember
+	for rows.Next() {
+		member, err := scanOrgMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// GetUserOrgMemberships returns the organizations userID belongs to, in the
+// order they were joined.
+func (s *postgresDB) GetUserOrgMemberships(ctx context.Context, userID int64) ([]*models.OrgMember, error) {
+	return s.queryOrgMembers(ctx, orgMemberQuery+`WHERE m.user_id = $1 ORDER BY m.created_at, m.org_id`, userID)
+}
+
+func (s *postgresDB) GetOrgMember(ctx context.Context, orgID, userID int64) (*models.OrgMember, error) {
+	member, err := scanOrgMember(s.db.QueryRowContext(ctx, orgMemberQuery+`WHERE m.org_id = $1 AND m.user_id = $2`, orgID, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return member, err
+}
+
+func (s *postgresDB) GetOrgMembers(ctx context.Context, orgID int64) ([]*models.OrgMember, error) {
+	return s.queryOrgMembers(ctx, orgMemberQuery+`WHERE m.org_id = $1 ORDER BY m.created_at, m.user_id`, orgID)
+}
+
+func (s *postgresDB) AddOrgMember(ctx context.Context, member *models.OrgMember) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO organization_members (org_id, user_id, role, share_annotations, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, member.OrgID, member.UserID, member.Role, member.ShareAnnotations, member.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrAlreadyMember
+	}
+	return err
+}
+
+func (s *postgresDB) UpdateOrgMemberRole(ctx context.Context, orgID, userID int64, role string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE organization_members SET role = $1 WHERE org_id = $2 AND user_id = $3
+	`, role, orgID, userID)
+	return err
+}
+
+func (s *postgresDB) SetOrgMemberSharing(ctx context.Context, orgID, userID int64, share bool) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE organization_members SET share_annotations = $1 WHERE org_id = $2 AND user_id = $3
+	`, share, orgID, userID)
+	return err
+}
+
+func (s *postgresDB) RemoveOrgMember(ctx context.Context, orgID, userID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM organization_members WHERE org_id = $1 AND user_id = $2
+	`, orgID, userID)
+	return err
+}
+
+// CreateOrgInvitation invites invitation.Email, replacing a previous
+// invitation of the same email to the organization.
+func (s *postgresDB) CreateOrgInvitation(ctx context.Context, invitation *models.OrgInvitation) (int64, error) {
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO organization_invitations (org_id, email, role, invited_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (org_id, email) DO UPDATE
+		SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by,
+			created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+		RETURNING id
+	`,
+		invitation.OrgID,
+		invitation.Email,
+		invitation.Role,
+		invitation.InvitedBy,
+		invitation.CreatedAt,
+		invitation.ExpiresAt,
+	).Scan(&invitation.ID)
+	return invitation.ID, err
+}
+
+const orgInvitationQuery = `
+	SELECT i.id, i.org_id, o.name, i.email, i.role, i.invited_by, i.created_at, i.expires_at
+	FROM organization_invitations i
+	JOIN organizations o ON o.id = i.org_id
+`
+
+func scanOrgInvitation(row interface{ Scan(...any) error }) (*models.OrgInvitation, error) {
+	var invitation models.OrgInvitation
+	var invitedBy sql.NullInt64
+	if err := row.Scan(
+		&invitation.ID,
+		&invitation.OrgID,
+		&invitation.OrgName,
+		&invitation.Email,
+		&invitation.Role,
+		&invitedBy,
+		&invitation.CreatedAt,
+		&invitation.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	if invitedBy.Valid {
+		invitation.InvitedBy = &invitedBy.Int64
+	}
+	return &invitation, nil
+}
+
+func (s *postgresDB) queryOrgInvitations(ctx context.Context, query string, args ...any) ([]*models.OrgInvitation, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*models.OrgInvitation
+	for rows.Next() {
+		invitation, err := scanOrgInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+// GetOrgInvitation returns the invitation with invitationID, or nil if it
+// does not exist or has expired.
+func (s *postgresDB) GetOrgInvitation(ctx context.Context, invitationID int64) (*models.OrgInvitation, error) {
+	invitation, err := scanOrgInvitation(s.db.QueryRowContext(ctx, orgInvitationQuery+`WHERE i.id = $1 AND i.expires_at > NOW()`, invitationID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return invitation, err
+}
+
+// GetOrgInvitations returns the organization's pending invitations.
+func (s *postgresDB) GetOrgInvitations(ctx context.Context, orgID int64) ([]*models.OrgInvitation, error) {
+	return s.queryOrgInvitations(ctx, orgInvitationQuery+`WHERE i.org_id = $1 AND i.expires_at > NOW() ORDER BY i.created_at, i.id`, orgID)
+}
+
+// GetInvitationsForEmail returns the pending invitations of email, which is
+// matched case-insensitively.
+func (s *postgresDB) GetInvitationsForEmail(ctx context.Context, email string) ([]*models.OrgInvitation, error) {
+	return s.queryOrgInvitations(ctx, orgInvitationQuery+`WHERE i.email = LOWER($1) AND i.expires_at > NOW() ORDER BY i.created_at, i.id`, email)
+}
+
+// AcceptOrgInvitation makes userID a member with the invitation's role and
+// deletes the invitation, in one transaction.
+func (s *postgresDB) AcceptOrgInvitation(ctx context.Context, invitationID, userID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orgID int64
+	var role string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM organization_invitations
+		WHERE id = $1 AND expires_at > NOW()
+		RETURNING org_id, role
+	`, invitationID).Scan(&orgID, &role)
+	if err == sql.ErrNoRows {
+		return ErrInvitationNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO organization_members (org_id, user_id, role, created_at)
+		VALUES ($1, $2, $3, NOW())
+	`, orgID, userID, role)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrAlreadyMember
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *postgresDB) DeleteOrgInvitation(ctx context.Context, invitationID int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM organization_invitations WHERE id = $1`, invitationID)
+	return err
+}
+
+// sharedAnnotations selects the annotations since $2 of the members of
+// organization $1 who share them.
+const sharedAnnotations = `
+	FROM annotations a
+	JOIN organization_members m ON m.user_id = a.user_id
+	WHERE m.org_id = $1 AND m.share_annotations AND a.created_at >= $2
+`
+
+// GetOrgInsights aggregates the annotations since since of the members who
+// share them, with the limit terms annotated by the most members.
+func (s *postgresDB) GetOrgInsights(ctx context.Context, orgID int64, since time.Time, limit int) (*models.OrgInsights, error) {
+	insights := &models.OrgInsights{
+		JLPTLevels:          make(map[string]int),
+		PolitenessRegisters: make(map[string]int),
+		PartsOfSpeech:       make(map[string]int),
+	}
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE share_annotations)
+		FROM organization_members
+		WHERE org_id = $1
+	`, orgID).Scan(&insights.Members, &insights.SharingMembers)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			COALESCE(a.nuance_data->>'jlptLevel', ''),
+			COALESCE(a.nuance_data->>'politenessRegister', ''),
+			COALESCE(a.nuance_data->>'partOfSpeech', ''),
+			COUNT(*)
+		`+sharedAnnotations+`
+		GROUP BY 1, 2, 3
+	`, orgID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jlptLevel, politeness, partOfSpeech string
+		var count int
+		if err := rows.Scan(&jlptLevel, &politeness, &partOfSpeech, &count); err != nil {
+			return nil, err
+		}
+		insights.Annotations += count
+		addCount(insights.JLPTLevels, jlptLevel, count)
+		addCount(insights.PolitenessRegisters, politeness, count)
+		addCount(insights.PartsOfSpeech, partOfSpeech, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	termRows, err := s.db.QueryContext(ctx, `
+		SELECT
+			a.highlighted_text,
+			MAX(COALESCE(a.nuance_data->>'reading', '')),
+			MAX(COALESCE(a.nuance_data->>'jlptLevel', '')),
+			COUNT(*),
+			COUNT(DISTINCT a.user_id)
+		`+sharedAnnotations+`
+		GROUP BY a.highlighted_text
+		ORDER BY COUNT(DISTINCT a.user_id) DESC, COUNT(*) DESC, a.highlighted_text
+		LIMIT $3
+	`, orgID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer termRows.Close()
+	for termRows.Next() {
+		var stat models.TermStat
+		if err := termRows.Scan(&stat.Term, &stat.Reading, &stat.JLPTLevel, &stat.Annotations, &stat.Members); err != nil {
+			return nil, err
+		}
+		insights.TopTerms = append(insights.TopTerms, stat)
+	}
+	return insights, termRows.Err()
+}
+
+func addCount(counts map[string]int, key string, n int) {
+	if key != "" {
+		counts[key] += n
+	}
+}
+
+const glossaryEntryColumns = `id, org_id, term, reading, meaning, romaji, description, context, created_by, created_at, updated_at`
+
+func scanGlossaryEntry(row interface{ Scan(...any) error }) (*models.GlossaryEntry, error) {
+	var entry models.GlossaryEntry
+	var createdBy sql.NullInt64
+	if err := row.Scan(
+		&entry.ID,
+		&entry.OrgID,
+		&entry.Term,
+		&entry.Reading,
+		&entry.Meaning,
+		&entry.Romaji,
+		&entry.Description,
+		&entry.Context,
+		&createdBy,
+		&entry.CreatedAt,
+		&entry.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if createdBy.Valid {
+		entry.CreatedBy = &createdBy.Int64
+	}
+	return &entry, nil
+}
+
+func (s *postgresDB) queryGlossaryEntries(ctx context.Context, query string, args ...any) ([]*models.GlossaryEntry, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.GlossaryEntry
+	for rows.Next() {
+		entry, err := scanGlossaryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func glossaryTermError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrGlossaryTermExists
+	}
+	return err
+}
+
+func (s *postgresDB) CreateGlossaryEntry(ctx context.Context, entry *models.GlossaryEntry) (int64, error) {
+	query := `
+		INSERT INTO glossary_entries (org_id, term, reading, meaning, romaji, description, context, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`
+	err := s.db.QueryRowContext(ctx, query,
+		entry.OrgID,
+		entry.Term,
+		entry.Reading,
+		entry.Meaning,
+		entry.Romaji,
+		entry.Description,
+		entry.Context,
+		entry.CreatedBy,
+		entry.CreatedAt,
+		entry.UpdatedAt,
+	).Scan(&entry.ID)
+	return entry.ID, glossaryTermError(err)
+}
+
+func (s *postgresDB) GetGlossaryEntry(ctx context.Context, entryID int64) (*models.GlossaryEntry, error) {
+	query := `SELECT ` + glossaryEntryColumns + ` FROM glossary_entries WHERE id = $1`
+	entry, err := scanGlossaryEntry(s.db.QueryRowContext(ctx, query, entryID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return entry, err
+}
+
+func (s *postgresDB) GetGlossaryEntries(ctx context.Context, orgID int64) ([]*models.GlossaryEntry, error) {
+	query := `SELECT ` + glossaryEntryColumns + ` FROM glossary_entries WHERE org_id = $1 ORDER BY term`
+	return s.queryGlossaryEntries(ctx, query, orgID)
+}
+
+// GetGlossaryEntriesForUser returns the glossaries of every organization
+// userID belongs to.
+func (s *postgresDB) GetGlossaryEntriesForUser(ctx context.Context, userID int64) ([]*models.GlossaryEntry, error) {
+	query := `
+		SELECT ` + glossaryEntryColumns + `
+		FROM glossary_entries
+		WHERE org_id IN (SELECT org_id FROM organization_members WHERE user_id = $1)
+		ORDER BY org_id, term
+	`
+	return s.queryGlossaryEntries(ctx, query, userID)
+}
+
+func (s *postgresDB) UpdateGlossaryEntry(ctx context.Context, entry *models.GlossaryEntry) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE glossary_entries
+		SET term = $1, reading = $2, meaning = $3, romaji = $4, description = $5, context = $6, updated_at = $7
+		WHERE id = $8
+	`,
+		entry.Term,
+		entry.Reading,
+		entry.Meaning,
+		entry.Romaji,
+		entry.Description,
+		entry.Context,
+		entry.UpdatedAt,
+		entry.ID,
+	)
+	return glossaryTermError(err)
+}
+
+func (s *postgresDB) DeleteGlossaryEntry(ctx context.Context, entryID int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM glossary_entries WHERE id = $1`, entryID)
+	return err
 }
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/logger"
+)
+
+// ErrRedisUnavailable is returned by FailoverClient while Redis is down and
+// there is no fallback.
+var ErrRedisUnavailable = errors.New("redis unavailable")
+
+// fallbackPurgeInterval is how often expired keys are deleted from the
+// fallback store.
+const fallbackPurgeInterval = time.Minute
+
+// RedisStatus is the state of Redis as last seen by a FailoverClient. Since
+// is when Up last changed; Err is the failure that took Redis down.
+// Fallback is empty when there is none.
+type RedisStatus struct {
+	Up       bool
+	Fallback string
+	Since    time.Time
+	Err      error
+}
+
+// FailoverClient uses Redis while it is up and the fallback store while it
+// is not, checking Redis every interval to switch back once it recovers.
+// Reads that miss in Redis also look in the fallback, so states and links
+// created during an outage keep working after it.
+//
+// The token denylist fails closed: denials made before an outage are only
+// in Redis, so while it is down IsTokenDenied fails, unless the fallback is
+// durable (it has a Durable method reporting true). Denials are then written
+// to the fallback as well as Redis, and it can answer on its own.
+type FailoverClient struct {
+	primary      RedisClient
+	fallback     RedisClient
+	fallbackName string
+	durable      bool
+
+	mu        sync.Mutex
+	up        bool
+	since     time.Time
+	lastErr   error
+	lastPurge time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewFailoverClient checks primary once and then every checkInterval.
+// fallback may be nil, in which case calls fail with ErrRedisUnavailable
+// during outages; fallbackName names it in the status.
+func NewFailoverClient(primary, fallback RedisClient, fallbackName string, checkInterval time.Duration) *FailoverClient {
+	if fallback == nil {
+		fallbackName = ""
+	}
+	durable, ok := fallback.(interface{ Durable() bool })
+	c := &FailoverClient{
+		primary:      primary,
+		fallback:     fallback,
+		fallbackName: fallbackName,
+		durable:      ok && durable.Durable(),
+		up:           true,
+		since:        time.Now(),
+		lastPurge:    time.Now(),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	c.Check(context.Background())
+
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.Check(context.Background())
+			}
+		}
+	}()
+	return c
+}
+
+// Check pings Redis and updates the status, and purges the fallback store
+// from time to time.
+func (c *FailoverClient) Check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if err := c.primary.Ping(ctx); err != nil {
+		c.markDown(err)
+	} else {
+		c.markUp()
+	}
+
+	purger, ok := c.fallback.(interface{ PurgeExpired(context.Context) error })
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	due := time.Since(c.lastPurge) >= fallbackPurgeInterval
+	if due {
+		c.lastPurge = time.Now()
+	}
+	c.mu.Unlock()
+	if due {
+		if err := purger.PurgeExpired(ctx); err != nil {
+			logger.GetDefaultLogger().ErrorWithErr(err, "Failed to purge expired keys from the Redis fallback")
+		}
+	}
+}
+
+func (c *FailoverClient) Status() RedisStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return RedisStatus{Up: c.up, Fallback: c.fallbackName, Since: c.since, Err: c.lastErr}
+}
+
+func (c *FailoverClient) isUp() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.up
+}
+
+func (c *FailoverClient) markDown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastErr = err
+	if !c.up {
+		return
+	}
+	c.up = false
+	c.since = time.Now()
+	if c.fallback != nil {
+		logger.GetDefaultLogger().Warnf("Redis is unavailable, using the %s fallback: %v", c.fallbackName, err)
+	} else {
+		logger.GetDefaultLogger().Warnf("Redis is unavailable and there is no fallback: %v", err)
+	}
+}
+
+func (c *FailoverClient) markUp() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastErr = nil
+	if c.up {
+		return
+	}
+	c.up = true
+	c.since = time.Now()
+	logger.GetDefaultLogger().Info("Redis is available again")
+}
+
+// write runs op on Redis, or on the fallback if Redis is or goes down.
+func (c *FailoverClient) write(op func(RedisClient) error) error {
+	var primaryErr error
+	if c.isUp() {
+		if primaryErr = op(c.primary); primaryErr == nil {
+			return nil
+		}
+		c.markDown(primaryErr)
+	}
+	if c.fallback == nil {
+		return unavailable(primaryErr)
+	}
+	return op(c.fallback)
+}
+
+// read runs op on Redis and, when Redis has nothing, on the fallback too.
+// found says whether a result is a hit.
+func read[T any](c *FailoverClient, op func(RedisClient) (T, error), found func(T) bool) (T, error) {
+	var primaryErr error
+	if c.isUp() {
+		value, err := op(c.primary)
+		if err == nil {
+			if found(value) || c.fallback == nil {
+				return value, nil
+			}
+			if fallbackValue, err := op(c.fallback); err == nil {
+				return fallbackValue, nil
+			}
+			return value, nil
+		}
+		primaryErr = err
+		c.markDown(err)
+	}
+	if c.fallback == nil {
+		var zero T
+		return zero, unavailable(primaryErr)
+	}
+	return op(c.fallback)
+}
+
+func unavailable(err error) error {
+	if err == nil {
+		return ErrRedisUnavailable
+	}
+	return fmt.Errorf("%w: %v", ErrRedisUnavailable, err)
+}
+
+func (c *FailoverClient) SetState(ctx context.Context, state, value string, ttl time.Duration) error {
+	return c.write(func(client RedisClient) error {
+		return client.SetState(ctx, state, value, ttl)
+	})
+}
+
+func (c *FailoverClient) ConsumeState(ctx context.Context, state string) (string, error) {
+	return read(c, func(client RedisClient) (string, error) {
+		return client.ConsumeState(ctx, state)
+	}, isSet)
+}
+
+// DenyToken writes the denial to Redis, and to a durable fallback too so
+// that it survives an outage.
+func (c *FailoverClient) DenyToken(ctx context.Context, jti string, ttl time.Duration) error {
+	if !c.durable {
+		return c.write(func(client RedisClient) error {
+			return client.DenyToken(ctx, jti, ttl)
+		})
+	}
+
+	if err := c.fallback.DenyToken(ctx, jti, ttl); err != nil {
+		return err
+	}
+	if c.isUp() {
+		if err := c.primary.DenyToken(ctx, jti, ttl); err != nil {
+			c.markDown(err)
+		}
+	}
+	return nil
+}
+
+// IsTokenDenied reports tokens denied in Redis or in the fallback. While
+// Redis is down it fails with ErrRedisUnavailable unless the fallback is
+// durable, since tokens revoked before the outage are not in the fallback.
+func (c *FailoverClient) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	if c.durable {
+		return read(c, func(client RedisClient) (bool, error) {
+			return client.IsTokenDenied(ctx, jti)
+		}, func(denied bool) bool { return denied })
+	}
+
+	if !c.isUp() {
+		return false, unavailable(c.Status().Err)
+	}
+	denied, err := c.primary.IsTokenDenied(ctx, jti)
+	if err != nil {
+		c.markDown(err)
+		return false, unavailable(err)
+	}
+	if denied || c.fallback == nil {
+		return denied, nil
+	}
+	// Tokens denied during an earlier outage are only in the fallback.
+	return c.fallback.IsTokenDenied(ctx, jti)
+}
+
+func (c *FailoverClient) SetMagicLink(ctx context.Context, tokenHash, email string, ttl time.Duration) error {
+	return c.write(func(client RedisClient) error {
+		return client.SetMagicLink(ctx, tokenHash, email, ttl)
+	})
+}
+
+func (c *FailoverClient) ConsumeMagicLink(ctx context.Context, tokenHash string) (string, error) {
+	return read(c, func(client RedisClient) (string, error) {
+		return client.ConsumeMagicLink(ctx, tokenHash)
+	}, isSet)
+}
+
+// Ping succeeds while Redis or the fallback can serve requests.
+func (c *FailoverClient) Ping(ctx context.Context) error {
+	err := c.primary.Ping(ctx)
+	if err == nil || c.fallback == nil {
+		return err
+	}
+	return c.fallback.Ping(ctx)
+}
+
+// Close stops checking Redis and closes both clients.
+func (c *FailoverClient) Close() error {
+	close(c.stop)
+	<-c.done
+
+	err := c.primary.Close()
+	if c.fallback != nil {
+		err = errors.Join(err, c.fallback.Close())
+	}
+	return err
+}
+
+func isSet(value string) bool {
+	return value != ""
+}
@@ -0,0 +1,123 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/storage"
+	"github.com/gemini-hackathon/app/internal/testutil"
+)
+
+func TestFailoverClient(t *testing.T) {
+	ctx := context.Background()
+	redis := testutil.NewMockRedisClient()
+	redis.Err = errors.New("connection refused")
+
+	client := storage.NewFailoverClient(redis, storage.NewMemoryClient(), "memory", time.Hour)
+	defer client.Close()
+
+	if status := client.Status(); status.Up || status.Fallback != "memory" || status.Err == nil {
+		t.Fatalf("Expected Redis to be down with the memory fallback, got %+v", status)
+	}
+
+	if err := client.SetState(ctx, "outage", "google", time.Minute); err != nil {
+		t.Fatalf("Expected the fallback to store the state, got %v", err)
+	}
+	if err := client.DenyToken(ctx, "jti-1", time.Minute); err != nil {
+		t.Fatalf("Expected the fallback to store the denied token, got %v", err)
+	}
+	if _, err := client.IsTokenDenied(ctx, "jti-0"); !errors.Is(err, storage.ErrRedisUnavailable) {
+		t.Errorf("Expected the denylist to fail closed on the memory fallback, got %v", err)
+	}
+
+	redis.Err = nil
+	client.Check(ctx)
+	if status := client.Status(); !status.Up {
+		t.Fatalf("Expected Redis to be up again, got %+v", status)
+	}
+
+	if value, err := client.ConsumeState(ctx, "outage"); err != nil || value != "google" {
+		t.Errorf("Expected a state from the outage to still work, got %q, %v", value, err)
+	}
+	if value, _ := client.ConsumeState(ctx, "outage"); value != "" {
+		t.Errorf("Expected the state to be single-use, got %q", value)
+	}
+	if denied, err := client.IsTokenDenied(ctx, "jti-1"); err != nil || !denied {
+		t.Errorf("Expected a token denied during the outage to stay denied, got %v, %v", denied, err)
+	}
+
+	if err := client.SetState(ctx, "redis", "github", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := redis.ConsumeState(ctx, "redis"); value != "github" {
+		t.Errorf("Expected new states to go to Redis, got %q", value)
+	}
+}
+
+// durableClient marks a fallback as durable, like the Postgres one.
+type durableClient struct {
+	storage.RedisClient
+}
+
+func (durableClient) Durable() bool {
+	return true
+}
+
+func TestFailoverClientDurableDenylist(t *testing.T) {
+	ctx := context.Background()
+	redis := testutil.NewMockRedisClient()
+	client := storage.NewFailoverClient(redis, durableClient{storage.NewMemoryClient()}, "postgres", time.Hour)
+	defer client.Close()
+
+	if err := client.DenyToken(ctx, "before", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if denied, _ := redis.IsTokenDenied(ctx, "before"); !denied {
+		t.Errorf("Expected the denial to be written to Redis")
+	}
+
+	redis.Err = errors.New("connection refused")
+	client.Check(ctx)
+	if denied, err := client.IsTokenDenied(ctx, "before"); err != nil || !denied {
+		t.Errorf("Expected a token denied before the outage to stay denied, got %v, %v", denied, err)
+	}
+	if denied, err := client.IsTokenDenied(ctx, "other"); err != nil || denied {
+		t.Errorf("Expected other tokens to be allowed, got %v, %v", denied, err)
+	}
+}
+
+func TestFailoverClientWithoutFallback(t *testing.T) {
+	redis := testutil.NewMockRedisClient()
+	redis.Err = errors.New("connection refused")
+
+	client := storage.NewFailoverClient(redis, nil, "none", time.Hour)
+	defer client.Close()
+
+	if status := client.Status(); status.Up || status.Fallback != "" {
+		t.Fatalf("Expected Redis to be down without a fallback, got %+v", status)
+	}
+	if err := client.SetState(context.Background(), "s", "google", time.Minute); !errors.Is(err, storage.ErrRedisUnavailable) {
+		t.Errorf("Expected ErrRedisUnavailable, got %v", err)
+	}
+	if _, err := client.IsTokenDenied(context.Background(), "jti"); !errors.Is(err, storage.ErrRedisUnavailable) {
+		t.Errorf("Expected ErrRedisUnavailable, got %v", err)
+	}
+}
+
+func TestMemoryClientExpiry(t *testing.T) {
+	ctx := context.Background()
+	client := storage.NewMemoryClient()
+
+	client.SetMagicLink(ctx, "short", "a@example.com", time.Millisecond)
+	client.SetMagicLink(ctx, "long", "b@example.com", time.Minute)
+	time.Sleep(5 * time.Millisecond)
+
+	if email, _ := client.ConsumeMagicLink(ctx, "short"); email != "" {
+		t.Errorf("Expected an expired link to be gone, got %q", email)
+	}
+	if email, _ := client.ConsumeMagicLink(ctx, "long"); email != "b@example.com" {
+		t.Errorf("Expected b@example.com, got %q", email)
+	}
+}
@@ -1,20 +1,35 @@
 package storage
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/gemini-hackathon/app/internal/config"
 )
 
 type FileStorage interface {
 	SaveImage(scanID string, data []byte, mimeType string) (string, *string, error)
+	SaveImageStream(scanID string, r io.Reader, size int64, mimeType string) (string, *string, error)
 	OpenImage(path string) ([]byte, error)
+	OpenImageReader(path string) (io.ReadSeekCloser, error)
 	DeleteImage(path string) error
 }
 
+// NewFileStorage returns the backend selected by STORAGE_BACKEND.
+func NewFileStorage(cfg *config.Config) (FileStorage, error) {
+	switch cfg.StorageBackend {
+	case "s3":
+		return NewS3FileStorage(S3OptionsFromConfig(cfg))
+	default:
+		return NewLocalFileStorage(cfg.UploadDir)
+	}
+}
+
 type localFileStorage struct {
 	baseDir string
 }
@@ -27,16 +42,25 @@ func NewLocalFileStorage(baseDir string) (FileStorage, error) {
 }
 
 func (l *localFileStorage) SaveImage(scanID string, data []byte, mimeType string) (string, *string, error) {
-	ext := getExtensionFromMimeType(mimeType)
-	filename := fmt.Sprintf("%s%s", scanID, ext)
+	return l.SaveImageStream(scanID, bytes.NewReader(data), int64(len(data)), mimeType)
+}
+
+func (l *localFileStorage) SaveImageStream(scanID string, r io.Reader, size int64, mimeType string) (string, *string, error) {
+	filename := ImageFilename(scanID, mimeType)
 	path := filepath.Join(l.baseDir, filename)
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to write image file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(file, io.TeeReader(r, hash)); err != nil {
 		return "", nil, fmt.Errorf("failed to write image file: %w", err)
 	}
 
-	hash := sha256.Sum256(data)
-	hashStr := hex.EncodeToString(hash[:])
+	hashStr := hex.EncodeToString(hash.Sum(nil))
 
 	return path, &hashStr, nil
 }
@@ -49,6 +73,14 @@ func (l *localFileStorage) OpenImage(path string) ([]byte, error) {
 	return data, nil
 }
 
+func (l *localFileStorage) OpenImageReader(path string) (io.ReadSeekCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image file: %w", err)
+	}
+	return file, nil
+}
+
 func (l *localFileStorage) DeleteImage(path string) error {
 	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete image file: %w", err)
@@ -56,6 +88,11 @@ func (l *localFileStorage) DeleteImage(path string) error {
 	return nil
 }
 
+// ImageFilename returns the object name used for a scan image, e.g. "42.jpg".
+func ImageFilename(scanID string, mimeType string) string {
+	return fmt.Sprintf("%s%s", scanID, getExtensionFromMimeType(mimeType))
+}
+
 func getExtensionFromMimeType(mimeType string) string {
 	switch mimeType {
 	case "image/jpeg", "image/jpg":
@@ -64,11 +101,29 @@ func getExtensionFromMimeType(mimeType string) string {
 		return ".png"
 	case "image/webp":
 		return ".webp"
+	case "application/pdf":
+		return ".pdf"
 	default:
 		return ".bin"
 	}
 }
 
+// MimeTypeFromExtension is the inverse of getExtensionFromMimeType.
+func MimeTypeFromExtension(ext string) string {
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	case ".pdf":
+		return "application/pdf"
+	default:
+		return "application/octet-stream"
+	}
+}
+
 func CalculateSHA256(reader io.Reader) (string, error) {
 	hash := sha256.New()
 	if _, err := io.Copy(hash, reader); err != nil {
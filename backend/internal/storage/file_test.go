@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestLocalFileStorage_SaveAndOpen(t *testing.T) {
+	fs, err := NewLocalFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalFileStorage() error = %v", err)
+	}
+
+	data := []byte("fake image bytes")
+	path, hash, err := fs.SaveImage("42", data, "image/png")
+	if err != nil {
+		t.Fatalf("SaveImage() error = %v", err)
+	}
+	if !strings.HasSuffix(path, "42.png") {
+		t.Errorf("Expected path ending in 42.png, got %q", path)
+	}
+
+	sum := sha256.Sum256(data)
+	if hash == nil || *hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected hash %v", hash)
+	}
+
+	got, err := fs.OpenImage(path)
+	if err != nil {
+		t.Fatalf("OpenImage() error = %v", err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("OpenImage() = %q; want %q", got, data)
+	}
+
+	if err := fs.DeleteImage(path); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected file to be removed")
+	}
+}
+
+func TestNewServerSideEncryption(t *testing.T) {
+	validKey := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	tests := []struct {
+		name    string
+		opts    S3Options
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "none", opts: S3Options{}, wantNil: true},
+		{name: "sse-s3", opts: S3Options{SSE: SSES3}},
+		{name: "sse-kms", opts: S3Options{SSE: SSEKMS, SSEKMSKeyID: "key-id"}},
+		{name: "sse-kms without key", opts: S3Options{SSE: SSEKMS}, wantErr: true},
+		{name: "sse-c", opts: S3Options{SSE: SSEC, SSECustomerKey: validKey}},
+		{name: "sse-c short key", opts: S3Options{SSE: SSEC, SSECustomerKey: "c2hvcnQ="}, wantErr: true},
+		{name: "unknown", opts: S3Options{SSE: "rot13"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sse, err := newServerSideEncryption(tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newServerSideEncryption() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (sse == nil) != tt.wantNil {
+				t.Errorf("newServerSideEncryption() = %v, wantNil %v", sse, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestS3FileStorage_Timeout(t *testing.T) {
+	// A bucket that does not answer until the test ends.
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	endpoint, _ := url.Parse(server.URL)
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4("key", "secret", ""),
+		Region:       "us-east-1",
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		t.Fatalf("minio.New() error = %v", err)
+	}
+	fs := &s3FileStorage{client: client, bucket: "scans", timeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := fs.OpenImage("1.jpg"); err == nil {
+		t.Error("Expected OpenImage() to fail")
+	}
+	if _, _, err := fs.SaveImage("1", []byte("image"), "image/jpeg"); err == nil {
+		t.Error("Expected SaveImage() to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected requests to give up after the timeout, took %v", elapsed)
+	}
+}
+
+// TestS3FileStorage_Integration runs against a real S3-compatible endpoint,
+// e.g. the MinIO service in docker-compose.yml:
+//
+//	S3_TEST_ENDPOINT=localhost:9000 S3_TEST_BUCKET=scans \
+//	S3_TEST_ACCESS_KEY_ID=minioadmin S3_TEST_SECRET_ACCESS_KEY=minioadmin go test ./internal/storage
+func TestS3FileStorage_Integration(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("Skipping integration test: S3_TEST_ENDPOINT not set")
+	}
+
+	fs, err := NewS3FileStorage(S3Options{
+		Endpoint:        endpoint,
+		Bucket:          os.Getenv("S3_TEST_BUCKET"),
+		Prefix:          "test",
+		AccessKeyID:     os.Getenv("S3_TEST_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_TEST_SECRET_ACCESS_KEY"),
+		ForcePathStyle:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3FileStorage() error = %v", err)
+	}
+
+	data := []byte("fake image bytes")
+	key, hash, err := fs.SaveImageStream("integration", strings.NewReader(string(data)), -1, "image/jpeg")
+	if err != nil {
+		t.Fatalf("SaveImageStream() error = %v", err)
+	}
+	if key != "test/integration.jpg" {
+		t.Errorf("Expected key test/integration.jpg, got %q", key)
+	}
+	if hash == nil || *hash == "" {
+		t.Error("Expected a content hash")
+	}
+
+	got, err := fs.OpenImage(key)
+	if err != nil {
+		t.Fatalf("OpenImage() error = %v", err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("OpenImage() = %q; want %q", got, data)
+	}
+
+	if err := fs.DeleteImage(key); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+}
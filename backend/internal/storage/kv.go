@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// kvStore is a key-value store with expiring keys, the subset of Redis the
+// app relies on.
+type kvStore interface {
+	set(ctx context.Context, key, value string, ttl time.Duration) error
+	// get and getDel report found=false for missing or expired keys.
+	get(ctx context.Context, key string) (value string, found bool, err error)
+	getDel(ctx context.Context, key string) (value string, found bool, err error)
+	ping(ctx context.Context) error
+	purgeExpired(ctx context.Context) error
+}
+
+// kvClient implements RedisClient on a kvStore, with the same keys as the
+// Redis client.
+type kvClient struct {
+	store   kvStore
+	durable bool
+}
+
+// NewMemoryClient returns a RedisClient that keeps everything in process
+// memory. It is lost on restart and not shared between instances.
+func NewMemoryClient() RedisClient {
+	return &kvClient{store: &memoryStore{entries: make(map[string]memoryEntry)}}
+}
+
+// NewPostgresKVClient returns a RedisClient storing keys in the kv_store
+// table, shared by every instance using the database.
+func NewPostgresKVClient(db *sql.DB) RedisClient {
+	return &kvClient{store: &postgresStore{db: db}, durable: true}
+}
+
+func (c *kvClient) SetState(ctx context.Context, state, value string, ttl time.Duration) error {
+	return c.store.set(ctx, "oauth:state:"+state, value, ttl)
+}
+
+func (c *kvClient) ConsumeState(ctx context.Context, state string) (string, error) {
+	value, _, err := c.store.getDel(ctx, "oauth:state:"+state)
+	return value, err
+}
+
+func (c *kvClient) DenyToken(ctx context.Context, jti string, ttl time.Duration) error {
+	return c.store.set(ctx, "jwt:deny:"+jti, "1", ttl)
+}
+
+func (c *kvClient) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	_, found, err := c.store.get(ctx, "jwt:deny:"+jti)
+	return found, err
+}
+
+func (c *kvClient) SetMagicLink(ctx context.Context, tokenHash, email string, ttl time.Duration) error {
+	return c.store.set(ctx, "magic:link:"+tokenHash, email, ttl)
+}
+
+func (c *kvClient) ConsumeMagicLink(ctx context.Context, tokenHash string) (string, error) {
+	email, _, err := c.store.getDel(ctx, "magic:link:"+tokenHash)
+	return email, err
+}
+
+func (c *kvClient) Ping(ctx context.Context) error {
+	return c.store.ping(ctx)
+}
+
+// PurgeExpired deletes expired keys. Expired keys are never returned, so
+// this only frees space.
+func (c *kvClient) PurgeExpired(ctx context.Context) error {
+	return c.store.purgeExpired(ctx)
+}
+
+// Durable reports whether keys outlive the process and are shared between
+// instances.
+func (c *kvClient) Durable() bool {
+	return c.durable
+}
+
+func (c *kvClient) Close() error {
+	return nil
+}
+
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+func (s *memoryStore) set(ctx context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = memoryEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryStore) get(ctx context.Context, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (s *memoryStore) getDel(ctx context.Context, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	delete(s.entries, key)
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (s *memoryStore) ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) purgeExpired(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
+
+// postgresStore keeps keys in kv_store. Expiry uses the database clock so
+// that instances agree on it.
+type postgresStore struct {
+	db *sql.DB
+}
+
+func (s *postgresStore) set(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO kv_store (key, value, expires_at)
+		VALUES ($1, $2, NOW() + $3 * INTERVAL '1 millisecond')
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at
+	`, key, value, ttl.Milliseconds())
+	return err
+}
+
+func (s *postgresStore) get(ctx context.Context, key string) (string, bool, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT value FROM kv_store WHERE key = $1 AND expires_at > NOW()
+	`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+// getDel deletes the key and returns its value in one statement, so two
+// callers cannot both consume it.
+func (s *postgresStore) getDel(ctx context.Context, key string) (string, bool, error) {
+	var value string
+	var live bool
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM kv_store WHERE key = $1
+		RETURNING value, expires_at > NOW()
+	`, key).Scan(&value, &live)
+	if err == sql.ErrNoRows || (err == nil && !live) {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s *postgresStore) ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *postgresStore) purgeExpired(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM kv_store WHERE expires_at <= NOW()`)
+	return err
+}
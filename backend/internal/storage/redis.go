@@ -8,9 +8,13 @@ import (
 )
 
 type RedisClient interface {
-	SetState(ctx context.Context, state, sessionID string, ttl time.Duration) error
-	GetState(ctx context.Context, state string) (string, error)
-	DeleteState(ctx context.Context, state string) error
+	SetState(ctx context.Context, state, value string, ttl time.Duration) error
+	ConsumeState(ctx context.Context, state string) (string, error)
+	DenyToken(ctx context.Context, jti string, ttl time.Duration) error
+	IsTokenDenied(ctx context.Context, jti string) (bool, error)
+	SetMagicLink(ctx context.Context, tokenHash, email string, ttl time.Duration) error
+	ConsumeMagicLink(ctx context.Context, tokenHash string) (string, error)
+	Ping(ctx context.Context) error
 	Close() error
 }
 
@@ -19,33 +23,75 @@ type redisClientImpl struct {
 }
 
 func NewRedisClient(addr string) (RedisClient, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
+	client := OpenRedisClient(addr)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := client.Ping(ctx).Err(); err != nil {
+	if err := client.Ping(ctx); err != nil {
+		client.Close()
 		return nil, err
 	}
 
-	return &redisClientImpl{client: client}, nil
+	return client, nil
 }
 
-func (c *redisClientImpl) SetState(ctx context.Context, state, sessionID string, ttl time.Duration) error {
-	key := "oauth:state:" + state
-	return c.client.Set(ctx, key, sessionID, ttl).Err()
+// OpenRedisClient returns a client for addr without checking that Redis is
+// up. Connections are made, and remade after failures, as commands run.
+func OpenRedisClient(addr string) RedisClient {
+	return &redisClientImpl{client: redis.NewClient(&redis.Options{
+		Addr: addr,
+	})}
 }
 
-func (c *redisClientImpl) GetState(ctx context.Context, state string) (string, error) {
+func (c *redisClientImpl) SetState(ctx context.Context, state, value string, ttl time.Duration) error {
 	key := "oauth:state:" + state
-	return c.client.Get(ctx, key).Result()
+	return c.client.Set(ctx, key, value, ttl).Err()
 }
 
-func (c *redisClientImpl) DeleteState(ctx context.Context, state string) error {
+// ConsumeState returns the value stored with an OAuth state and deletes it
+// in the same command, so a state completes at most one callback. It
+// returns "" for unknown or expired states.
+func (c *redisClientImpl) ConsumeState(ctx context.Context, state string) (string, error) {
 	key := "oauth:state:" + state
-	return c.client.Del(ctx, key).Err()
+	value, err := c.client.GetDel(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return value, err
+}
+
+// DenyToken records a revoked access token until ttl, when it expires
+// anyway.
+func (c *redisClientImpl) DenyToken(ctx context.Context, jti string, ttl time.Duration) error {
+	key := "jwt:deny:" + jti
+	return c.client.Set(ctx, key, "1", ttl).Err()
+}
+
+func (c *redisClientImpl) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	key := "jwt:deny:" + jti
+	n, err := c.client.Exists(ctx, key).Result()
+	return n > 0, err
+}
+
+func (c *redisClientImpl) SetMagicLink(ctx context.Context, tokenHash, email string, ttl time.Duration) error {
+	key := "magic:link:" + tokenHash
+	return c.client.Set(ctx, key, email, ttl).Err()
+}
+
+// ConsumeMagicLink returns the email of a magic link and deletes it, so each
+// link works once. It returns "" for unknown or expired links.
+func (c *redisClientImpl) ConsumeMagicLink(ctx context.Context, tokenHash string) (string, error) {
+	key := "magic:link:" + tokenHash
+	email, err := c.client.GetDel(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return email, err
+}
+
+func (c *redisClientImpl) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
 }
 
 func (c *redisClientImpl) Close() error {
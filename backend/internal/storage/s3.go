@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+
+	"github.com/gemini-hackathon/app/internal/config"
+)
+
+// Server-side encryption modes accepted by S3Options.SSE.
+const (
+	SSENone = ""
+	SSES3   = "sse-s3"
+	SSEKMS  = "sse-kms"
+	SSEC    = "sse-c"
+)
+
+// S3Options configures an S3-compatible bucket (AWS S3, MinIO, R2, ...).
+type S3Options struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	ForcePathStyle  bool
+
+	// SSE selects server-side encryption: "", "sse-s3", "sse-kms" or "sse-c".
+	SSE string
+	// SSEKMSKeyID is the KMS key used when SSE is "sse-kms".
+	SSEKMSKeyID string
+	// SSECustomerKey is the base64-encoded 32-byte key used when SSE is "sse-c".
+	SSECustomerKey string
+
+	// Timeout bounds each request to the bucket; 0 uses defaultS3Timeout.
+	Timeout time.Duration
+}
+
+// defaultS3Timeout bounds bucket requests when S3Options.Timeout is unset.
+const defaultS3Timeout = time.Minute
+
+// S3OptionsFromConfig builds S3Options from the S3_* environment settings.
+func S3OptionsFromConfig(cfg *config.Config) S3Options {
+	return S3Options{
+		Endpoint:        cfg.S3Endpoint,
+		Region:          cfg.S3Region,
+		Bucket:          cfg.S3Bucket,
+		Prefix:          cfg.S3Prefix,
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: cfg.S3SecretAccessKey,
+		UseSSL:          cfg.S3UseSSL,
+		ForcePathStyle:  cfg.S3ForcePathStyle,
+		SSE:             cfg.S3SSE,
+		SSEKMSKeyID:     cfg.S3SSEKMSKeyID,
+		SSECustomerKey:  cfg.S3SSECustomerKey,
+		Timeout:         time.Duration(cfg.S3TimeoutSeconds) * time.Second,
+	}
+}
+
+type s3FileStorage struct {
+	client  *minio.Client
+	bucket  string
+	prefix  string
+	sse     encrypt.ServerSide
+	timeout time.Duration
+}
+
+func NewS3FileStorage(opts S3Options) (FileStorage, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket is required")
+	}
+
+	sse, err := newServerSideEncryption(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := minio.BucketLookupAuto
+	if opts.ForcePathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, ""),
+		Secure:       opts.UseSSL,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	exists, err := client.BucketExists(ctx, opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check S3 bucket: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("S3 bucket %q does not exist", opts.Bucket)
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultS3Timeout
+	}
+
+	return &s3FileStorage{
+		client:  client,
+		bucket:  opts.Bucket,
+		prefix:  strings.Trim(opts.Prefix, "/"),
+		sse:     sse,
+		timeout: timeout,
+	}, nil
+}
+
+func newServerSideEncryption(opts S3Options) (encrypt.ServerSide, error) {
+	switch strings.ToLower(opts.SSE) {
+	case SSENone:
+		return nil, nil
+	case SSES3:
+		return encrypt.NewSSE(), nil
+	case SSEKMS:
+		if opts.SSEKMSKeyID == "" {
+			return nil, fmt.Errorf("S3 SSE-KMS requires a KMS key ID")
+		}
+		return encrypt.NewSSEKMS(opts.SSEKMSKeyID, nil)
+	case SSEC:
+		key, err := base64.StdEncoding.DecodeString(opts.SSECustomerKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 SSE-C key: %w", err)
+		}
+		return encrypt.NewSSEC(key)
+	default:
+		return nil, fmt.Errorf("unsupported S3 server-side encryption %q", opts.SSE)
+	}
+}
+
+func (s *s3FileStorage) SaveImage(scanID string, data []byte, mimeType string) (string, *string, error) {
+	return s.SaveImageStream(scanID, bytes.NewReader(data), int64(len(data)), mimeType)
+}
+
+// SaveImageStream uploads r without buffering it in memory. A size of -1
+// lets the client fall back to a multipart upload of unknown length.
+func (s *s3FileStorage) SaveImageStream(scanID string, r io.Reader, size int64, mimeType string) (string, *string, error) {
+	key := s.objectKey(ImageFilename(scanID, mimeType))
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	hash := sha256.New()
+	_, err := s.client.PutObject(ctx, s.bucket, key, io.TeeReader(r, hash), size, minio.PutObjectOptions{
+		ContentType:          mimeType,
+		ServerSideEncryption: s.sse,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to upload image to S3: %w", err)
+	}
+
+	hashStr := hex.EncodeToString(hash.Sum(nil))
+
+	return key, &hashStr, nil
+}
+
+func (s *s3FileStorage) OpenImage(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{
+		ServerSideEncryption: s.readEncryption(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image from S3: %w", err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image from S3: %w", err)
+	}
+	return data, nil
+}
+
+// OpenImageReader returns a seekable handle that fetches byte ranges from S3
+// on demand, so range requests do not download the whole object. The timeout
+// covers the handle's whole life, until it is closed.
+func (s *s3FileStorage) OpenImageReader(key string) (io.ReadSeekCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{
+		ServerSideEncryption: s.readEncryption(),
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to open image from S3: %w", err)
+	}
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to open image from S3: %w", err)
+	}
+	return &s3Object{Object: obj, cancel: cancel}, nil
+}
+
+// s3Object releases the request context of an object handle when it is
+// closed.
+type s3Object struct {
+	*minio.Object
+	cancel context.CancelFunc
+}
+
+func (o *s3Object) Close() error {
+	defer o.cancel()
+	return o.Object.Close()
+}
+
+func (s *s3FileStorage) DeleteImage(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete image from S3: %w", err)
+	}
+	return nil
+}
+
+func (s *s3FileStorage) objectKey(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return path.Join(s.prefix, name)
+}
+
+// readEncryption returns the SSE headers required on GET. Only SSE-C needs the
+// key to be resent; SSE-S3 and SSE-KMS are transparent to readers.
+func (s *s3FileStorage) readEncryption() encrypt.ServerSide {
+	if s.sse != nil && s.sse.Type() == encrypt.SSEC {
+		return s.sse
+	}
+	return nil
+}
@@ -2,20 +2,66 @@ package testutil
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/gemini-hackathon/app/internal/gemini"
+	"github.com/gemini-hackathon/app/internal/knowledge"
+	"github.com/gemini-hackathon/app/internal/mail"
 	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
 )
 
+// MockDB is an in-memory storage.DB. It is safe for the background OCR
+// goroutines that handlers start.
 type MockDB struct {
+	// PingErr is returned by Ping, to simulate the database being down.
+	PingErr error
+	// RevisionErr is returned by the methods that write OCR revisions,
+	// which then change nothing.
+	RevisionErr error
+
+	mu             sync.Mutex
 	users          map[int64]*models.User
 	scans          map[int64]*models.Scan
 	annotations    map[int64]*models.Annotation
+	thumbnails     map[int64]map[int]*models.ScanThumbnail
+	pages          map[int64][]*models.ScanPage
+	revisions      map[int64][]*models.OCRRevision
+	reprocesses    []reprocessRequest
+	magicLinks     []magicLinkRequest
+	translations   map[string]*models.ScanTranslation
+	vocabularies   map[string]*models.ScanVocabulary
+	analyses       map[int64]*models.Analysis
+	messages       map[int64][]*models.AnnotationMessage
+	refreshTokens  map[int64]*models.RefreshToken
+	identities     map[int64]*models.UserIdentity
+	scanFailures   []*models.ScanFailure
+	auditEntries   []*models.AuditEntry
+	orgs           map[int64]*models.Organization
+	orgMembers     []*models.OrgMember
+	invitations    map[int64]*models.OrgInvitation
+	glossary       map[int64]*models.GlossaryEntry
 	userByEmail    map[string]*models.User
 	userByProvider map[string]*models.User
 	nextUserID     int64
 	nextScanID     int64
 	nextAnnID      int64
+	nextPageID     int64
+	nextRevisionID int64
+	nextMessageID  int64
+	nextAnalysisID int64
+	nextTokenID    int64
+	nextIdentityID int64
+	nextFailureID  int64
+	nextAuditID    int64
+	nextOrgID      int64
+	nextGlossaryID int64
+	nextInviteID   int64
 }
 
 func NewMockDB() *MockDB {
@@ -23,17 +69,50 @@ func NewMockDB() *MockDB {
 		users:          make(map[int64]*models.User),
 		scans:          make(map[int64]*models.Scan),
 		annotations:    make(map[int64]*models.Annotation),
+		thumbnails:     make(map[int64]map[int]*models.ScanThumbnail),
+		pages:          make(map[int64][]*models.ScanPage),
+		revisions:      make(map[int64][]*models.OCRRevision),
+		translations:   make(map[string]*models.ScanTranslation),
+		vocabularies:   make(map[string]*models.ScanVocabulary),
+		analyses:       make(map[int64]*models.Analysis),
+		messages:       make(map[int64][]*models.AnnotationMessage),
+		refreshTokens:  make(map[int64]*models.RefreshToken),
+		identities:     make(map[int64]*models.UserIdentity),
 		userByEmail:    make(map[string]*models.User),
 		userByProvider: make(map[string]*models.User),
+		orgs:           make(map[int64]*models.Organization),
+		glossary:       make(map[int64]*models.GlossaryEntry),
+		invitations:    make(map[int64]*models.OrgInvitation),
 		nextUserID:     1,
 		nextScanID:     1,
 		nextAnnID:      1,
+		nextPageID:     1,
+		nextRevisionID: 1,
+		nextMessageID:  1,
+		nextAnalysisID: 1,
+		nextTokenID:    1,
+		nextIdentityID: 1,
+		nextFailureID:  1,
+		nextAuditID:    1,
+		nextOrgID:      1,
+		nextGlossaryID: 1,
+		nextInviteID:   1,
 	}
 }
 
+func (m *MockDB) Ping(ctx context.Context) error {
+	return m.PingErr
+}
+
 func (m *MockDB) CreateUser(ctx context.Context, user *models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	user.ID = m.nextUserID
 	m.nextUserID++
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
 	m.users[user.ID] = user
 	m.userByEmail[user.Email] = user
 	m.userByProvider[user.Provider+":"+user.ProviderID] = user
@@ -41,18 +120,30 @@ func (m *MockDB) CreateUser(ctx context.Context, user *models.User) error {
 }
 
 func (m *MockDB) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return m.userByEmail[email], nil
 }
 
 func (m *MockDB) GetUserByProvider(ctx context.Context, provider, providerID string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return m.userByProvider[provider+":"+providerID], nil
 }
 
 func (m *MockDB) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return m.users[userID], nil
 }
 
 func (m *MockDB) UpdateUserLanguage(ctx context.Context, userID int64, language string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if user, ok := m.users[userID]; ok {
 		user.PreferredLanguage = language
 		user.UpdatedAt = time.Now()
@@ -60,7 +151,236 @@ func (m *MockDB) UpdateUserLanguage(ctx context.Context, userID int64, language
 	return nil
 }
 
+func (m *MockDB) ListUsers(ctx context.Context, search string, page, size int) ([]*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []*models.User
+	for _, user := range m.users {
+		if strings.Contains(strings.ToLower(user.Email), strings.ToLower(search)) {
+			result = append(result, user)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+func (m *MockDB) UpdateUserRole(ctx context.Context, userID int64, role string, audit *models.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[userID]; ok {
+		user.Role = role
+		user.UpdatedAt = time.Now()
+	}
+	m.addAuditEntry(audit)
+	return nil
+}
+
+func (m *MockDB) DisableUser(ctx context.Context, userID int64, audit *models.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if user, ok := m.users[userID]; ok && user.DisabledAt == nil {
+		user.DisabledAt = &now
+		user.UpdatedAt = now
+	}
+	for _, token := range m.refreshTokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	m.addAuditEntry(audit)
+	return nil
+}
+
+func (m *MockDB) EnableUser(ctx context.Context, userID int64, audit *models.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[userID]; ok {
+		user.DisabledAt = nil
+		user.UpdatedAt = time.Now()
+	}
+	m.addAuditEntry(audit)
+	return nil
+}
+
+func (m *MockDB) RecordImpersonation(ctx context.Context, userID int64, audit *models.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok || user.Role == models.RoleAdmin || user.DisabledAt != nil {
+		return storage.ErrNotImpersonable
+	}
+	m.addAuditEntry(audit)
+	return nil
+}
+
+func (m *MockDB) GetUsageStats(ctx context.Context, since time.Time) (*models.UsageStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := &models.UsageStats{UsersByRole: make(map[string]int)}
+	for _, user := range m.users {
+		stats.Users++
+		stats.UsersByRole[user.Role]++
+		if user.DisabledAt != nil {
+			stats.DisabledUsers++
+		}
+		if !user.CreatedAt.Before(since) {
+			stats.NewUsers++
+		}
+	}
+	active := make(map[int64]bool)
+	for _, token := range m.refreshTokens {
+		if !token.CreatedAt.Before(since) {
+			active[token.UserID] = true
+		}
+	}
+	stats.ActiveUsers = len(active)
+	for _, scan := range m.scans {
+		stats.Scans++
+		if !scan.CreatedAt.Before(since) {
+			stats.NewScans++
+		}
+	}
+	failed := make(map[int64]bool)
+	for _, failure := range m.scanFailures {
+		if !failure.CreatedAt.Before(since) {
+			failed[failure.ScanID] = true
+		}
+	}
+	stats.FailedScans = len(failed)
+	for _, ann := range m.annotations {
+		stats.Annotations++
+		if !ann.CreatedAt.Before(since) {
+			stats.NewAnnotations++
+		}
+	}
+	return stats, nil
+}
+
+func (m *MockDB) CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.identityTaken(identity) {
+		return storage.ErrIdentityTaken
+	}
+	user.ID = m.nextUserID
+	m.nextUserID++
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	m.users[user.ID] = user
+	m.userByEmail[user.Email] = user
+	m.userByProvider[user.Provider+":"+user.ProviderID] = user
+
+	identity.UserID = user.ID
+	m.addIdentity(identity)
+	return nil
+}
+
+func (m *MockDB) identityTaken(identity *models.UserIdentity) bool {
+	for _, existing := range m.identities {
+		if existing.Provider == identity.Provider && existing.ProviderID == identity.ProviderID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MockDB) addIdentity(identity *models.UserIdentity) {
+	identity.ID = m.nextIdentityID
+	m.nextIdentityID++
+	identity.Email = strings.ToLower(identity.Email)
+	m.identities[identity.ID] = identity
+}
+
+func (m *MockDB) GetUserIdentity(ctx context.Context, provider, providerID string) (*models.UserIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, identity := range m.identities {
+		if identity.Provider == provider && identity.ProviderID == providerID {
+			return identity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockDB) GetUserIdentities(ctx context.Context, userID int64) ([]*models.UserIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var identities []*models.UserIdentity
+	for _, identity := range m.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].ID < identities[j].ID
+	})
+	return identities, nil
+}
+
+func (m *MockDB) GetUserByVerifiedEmail(ctx context.Context, email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found *models.User
+	for _, identity := range m.identities {
+		if identity.EmailVerified && identity.Email == strings.ToLower(email) {
+			if user := m.users[identity.UserID]; user != nil && (found == nil || user.ID < found.ID) {
+				found = user
+			}
+		}
+	}
+	return found, nil
+}
+
+func (m *MockDB) CreateUserIdentity(ctx context.Context, identity *models.UserIdentity) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.identityTaken(identity) {
+		return 0, storage.ErrIdentityTaken
+	}
+	m.addIdentity(identity)
+	return identity.ID, nil
+}
+
+func (m *MockDB) DeleteUserIdentity(ctx context.Context, userID, identityID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	identity := m.identities[identityID]
+	if identity == nil || identity.UserID != userID {
+		return false, nil
+	}
+	count := 0
+	for _, other := range m.identities {
+		if other.UserID == userID {
+			count++
+		}
+	}
+	if count < 2 {
+		return false, nil
+	}
+	delete(m.identities, identityID)
+	return true, nil
+}
+
 func (m *MockDB) CreateScan(ctx context.Context, scan *models.Scan) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	scan.ID = m.nextScanID
 	m.nextScanID++
 	m.scans[scan.ID] = scan
@@ -68,10 +388,16 @@ func (m *MockDB) CreateScan(ctx context.Context, scan *models.Scan) (int64, erro
 }
 
 func (m *MockDB) GetScanByID(ctx context.Context, scanID int64) (*models.Scan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return m.scans[scanID], nil
 }
 
 func (m *MockDB) GetScansByUserID(ctx context.Context, userID int64, page, size int) ([]*models.Scan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	var result []*models.Scan
 	for _, scan := range m.scans {
 		if scan.UserID == userID {
@@ -82,6 +408,9 @@ func (m *MockDB) GetScansByUserID(ctx context.Context, userID int64, page, size
 }
 
 func (m *MockDB) UpdateScanOCR(ctx context.Context, scanID int64, text, language string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if scan, ok := m.scans[scanID]; ok {
 		scan.FullOCRText = &text
 		scan.DetectedLanguage = &language
@@ -89,7 +418,250 @@ func (m *MockDB) UpdateScanOCR(ctx context.Context, scanID int64, text, language
 	return nil
 }
 
+func (m *MockDB) UpdateScanImage(ctx context.Context, scanID int64, imageURL string, imageHash *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if scan, ok := m.scans[scanID]; ok {
+		scan.ImageURL = imageURL
+		scan.ImageHash = imageHash
+	}
+	return nil
+}
+
+func (m *MockDB) UpdateScanOriginalImage(ctx context.Context, scanID int64, originalImageURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if scan, ok := m.scans[scanID]; ok {
+		scan.OriginalImageURL = &originalImageURL
+	}
+	return nil
+}
+
+func (m *MockDB) UpdateScanDocument(ctx context.Context, scanID int64, documentURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if scan, ok := m.scans[scanID]; ok {
+		scan.DocumentURL = &documentURL
+	}
+	return nil
+}
+
+func (m *MockDB) CreateScanPage(ctx context.Context, page *models.ScanPage) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	page.ID = m.nextPageID
+	m.nextPageID++
+	m.pages[page.ScanID] = append(m.pages[page.ScanID], page)
+	return page.ID, nil
+}
+
+func (m *MockDB) GetScanPages(ctx context.Context, scanID int64) ([]*models.ScanPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.pages[scanID], nil
+}
+
+func (m *MockDB) UpdateScanPageOCR(ctx context.Context, revision *models.OCRRevision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.RevisionErr != nil {
+		return m.RevisionErr
+	}
+	language := ""
+	if revision.Language != nil {
+		language = *revision.Language
+	}
+	for _, page := range m.pages[revision.ScanID] {
+		if page.PageNumber == revision.PageNumber {
+			text := revision.Text
+			page.OCRText = &text
+			page.DetectedLanguage = &language
+		}
+	}
+	m.addRevision(revision)
+	return nil
+}
+
+func (m *MockDB) UpdateScanPageImage(ctx context.Context, scanID int64, pageNumber int, imageURL string, imageHash *string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, page := range m.pages[scanID] {
+		if page.PageNumber == pageNumber {
+			page.ImageURL = imageURL
+			page.ImageHash = imageHash
+		}
+	}
+	return nil
+}
+
+func (m *MockDB) UpdateScanPageOriginalImage(ctx context.Context, scanID int64, pageNumber int, originalImageURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, page := range m.pages[scanID] {
+		if page.PageNumber == pageNumber {
+			page.OriginalImageURL = &originalImageURL
+		}
+	}
+	return nil
+}
+
+func (m *MockDB) UpdateScanPageCount(ctx context.Context, scanID int64, pageCount int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if scan, ok := m.scans[scanID]; ok {
+		scan.PageCount = pageCount
+	}
+	return nil
+}
+
+func (m *MockDB) DeleteScanPagesAfter(ctx context.Context, scanID int64, pageNumber int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []*models.ScanPage
+	for _, page := range m.pages[scanID] {
+		if page.PageNumber <= pageNumber {
+			kept = append(kept, page)
+		}
+	}
+	m.pages[scanID] = kept
+	return nil
+}
+
+func (m *MockDB) CreateOCRRevision(ctx context.Context, revision *models.OCRRevision) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.RevisionErr != nil {
+		return 0, m.RevisionErr
+	}
+	m.addRevision(revision)
+	return revision.ID, nil
+}
+
+// addRevision stores revision with the next ID. The caller holds m.mu.
+func (m *MockDB) addRevision(revision *models.OCRRevision) {
+	revision.ID = m.nextRevisionID
+	m.nextRevisionID++
+	m.revisions[revision.ScanID] = append(m.revisions[revision.ScanID], revision)
+}
+
+func (m *MockDB) GetOCRRevisions(ctx context.Context, scanID int64) ([]*models.OCRRevision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revisions := m.revisions[scanID]
+	result := make([]*models.OCRRevision, len(revisions))
+	for i, revision := range revisions {
+		result[len(revisions)-1-i] = revision
+	}
+	return result, nil
+}
+
+// reprocessRequest is a row of scan_reprocess_requests.
+type reprocessRequest struct {
+	scanID, userID int64
+	createdAt      time.Time
+}
+
+func (m *MockDB) RecordReprocess(ctx context.Context, scanID, userID int64, window time.Duration, scanLimit, userLimit int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since := time.Now().Add(-window)
+	var forScan, forUser int
+	for _, request := range m.reprocesses {
+		if request.createdAt.After(since) {
+			if request.scanID == scanID {
+				forScan++
+			}
+			if request.userID == userID {
+				forUser++
+			}
+		}
+	}
+	if forScan >= scanLimit || forUser >= userLimit {
+		return false, nil
+	}
+	m.reprocesses = append(m.reprocesses, reprocessRequest{scanID: scanID, userID: userID, createdAt: time.Now()})
+	return true, nil
+}
+
+func (m *MockDB) GetScansAfterID(ctx context.Context, afterID int64, limit int) ([]*models.Scan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []*models.Scan
+	for id := afterID + 1; id < m.nextScanID && len(result) < limit; id++ {
+		if scan, ok := m.scans[id]; ok {
+			result = append(result, scan)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockDB) CreateScanFailure(ctx context.Context, failure *models.ScanFailure) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failure.ID = m.nextFailureID
+	m.nextFailureID++
+	m.scanFailures = append(m.scanFailures, failure)
+	return failure.ID, nil
+}
+
+func (m *MockDB) GetScanFailures(ctx context.Context, page, size int) ([]*models.ScanFailure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []*models.ScanFailure
+	for i := len(m.scanFailures) - 1; i >= 0; i-- {
+		copied := *m.scanFailures[i]
+		if scan := m.scans[copied.ScanID]; scan != nil {
+			copied.UserID = scan.UserID
+		}
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+func (m *MockDB) UpsertScanThumbnail(ctx context.Context, thumbnail *models.ScanThumbnail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.thumbnails[thumbnail.ScanID] == nil {
+		m.thumbnails[thumbnail.ScanID] = make(map[int]*models.ScanThumbnail)
+	}
+	m.thumbnails[thumbnail.ScanID][thumbnail.Size] = thumbnail
+	return nil
+}
+
+func (m *MockDB) GetScanThumbnails(ctx context.Context, scanIDs []int64) (map[int64][]*models.ScanThumbnail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[int64][]*models.ScanThumbnail)
+	for _, scanID := range scanIDs {
+		for _, thumbnail := range m.thumbnails[scanID] {
+			result[scanID] = append(result[scanID], thumbnail)
+		}
+	}
+	return result, nil
+}
+
 func (m *MockDB) CreateAnnotation(ctx context.Context, annotation *models.Annotation) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	annotation.ID = m.nextAnnID
 	m.nextAnnID++
 	m.annotations[annotation.ID] = annotation
@@ -97,15 +669,842 @@ func (m *MockDB) CreateAnnotation(ctx context.Context, annotation *models.Annota
 }
 
 func (m *MockDB) GetAnnotationByID(ctx context.Context, annotationID int64) (*models.Annotation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	return m.annotations[annotationID], nil
 }
 
-func (m *MockDB) GetAnnotationsByUserID(ctx context.Context, userID int64, page, size int) ([]*models.Annotation, error) {
+func (m *MockDB) GetAnnotationsByUserID(ctx context.Context, userID int64, filter models.AnnotationFilter, page, size int) ([]*models.Annotation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matches := func(want, got string) bool { return want == "" || want == got }
+
+	var result []*models.Annotation
+	for _, ann := range m.annotations {
+		nuance := ann.NuanceData
+		if ann.UserID == userID &&
+			matches(filter.JLPTLevel, nuance.JLPTLevel) &&
+			matches(filter.PolitenessRegister, nuance.PolitenessRegister) &&
+			matches(filter.PartOfSpeech, nuance.PartOfSpeech) &&
+			matches(filter.Reading, nuance.Reading) {
+			result = append(result, ann)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if filter.Sort == models.AnnotationSortJLPT {
+			a, b := result[i].NuanceData.JLPTLevel, result[j].NuanceData.JLPTLevel
+			if a != b {
+				return b == "" || (a != "" && a < b)
+			}
+		}
+		return result[i].ID > result[j].ID
+	})
+	return result, nil
+}
+
+func (m *MockDB) GetAnnotationsByScanID(ctx context.Context, scanID int64) ([]*models.Annotation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	var result []*models.Annotation
 	for _, ann := range m.annotations {
-		if ann.UserID == userID {
+		if ann.ScanID != nil && *ann.ScanID == scanID {
 			result = append(result, ann)
 		}
 	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
 	return result, nil
 }
+
+func (m *MockDB) GetScanTranslation(ctx context.Context, scanID int64, targetLanguage string) (*models.ScanTranslation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	translation, ok := m.translations[fmt.Sprintf("%d:%s", scanID, targetLanguage)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *translation
+	return &copied, nil
+}
+
+func (m *MockDB) UpsertScanTranslation(ctx context.Context, translation *models.ScanTranslation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("%d:%s", translation.ScanID, translation.TargetLanguage)
+	if existing, ok := m.translations[key]; ok {
+		translation.ID = existing.ID
+		translation.CreatedAt = existing.CreatedAt
+	} else {
+		translation.ID = int64(len(m.translations) + 1)
+	}
+	copied := *translation
+	m.translations[key] = &copied
+	return nil
+}
+
+func (m *MockDB) GetScanVocabulary(ctx context.Context, scanID int64, targetLanguage string) (*models.ScanVocabulary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vocabulary, ok := m.vocabularies[fmt.Sprintf("%d:%s", scanID, targetLanguage)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *vocabulary
+	return &copied, nil
+}
+
+func (m *MockDB) UpsertScanVocabulary(ctx context.Context, vocabulary *models.ScanVocabulary) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("%d:%s", vocabulary.ScanID, vocabulary.TargetLanguage)
+	if existing, ok := m.vocabularies[key]; ok {
+		vocabulary.ID = existing.ID
+		vocabulary.CreatedAt = existing.CreatedAt
+	} else {
+		vocabulary.ID = int64(len(m.vocabularies) + 1)
+	}
+	copied := *vocabulary
+	m.vocabularies[key] = &copied
+	return nil
+}
+
+func (m *MockDB) CreateAnalysis(ctx context.Context, analysis *models.Analysis) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	analysis.ID = m.nextAnalysisID
+	m.nextAnalysisID++
+	m.analyses[analysis.ID] = analysis
+	return analysis.ID, nil
+}
+
+func (m *MockDB) GetAnalysisByID(ctx context.Context, analysisID int64) (*models.Analysis, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.analyses[analysisID], nil
+}
+
+func (m *MockDB) CreateAnnotationFromAnalysis(ctx context.Context, annotation *models.Annotation, analysisID int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	analysis := m.analyses[analysisID]
+	if analysis == nil || analysis.AnnotationID != nil {
+		return 0, storage.ErrAnalysisAlreadySaved
+	}
+
+	annotation.ID = m.nextAnnID
+	m.nextAnnID++
+	m.annotations[annotation.ID] = annotation
+	analysis.AnnotationID = &annotation.ID
+	return annotation.ID, nil
+}
+
+func (m *MockDB) CreateAnnotationMessages(ctx context.Context, messages ...*models.AnnotationMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, message := range messages {
+		message.ID = m.nextMessageID
+		m.nextMessageID++
+		m.messages[message.AnnotationID] = append(m.messages[message.AnnotationID], message)
+	}
+	return nil
+}
+
+func (m *MockDB) GetAnnotationMessages(ctx context.Context, annotationID int64) ([]*models.AnnotationMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*models.AnnotationMessage(nil), m.messages[annotationID]...), nil
+}
+
+func (m *MockDB) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token.ID = m.nextTokenID
+	m.nextTokenID++
+	m.refreshTokens[token.ID] = token
+	return token.ID, nil
+}
+
+func (m *MockDB) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, token := range m.refreshTokens {
+		if token.TokenHash == tokenHash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockDB) RotateRefreshToken(ctx context.Context, oldID int64, next *models.RefreshToken) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := m.refreshTokens[oldID]
+	if old == nil || old.RevokedAt != nil {
+		return 0, storage.ErrRefreshTokenRevoked
+	}
+
+	next.ID = m.nextTokenID
+	m.nextTokenID++
+	m.refreshTokens[next.ID] = next
+	revokedAt := next.CreatedAt
+	old.RevokedAt = &revokedAt
+	old.ReplacedBy = &next.ID
+	return next.ID, nil
+}
+
+func (m *MockDB) RevokeRefreshTokenFamily(ctx context.Context, userID int64, familyID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revoked := false
+	now := time.Now()
+	for _, token := range m.refreshTokens {
+		if token.UserID == userID && token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			revoked = true
+		}
+	}
+	return revoked, nil
+}
+
+func (m *MockDB) GetActiveRefreshTokens(ctx context.Context, userID int64) ([]*models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []*models.RefreshToken
+	now := time.Now()
+	for _, token := range m.refreshTokens {
+		if token.UserID == userID && token.RevokedAt == nil && token.ExpiresAt.After(now) {
+			copied := *token
+			tokens = append(tokens, &copied)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID > tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// magicLinkRequest is a row of magic_link_requests.
+type magicLinkRequest struct {
+	emailHash, ipAddress string
+	createdAt            time.Time
+}
+
+func (m *MockDB) RecordMagicLinkRequest(ctx context.Context, emailHash, ipAddress string, cooldown, window time.Duration, ipLimit int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var forIP int
+	for _, request := range m.magicLinks {
+		if request.emailHash == emailHash && request.createdAt.After(now.Add(-cooldown)) {
+			return false, nil
+		}
+		if request.ipAddress == ipAddress && request.createdAt.After(now.Add(-window)) {
+			forIP++
+		}
+	}
+	if forIP >= ipLimit {
+		return false, nil
+	}
+	m.magicLinks = append(m.magicLinks, magicLinkRequest{emailHash: emailHash, ipAddress: ipAddress, createdAt: now})
+	return true, nil
+}
+
+func (m *MockDB) CreateAuditEntry(ctx context.Context, entry *models.AuditEntry) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addAuditEntry(entry)
+	return entry.ID, nil
+}
+
+// addAuditEntry stores entry and fills in its ID. The caller holds m.mu.
+func (m *MockDB) addAuditEntry(entry *models.AuditEntry) {
+	entry.ID = m.nextAuditID
+	m.nextAuditID++
+	m.auditEntries = append(m.auditEntries, entry)
+}
+
+func (m *MockDB) GetAuditEntries(ctx context.Context, targetUserID int64, page, size int) ([]*models.AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []*models.AuditEntry
+	for i := len(m.auditEntries) - 1; i >= 0; i-- {
+		entry := m.auditEntries[i]
+		if targetUserID == 0 || (entry.TargetUserID != nil && *entry.TargetUserID == targetUserID) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+func (m *MockDB) CreateOrganization(ctx context.Context, org *models.Organization, ownerID int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org.ID = m.nextOrgID
+	m.nextOrgID++
+	m.orgs[org.ID] = org
+	m.orgMembers = append(m.orgMembers, &models.OrgMember{
+		OrgID:     org.ID,
+		UserID:    ownerID,
+		Role:      models.OrgRoleOwner,
+		CreatedAt: org.CreatedAt,
+	})
+	return org.ID, nil
+}
+
+func (m *MockDB) GetOrganization(ctx context.Context, orgID int64) (*models.Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.orgs[orgID], nil
+}
+
+// orgMember returns a copy of member with its OrgName and Email filled in,
+// as the join in the postgres implementation does. The caller holds m.mu.
+func (m *MockDB) orgMember(member *models.OrgMember) *models.OrgMember {
+	result := *member
+	if org := m.orgs[member.OrgID]; org != nil {
+		result.OrgName = org.Name
+	}
+	if user := m.users[member.UserID]; user != nil {
+		result.Email = user.Email
+	}
+	return &result
+}
+
+func (m *MockDB) GetUserOrgMemberships(ctx context.Context, userID int64) ([]*models.OrgMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []*models.OrgMember
+	for _, member := range m.orgMembers {
+		if member.UserID == userID {
+			result = append(result, m.orgMember(member))
+		}
+	}
+	return result, nil
+}
+
+func (m *MockDB) GetOrgMember(ctx context.Context, orgID, userID int64) (*models.OrgMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, member := range m.orgMembers {
+		if member.OrgID == orgID && member.UserID == userID {
+			return m.orgMember(member), nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockDB) GetOrgMembers(ctx context.Context, orgID int64) ([]*models.OrgMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []*models.OrgMember
+	for _, member := range m.orgMembers {
+		if member.OrgID == orgID {
+			result = append(result, m.orgMember(member))
+		}
+	}
+	return result, nil
+}
+
+func (m *MockDB) AddOrgMember(ctx context.Context, member *models.OrgMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.orgMembers {
+		if existing.OrgID == member.OrgID && existing.UserID == member.UserID {
+			return storage.ErrAlreadyMember
+		}
+	}
+	m.orgMembers = append(m.orgMembers, member)
+	return nil
+}
+
+func (m *MockDB) UpdateOrgMemberRole(ctx context.Context, orgID, userID int64, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, member := range m.orgMembers {
+		if member.OrgID == orgID && member.UserID == userID {
+			member.Role = role
+		}
+	}
+	return nil
+}
+
+func (m *MockDB) SetOrgMemberSharing(ctx context.Context, orgID, userID int64, share bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, member := range m.orgMembers {
+		if member.OrgID == orgID && member.UserID == userID {
+			member.ShareAnnotations = share
+		}
+	}
+	return nil
+}
+
+func (m *MockDB) RemoveOrgMember(ctx context.Context, orgID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.orgMembers = slices.DeleteFunc(m.orgMembers, func(member *models.OrgMember) bool {
+		return member.OrgID == orgID && member.UserID == userID
+	})
+	return nil
+}
+
+func (m *MockDB) CreateOrgInvitation(ctx context.Context, invitation *models.OrgInvitation) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, existing := range m.invitations {
+		if existing.OrgID == invitation.OrgID && existing.Email == invitation.Email {
+			delete(m.invitations, id)
+			invitation.ID = id
+		}
+	}
+	if invitation.ID == 0 {
+		invitation.ID = m.nextInviteID
+		m.nextInviteID++
+	}
+	m.invitations[invitation.ID] = invitation
+	return invitation.ID, nil
+}
+
+// orgInvitation returns a copy of a pending invitation with its OrgName
+// filled in, or nil. The caller holds m.mu.
+func (m *MockDB) orgInvitation(invitation *models.OrgInvitation) *models.OrgInvitation {
+	if invitation == nil || !invitation.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+	result := *invitation
+	if org := m.orgs[invitation.OrgID]; org != nil {
+		result.OrgName = org.Name
+	}
+	return &result
+}
+
+func (m *MockDB) GetOrgInvitation(ctx context.Context, invitationID int64) (*models.OrgInvitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.orgInvitation(m.invitations[invitationID]), nil
+}
+
+// orgInvitations returns the pending invitations matching keep, oldest
+// first. The caller holds m.mu.
+func (m *MockDB) orgInvitations(keep func(*models.OrgInvitation) bool) []*models.OrgInvitation {
+	var result []*models.OrgInvitation
+	for _, invitation := range m.invitations {
+		if pending := m.orgInvitation(invitation); pending != nil && keep(pending) {
+			result = append(result, pending)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func (m *MockDB) GetOrgInvitations(ctx context.Context, orgID int64) ([]*models.OrgInvitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.orgInvitations(func(invitation *models.OrgInvitation) bool { return invitation.OrgID == orgID }), nil
+}
+
+func (m *MockDB) GetInvitationsForEmail(ctx context.Context, email string) ([]*models.OrgInvitation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	email = strings.ToLower(email)
+	return m.orgInvitations(func(invitation *models.OrgInvitation) bool { return invitation.Email == email }), nil
+}
+
+func (m *MockDB) AcceptOrgInvitation(ctx context.Context, invitationID, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invitation := m.orgInvitation(m.invitations[invitationID])
+	if invitation == nil {
+		return storage.ErrInvitationNotFound
+	}
+	for _, member := range m.orgMembers {
+		if member.OrgID == invitation.OrgID && member.UserID == userID {
+			return storage.ErrAlreadyMember
+		}
+	}
+	delete(m.invitations, invitationID)
+	m.orgMembers = append(m.orgMembers, &models.OrgMember{
+		OrgID:     invitation.OrgID,
+		UserID:    userID,
+		Role:      invitation.Role,
+		CreatedAt: time.Now(),
+	})
+	return nil
+}
+
+func (m *MockDB) DeleteOrgInvitation(ctx context.Context, invitationID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.invitations, invitationID)
+	return nil
+}
+
+func (m *MockDB) GetOrgInsights(ctx context.Context, orgID int64, since time.Time, limit int) (*models.OrgInsights, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	insights := &models.OrgInsights{
+		JLPTLevels:          make(map[string]int),
+		PolitenessRegisters: make(map[string]int),
+		PartsOfSpeech:       make(map[string]int),
+	}
+	sharing := make(map[int64]bool)
+	for _, member := range m.orgMembers {
+		if member.OrgID != orgID {
+			continue
+		}
+		insights.Members++
+		if member.ShareAnnotations {
+			insights.SharingMembers++
+			sharing[member.UserID] = true
+		}
+	}
+
+	terms := make(map[string]*models.TermStat)
+	termMembers := make(map[string]map[int64]bool)
+	for _, ann := range m.annotations {
+		if !sharing[ann.UserID] || ann.CreatedAt.Before(since) {
+			continue
+		}
+		insights.Annotations++
+		nuance := ann.NuanceData
+		if nuance.JLPTLevel != "" {
+			insights.JLPTLevels[nuance.JLPTLevel]++
+		}
+		if nuance.PolitenessRegister != "" {
+			insights.PolitenessRegisters[nuance.PolitenessRegister]++
+		}
+		if nuance.PartOfSpeech != "" {
+			insights.PartsOfSpeech[nuance.PartOfSpeech]++
+		}
+
+		stat := terms[ann.HighlightedText]
+		if stat == nil {
+			stat = &models.TermStat{Term: ann.HighlightedText}
+			terms[ann.HighlightedText] = stat
+			termMembers[ann.HighlightedText] = make(map[int64]bool)
+		}
+		stat.Annotations++
+		stat.Reading = max(stat.Reading, nuance.Reading)
+		stat.JLPTLevel = max(stat.JLPTLevel, nuance.JLPTLevel)
+		termMembers[ann.HighlightedText][ann.UserID] = true
+		stat.Members = len(termMembers[ann.HighlightedText])
+	}
+
+	for _, stat := range terms {
+		insights.TopTerms = append(insights.TopTerms, *stat)
+	}
+	sort.Slice(insights.TopTerms, func(i, j int) bool {
+		a, b := insights.TopTerms[i], insights.TopTerms[j]
+		if a.Members != b.Members {
+			return a.Members > b.Members
+		}
+		if a.Annotations != b.Annotations {
+			return a.Annotations > b.Annotations
+		}
+		return a.Term < b.Term
+	})
+	if len(insights.TopTerms) > limit {
+		insights.TopTerms = insights.TopTerms[:limit]
+	}
+	return insights, nil
+}
+
+// glossaryTermTaken reports whether another entry of the organization has
+// the term. The caller holds m.mu.
+func (m *MockDB) glossaryTermTaken(entry *models.GlossaryEntry) bool {
+	for _, existing := range m.glossary {
+		if existing.ID != entry.ID && existing.OrgID == entry.OrgID && existing.Term == entry.Term {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MockDB) CreateGlossaryEntry(ctx context.Context, entry *models.GlossaryEntry) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.glossaryTermTaken(entry) {
+		return 0, storage.ErrGlossaryTermExists
+	}
+	entry.ID = m.nextGlossaryID
+	m.nextGlossaryID++
+	m.glossary[entry.ID] = entry
+	return entry.ID, nil
+}
+
+func (m *MockDB) GetGlossaryEntry(ctx context.Context, entryID int64) (*models.GlossaryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.glossary[entryID], nil
+}
+
+// glossaryEntries returns the entries of the organizations, sorted as the
+// postgres implementation sorts them. The caller holds m.mu.
+func (m *MockDB) glossaryEntries(orgIDs map[int64]bool) []*models.GlossaryEntry {
+	var result []*models.GlossaryEntry
+	for _, entry := range m.glossary {
+		if orgIDs[entry.OrgID] {
+			result = append(result, entry)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].OrgID != result[j].OrgID {
+			return result[i].OrgID < result[j].OrgID
+		}
+		return result[i].Term < result[j].Term
+	})
+	return result
+}
+
+func (m *MockDB) GetGlossaryEntries(ctx context.Context, orgID int64) ([]*models.GlossaryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.glossaryEntries(map[int64]bool{orgID: true}), nil
+}
+
+func (m *MockDB) GetGlossaryEntriesForUser(ctx context.Context, userID int64) ([]*models.GlossaryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgIDs := make(map[int64]bool)
+	for _, member := range m.orgMembers {
+		if member.UserID == userID {
+			orgIDs[member.OrgID] = true
+		}
+	}
+	return m.glossaryEntries(orgIDs), nil
+}
+
+func (m *MockDB) UpdateGlossaryEntry(ctx context.Context, entry *models.GlossaryEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.glossaryTermTaken(entry) {
+		return storage.ErrGlossaryTermExists
+	}
+	if _, ok := m.glossary[entry.ID]; ok {
+		m.glossary[entry.ID] = entry
+	}
+	return nil
+}
+
+func (m *MockDB) DeleteGlossaryEntry(ctx context.Context, entryID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.glossary, entryID)
+	return nil
+}
+
+// MockRedisClient is an in-memory storage.RedisClient. Keys expire like
+// Redis keys; Err, when set, is returned by every call.
+type MockRedisClient struct {
+	mu     sync.Mutex
+	values map[string]string
+	expiry map[string]time.Time
+	Err    error
+}
+
+func NewMockRedisClient() *MockRedisClient {
+	return &MockRedisClient{
+		values: make(map[string]string),
+		expiry: make(map[string]time.Time),
+	}
+}
+
+func (m *MockRedisClient) set(key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+	m.values[key] = value
+	m.expiry[key] = time.Now().Add(ttl)
+	return nil
+}
+
+// get returns the value of key and whether it exists.
+func (m *MockRedisClient) get(key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return "", false, m.Err
+	}
+	value, ok := m.values[key]
+	if ok && !time.Now().Before(m.expiry[key]) {
+		delete(m.values, key)
+		delete(m.expiry, key)
+		return "", false, nil
+	}
+	return value, ok, nil
+}
+
+func (m *MockRedisClient) SetState(ctx context.Context, state, value string, ttl time.Duration) error {
+	return m.set("oauth:state:"+state, value, ttl)
+}
+
+func (m *MockRedisClient) ConsumeState(ctx context.Context, state string) (string, error) {
+	return m.consume("oauth:state:" + state)
+}
+
+func (m *MockRedisClient) DenyToken(ctx context.Context, jti string, ttl time.Duration) error {
+	return m.set("jwt:deny:"+jti, "1", ttl)
+}
+
+func (m *MockRedisClient) IsTokenDenied(ctx context.Context, jti string) (bool, error) {
+	_, ok, err := m.get("jwt:deny:" + jti)
+	return ok, err
+}
+
+func (m *MockRedisClient) SetMagicLink(ctx context.Context, tokenHash, email string, ttl time.Duration) error {
+	return m.set("magic:link:"+tokenHash, email, ttl)
+}
+
+func (m *MockRedisClient) ConsumeMagicLink(ctx context.Context, tokenHash string) (string, error) {
+	return m.consume("magic:link:" + tokenHash)
+}
+
+// consume gets and deletes key like GETDEL, returning "" when it is missing.
+func (m *MockRedisClient) consume(key string) (string, error) {
+	value, _, err := m.get(key)
+	if err == nil {
+		m.mu.Lock()
+		delete(m.values, key)
+		m.mu.Unlock()
+	}
+	return value, err
+}
+
+func (m *MockRedisClient) Ping(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.Err
+}
+
+func (m *MockRedisClient) Close() error {
+	return nil
+}
+
+// MockMailSender records sent messages instead of sending them.
+type MockMailSender struct {
+	mu       sync.Mutex
+	Messages []mail.Message
+}
+
+func (m *MockMailSender) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Messages = append(m.Messages, msg)
+	return nil
+}
+
+// MockGeminiClient returns canned responses without calling the API.
+type MockGeminiClient struct {
+	OCRText    string
+	Language   string
+	Vocabulary []gemini.VocabularyItem
+}
+
+func (m *MockGeminiClient) OCR(ctx context.Context, imageData []byte, mimeType string, opts gemini.OCROptions) (*gemini.OCRResponse, error) {
+	return &gemini.OCRResponse{RawText: m.OCRText, Language: m.Language, Model: opts.Model}, nil
+}
+
+func (m *MockGeminiClient) OCRDocument(ctx context.Context, documentData []byte, mimeType string, opts gemini.OCROptions) (*gemini.DocumentOCRResponse, error) {
+	return &gemini.DocumentOCRResponse{
+		Pages:    []gemini.PageText{{PageNumber: 1, RawText: m.OCRText}},
+		Language: m.Language,
+		Model:    opts.Model,
+	}, nil
+}
+
+func (m *MockGeminiClient) Annotate(ctx context.Context, ocrText string, selectedText string) (*gemini.AnnotationResponse, error) {
+	return &gemini.AnnotationResponse{Meaning: "meaning of " + selectedText}, nil
+}
+
+func (m *MockGeminiClient) AnnotateWithKnowledge(ctx context.Context, ocrText string, selectedText string, entries []knowledge.Entry) (*gemini.AnnotationResponse, error) {
+	return m.Annotate(ctx, ocrText, selectedText)
+}
+
+// AnnotateWithKnowledgeStream reports the meaning as a single field before
+// returning the same annotation as AnnotateWithKnowledge.
+func (m *MockGeminiClient) AnnotateWithKnowledgeStream(ctx context.Context, ocrText string, selectedText string, entries []knowledge.Entry, onField gemini.FieldFunc) (*gemini.AnnotationResponse, error) {
+	resp, err := m.AnnotateWithKnowledge(ctx, ocrText, selectedText, entries)
+	if err != nil {
+		return nil, err
+	}
+	if onField != nil {
+		onField("meaning", resp.Meaning)
+	}
+	return resp, nil
+}
+
+func (m *MockGeminiClient) TranslateDocument(ctx context.Context, paragraphs []string, targetLanguage string) (*gemini.TranslationResponse, error) {
+	response := &gemini.TranslationResponse{
+		Summary:     "summary in " + targetLanguage,
+		ActionItems: []gemini.ActionItem{{Type: "deadline", Description: "submit the form", DueDate: "2026-01-31"}},
+	}
+	for i, paragraph := range paragraphs {
+		response.Paragraphs = append(response.Paragraphs, gemini.ParagraphTranslation{Index: i, Translation: targetLanguage + ": " + paragraph})
+	}
+	return response, nil
+}
+
+func (m *MockGeminiClient) ExtractVocabulary(ctx context.Context, text string, targetLanguage string) (*gemini.VocabularyResponse, error) {
+	return &gemini.VocabularyResponse{Items: m.Vocabulary}, nil
+}
+
+// ChatAboutAnnotation replies with the question and the number of earlier
+// turns, streamed in two parts.
+func (m *MockGeminiClient) ChatAboutAnnotation(ctx context.Context, req gemini.AnnotationChatRequest, onText func(text string)) (*gemini.ChatResponse, error) {
+	parts := []string{"reply to " + req.Question, fmt.Sprintf(" after %d turns", len(req.History))}
+	if onText != nil {
+		for _, part := range parts {
+			onText(part)
+		}
+	}
+	return &gemini.ChatResponse{Text: parts[0] + parts[1]}, nil
+}
@@ -0,0 +1,64 @@
+package thumbnail
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gemini-hackathon/app/internal/imageproc"
+	"github.com/gemini-hackathon/app/internal/models"
+	"github.com/gemini-hackathon/app/internal/storage"
+)
+
+// Generator renders scan thumbnails in the configured sizes and records them
+// in scan_thumbnails. It is shared by the upload handler and the backfill
+// command.
+type Generator struct {
+	db          storage.DB
+	fileStorage storage.FileStorage
+	processor   imageproc.Processor
+	sizes       []int
+}
+
+func NewGenerator(db storage.DB, fileStorage storage.FileStorage, processor imageproc.Processor, sizes []int) *Generator {
+	return &Generator{
+		db:          db,
+		fileStorage: fileStorage,
+		processor:   processor,
+		sizes:       sizes,
+	}
+}
+
+// Sizes returns the thumbnail sizes in pixels, smallest first.
+func (g *Generator) Sizes() []int {
+	return g.sizes
+}
+
+// Generate creates every configured thumbnail for scanID from imageData.
+func (g *Generator) Generate(ctx context.Context, scanID int64, imageData []byte) error {
+	thumbs, err := g.processor.Thumbnails(imageData, g.sizes)
+	if err != nil {
+		return err
+	}
+
+	for _, size := range g.sizes {
+		name := fmt.Sprintf("%s-thumb-%d", strconv.FormatInt(scanID, 10), size)
+		path, hash, err := g.fileStorage.SaveImage(name, thumbs[size], "image/jpeg")
+		if err != nil {
+			return fmt.Errorf("failed to save %dpx thumbnail: %w", size, err)
+		}
+
+		if err := g.db.UpsertScanThumbnail(ctx, &models.ScanThumbnail{
+			ScanID:    scanID,
+			Size:      size,
+			ImageURL:  path,
+			ImageHash: hash,
+			CreatedAt: time.Now(),
+		}); err != nil {
+			return fmt.Errorf("failed to record %dpx thumbnail: %w", size, err)
+		}
+	}
+
+	return nil
+}
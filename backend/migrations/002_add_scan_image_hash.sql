@@ -0,0 +1,3 @@
+-- Migration 002: Store the image content hash so scan images can be served with a strong ETag
+
+ALTER TABLE scans ADD COLUMN image_hash VARCHAR(64);
@@ -0,0 +1,3 @@
+-- Migration 003: Keep the untouched upload alongside the preprocessed image used for OCR and display
+
+ALTER TABLE scans ADD COLUMN original_image_url TEXT;
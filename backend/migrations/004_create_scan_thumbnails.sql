@@ -0,0 +1,10 @@
+-- Migration 004: Thumbnails generated per scan for the history list
+
+CREATE TABLE scan_thumbnails (
+    scan_id BIGINT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    size INTEGER NOT NULL,
+    image_url TEXT NOT NULL,
+    image_hash VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scan_id, size)
+);
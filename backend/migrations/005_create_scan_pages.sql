@@ -0,0 +1,26 @@
+-- Migration 005: Multi-page documents. A scan is a document made of ordered pages,
+-- either several photographed images or a single uploaded PDF.
+
+ALTER TABLE scans ADD COLUMN document_type VARCHAR(10) NOT NULL DEFAULT 'image';
+ALTER TABLE scans ADD COLUMN document_url TEXT;
+ALTER TABLE scans ADD COLUMN page_count INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE scan_pages (
+    id BIGSERIAL PRIMARY KEY,
+    scan_id BIGINT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    page_number INTEGER NOT NULL,
+    image_url TEXT NOT NULL DEFAULT '',
+    image_hash VARCHAR(64),
+    original_image_url TEXT,
+    ocr_text TEXT,
+    detected_language VARCHAR(10),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (scan_id, page_number)
+);
+
+-- Existing single-image scans become one-page documents.
+INSERT INTO scan_pages (scan_id, page_number, image_url, image_hash, original_image_url, ocr_text, detected_language, created_at)
+SELECT id, 1, image_url, image_hash, original_image_url, full_ocr_text, detected_language, created_at
+FROM scans;
+
+ALTER TABLE annotations ADD COLUMN page_number INTEGER;
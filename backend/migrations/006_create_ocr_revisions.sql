@@ -0,0 +1,23 @@
+-- Migration 006: Revision history of the OCR text of each scan page. Every
+-- pipeline run, re-run and manual correction adds a row; scan_pages.ocr_text
+-- holds the latest one.
+
+CREATE TABLE ocr_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    scan_id BIGINT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    page_number INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    language VARCHAR(10),
+    source VARCHAR(20) NOT NULL,
+    model VARCHAR(100),
+    language_hint VARCHAR(35),
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_ocr_revisions_scan_id ON ocr_revisions(scan_id, page_number);
+
+INSERT INTO ocr_revisions (scan_id, page_number, text, language, source, created_at)
+SELECT scan_id, page_number, ocr_text, detected_language, 'ocr', created_at
+FROM scan_pages
+WHERE ocr_text IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Migration 007: Record where a scan's content came from: an uploaded file,
+-- text pasted by the user, or an image fetched from a URL.
+
+ALTER TABLE scans ADD COLUMN source_type VARCHAR(10) NOT NULL DEFAULT 'upload';
+ALTER TABLE scans ADD COLUMN source_url TEXT;
@@ -0,0 +1,17 @@
+-- Migration 008: Whole-document translations of a scan, one per target
+-- language. source_hash is the SHA-256 of the scan text that was translated,
+-- so a translation made before an OCR correction can be detected and redone.
+
+CREATE TABLE scan_translations (
+    id BIGSERIAL PRIMARY KEY,
+    scan_id BIGINT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    target_language VARCHAR(10) NOT NULL,
+    source_hash VARCHAR(64) NOT NULL,
+    paragraphs JSONB NOT NULL,
+    summary TEXT NOT NULL DEFAULT '',
+    action_items JSONB NOT NULL DEFAULT '[]',
+    model VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(scan_id, target_language)
+);
@@ -0,0 +1,16 @@
+-- Migration 009: Vocabulary extracted from a scan's text, one ranked list
+-- per explanation language. source_hash works as in scan_translations.
+
+CREATE TABLE scan_vocabularies (
+    id BIGSERIAL PRIMARY KEY,
+    scan_id BIGINT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    target_language VARCHAR(10) NOT NULL,
+    source_hash VARCHAR(64) NOT NULL,
+    items JSONB NOT NULL DEFAULT '[]',
+    model VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(scan_id, target_language)
+);
+
+CREATE INDEX idx_annotations_scan_id ON annotations(scan_id);
@@ -0,0 +1,6 @@
+-- Migration 010: Index the structured nuance_data fields used to filter a
+-- user's annotations (JLPT level, politeness register, part of speech).
+
+CREATE INDEX idx_annotations_user_jlpt ON annotations(user_id, (nuance_data->>'jlptLevel'));
+CREATE INDEX idx_annotations_user_politeness ON annotations(user_id, (nuance_data->>'politenessRegister'));
+CREATE INDEX idx_annotations_user_part_of_speech ON annotations(user_id, (nuance_data->>'partOfSpeech'));
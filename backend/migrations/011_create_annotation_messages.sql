@@ -0,0 +1,13 @@
+-- Migration 011: Follow-up chat about an annotation. Each row is one turn,
+-- either the user's question or the model's reply, in created order.
+
+CREATE TABLE annotation_messages (
+    id BIGSERIAL PRIMARY KEY,
+    annotation_id BIGINT NOT NULL REFERENCES annotations(id) ON DELETE CASCADE,
+    role VARCHAR(10) NOT NULL,
+    content TEXT NOT NULL,
+    model VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_annotation_messages_annotation_id ON annotation_messages(annotation_id, id);
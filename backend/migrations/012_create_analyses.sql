@@ -0,0 +1,16 @@
+-- Migration 012: Analysis results kept server-side, so an annotation is saved
+-- from the analysis the model produced instead of nuance data sent by the
+-- client. annotation_id is set when an analysis is saved, which happens once.
+
+CREATE TABLE analyses (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scan_id BIGINT REFERENCES scans(id) ON DELETE CASCADE,
+    text_to_analyze TEXT NOT NULL,
+    context_text TEXT,
+    nuance_data JSONB NOT NULL,
+    annotation_id BIGINT REFERENCES annotations(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_analyses_user_id ON analyses(user_id);
@@ -0,0 +1,14 @@
+-- Migration 013: Locate each highlight in its scan. start_offset and
+-- end_offset are offsets into scans.full_ocr_text in UTF-16 code units, as
+-- JavaScript string indices count them (end exclusive); ocr_block and
+-- bounding_box optionally point at the highlighted area of the page image.
+
+ALTER TABLE annotations
+    ADD COLUMN start_offset INTEGER,
+    ADD COLUMN end_offset INTEGER,
+    ADD COLUMN ocr_block VARCHAR(100),
+    ADD COLUMN bounding_box JSONB,
+    ADD CONSTRAINT annotations_offsets_check CHECK (
+        (start_offset IS NULL AND end_offset IS NULL)
+        OR (start_offset >= 0 AND end_offset > start_offset)
+    );
@@ -0,0 +1,20 @@
+-- Migration 014: Refresh tokens, stored as SHA-256 hashes. Each refresh
+-- replaces the token with a new one in the same family; a family is one
+-- signed-in device. Presenting a replaced token again revokes the family.
+
+CREATE TABLE refresh_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    user_agent TEXT,
+    ip_address VARCHAR(64),
+    session_started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    replaced_by BIGINT REFERENCES refresh_tokens(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX idx_refresh_tokens_active_user_id ON refresh_tokens(user_id) WHERE revoked_at IS NULL;
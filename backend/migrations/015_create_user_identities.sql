@@ -0,0 +1,26 @@
+-- Migration 015: Identities a user signs in with. One user can have several
+-- (Google, GitHub, Apple, email), so the same person gets one account.
+-- users.provider and users.provider_id now only record how the account was
+-- created; an identity that was unlinked may sign up again, so they are no
+-- longer unique.
+
+CREATE TABLE user_identities (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    provider_id VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, provider_id)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+CREATE INDEX idx_user_identities_verified_email ON user_identities(LOWER(email)) WHERE email_verified;
+
+-- Google and magic-link accounts were created from verified addresses.
+INSERT INTO user_identities (user_id, provider, provider_id, email, email_verified, created_at)
+SELECT id, provider, provider_id, LOWER(email), provider IN ('google', 'email'), created_at
+FROM users;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_provider_provider_id_key;
@@ -0,0 +1,11 @@
+-- Migration 016: Key-value fallback for Redis
+-- Holds OAuth states, magic links and the token denylist while Redis is
+-- unavailable and REDIS_FALLBACK=postgres.
+
+CREATE TABLE kv_store (
+    key VARCHAR(255) PRIMARY KEY,
+    value TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_kv_store_expires_at ON kv_store(expires_at);
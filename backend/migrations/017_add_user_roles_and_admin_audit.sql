@@ -0,0 +1,32 @@
+-- Migration 017: User roles and disabled accounts, OCR failures, and the
+-- audit log of admin actions. Admins are promoted with
+--   UPDATE users SET role = 'admin' WHERE email = '...';
+
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user'
+    CHECK (role IN ('user', 'admin', 'content-editor'));
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP WITH TIME ZONE;
+
+-- One row per failed OCR attempt; page_number is NULL when a whole document
+-- failed at once.
+CREATE TABLE scan_failures (
+    id BIGSERIAL PRIMARY KEY,
+    scan_id BIGINT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    page_number INTEGER,
+    source VARCHAR(20) NOT NULL,
+    error TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_scan_failures_created_at ON scan_failures(created_at DESC);
+
+-- Users are referenced without a foreign key so that entries outlive them.
+CREATE TABLE admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_user_id BIGINT NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target_user_id BIGINT,
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_admin_audit_log_target_user_id ON admin_audit_log(target_user_id, created_at DESC);
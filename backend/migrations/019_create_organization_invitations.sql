@@ -0,0 +1,15 @@
+-- Migration 019: Invitations to organizations. Owners invite by email and
+-- the invitee becomes a member only by accepting.
+
+CREATE TABLE organization_invitations (
+    id BIGSERIAL PRIMARY KEY,
+    org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'mentor', 'member')),
+    invited_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    UNIQUE (org_id, email)
+);
+
+CREATE INDEX idx_organization_invitations_email ON organization_invitations(email);
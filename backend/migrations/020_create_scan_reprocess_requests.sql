@@ -0,0 +1,12 @@
+-- Migration 020: Reprocessing requests, counted to limit how often a user can
+-- re-run OCR.
+
+CREATE TABLE scan_reprocess_requests (
+    id BIGSERIAL PRIMARY KEY,
+    scan_id BIGINT NOT NULL REFERENCES scans(id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_scan_reprocess_requests_scan_id ON scan_reprocess_requests(scan_id, created_at);
+CREATE INDEX idx_scan_reprocess_requests_user_id ON scan_reprocess_requests(user_id, created_at);
@@ -0,0 +1,12 @@
+-- Migration 021: Magic link requests, counted to limit how often a link can be
+-- mailed to an address and requested from one IP. Addresses are stored hashed.
+
+CREATE TABLE magic_link_requests (
+    id BIGSERIAL PRIMARY KEY,
+    email_hash VARCHAR(64) NOT NULL,
+    ip_address VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_magic_link_requests_email_hash ON magic_link_requests(email_hash, created_at);
+CREATE INDEX idx_magic_link_requests_ip_address ON magic_link_requests(ip_address, created_at);
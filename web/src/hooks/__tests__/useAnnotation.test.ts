@@ -39,7 +39,7 @@ describe('useAnnotation', () => {
       nuanceData: mockNuanceResponse,
     }
 
-    vi.mocked(analyzeText).mockResolvedValueOnce(mockNuanceResponse)
+    vi.mocked(analyzeText).mockResolvedValueOnce({ ...mockNuanceResponse, analysisId: 42 })
     vi.mocked(createAnnotation).mockResolvedValueOnce(mockAnnotationResponse)
 
     const { result } = renderHook(() => useAnnotation(123), { wrapper })
@@ -58,6 +58,7 @@ describe('useAnnotation', () => {
       highlightedText: 'test text',
       contextText: 'test context',
       nuanceData: mockNuanceResponse,
+      analysisId: 42,
     })
   })
 
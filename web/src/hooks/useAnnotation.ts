@@ -10,7 +10,7 @@ interface AnnotateRequest {
 export function useAnnotation(scanId: number) {
   return useMutation({
     mutationFn: async ({ textToAnalyze, context }: AnnotateRequest) => {
-      const nuanceData: NuanceData = await analyzeText({
+      const { analysisId, ...nuanceData } = await analyzeText({
         textToAnalyze,
         context,
       })
@@ -18,7 +18,8 @@ export function useAnnotation(scanId: number) {
         scanId,
         highlightedText: textToAnalyze,
         contextText: context,
-        nuanceData,
+        nuanceData: nuanceData as NuanceData,
+        analysisId,
       })
     },
   })
@@ -64,6 +64,7 @@ export interface Annotation {
   highlighted_text: string
   context_text?: string
   nuance_data: NuanceData
+  analysis_id?: number
   is_bookmarked: boolean
   created_at: string
 }
@@ -98,6 +99,7 @@ export interface CreateAnnotationRequest {
   highlightedText: string
   contextText?: string
   nuanceData: NuanceData
+  analysisId?: number
 }
 
 export interface CreateAnnotationResponse {
@@ -111,7 +113,8 @@ export interface AnalyzeRequest {
   context: string
 }
 
-export type AnalyzeResponse = NuanceData
+// analysisId identifies the stored result; pass it when saving the annotation.
+export type AnalyzeResponse = NuanceData & { analysisId?: number }
 
 // Language Types
 export interface Language {
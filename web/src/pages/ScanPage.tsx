@@ -53,6 +53,7 @@ export default function ScanPage() {
         highlighted_text: selectedText,
         context_text: contextText,
         nuance_data: result,
+        analysis_id: result.analysisId,
         is_bookmarked: true,
         created_at: new Date().toISOString(),
       }
@@ -76,6 +77,7 @@ export default function ScanPage() {
         highlightedText: currentAnnotation.highlighted_text,
         contextText: currentAnnotation.context_text,
         nuanceData: currentAnnotation.nuance_data,
+        analysisId: currentAnnotation.analysis_id,
       })
       setIsDrawerOpen(false)
       setCurrentAnnotation(null)